@@ -2,20 +2,60 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/perebaj/policycraft"
 )
 
-// Storage is the interface that wraps the postgres methods that iteract with the API
+// Storage is the interface that wraps the postgres methods that iteract with the API.
+// Every method receives the request context, so a slow database can't hang a request forever.
 type Storage interface {
-	SavePolicy(policy policycraft.Policy) error
-	Policies() ([]policycraft.Policy, error)
+	SavePolicy(ctx context.Context, policy policycraft.Policy) error
+	Policies(ctx context.Context) ([]policycraft.Policy, error)
+	ListPolicies(ctx context.Context, filter policycraft.PolicyFilter) (policycraft.PolicyPage, error)
+	PoliciesBySet(ctx context.Context, name string) ([]policycraft.Policy, error)
+	SavePolicySet(ctx context.Context, set policycraft.PolicySet) error
+	PolicySet(ctx context.Context, name string) (policycraft.PolicySet, error)
+	PolicySets(ctx context.Context) ([]policycraft.PolicySet, error)
+	SaveDecision(ctx context.Context, decision policycraft.Decision) error
+	Decision(ctx context.Context, id string) (policycraft.Decision, error)
+	Decisions(ctx context.Context, filter policycraft.DecisionFilter) ([]policycraft.Decision, error)
+	ImportBundle(ctx context.Context, bundle policycraft.Bundle) error
+	SaveAPIKey(ctx context.Context, key policycraft.APIKey) error
+	APIKeys(ctx context.Context) ([]policycraft.APIKey, error)
+	APIKeyByHash(ctx context.Context, hash string) (policycraft.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+	SaveChangeRequest(ctx context.Context, cr policycraft.ChangeRequest) error
+	ChangeRequest(ctx context.Context, id string) (policycraft.ChangeRequest, error)
+	ChangeRequests(ctx context.Context, filter policycraft.ChangeRequestFilter) ([]policycraft.ChangeRequest, error)
+	PublishChangeRequest(ctx context.Context, cr policycraft.ChangeRequest) error
+	PolicyHistory(ctx context.Context, id string) ([]policycraft.PolicyRevision, error)
+	LastPolicyRevision(ctx context.Context, id string) (int, error)
+	Policy(ctx context.Context, id string) (policycraft.Policy, error)
+	UpdatePolicy(ctx context.Context, policy policycraft.Policy) error
+	DeletePolicy(ctx context.Context, id string) error
+	DeletedPolicy(ctx context.Context, id string) (policycraft.Policy, error)
+	RestorePolicy(ctx context.Context, id string) error
+}
+
+// Enricher is the interface that wraps the fetching of the custom fields declared by the enrichment providers of a policy set.
+type Enricher interface {
+	Enrich(ctx context.Context, providers []policycraft.EnrichmentProvider, input map[string]interface{}) (map[string]interface{}, []policycraft.Enrichment, error)
+}
+
+// Observer is the interface that wraps the observation of the decisions of the executions, such as counting them in the metrics.
+type Observer interface {
+	ObserveDecision(ctx context.Context, decision policycraft.Decision)
 }
 
 // Policy is the struct that represents the policy entity in the API.
@@ -26,23 +66,61 @@ type Policy struct {
 	Name string `json:"name"`
 	// Value is the value that the policy will use to compare.
 	Value *int `json:"value,omitempty"`
-	// Criteria is the criteria that the policy will use to compare the value.
+	// Criteria is the criteria that the policy will use to compare the value. It must be a registered operator.
 	Criteria string `json:"criteria"`
+	// Function is the optional registered function applied to the custom field before the comparison. Empty removes it.
+	Function *string `json:"function,omitempty"`
+	// Type is the type of the policy condition. It can be: criteria(default) or starlark.
+	Type string `json:"type,omitempty"`
+	// Script is the Starlark script of starlark policies. It must define the function condition(input), returning a bool.
+	// Empty removes it.
+	Script *string `json:"script,omitempty"`
 	// SuccessCase is the boolean that will be used to compare the result of the policy
 	SuccessCase *bool `json:"success_case,omitempty"`
 	// Priority is the priority of the policy. The lower the number, the higher the priority.
 	Priority *int `json:"priority,omitempty"`
+	// PolicySet is the name of the policy set that the policy belongs to. If absent, the policy belongs to the default policy set.
+	PolicySet string `json:"policy_set,omitempty"`
+	// Tags are the labels used to find the policy. They are present when not null, so an empty array removes them.
+	Tags []string `json:"tags"`
 	// IMPORTANT: The pointer fields were chosen to be able to differentiate between the absence of the field and the zero value of the field.
 }
 
-// validateCriteria checks if the criteria field is valid.
+// validateCriteria checks if the criteria and the function fields reference operators and functions registered in the policycraft.DefaultRegistry.
+// For starlark policies, the script syntax is checked instead.
 func (p *Policy) validateCriteria() error {
-	switch p.Criteria {
-	case ">", "<", ">=", "<=", "==":
-		return nil
-	default:
-		return fmt.Errorf("invalid criteria: %s", p.Criteria)
+	return policycraft.DefaultRegistry.Validate(policycraft.Policy{Criteria: p.Criteria, Function: deref(p.Function), Type: p.Type, Script: deref(p.Script)})
+}
+
+// Entity converts the validated policy to the business entity. The policy without a policy set belongs to the default one.
+func (p *Policy) Entity() policycraft.Policy {
+	policy := policycraft.Policy{
+		ID:          p.ID,
+		Name:        p.Name,
+		Criteria:    p.Criteria,
+		Function:    deref(p.Function),
+		SuccessCase: *p.SuccessCase,
+		Priority:    *p.Priority,
+		Type:        p.Type,
+		Script:      deref(p.Script),
+		PolicySet:   p.PolicySet,
+		Tags:        policycraft.NewTags(p.Tags),
+	}
+	if p.Value != nil {
+		policy.Value = *p.Value
 	}
+	if policy.PolicySet == "" {
+		policy.PolicySet = policycraft.DefaultPolicySet
+	}
+	return policy
+}
+
+// deref returns the string s points to, or an empty string if s is nil.
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
 }
 
 // Validate checks if the policy is valid. If all required fields are present. Or if their values are equal to the expected.
@@ -53,7 +131,8 @@ func (p *Policy) Validate() error {
 	if _, err := uuid.Parse(p.ID); err != nil {
 		errs = append(errs, fmt.Errorf("id is not a valid UUID"))
 	}
-	if p.Value == nil {
+	// starlark policies compare inside the script, so they don't have a value
+	if p.Value == nil && p.Type != policycraft.PolicyTypeStarlark {
 		errs = append(errs, fmt.Errorf("value is required"))
 	}
 	if p.SuccessCase == nil {
@@ -62,13 +141,20 @@ func (p *Policy) Validate() error {
 	if p.Priority == nil {
 		errs = append(errs, fmt.Errorf("priority is required"))
 	}
-	if p.validateCriteria() != nil {
-		errs = append(errs, fmt.Errorf("invalid criteria"))
+	if err := p.validateCriteria(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, tag := range p.Tags {
+		if err := policycraft.CheckTag(tag); err != nil {
+			errs = append(errs, err)
+		}
 	}
 	return errors.Join(errs...)
 }
 
-// SavePolicyHandler returns a http.HandlerFunc that receive a policy and save it to the database
+// SavePolicyHandler returns a http.HandlerFunc that receive a policy and save it to the database, replacing every field of an existing
+// one. The change is recorded in the history of the policy, with the reason of the ChangeReasonHeader, and is refused if the policy
+// changed since the If-Match header, or since it was read. The ETag of the saved policy is returned.
 func SavePolicyHandler(db Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var policy Policy
@@ -83,85 +169,306 @@ func SavePolicyHandler(db Storage) http.HandlerFunc {
 			return
 		}
 
-		p := policycraft.Policy{
-			ID:          policy.ID,
-			Name:        policy.Name,
-			Value:       *policy.Value,
-			Criteria:    policy.Criteria,
-			SuccessCase: *policy.SuccessCase,
-			Priority:    *policy.Priority,
+		p := policy.Entity()
+
+		// Policies with the same priority are evaluated in a tie-break order, so they are rejected to keep the order explicit.
+		policies, err := db.PoliciesBySet(r.Context(), p.PolicySet)
+		if err != nil {
+			slog.Error("failed to get policies", "error", err)
+			sendErr(w, "failed to get policies", http.StatusInternalServerError)
+			return
+		}
+		if duplicated, ok := policycraft.DuplicatedPriority(p, policies); ok {
+			sendErr(w, fmt.Sprintf("priority %d is already used by the policy %s in the policy set %s", p.Priority, duplicated.ID, p.PolicySet), http.StatusConflict)
+			return
 		}
 
-		err = db.SavePolicy(p)
+		current, base, ok := basePolicy(w, r, db, p.ID)
+		if !ok {
+			return
+		}
+		err = db.SavePolicy(revisionContext(r, base), p)
+		if errors.Is(err, policycraft.ErrConflict) {
+			sendRevisionConflict(w, r)
+			return
+		}
 		if err != nil {
 			slog.Error("failed to save policy", "error", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		w.Header().Set("ETag", policyETag(revisionAfter(base, current, p)))
 	}
 }
 
-// ListPoliciesHandler returns a http.HandlerFunc that get all the policies from the database and return it as a response
+const (
+	// TotalCountHeader is the header with the number of entities that match the filters of a listing, in every page.
+	TotalCountHeader = "X-Total-Count"
+	// NextCursorHeader is the header with the cursor of the next page of a listing, absent on the last page.
+	NextCursorHeader = "X-Next-Cursor"
+
+	// DefaultPoliciesLimit is the number of policies returned when the limit of a listing is absent.
+	DefaultPoliciesLimit = 100
+	// MaxPoliciesLimit is the maximum number of policies that can be returned at once.
+	MaxPoliciesLimit = 1000
+)
+
+// ListPoliciesHandler returns a http.HandlerFunc that get a page of the policies, filtered and sorted by the query parameters.
+// The total of policies that match the filters and the cursor of the next page are returned in the TotalCountHeader and the
+// NextCursorHeader.
 func ListPoliciesHandler(db Storage) http.HandlerFunc {
-	return func(w http.ResponseWriter, _ *http.Request) {
-		policies, err := db.Policies()
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parsePolicyFilter(r)
 		if err != nil {
-			slog.Error("failed to get policies", "error", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			sendErr(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		//convert the policies to response body
-		policiesByte, err := json.Marshal(policies)
+		page, err := db.ListPolicies(r.Context(), filter)
+		if errors.Is(err, policycraft.ErrInvalidCursor) {
+			sendErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			slog.Error("failed to marshal policies", "error", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			slog.Error("failed to get policies", "error", err)
+			sendErr(w, "failed to get policies", http.StatusInternalServerError)
 			return
 		}
+		if page.Policies == nil {
+			page.Policies = []policycraft.ListedPolicy{}
+		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		defer func() {
-			_, _ = w.Write(policiesByte)
-		}()
+		w.Header().Set(TotalCountHeader, strconv.Itoa(page.Total))
+		if page.Next != "" {
+			w.Header().Set(NextCursorHeader, page.Next)
+		}
+		sendJSON(w, page.Policies, http.StatusOK)
 	}
 }
 
-// ExecutionEngineHandler returns a http.HandlerFunc that receive a custom fields and evaluate the policies
-func ExecutionEngineHandler(db Storage) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var e policycraft.Execution
-		err := json.NewDecoder(r.Body).Decode(&e)
+// parsePolicyFilter reads the policy filter from the request query parameters.
+func parsePolicyFilter(r *http.Request) (policycraft.PolicyFilter, error) {
+	query := r.URL.Query()
+	filter := policycraft.PolicyFilter{
+		PolicySet: query.Get("policy_set"),
+		Name:      query.Get("name"),
+		Criteria:  query.Get("criteria"),
+		Tag:       query.Get("tag"),
+		Sort:      policycraft.PolicySort(query.Get("sort")),
+		Cursor:    query.Get("cursor"),
+		Limit:     DefaultPoliciesLimit,
+	}
+
+	var errs []error
+	if err := filter.Sort.Check(); err != nil {
+		errs = append(errs, err)
+	}
+	if v := query.Get("updated_from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
+			errs = append(errs, errors.New("updated_from must be a RFC3339 time"))
+		}
+		filter.UpdatedFrom = from
+	}
+	if v := query.Get("updated_to"); v != "" {
+		to, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errs = append(errs, errors.New("updated_to must be a RFC3339 time"))
+		}
+		filter.UpdatedTo = to
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > MaxPoliciesLimit {
+			errs = append(errs, errors.New("limit must be an integer between 1 and 1000"))
+		}
+		filter.Limit = limit
+	}
+	return filter, errors.Join(errs...)
+}
+
+// ExecutionResponse is the struct that represents the response of the execution engine.
+type ExecutionResponse struct {
+	// DecisionID is the identifier of the persisted decision. It can be used to query the decision later.
+	DecisionID string `json:"decision_id"`
+	// Decision is the result of the execution.
+	Decision bool `json:"decision"`
+	// TimedOut tells if the decision was given by the policy set fail mode, because the deadline was hit.
+	TimedOut bool `json:"timed_out,omitempty"`
+}
+
+// ExecutionRequest is an execution of the policies of a policy set, see Execute.
+type ExecutionRequest struct {
+	// PolicySet is the policy set evaluated. If empty, the default policy set is evaluated.
+	PolicySet string
+	// CustomFields are the custom fields sent by the caller.
+	CustomFields map[string]interface{}
+	// RequestID correlates the execution with the request that triggered it. If empty, one is generated.
+	RequestID string
+	// CallerRef is the optional reference of the caller attached to the decision.
+	CallerRef string
+}
+
+// FieldErrors is the error of an input with invalid fields.
+type FieldErrors []policycraft.FieldError
+
+func (e FieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fieldErr := range e {
+		msgs[i] = fieldErr.Field + " " + fieldErr.Msg
+	}
+	return "invalid input: " + strings.Join(msgs, ", ")
+}
+
+// Execute evaluates the policies of the policy set of the request and persists the decision, so it's possible to explain it later.
+// The enrichment providers of the policy set fill their custom fields before the evaluation, the caller can't send them.
+// The enriched custom fields are validated against the input schema of the policy set before the evaluation.
+// If the policy set has a deadline and it's hit, the decision is given by the policy set fail mode.
+// Once the policies are evaluated, the decision is observed by the observer, if not nil, even if it fails to be persisted.
+// On failure, it returns the HTTP status code of the error, which is FieldErrors if the input has invalid fields.
+// The error of an execution canceled by the caller wraps the error of the context.
+func Execute(ctx context.Context, db Storage, enricher Enricher, observer Observer, req ExecutionRequest) (policycraft.Decision, int, error) {
+	startedAt := time.Now().UTC()
+
+	setName := req.PolicySet
+	if setName == "" {
+		setName = policycraft.DefaultPolicySet
+	}
+
+	// A policy set that was never declared doesn't have an input schema, so any input is accepted.
+	set, err := db.PolicySet(ctx, setName)
+	if err != nil && !errors.Is(err, policycraft.ErrNotFound) {
+		slog.Error("failed to get policy set", "error", err)
+		return policycraft.Decision{}, http.StatusInternalServerError, errors.New("failed to get policy set")
+	}
+	if fieldErrs := enrichedFieldErrs(set, req.CustomFields); len(fieldErrs) > 0 {
+		return policycraft.Decision{}, http.StatusBadRequest, FieldErrors(fieldErrs)
+	}
+
+	evalCtx := ctx
+	if set.Timeout() > 0 {
+		var cancel context.CancelFunc
+		evalCtx, cancel = context.WithTimeout(ctx, set.Timeout())
+		defer cancel()
+	}
+
+	var result policycraft.Result
+	var policies []policycraft.Policy
+	input, enrichments, err := enricher.Enrich(evalCtx, set.Enrichments, req.CustomFields)
+	if err == nil {
+		if fieldErrs := set.InputSchema.Validate(input); len(fieldErrs) > 0 {
+			return policycraft.Decision{}, http.StatusBadRequest, FieldErrors(fieldErrs)
 		}
+		policies, err = db.PoliciesBySet(evalCtx, setName)
+	}
+	if err == nil {
+		e := policycraft.Execution{CustomFields: input}
+		result, err = e.EvaluateWithTrace(evalCtx, policies)
+	}
+	// The storage drivers don't always wrap the context error, so the deadline is checked in the context itself.
+	timedOut := err != nil && errors.Is(evalCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+	switch {
+	case timedOut:
+		slog.Warn("policy set deadline exceeded", "policy_set", setName, "timeout", set.Timeout(), "fail_mode", set.FailMode)
+		result = policycraft.Result{Decision: set.FailDecision(), Trace: result.Trace}
+	case err != nil && ctx.Err() != nil:
+		slog.Info("execution canceled by the client", "policy_set", setName, "error", err)
+		return policycraft.Decision{}, http.StatusInternalServerError, fmt.Errorf("execution canceled: %w", ctx.Err())
+	case errors.Is(err, policycraft.ErrInvalidInput):
+		return policycraft.Decision{}, http.StatusBadRequest, err
+	case err != nil && input == nil:
+		slog.Error("failed to enrich input", "policy_set", setName, "error", err)
+		return policycraft.Decision{}, http.StatusBadGateway, fmt.Errorf("failed to enrich input: %v", err)
+	case err != nil:
+		slog.Error("failed to evaluate policies", "error", err)
+		return policycraft.Decision{}, http.StatusInternalServerError, fmt.Errorf("failed to evaluate policies %v", err)
+	}
+	if input == nil {
+		input = req.CustomFields
+	}
+
+	requestID := req.RequestID
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	decision := policycraft.Decision{
+		ID:            uuid.NewString(),
+		RequestID:     requestID,
+		CallerRef:     req.CallerRef,
+		PolicySet:     setName,
+		Input:         input,
+		PolicyVersion: policycraft.PoliciesVersion(policies),
+		Policies:      policies,
+		Decision:      result.Decision,
+		Trace:         result.Trace,
+		Enrichments:   enrichments,
+		TimedOut:      timedOut,
+		StartedAt:     startedAt,
+		FinishedAt:    time.Now().UTC(),
+	}
+	if observer != nil {
+		observer.ObserveDecision(ctx, decision)
+	}
+	// A decision that can't be explained later must not be returned, so a failure here fails the execution.
+	// The evaluation deadline doesn't apply to the persistence.
+	err = db.SaveDecision(context.WithoutCancel(ctx), decision)
+	if err != nil {
+		slog.Error("failed to save decision", "error", err)
+		return policycraft.Decision{}, http.StatusInternalServerError, errors.New("failed to save decision")
+	}
+	return decision, http.StatusOK, nil
+}
 
-		policies, err := db.Policies()
+// ExecutionEngineHandler returns a http.HandlerFunc that receive a custom fields and evaluate the policies, see Execute.
+// The policy set is chosen by the policy_set query parameter, if absent the default policy set is evaluated.
+func ExecutionEngineHandler(db Storage, enricher Enricher, observer Observer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var customFields map[string]interface{}
+		err := json.NewDecoder(r.Body).Decode(&customFields)
 		if err != nil {
-			slog.Error("failed to get policies", "error", err)
-			sendErr(w, "failed to get policies", http.StatusInternalServerError)
+			sendErr(w, "invalid request body", http.StatusBadRequest)
 			return
 		}
 
-		decision, err := e.Evaluate(policies)
-		if err != nil {
-			slog.Error("failed to evaluate policies", "error", err)
-			sendErr(w, "failed to evaluate policies "+err.Error(), http.StatusInternalServerError)
+		decision, code, err := Execute(r.Context(), db, enricher, observer, ExecutionRequest{
+			PolicySet:    r.URL.Query().Get("policy_set"),
+			CustomFields: customFields,
+			RequestID:    r.Header.Get(RequestIDHeader),
+			CallerRef:    r.Header.Get(CallerRefHeader),
+		})
+		var fieldErrs FieldErrors
+		switch {
+		case r.Context().Err() != nil && err != nil:
+			return
+		case errors.As(err, &fieldErrs):
+			sendFieldErrs(w, fieldErrs)
+			return
+		case err != nil:
+			sendErr(w, err.Error(), code)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		defer func() {
-			_, _ = w.Write([]byte(fmt.Sprintf(`{"decision": %t}`, decision)))
-		}()
+		sendJSON(w, ExecutionResponse{DecisionID: decision.ID, Decision: decision.Decision, TimedOut: decision.TimedOut}, http.StatusOK)
 	}
 }
 
+// enrichedFieldErrs rejects the custom fields sent by the caller that are filled by the enrichment providers.
+func enrichedFieldErrs(set policycraft.PolicySet, customFields map[string]interface{}) []policycraft.FieldError {
+	var fieldErrs []policycraft.FieldError
+	for field, provider := range set.EnrichedFields() {
+		if _, ok := customFields[field]; ok {
+			fieldErrs = append(fieldErrs, policycraft.FieldError{Field: field, Msg: fmt.Sprintf("is filled by the enrichment provider %s", provider)})
+		}
+	}
+	sort.Slice(fieldErrs, func(i, j int) bool { return fieldErrs[i].Field < fieldErrs[j].Field })
+	return fieldErrs
+}
+
 // ErrMsg is the struct that represents the error message in the API.
 type ErrMsg struct {
 	Msg string `json:"msg"`
+	// Fields is filled when the error is caused by invalid fields of the request.
+	Fields []policycraft.FieldError `json:"fields,omitempty"`
 }
 
 // sendErr send a error message as a response
@@ -177,3 +484,22 @@ func sendErr(w http.ResponseWriter, msg string, code int) {
 	}
 	w.Write(errMsgBytest)
 }
+
+// sendFieldErrs send a bad request response detailing every invalid field
+func sendFieldErrs(w http.ResponseWriter, fieldErrs []policycraft.FieldError) {
+	sendJSON(w, ErrMsg{Msg: "invalid input", Fields: fieldErrs}, http.StatusBadRequest)
+}
+
+// sendJSON send the given value encoded as JSON as a response
+func sendJSON(w http.ResponseWriter, v interface{}, code int) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("failed to marshal response", "error", err)
+		sendErr(w, "failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,134 @@
+// Package api ...
+// api_keys.go gather the handlers related to the API keys.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+)
+
+// APIKey is the struct that represents the request to create an API key.
+type APIKey struct {
+	// Name is a free text telling who uses the key.
+	Name string `json:"name"`
+	// Roles are the roles given to the requests made with the key.
+	Roles []policycraft.Role `json:"roles"`
+	// ExpiresAt is the time when the key stops working. If absent, the key never expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateAPIKeyResponse is the response of the creation of an API key.
+type CreateAPIKeyResponse struct {
+	APIKey policycraft.APIKey `json:"api_key"`
+	// Secret is the credential sent as the bearer token. Only its hash is stored, so it can't be shown again.
+	Secret string `json:"secret"`
+}
+
+// CreateAPIKeyHandler returns a http.HandlerFunc that creates an API key of the request tenant.
+// The principal can only give the roles it has, so a key never has more permissions than who created it.
+func CreateAPIKeyHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req APIKey
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			sendErr(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now().UTC()
+		var fieldErrs []policycraft.FieldError
+		if strings.TrimSpace(req.Name) == "" {
+			fieldErrs = append(fieldErrs, policycraft.FieldError{Field: "name", Msg: "is required"})
+		}
+		if err := policycraft.CheckRoles(req.Roles); err != nil {
+			fieldErrs = append(fieldErrs, policycraft.FieldError{Field: "roles", Msg: err.Error()})
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+			fieldErrs = append(fieldErrs, policycraft.FieldError{Field: "expires_at", Msg: "must be in the future"})
+		}
+		if len(fieldErrs) > 0 {
+			sendFieldErrs(w, fieldErrs)
+			return
+		}
+
+		principal, _ := policycraft.PrincipalFromContext(r.Context())
+		for _, role := range req.Roles {
+			if !principal.HasRole(role) {
+				sendErr(w, fmt.Sprintf("the credentials can't give the role %s", role), http.StatusForbidden)
+				return
+			}
+		}
+
+		secret, hash, err := policycraft.NewAPIKeySecret()
+		if err != nil {
+			slog.Error("failed to generate API key", "error", err)
+			sendErr(w, "failed to generate API key", http.StatusInternalServerError)
+			return
+		}
+		key := policycraft.APIKey{
+			ID:        uuid.NewString(),
+			Name:      req.Name,
+			Tenant:    policycraft.Tenant(r.Context()),
+			Roles:     req.Roles,
+			Hash:      hash,
+			CreatedAt: now,
+			ExpiresAt: req.ExpiresAt,
+		}
+		err = db.SaveAPIKey(r.Context(), key)
+		if err != nil {
+			slog.Error("failed to save API key", "error", err)
+			sendErr(w, "failed to save API key", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("API key created", "id", key.ID, "tenant", key.Tenant, "roles", key.Roles, "by", principal.Subject)
+
+		sendJSON(w, CreateAPIKeyResponse{APIKey: key, Secret: secret}, http.StatusCreated)
+	}
+}
+
+// ListAPIKeysHandler returns a http.HandlerFunc that get the API keys of the request tenant, without their secrets.
+func ListAPIKeysHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := db.APIKeys(r.Context())
+		if err != nil {
+			slog.Error("failed to get API keys", "error", err)
+			sendErr(w, "failed to get API keys", http.StatusInternalServerError)
+			return
+		}
+		if keys == nil {
+			keys = []policycraft.APIKey{}
+		}
+
+		sendJSON(w, keys, http.StatusOK)
+	}
+}
+
+// RevokeAPIKeyHandler returns a http.HandlerFunc that revokes the API key of the request tenant with the id of the URL path.
+// The key stays listed, with its revocation time.
+func RevokeAPIKeyHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		err := db.RevokeAPIKey(r.Context(), id, time.Now().UTC())
+		if errors.Is(err, policycraft.ErrNotFound) {
+			sendErr(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("failed to revoke API key", "error", err)
+			sendErr(w, "failed to revoke API key", http.StatusInternalServerError)
+			return
+		}
+		principal, _ := policycraft.PrincipalFromContext(r.Context())
+		slog.Info("API key revoked", "id", id, "tenant", policycraft.Tenant(r.Context()), "by", principal.Subject)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perebaj/policycraft"
+)
+
+func TestAPIKeysLifecycle(t *testing.T) {
+	db := NewMockStorage()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api-keys", Authorize(CreateAPIKeyHandler(db), policycraft.RoleAdmin))
+	mux.HandleFunc("GET /api-keys", Authorize(ListAPIKeysHandler(db), policycraft.RoleAdmin))
+	mux.HandleFunc("DELETE /api-keys/{id}", Authorize(RevokeAPIKeyHandler(db), policycraft.RoleAdmin))
+	mux.HandleFunc("GET /policies", Authorize(ListPoliciesHandler(db), policycraft.RoleViewer))
+	handler := NewAuthenticator(db, nil, "operator-secret").Middleware(TenantMiddleware(mux))
+
+	do := func(secret, tenant, method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+secret)
+		if tenant != "" {
+			req.Header.Set(TenantHeader, tenant)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// the operator key creates the first admin key of the tenant
+	w := do("operator-secret", "credit", "POST", "/api-keys", `{"name": "credit admin", "roles": ["admin"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d creating the API key, got %d | response: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var admin CreateAPIKeyResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &admin); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !strings.HasPrefix(admin.Secret, "pck_") || admin.APIKey.Tenant != "credit" {
+		t.Fatalf("unexpected API key %+v", admin)
+	}
+
+	// the admin key of the tenant creates a viewer key, which works right away
+	w = do(admin.Secret, "", "POST", "/api-keys", `{"name": "dashboard", "roles": ["viewer"], "expires_at": "`+time.Now().Add(time.Hour).UTC().Format(time.RFC3339)+`"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d creating the API key, got %d | response: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var viewer CreateAPIKeyResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &viewer); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if w := do(viewer.Secret, "", "GET", "/policies", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d using the new key, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := do(viewer.Secret, "", "GET", "/api-keys", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected status code %d listing the keys with a viewer key, got %d", http.StatusForbidden, w.Code)
+	}
+
+	// the list never shows the secrets nor their hashes
+	w = do(admin.Secret, "", "GET", "/api-keys", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d listing the API keys, got %d", http.StatusOK, w.Code)
+	}
+	if strings.Contains(w.Body.String(), viewer.Secret) || strings.Contains(w.Body.String(), policycraft.HashAPIKey(viewer.Secret)) {
+		t.Fatalf("the list leaked a secret: %s", w.Body.String())
+	}
+	var keys []policycraft.APIKey
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != admin.APIKey.ID || keys[1].ID != viewer.APIKey.ID {
+		t.Fatalf("unexpected API keys %+v", keys)
+	}
+
+	// another tenant neither sees nor revokes the keys
+	if w := do("operator-secret", "insurance", "GET", "/api-keys", ""); w.Body.String() != "[]" {
+		t.Fatalf("another tenant listed the API keys: %s", w.Body.String())
+	}
+	if w := do("operator-secret", "insurance", "DELETE", "/api-keys/"+viewer.APIKey.ID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status code %d revoking the key of another tenant, got %d", http.StatusNotFound, w.Code)
+	}
+
+	if w := do(admin.Secret, "", "DELETE", "/api-keys/"+viewer.APIKey.ID, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %d revoking the API key, got %d | response: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	if w := do(viewer.Secret, "", "GET", "/policies", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status code %d using the revoked key, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := do(admin.Secret, "", "DELETE", "/api-keys/unknown", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status code %d revoking an unknown key, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestCreateAPIKeyHandlerInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		roles      []policycraft.Role
+		body       string
+		wantStatus int
+		wantFields []string
+	}{
+		{name: "invalid body", roles: []policycraft.Role{policycraft.RoleAdmin}, body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "no name nor roles", roles: []policycraft.Role{policycraft.RoleAdmin}, body: `{}`, wantStatus: http.StatusBadRequest, wantFields: []string{"name", "roles"}},
+		{name: "unknown role", roles: []policycraft.Role{policycraft.RoleAdmin}, body: `{"name": "ci", "roles": ["root"]}`, wantStatus: http.StatusBadRequest, wantFields: []string{"roles"}},
+		{name: "expired", roles: []policycraft.Role{policycraft.RoleAdmin}, body: `{"name": "ci", "roles": ["viewer"], "expires_at": "2020-01-01T00:00:00Z"}`, wantStatus: http.StatusBadRequest, wantFields: []string{"expires_at"}},
+		{name: "role the principal doesn't have", roles: []policycraft.Role{policycraft.RolePublisher}, body: `{"name": "ci", "roles": ["executor"]}`, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewMockStorage()
+			req := httptest.NewRequest("POST", "/api-keys", strings.NewReader(tt.body))
+			req = req.WithContext(policycraft.WithPrincipal(req.Context(), policycraft.Principal{Subject: "test", Tenant: "credit", Roles: tt.roles}))
+			w := httptest.NewRecorder()
+
+			CreateAPIKeyHandler(db).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status code %d, got %d | response: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			var errMsg ErrMsg
+			if err := json.Unmarshal(w.Body.Bytes(), &errMsg); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			var fields []string
+			for _, f := range errMsg.Fields {
+				fields = append(fields, f.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Fatalf("expected the invalid fields %v, got %v", tt.wantFields, errMsg.Fields)
+			}
+		})
+	}
+}
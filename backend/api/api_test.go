@@ -2,30 +2,94 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/memory"
 )
 
-// MockStorage is a mock implementation of the Storage interface
-type MockStorage struct{}
-
-// SavePolicy is a mock implementation of the SavePolicy method
-func (m *MockStorage) SavePolicy(_ policycraft.Policy) error {
-	return nil
+// MockStorage is the in-memory storage with helpers to seed and inspect it in the tests.
+type MockStorage struct {
+	*memory.Storage
+	// delay simulates a slow database when loading the policies of a policy set
+	delay time.Duration
 }
 
-func (m *MockStorage) Policies() ([]policycraft.Policy, error) {
-	return nil, nil
+// PoliciesBySet waits for the delay before loading the policies, unless the context is done first.
+func (m *MockStorage) PoliciesBySet(ctx context.Context, name string) ([]policycraft.Policy, error) {
+	select {
+	case <-time.After(m.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return m.Storage.PoliciesBySet(ctx, name)
 }
 
 // NewMockStorage returns a new instance of MockStorage
 func NewMockStorage() *MockStorage {
-	return &MockStorage{}
+	return &MockStorage{Storage: memory.NewStorage()}
+}
+
+func (m *MockStorage) addPolicies(t *testing.T, policies []policycraft.Policy) {
+	t.Helper()
+	for _, p := range policies {
+		if err := m.SavePolicy(context.Background(), p); err != nil {
+			t.Fatalf("failed to save policy: %v", err)
+		}
+	}
+}
+
+func (m *MockStorage) addPolicySets(t *testing.T, sets []policycraft.PolicySet) {
+	t.Helper()
+	for _, set := range sets {
+		if err := m.SavePolicySet(context.Background(), set); err != nil {
+			t.Fatalf("failed to save policy set: %v", err)
+		}
+	}
+}
+
+func (m *MockStorage) addDecisions(t *testing.T, decisions []policycraft.Decision) {
+	t.Helper()
+	for _, d := range decisions {
+		if err := m.SaveDecision(context.Background(), d); err != nil {
+			t.Fatalf("failed to save decision: %v", err)
+		}
+	}
+}
+
+func (m *MockStorage) allPolicies(t *testing.T) []policycraft.Policy {
+	t.Helper()
+	policies, err := m.Policies(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list policies: %v", err)
+	}
+	return policies
+}
+
+func (m *MockStorage) allPolicySets(t *testing.T) []policycraft.PolicySet {
+	t.Helper()
+	sets, err := m.PolicySets(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list policy sets: %v", err)
+	}
+	return sets
+}
+
+func (m *MockStorage) allDecisions(t *testing.T) []policycraft.Decision {
+	t.Helper()
+	decisions, err := m.Decisions(context.Background(), policycraft.DecisionFilter{})
+	if err != nil {
+		t.Fatalf("failed to list decisions: %v", err)
+	}
+	return decisions
 }
 
 func TestSavePolicyHandler(t *testing.T) {
@@ -191,3 +255,167 @@ func TestSendErr(t *testing.T) {
 		})
 	}
 }
+
+func TestSavePolicyHandlerDuplicatedPriority(t *testing.T) {
+	existing := policycraft.Policy{
+		ID:          uuid.NewString(),
+		Name:        "age",
+		Value:       18,
+		Criteria:    ">=",
+		SuccessCase: true,
+		Priority:    1,
+		PolicySet:   "credit",
+	}
+	db := NewMockStorage()
+	db.addPolicies(t, []policycraft.Policy{existing})
+	handler := SavePolicyHandler(db)
+
+	tests := []struct {
+		name     string
+		policy   policycraft.Policy
+		expected int
+	}{
+		{
+			name:     "Same priority in the same policy set",
+			policy:   policycraft.Policy{ID: uuid.NewString(), Name: "income", Value: 1000, Criteria: ">", SuccessCase: true, Priority: 1, PolicySet: "credit"},
+			expected: http.StatusConflict,
+		},
+		{
+			name:     "Same priority in another policy set",
+			policy:   policycraft.Policy{ID: uuid.NewString(), Name: "income", Value: 1000, Criteria: ">", SuccessCase: true, Priority: 1, PolicySet: "insurance"},
+			expected: http.StatusOK,
+		},
+		{
+			name:     "Updating the existing policy",
+			policy:   existing,
+			expected: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := json.NewEncoder(&buf).Encode(test.policy)
+			if err != nil {
+				t.Fatalf("failed to encode policy: %v", err)
+			}
+
+			req := httptest.NewRequest("POST", "/policies", &buf)
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != test.expected {
+				t.Fatalf("expected status code %d, got %d | response: %s", test.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSavePolicyHandlerScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{
+			name:     "Valid script",
+			body:     `{"id": "` + uuid.NewString() + `", "name": "income", "type": "starlark", "script": "def condition(input):\n    return input['income'] > 1000\n", "success_case": true, "priority": 1}`,
+			expected: http.StatusOK,
+		},
+		{
+			name:     "Syntax error",
+			body:     `{"id": "` + uuid.NewString() + `", "name": "income", "type": "starlark", "script": "def condition(input)\n    return True\n", "success_case": true, "priority": 1}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Missing script",
+			body:     `{"id": "` + uuid.NewString() + `", "name": "income", "type": "starlark", "success_case": true, "priority": 1}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Unknown type",
+			body:     `{"id": "` + uuid.NewString() + `", "name": "income", "type": "lua", "value": 1, "success_case": true, "priority": 1}`,
+			expected: http.StatusBadRequest,
+		},
+	}
+
+	handler := SavePolicyHandler(NewMockStorage())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/policies", strings.NewReader(test.body))
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != test.expected {
+				t.Fatalf("expected status code %d, got %d | response: %s", test.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestListPoliciesHandler(t *testing.T) {
+	db := NewMockStorage()
+	db.addPolicies(t, []policycraft.Policy{
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "loans"},
+		{ID: uuid.NewString(), Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2, PolicySet: "loans"},
+		{ID: uuid.NewString(), Name: "score", Criteria: ">", Value: 500, SuccessCase: true, Priority: 1, PolicySet: "cards"},
+	})
+	handler := ListPoliciesHandler(db)
+	list := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/policies"+query, nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	// the pages of the policy set follow each other through the cursor
+	var names []string
+	query := "?policy_set=loans&sort=-priority&limit=1"
+	for {
+		w := list(query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get(TotalCountHeader); got != "2" {
+			t.Fatalf("expected a total of 2, got %s", got)
+		}
+		var policies []policycraft.ListedPolicy
+		if err := json.Unmarshal(w.Body.Bytes(), &policies); err != nil {
+			t.Fatalf("failed to unmarshal response: %v | response: %s", err, w.Body.String())
+		}
+		for _, p := range policies {
+			names = append(names, p.Name)
+		}
+		next := w.Header().Get(NextCursorHeader)
+		if next == "" {
+			break
+		}
+		query = "?policy_set=loans&sort=-priority&limit=1&cursor=" + next
+	}
+	if want := []string{"income", "age"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected policies %v, got %v", want, names)
+	}
+
+	if w := list("?policy_set=missing"); w.Code != http.StatusOK || w.Body.String() != "[]" {
+		t.Errorf("expected an empty list, got %d | response: %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "invalid sort", query: "?sort=value"},
+		{name: "invalid updated_from", query: "?updated_from=yesterday"},
+		{name: "invalid limit", query: "?limit=1001"},
+		{name: "invalid cursor", query: "?cursor=abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := list(tt.query); w.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d, got %d | response: %s", http.StatusBadRequest, w.Code, w.Body.String())
+			}
+		})
+	}
+}
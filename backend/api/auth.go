@@ -0,0 +1,134 @@
+// Package api ...
+// auth.go gather the authentication of the requests and the authorization of the routes.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/perebaj/policycraft"
+)
+
+// TokenVerifier is the interface that wraps the verification of the JWT bearer tokens, returning the principal they identify.
+type TokenVerifier interface {
+	Verify(token string) (policycraft.Principal, error)
+}
+
+// Authenticator identifies the principal of the requests by the bearer credential of the Authorization header, which is either
+// an API key or a JWT. The JWTs are only accepted when a TokenVerifier is configured.
+type Authenticator struct {
+	db       Storage
+	verifier TokenVerifier
+	// adminKeyHash is the hash of the operator key, which is an admin of every tenant. Empty disables it.
+	adminKeyHash string
+	now          func() time.Time
+}
+
+// NewAuthenticator returns an Authenticator of the API keys of the storage and, if verifier isn't nil, of the JWTs.
+// adminKey is the secret of the operator key, used to create the first API keys of the tenants. Empty disables it.
+func NewAuthenticator(db Storage, verifier TokenVerifier, adminKey string) *Authenticator {
+	a := &Authenticator{db: db, verifier: verifier, now: time.Now}
+	if adminKey != "" {
+		a.adminKeyHash = policycraft.HashAPIKey(adminKey)
+	}
+	return a
+}
+
+// Middleware authenticates the requests, carrying the principal in the request context (see policycraft.WithPrincipal).
+// A request without valid credentials is refused with 401. It must run before the TenantMiddleware, which binds the tenant of the principal.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credential, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || credential == "" {
+			sendUnauthorized(w, "missing bearer credentials")
+			return
+		}
+
+		principal, err := a.Authenticate(r.Context(), credential)
+		if errors.Is(err, ErrInvalidCredentials) {
+			sendUnauthorized(w, err.Error())
+			return
+		}
+		if err != nil {
+			slog.Error("failed to authenticate", "error", err)
+			sendErr(w, "failed to authenticate", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(policycraft.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// ErrInvalidCredentials is the error of a credential that doesn't identify a principal.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticate returns the principal identified by the bearer credential, an API key or a JWT.
+// It returns ErrInvalidCredentials if the credential is unknown, expired or revoked.
+func (a *Authenticator) Authenticate(ctx context.Context, credential string) (policycraft.Principal, error) {
+	// the API keys are opaque, while the JWTs always have three dot-separated parts
+	if strings.Count(credential, ".") == 2 {
+		if a.verifier == nil {
+			return policycraft.Principal{}, ErrInvalidCredentials
+		}
+		principal, err := a.verifier.Verify(credential)
+		if err != nil {
+			slog.Debug("invalid bearer token", "error", err)
+			return policycraft.Principal{}, ErrInvalidCredentials
+		}
+		return principal, nil
+	}
+
+	hash := policycraft.HashAPIKey(credential)
+	if a.adminKeyHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(a.adminKeyHash)) == 1 {
+		return policycraft.Principal{Subject: "admin-key", Roles: []policycraft.Role{policycraft.RoleAdmin}}, nil
+	}
+	key, err := a.db.APIKeyByHash(ctx, hash)
+	if errors.Is(err, policycraft.ErrNotFound) {
+		return policycraft.Principal{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return policycraft.Principal{}, err
+	}
+	if !key.Active(a.now()) {
+		return policycraft.Principal{}, ErrInvalidCredentials
+	}
+	return key.Principal(), nil
+}
+
+// AnonymousMiddleware carries an admin principal of every tenant in the request context. It replaces the Authenticator
+// when the authentication is disabled, for local development.
+func AnonymousMiddleware(next http.Handler) http.Handler {
+	principal := policycraft.Principal{Subject: "anonymous", Roles: []policycraft.Role{policycraft.RoleAdmin}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(policycraft.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// Authorize returns a http.HandlerFunc that only calls next if the principal of the request has any of the roles.
+// Without a principal the request is refused with 401, and without the roles with 403.
+func Authorize(next http.HandlerFunc, roles ...policycraft.Role) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := policycraft.PrincipalFromContext(r.Context())
+		if !ok {
+			sendUnauthorized(w, "missing bearer credentials")
+			return
+		}
+		for _, role := range roles {
+			if principal.HasRole(role) {
+				next(w, r)
+				return
+			}
+		}
+		sendErr(w, "the credentials don't have the role required by the route", http.StatusForbidden)
+	}
+}
+
+// sendUnauthorized send a 401 response, telling the client to authenticate with a bearer credential.
+func sendUnauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	sendErr(w, msg, http.StatusUnauthorized)
+}
@@ -0,0 +1,185 @@
+// Package api ...
+// bundles.go gather the handlers that export and import whole policy sets as bundles.
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/perebaj/policycraft"
+)
+
+// maxBundleSize is the maximum size of an imported bundle.
+const maxBundleSize = 10 << 20
+
+// ImportResponse is the struct that represents the response of a bundle import.
+type ImportResponse struct {
+	// DryRun tells if the bundle was only compared with the stored policy set, without changing it.
+	DryRun bool `json:"dry_run"`
+	// Diff is what changed, or what would change in a dry run.
+	Diff policycraft.BundleDiff `json:"diff"`
+}
+
+// ExportPoliciesHandler returns a http.HandlerFunc that export a policy set and its policies as a bundle.
+// The policy set is chosen by the policy_set query parameter, if absent the default policy set is exported.
+// The format query parameter can be json(default) or yaml.
+func ExportPoliciesHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setName := r.URL.Query().Get("policy_set")
+		if setName == "" {
+			setName = policycraft.DefaultPolicySet
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = policycraft.BundleFormatJSON
+		}
+		if format != policycraft.BundleFormatJSON && format != policycraft.BundleFormatYAML {
+			sendErr(w, "format must be json or yaml", http.StatusBadRequest)
+			return
+		}
+
+		bundle, found, err := StoredBundle(r.Context(), db, setName)
+		if err != nil {
+			slog.Error("failed to get policy set", "policy_set", setName, "error", err)
+			sendErr(w, "failed to get policy set", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			sendErr(w, "policy set not found", http.StatusNotFound)
+			return
+		}
+
+		b, err := bundle.Encode(format)
+		if err != nil {
+			slog.Error("failed to encode bundle", "error", err)
+			sendErr(w, "failed to encode bundle", http.StatusInternalServerError)
+			return
+		}
+		contentType := "application/json"
+		if format == policycraft.BundleFormatYAML {
+			contentType = "application/yaml"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(b)
+	}
+}
+
+// ImportPoliciesHandler returns a http.HandlerFunc that import a bundle in YAML or JSON, replacing the policy set and all its policies.
+// The bundle is rejected if it's invalid or if any policy set test fails, and it's applied in a single transaction.
+// With the dry_run query parameter set to true, the bundle is only validated and compared with the stored policy set.
+// The changes are recorded in the history of the policies, with the reason of the ChangeReasonHeader.
+func ImportPoliciesHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun := false
+		if v := r.URL.Query().Get("dry_run"); v != "" {
+			var err error
+			dryRun, err = strconv.ParseBool(v)
+			if err != nil {
+				sendErr(w, "dry_run must be true or false", http.StatusBadRequest)
+				return
+			}
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBundleSize))
+		if err != nil {
+			sendErr(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		bundle, err := policycraft.ParseBundle(data)
+		if err != nil {
+			sendErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp, code, err := ImportBundle(changeContext(r), db, bundle, dryRun)
+		if err != nil {
+			sendErr(w, err.Error(), code)
+			return
+		}
+		sendJSON(w, resp, code)
+	}
+}
+
+// ImportBundle replaces the policy set of the bundle and all its policies, returning what changed with its HTTP status code.
+// The bundle is rejected if it's invalid, if any policy set test fails or if it has a policy of another policy set, with 409.
+// In a dry run, it's only checked and compared with the stored policy set.
+// The changes are recorded in the history of the policies with the change info of the context.
+func ImportBundle(ctx context.Context, db Storage, bundle policycraft.Bundle, dryRun bool) (ImportResponse, int, error) {
+	err := bundle.Check(ctx, policycraft.DefaultRegistry)
+	if err != nil {
+		return ImportResponse{}, http.StatusBadRequest, err
+	}
+
+	current, _, err := StoredBundle(ctx, db, bundle.PolicySet.Name)
+	if err != nil {
+		slog.Error("failed to get policy set", "policy_set", bundle.PolicySet.Name, "error", err)
+		return ImportResponse{}, http.StatusInternalServerError, errors.New("failed to get policy set")
+	}
+	others, err := policiesInOtherSets(ctx, db, bundle)
+	if err != nil {
+		slog.Error("failed to get policies", "policy_set", bundle.PolicySet.Name, "error", err)
+		return ImportResponse{}, http.StatusInternalServerError, errors.New("failed to get policies")
+	}
+	if len(others) > 0 {
+		return ImportResponse{}, http.StatusConflict, fmt.Errorf("the policies %s belong to other policy sets, remove them from their policy sets first", strings.Join(others, ", "))
+	}
+	diff := policycraft.DiffBundles(current, bundle)
+	if dryRun || diff.Empty() {
+		return ImportResponse{DryRun: dryRun, Diff: diff}, http.StatusOK, nil
+	}
+
+	err = db.ImportBundle(ctx, bundle)
+	if errors.Is(err, policycraft.ErrPolicyInOtherSet) {
+		return ImportResponse{}, http.StatusConflict, err
+	}
+	if err != nil {
+		slog.Error("failed to import bundle", "policy_set", bundle.PolicySet.Name, "error", err)
+		return ImportResponse{}, http.StatusInternalServerError, errors.New("failed to import bundle")
+	}
+	slog.Info("bundle imported", "policy_set", bundle.PolicySet.Name, "added", len(diff.Added), "updated", len(diff.Updated), "removed", len(diff.Removed))
+	return ImportResponse{Diff: diff}, http.StatusOK, nil
+}
+
+// policiesInOtherSets returns the ids of the bundle policies that belong to another policy set.
+func policiesInOtherSets(ctx context.Context, db Storage, bundle policycraft.Bundle) ([]string, error) {
+	var others []string
+	for _, p := range bundle.Policies {
+		stored, err := db.Policy(ctx, p.ID)
+		if errors.Is(err, policycraft.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if stored.PolicySet != bundle.PolicySet.Name {
+			others = append(others, p.ID)
+		}
+	}
+	return others, nil
+}
+
+// StoredBundle returns the bundle of the stored policy set. A policy set that was never declared but has policies is found,
+// as the default policy set usually is.
+func StoredBundle(ctx context.Context, db Storage, name string) (policycraft.Bundle, bool, error) {
+	set, err := db.PolicySet(ctx, name)
+	declared := err == nil
+	if err != nil && !errors.Is(err, policycraft.ErrNotFound) {
+		return policycraft.Bundle{}, false, err
+	}
+	if !declared {
+		set = policycraft.PolicySet{Name: name}
+	}
+
+	policies, err := db.PoliciesBySet(ctx, name)
+	if err != nil {
+		return policycraft.Bundle{}, false, err
+	}
+	return policycraft.NewBundle(set, policies), declared || len(policies) > 0, nil
+}
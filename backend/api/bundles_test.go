@@ -0,0 +1,182 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/perebaj/policycraft"
+)
+
+func TestExportPoliciesHandler(t *testing.T) {
+	db := NewMockStorage()
+	db.addPolicySets(t, []policycraft.PolicySet{{Name: "credit", Description: "credit card approval"}})
+	db.addPolicies(t, []policycraft.Policy{
+		{ID: "a43cafc3-87ad-4e13-9e42-fbd7113b7e82", Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"},
+	})
+	handler := ExportPoliciesHandler(db)
+
+	tests := []struct {
+		name        string
+		query       string
+		expected    int
+		contentType string
+	}{
+		{name: "JSON", query: "?policy_set=credit", expected: http.StatusOK, contentType: "application/json"},
+		{name: "YAML", query: "?policy_set=credit&format=yaml", expected: http.StatusOK, contentType: "application/yaml"},
+		{name: "Unknown format", query: "?policy_set=credit&format=xml", expected: http.StatusBadRequest},
+		{name: "Unknown policy set", query: "?policy_set=debit", expected: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/policies/export"+test.query, nil)
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != test.expected {
+				t.Fatalf("expected status code %d, got %d | response: %s", test.expected, w.Code, w.Body.String())
+			}
+			if test.expected != http.StatusOK {
+				return
+			}
+			if w.Header().Get("Content-Type") != test.contentType {
+				t.Fatalf("expected content type %s, got %s", test.contentType, w.Header().Get("Content-Type"))
+			}
+			bundle, err := policycraft.ParseBundle(w.Body.Bytes())
+			if err != nil {
+				t.Fatalf("failed to parse exported bundle: %v", err)
+			}
+			if bundle.PolicySet.Description != "credit card approval" || len(bundle.Policies) != 1 {
+				t.Fatalf("expected the credit policy set to be exported, got %+v", bundle)
+			}
+		})
+	}
+}
+
+func TestImportPoliciesHandler(t *testing.T) {
+	bundle := `
+version: policycraft/v1
+policy_set:
+  name: credit
+  tests:
+    - name: minor
+      input: {age: 16}
+      decision: false
+policies:
+  - {id: a43cafc3-87ad-4e13-9e42-fbd7113b7e82, name: age, criteria: ">", value: 17, success_case: true, priority: 1}
+`
+	db := NewMockStorage()
+	db.addPolicies(t, []policycraft.Policy{
+		{ID: "0b5d8a5e-5d2c-4b8e-9d8b-1f3f3f3f3f3f", Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 1, PolicySet: "credit"},
+		{ID: "6f1c2f7e-2a56-4c4f-a4f5-7b1e7a0b5c11", Name: "age", Criteria: ">", Value: 20, SuccessCase: true, Priority: 1, PolicySet: "debit"},
+	})
+	handler := ImportPoliciesHandler(db)
+
+	// the dry run reports the difference without changing anything
+	req := httptest.NewRequest("POST", "/policies/import?dry_run=true", strings.NewReader(bundle))
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp ImportResponse
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !resp.DryRun || !resp.Diff.PolicySetChanged || len(resp.Diff.Added) != 1 || len(resp.Diff.Removed) != 1 {
+		t.Fatalf("expected a dry run adding and removing a policy, got %+v", resp)
+	}
+	sets, policies := db.allPolicySets(t), db.allPolicies(t)
+	if len(sets) != 0 || len(policies) != 2 || policies[1].Name != "income" {
+		t.Fatalf("expected the dry run to keep the storage, got %v %v", sets, policies)
+	}
+
+	// the import replaces only the policies of the policy set
+	req = httptest.NewRequest("POST", "/policies/import", strings.NewReader(bundle))
+	w = httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	sets, policies = db.allPolicySets(t), db.allPolicies(t)
+	if len(sets) != 1 || len(policies) != 2 {
+		t.Fatalf("expected the bundle to be imported, got %v %v", sets, policies)
+	}
+	for _, p := range policies {
+		if p.Name == "income" {
+			t.Fatalf("expected the policy out of the bundle to be removed, got %v", policies)
+		}
+	}
+
+	// importing again changes nothing
+	req = httptest.NewRequest("POST", "/policies/import", strings.NewReader(bundle))
+	w = httptest.NewRecorder()
+	handler(w, req)
+
+	resp = ImportResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !resp.Diff.Empty() {
+		t.Fatalf("expected no difference importing the same bundle, got %+v", resp.Diff)
+	}
+
+	invalid := map[string]string{
+		"Failing test":    strings.Replace(bundle, "decision: false", "decision: true", 1),
+		"Invalid version": strings.Replace(bundle, "policycraft/v1", "v1", 1),
+		"Invalid id":      strings.Replace(bundle, "a43cafc3-87ad-4e13-9e42-fbd7113b7e82", "not-a-uuid", 1),
+		"Invalid dry run": bundle,
+	}
+	for name, body := range invalid {
+		t.Run(name, func(t *testing.T) {
+			target := "/policies/import"
+			if name == "Invalid dry run" {
+				target += "?dry_run=maybe"
+			}
+			req := httptest.NewRequest("POST", target, strings.NewReader(body))
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code %d, got %d | response: %s", http.StatusBadRequest, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestImportPoliciesHandlerPolicyOfAnotherSet(t *testing.T) {
+	bundle := `
+version: policycraft/v1
+policy_set:
+  name: credit
+policies:
+  - {id: 6f1c2f7e-2a56-4c4f-a4f5-7b1e7a0b5c11, name: age, criteria: ">", value: 17, success_case: true, priority: 1}
+`
+	db := NewMockStorage()
+	db.addPolicies(t, []policycraft.Policy{
+		{ID: "6f1c2f7e-2a56-4c4f-a4f5-7b1e7a0b5c11", Name: "age", Criteria: ">", Value: 20, SuccessCase: true, Priority: 1, PolicySet: "debit"},
+	})
+	handler := ImportPoliciesHandler(db)
+
+	// the dry run refuses the bundle too, as the import would
+	for _, target := range []string{"/policies/import?dry_run=true", "/policies/import"} {
+		req := httptest.NewRequest("POST", target, strings.NewReader(bundle))
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "6f1c2f7e-2a56-4c4f-a4f5-7b1e7a0b5c11") {
+			t.Fatalf("%s: expected status code %d with the policy, got %d | response: %s", target, http.StatusConflict, w.Code, w.Body.String())
+		}
+	}
+	if policies := db.allPolicies(t); len(policies) != 1 || policies[0].PolicySet != "debit" {
+		t.Fatalf("expected the policy to stay in its policy set, got %v", policies)
+	}
+}
@@ -0,0 +1,445 @@
+// Package api ...
+// change_requests.go gather the handlers of the four-eyes review of the policy changes.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+)
+
+// ChangeRequest is the struct that represents the request to create or edit a change request.
+type ChangeRequest struct {
+	// Title is a short description of the change.
+	Title string `json:"title"`
+	// Policies are the policies created or updated when the change request is published. They are validated as in POST /policies.
+	Policies []Policy `json:"policies"`
+}
+
+// Comment is the struct that represents the body of a comment, and of the approval or rejection of a change request.
+type Comment struct {
+	// Body is the text of the comment.
+	Body string `json:"body"`
+}
+
+// CreateChangeRequestHandler returns a http.HandlerFunc that creates a draft change request, authored by the principal of the request.
+func CreateChangeRequestHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, ok := subject(w, r)
+		if !ok {
+			return
+		}
+		var req ChangeRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			sendErr(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		policies, code, err := req.validate(r.Context(), db)
+		if err != nil {
+			sendErr(w, err.Error(), code)
+			return
+		}
+		bases, err := baseRevisions(r.Context(), db, policies)
+		if err != nil {
+			sendErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		now := time.Now().UTC()
+		cr := policycraft.ChangeRequest{
+			ID:            uuid.NewString(),
+			Title:         req.Title,
+			Author:        author,
+			Status:        policycraft.ChangeStatusDraft,
+			Policies:      policies,
+			BaseRevisions: bases,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		createChangeRequest(w, r, db, cr)
+	}
+}
+
+// ProposePolicyHandler returns a http.HandlerFunc that receives a policy, as SavePolicyHandler, but instead of saving it creates
+// a change request with it, already in review. It replaces SavePolicyHandler when the approvals are required. The change request is
+// based on the last revision of the policy, checked against the If-Match header, and can't be published once the policy has another.
+func ProposePolicyHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, ok := subject(w, r)
+		if !ok {
+			return
+		}
+		var policy Policy
+		err := json.NewDecoder(r.Body).Decode(&policy)
+		if err != nil {
+			sendErr(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		_, base, ok := basePolicy(w, r, db, policy.ID)
+		if !ok {
+			return
+		}
+		cr, code, err := ProposePolicy(r.Context(), db, author, fmt.Sprintf("save policy %s", policy.Name), policy, base)
+		if err != nil {
+			sendErr(w, err.Error(), code)
+			return
+		}
+		sendJSON(w, cr, http.StatusCreated)
+	}
+}
+
+// ProposePolicy creates a change request, already in review, with the policy based on the given revision, instead of saving it.
+// It's how the policies are changed when the approvals are required. On failure, it returns the HTTP status code of the error.
+func ProposePolicy(ctx context.Context, db Storage, author, title string, policy Policy, base int) (policycraft.ChangeRequest, int, error) {
+	return proposePolicy(ctx, db, author, title, policy, base, nil)
+}
+
+// proposePolicy is shared by ProposePolicy and ProposePolicyPatchHandler, that keeps the patch in the change request, so the
+// reviewers see the fields it changes.
+func proposePolicy(ctx context.Context, db Storage, author, title string, policy Policy, base int, patch json.RawMessage) (policycraft.ChangeRequest, int, error) {
+	req := ChangeRequest{Title: title, Policies: []Policy{policy}}
+	policies, code, err := req.validate(ctx, db)
+	if err != nil {
+		return policycraft.ChangeRequest{}, code, err
+	}
+
+	now := time.Now().UTC()
+	cr := policycraft.ChangeRequest{
+		ID:            uuid.NewString(),
+		Title:         req.Title,
+		Author:        author,
+		Status:        policycraft.ChangeStatusInReview,
+		Policies:      policies,
+		BaseRevisions: map[string]int{policy.ID: base},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if patch != nil {
+		cr.Patches = map[string]json.RawMessage{policy.ID: patch}
+	}
+	if err := db.SaveChangeRequest(ctx, cr); err != nil {
+		slog.Error("failed to save change request", "error", err)
+		return policycraft.ChangeRequest{}, http.StatusInternalServerError, errors.New("failed to save change request")
+	}
+	cr.Version = 1
+	slog.Info("change request created", "id", cr.ID, "status", cr.Status, "author", cr.Author)
+	return cr, http.StatusCreated, nil
+}
+
+func createChangeRequest(w http.ResponseWriter, r *http.Request, db Storage, cr policycraft.ChangeRequest) {
+	err := db.SaveChangeRequest(r.Context(), cr)
+	if err != nil {
+		slog.Error("failed to save change request", "error", err)
+		sendErr(w, "failed to save change request", http.StatusInternalServerError)
+		return
+	}
+	cr.Version = 1
+	slog.Info("change request created", "id", cr.ID, "status", cr.Status, "author", cr.Author)
+
+	sendJSON(w, cr, http.StatusCreated)
+}
+
+// ListChangeRequestsHandler returns a http.HandlerFunc that get the change requests, the most recent first.
+// The query parameter status filters them.
+func ListChangeRequestsHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := policycraft.ChangeRequestFilter{Status: policycraft.ChangeStatus(r.URL.Query().Get("status"))}
+		if filter.Status != "" {
+			if err := policycraft.CheckChangeStatus(filter.Status); err != nil {
+				sendErr(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		crs, err := db.ChangeRequests(r.Context(), filter)
+		if err != nil {
+			slog.Error("failed to get change requests", "error", err)
+			sendErr(w, "failed to get change requests", http.StatusInternalServerError)
+			return
+		}
+		if crs == nil {
+			crs = []policycraft.ChangeRequest{}
+		}
+
+		sendJSON(w, crs, http.StatusOK)
+	}
+}
+
+// GetChangeRequestHandler returns a http.HandlerFunc that get the change request with the id of the URL path.
+func GetChangeRequestHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cr, err := db.ChangeRequest(r.Context(), r.PathValue("id"))
+		if err != nil {
+			sendChangeRequestErr(w, err)
+			return
+		}
+
+		sendJSON(w, cr, http.StatusOK)
+	}
+}
+
+// EditChangeRequestHandler returns a http.HandlerFunc that replaces the title and the policies of a draft, based on their current
+// last revisions. Only its author can edit it.
+func EditChangeRequestHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ChangeRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			sendErr(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		policies, code, err := req.validate(r.Context(), db)
+		if err != nil {
+			sendErr(w, err.Error(), code)
+			return
+		}
+		bases, err := baseRevisions(r.Context(), db, policies)
+		if err != nil {
+			sendErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		updateChangeRequest(w, r, db, db.SaveChangeRequest, func(cr *policycraft.ChangeRequest, by string, now time.Time) error {
+			return cr.Edit(by, req.Title, policies, bases, now)
+		})
+	}
+}
+
+// SubmitChangeRequestHandler returns a http.HandlerFunc that sends a draft to review. Only its author can submit it.
+func SubmitChangeRequestHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		updateChangeRequest(w, r, db, db.SaveChangeRequest, func(cr *policycraft.ChangeRequest, by string, now time.Time) error {
+			return cr.Submit(by, now)
+		})
+	}
+}
+
+// ApproveChangeRequestHandler returns a http.HandlerFunc that approves a change request in review. The body, with an optional
+// comment, may be omitted. The author can't approve its own change request.
+func ApproveChangeRequestHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var comment Comment
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+				sendErr(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		updateChangeRequest(w, r, db, db.SaveChangeRequest, func(cr *policycraft.ChangeRequest, by string, now time.Time) error {
+			return cr.Approve(by, comment.Body, now)
+		})
+	}
+}
+
+// RejectChangeRequestHandler returns a http.HandlerFunc that sends a change request in review back to draft. The comment is required,
+// telling the author what to change.
+func RejectChangeRequestHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		comment, ok := decodeComment(w, r)
+		if !ok {
+			return
+		}
+		updateChangeRequest(w, r, db, db.SaveChangeRequest, func(cr *policycraft.ChangeRequest, by string, now time.Time) error {
+			return cr.Reject(by, comment.Body, now)
+		})
+	}
+}
+
+// CommentChangeRequestHandler returns a http.HandlerFunc that records a comment in a change request, whatever its status.
+func CommentChangeRequestHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		comment, ok := decodeComment(w, r)
+		if !ok {
+			return
+		}
+		updateChangeRequest(w, r, db, db.SaveChangeRequest, func(cr *policycraft.ChangeRequest, by string, now time.Time) error {
+			cr.AddComment(by, comment.Body, now)
+			return nil
+		})
+	}
+}
+
+// PublishChangeRequestHandler returns a http.HandlerFunc that publishes an approved change request, saving its policies, so they
+// are evaluated by the execution engine. The priorities are checked again, because the policies may have changed since the review.
+// A policy changed since the revision the change request is based on is a conflict, so a stale change request doesn't overwrite the
+// later changes and the published policies are the ones approved.
+func PublishChangeRequestHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The history of the policies credits the author of the change request, which records who approved and published it.
+		publish := func(ctx context.Context, cr policycraft.ChangeRequest) error {
+			ctx = policycraft.WithChangeInfo(ctx, policycraft.ChangeInfo{
+				Author:        cr.Author,
+				Reason:        fmt.Sprintf("change request %s: %s", cr.ID, cr.Title),
+				BaseRevisions: cr.BaseRevisions,
+			})
+			return db.PublishChangeRequest(ctx, cr)
+		}
+		updateChangeRequest(w, r, db, publish, func(cr *policycraft.ChangeRequest, by string, now time.Time) error {
+			if err := CheckPriorities(r.Context(), db, cr.Policies); err != nil {
+				return fmt.Errorf("%w: %v", policycraft.ErrConflict, err)
+			}
+			return cr.Publish(by, now)
+		})
+	}
+}
+
+// baseRevisions returns the last revision of each policy, the revision a change of them is based on.
+func baseRevisions(ctx context.Context, db Storage, policies []policycraft.Policy) (map[string]int, error) {
+	bases := make(map[string]int, len(policies))
+	for _, p := range policies {
+		last, err := db.LastPolicyRevision(ctx, p.ID)
+		if err != nil {
+			slog.Error("failed to get policy revision", "error", err)
+			return nil, errors.New("failed to get policy revision")
+		}
+		bases[p.ID] = last
+	}
+	return bases, nil
+}
+
+// updateChangeRequest loads the change request with the id of the URL path, changes it on behalf of the principal of the request
+// and saves it, answering with the saved change request.
+func updateChangeRequest(w http.ResponseWriter, r *http.Request, db Storage, save func(context.Context, policycraft.ChangeRequest) error,
+	change func(cr *policycraft.ChangeRequest, by string, now time.Time) error) {
+	by, ok := subject(w, r)
+	if !ok {
+		return
+	}
+	cr, err := db.ChangeRequest(r.Context(), r.PathValue("id"))
+	if err != nil {
+		sendChangeRequestErr(w, err)
+		return
+	}
+	from := cr.Status
+	if err := change(&cr, by, time.Now().UTC()); err != nil {
+		sendChangeRequestErr(w, err)
+		return
+	}
+	if err := save(r.Context(), cr); err != nil {
+		sendChangeRequestErr(w, err)
+		return
+	}
+	cr.Version++
+	if cr.Status != from {
+		slog.Info("change request status changed", "id", cr.ID, "from", from, "to", cr.Status, "by", by)
+	}
+
+	sendJSON(w, cr, http.StatusOK)
+}
+
+// validate checks the policies of the change request, returning them as business entities, or the error and its status code.
+func (c ChangeRequest) validate(ctx context.Context, db Storage) ([]policycraft.Policy, int, error) {
+	if strings.TrimSpace(c.Title) == "" {
+		return nil, http.StatusBadRequest, errors.New("title is required")
+	}
+	if len(c.Policies) == 0 {
+		return nil, http.StatusBadRequest, errors.New("at least one policy is required")
+	}
+	ids := make(map[string]bool, len(c.Policies))
+	policies := make([]policycraft.Policy, 0, len(c.Policies))
+	for i, p := range c.Policies {
+		if err := p.Validate(); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("policy %d: %v", i, err)
+		}
+		if ids[p.ID] {
+			return nil, http.StatusBadRequest, fmt.Errorf("policy %d: id %s is repeated", i, p.ID)
+		}
+		ids[p.ID] = true
+		policies = append(policies, p.Entity())
+	}
+	if err := CheckPriorities(ctx, db, policies); err != nil {
+		var dup DuplicatedPriorityError
+		if errors.As(err, &dup) {
+			return nil, http.StatusConflict, err
+		}
+		slog.Error("failed to get policies", "error", err)
+		return nil, http.StatusInternalServerError, errors.New("failed to get policies")
+	}
+	return policies, 0, nil
+}
+
+// DuplicatedPriorityError tells that a policy has the priority of another one in the same policy set.
+type DuplicatedPriorityError struct {
+	Policy, Duplicated policycraft.Policy
+}
+
+func (e DuplicatedPriorityError) Error() string {
+	return fmt.Sprintf("priority %d of the policy %s is already used by the policy %s in the policy set %s", e.Policy.Priority, e.Policy.ID, e.Duplicated.ID, e.Policy.PolicySet)
+}
+
+// CheckPriorities checks if the priorities of the changed policies are unique in their policy sets, once the change is applied.
+// It returns DuplicatedPriorityError if they aren't.
+func CheckPriorities(ctx context.Context, db Storage, changed []policycraft.Policy) error {
+	changedIDs := make(map[string]bool, len(changed))
+	for _, p := range changed {
+		changedIDs[p.ID] = true
+	}
+	// the policies of each policy set after the change: the current ones that aren't changed, and the changed ones
+	bySet := make(map[string][]policycraft.Policy)
+	for _, p := range changed {
+		if _, ok := bySet[p.PolicySet]; !ok {
+			current, err := db.PoliciesBySet(ctx, p.PolicySet)
+			if err != nil {
+				return err
+			}
+			bySet[p.PolicySet] = []policycraft.Policy{}
+			for _, c := range current {
+				if !changedIDs[c.ID] {
+					bySet[p.PolicySet] = append(bySet[p.PolicySet], c)
+				}
+			}
+		}
+		bySet[p.PolicySet] = append(bySet[p.PolicySet], p)
+	}
+	for _, p := range changed {
+		if duplicated, ok := policycraft.DuplicatedPriority(p, bySet[p.PolicySet]); ok {
+			return DuplicatedPriorityError{Policy: p, Duplicated: duplicated}
+		}
+	}
+	return nil
+}
+
+// subject returns the subject of the principal of the request, refusing the request without a principal.
+func subject(w http.ResponseWriter, r *http.Request) (string, bool) {
+	principal, ok := policycraft.PrincipalFromContext(r.Context())
+	if !ok || principal.Subject == "" {
+		sendUnauthorized(w, "missing bearer credentials")
+		return "", false
+	}
+	return principal.Subject, true
+}
+
+func decodeComment(w http.ResponseWriter, r *http.Request) (Comment, bool) {
+	var comment Comment
+	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+		sendErr(w, "invalid request body", http.StatusBadRequest)
+		return Comment{}, false
+	}
+	if strings.TrimSpace(comment.Body) == "" {
+		sendErr(w, "body is required", http.StatusBadRequest)
+		return Comment{}, false
+	}
+	return comment, true
+}
+
+// sendChangeRequestErr sends the error of a change request operation with its status code.
+func sendChangeRequestErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, policycraft.ErrNotFound):
+		sendErr(w, "change request not found", http.StatusNotFound)
+	case errors.Is(err, policycraft.ErrNotAuthor), errors.Is(err, policycraft.ErrSelfApproval):
+		sendErr(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, policycraft.ErrConflict):
+		sendErr(w, err.Error(), http.StatusConflict)
+	default:
+		slog.Error("failed to change change request", "error", err)
+		sendErr(w, "failed to change change request", http.StatusInternalServerError)
+	}
+}
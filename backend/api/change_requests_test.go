@@ -0,0 +1,276 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+)
+
+// newChangeRequestsHandler returns the change requests routes, authorized as the service wires them. The principal of each
+// request is given by the test, with the subject and the roles.
+func newChangeRequestsHandler(db *MockStorage) func(subject string, roles []policycraft.Role, method, target, body string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /policies", Authorize(ProposePolicyHandler(db), policycraft.RoleEditor))
+	mux.HandleFunc("PATCH /policies/{id}", Authorize(ProposePolicyPatchHandler(db), policycraft.RoleEditor))
+	mux.HandleFunc("POST /change-requests", Authorize(CreateChangeRequestHandler(db), policycraft.RoleEditor))
+	mux.HandleFunc("GET /change-requests", Authorize(ListChangeRequestsHandler(db), policycraft.RoleViewer))
+	mux.HandleFunc("GET /change-requests/{id}", Authorize(GetChangeRequestHandler(db), policycraft.RoleViewer))
+	mux.HandleFunc("PUT /change-requests/{id}", Authorize(EditChangeRequestHandler(db), policycraft.RoleEditor))
+	mux.HandleFunc("POST /change-requests/{id}/submit", Authorize(SubmitChangeRequestHandler(db), policycraft.RoleEditor))
+	mux.HandleFunc("POST /change-requests/{id}/approve", Authorize(ApproveChangeRequestHandler(db), policycraft.RoleEditor))
+	mux.HandleFunc("POST /change-requests/{id}/reject", Authorize(RejectChangeRequestHandler(db), policycraft.RoleEditor))
+	mux.HandleFunc("POST /change-requests/{id}/comments", Authorize(CommentChangeRequestHandler(db), policycraft.RoleViewer))
+	mux.HandleFunc("POST /change-requests/{id}/publish", Authorize(PublishChangeRequestHandler(db), policycraft.RolePublisher))
+
+	return func(subject string, roles []policycraft.Role, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req = req.WithContext(policycraft.WithPrincipal(req.Context(), policycraft.Principal{Subject: subject, Roles: roles}))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+}
+
+func decodeChangeRequest(t *testing.T, w *httptest.ResponseRecorder) policycraft.ChangeRequest {
+	t.Helper()
+	var cr policycraft.ChangeRequest
+	if err := json.Unmarshal(w.Body.Bytes(), &cr); err != nil {
+		t.Fatalf("failed to unmarshal response: %v | response: %s", err, w.Body.String())
+	}
+	return cr
+}
+
+func TestChangeRequestLifecycle(t *testing.T) {
+	db := NewMockStorage()
+	current := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	db.addPolicies(t, []policycraft.Policy{current})
+	do := newChangeRequestsHandler(db)
+	editor := []policycraft.Role{policycraft.RoleEditor}
+	viewer := []policycraft.Role{policycraft.RoleViewer}
+	publisher := []policycraft.Role{policycraft.RolePublisher}
+
+	w := do("alice", editor, "POST", "/change-requests", `{"title": "raise the age", "policies": [{"id": "`+current.ID+`", "name": "age", "criteria": ">", "value": 18, "success_case": true, "priority": 1, "policy_set": "loans"}]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d creating the change request, got %d | response: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	cr := decodeChangeRequest(t, w)
+	if cr.Status != policycraft.ChangeStatusDraft || cr.Author != "alice" || cr.Version != 1 {
+		t.Fatalf("unexpected change request %+v", cr)
+	}
+	target := "/change-requests/" + cr.ID
+
+	// only the author edits and submits the draft
+	edit := `{"title": "raise the age and lower the priority", "policies": [{"id": "` + current.ID + `", "name": "age", "criteria": ">", "value": 21, "success_case": false, "priority": 2, "policy_set": "loans"}]}`
+	if w := do("bob", editor, "PUT", target, edit); w.Code != http.StatusForbidden {
+		t.Fatalf("expected status code %d editing the change request of another author, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := do("alice", editor, "PUT", target, edit); w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d editing the change request, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := do("bob", editor, "POST", target+"/submit", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected status code %d submitting the change request of another author, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := do("bob", editor, "POST", target+"/approve", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected status code %d approving a draft, got %d", http.StatusConflict, w.Code)
+	}
+	if w := do("alice", editor, "POST", target+"/submit", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d submitting the change request, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := do("alice", editor, "PUT", target, edit); w.Code != http.StatusConflict {
+		t.Fatalf("expected status code %d editing a change request in review, got %d", http.StatusConflict, w.Code)
+	}
+
+	// the author can't approve its own change request, and a viewer can comment but not review
+	if w := do("alice", editor, "POST", target+"/approve", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected status code %d approving its own change request, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := do("dave", viewer, "POST", target+"/approve", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected status code %d approving as a viewer, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := do("dave", viewer, "POST", target+"/comments", `{"body": "why 21?"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d commenting, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := do("bob", editor, "POST", target+"/reject", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code %d rejecting without a comment, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := do("bob", editor, "POST", target+"/reject", `{"body": "explain the new age"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d rejecting the change request, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := do("alice", editor, "POST", target+"/submit", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d submitting the change request again, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := do("carol", publisher, "POST", target+"/publish", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected status code %d publishing a change request in review, got %d", http.StatusConflict, w.Code)
+	}
+	w = do("bob", editor, "POST", target+"/approve", `{"body": "ok, it's the legal age"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d approving the change request, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if cr := decodeChangeRequest(t, w); cr.Status != policycraft.ChangeStatusApproved || cr.ApprovedBy != "bob" {
+		t.Fatalf("unexpected approved change request %+v", cr)
+	}
+
+	// until it's published, the evaluated policies don't change
+	if got := db.allPolicies(t); len(got) != 1 || !reflect.DeepEqual(got[0], current) {
+		t.Fatalf("expected the current policy before the publication, got %+v", got)
+	}
+	if w := do("bob", editor, "POST", target+"/publish", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected status code %d publishing as an editor, got %d", http.StatusForbidden, w.Code)
+	}
+	w = do("carol", publisher, "POST", target+"/publish", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d publishing the change request, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	want := current
+	want.Value, want.SuccessCase, want.Priority = 21, false, 2
+	if got := db.allPolicies(t); len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Fatalf("expected the published policy %+v, got %+v", want, got)
+	}
+	if w := do("carol", publisher, "POST", target+"/publish", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected status code %d publishing again, got %d", http.StatusConflict, w.Code)
+	}
+
+	// the history of the review is kept in the comments
+	w = do("dave", viewer, "GET", target, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d getting the change request, got %d", http.StatusOK, w.Code)
+	}
+	cr = decodeChangeRequest(t, w)
+	var comments []string
+	for _, c := range cr.Comments {
+		comments = append(comments, c.Author+": "+c.Body)
+	}
+	wantComments := "dave: why 21?|bob: explain the new age|bob: ok, it's the legal age"
+	if strings.Join(comments, "|") != wantComments {
+		t.Fatalf("expected the comments %q, got %q", wantComments, strings.Join(comments, "|"))
+	}
+	if cr.Status != policycraft.ChangeStatusPublished || cr.PublishedBy != "carol" {
+		t.Fatalf("unexpected published change request %+v", cr)
+	}
+
+	w = do("dave", viewer, "GET", "/change-requests?status=published", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), cr.ID) {
+		t.Fatalf("expected the published change request listed, got %d | response: %s", w.Code, w.Body.String())
+	}
+	if w := do("dave", viewer, "GET", "/change-requests?status=merged", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code %d listing an unknown status, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := do("dave", viewer, "GET", "/change-requests/"+uuid.NewString(), ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status code %d getting an unknown change request, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestProposePolicyHandler(t *testing.T) {
+	db := NewMockStorage()
+	current := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	db.addPolicies(t, []policycraft.Policy{current})
+	do := newChangeRequestsHandler(db)
+	editor := []policycraft.Role{policycraft.RoleEditor}
+
+	w := do("alice", editor, "POST", "/policies", `{"id": "`+uuid.NewString()+`", "name": "score", "criteria": ">", "value": 600, "success_case": true, "priority": 2, "policy_set": "loans"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d proposing the policy, got %d | response: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	cr := decodeChangeRequest(t, w)
+	if cr.Status != policycraft.ChangeStatusInReview || len(cr.Policies) != 1 || cr.Policies[0].Name != "score" {
+		t.Fatalf("unexpected change request %+v", cr)
+	}
+	if got := db.allPolicies(t); len(got) != 1 {
+		t.Fatalf("expected the proposed policy not to be saved, got %+v", got)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "invalid body", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "invalid policy", body: `{"id": "invalid", "name": "score", "criteria": ">", "value": 600, "success_case": true, "priority": 2}`, wantStatus: http.StatusBadRequest},
+		{name: "duplicated priority", body: `{"id": "` + uuid.NewString() + `", "name": "score", "criteria": ">", "value": 600, "success_case": true, "priority": 1, "policy_set": "loans"}`, wantStatus: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do("alice", editor, "POST", "/policies", tt.body); w.Code != tt.wantStatus {
+				t.Fatalf("expected status code %d, got %d | response: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// a change request can't give two of its policies the same priority either
+	id := uuid.NewString()
+	body := `{"title": "two", "policies": [` +
+		`{"id": "` + id + `", "name": "a", "criteria": ">", "value": 1, "success_case": true, "priority": 7},` +
+		`{"id": "` + uuid.NewString() + `", "name": "b", "criteria": ">", "value": 1, "success_case": true, "priority": 7}]}`
+	if w := do("alice", editor, "POST", "/change-requests", body); w.Code != http.StatusConflict {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusConflict, w.Code, w.Body.String())
+	}
+	if crs, _ := db.ChangeRequests(context.Background(), policycraft.ChangeRequestFilter{}); len(crs) != 1 {
+		t.Fatalf("expected only the proposed change request, got %d", len(crs))
+	}
+}
+
+func TestPublishChangeRequestBasedOnAnotherRevision(t *testing.T) {
+	current := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	editor := []policycraft.Role{policycraft.RoleEditor}
+	publisher := []policycraft.Role{policycraft.RolePublisher}
+
+	tests := []struct {
+		name string
+		// method, target and body propose the value 18 for the policy
+		method, target, body string
+	}{
+		{
+			name:   "full policy",
+			method: "POST", target: "/policies",
+			body: `{"id": "` + current.ID + `", "name": "age", "criteria": ">", "value": 18, "success_case": true, "priority": 1, "policy_set": "loans"}`,
+		},
+		{
+			name:   "patch",
+			method: "PATCH", target: "/policies/" + current.ID,
+			body: `{"value": 18}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewMockStorage()
+			db.addPolicies(t, []policycraft.Policy{current})
+			do := newChangeRequestsHandler(db)
+
+			w := do("alice", editor, tt.method, tt.target, tt.body)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("expected status code %d proposing the policy, got %d | response: %s", http.StatusCreated, w.Code, w.Body.String())
+			}
+			cr := decodeChangeRequest(t, w)
+			if w := do("bob", editor, "POST", "/change-requests/"+cr.ID+"/approve", ""); w.Code != http.StatusOK {
+				t.Fatalf("expected status code %d approving, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			// the policy is renamed after the change was proposed
+			renamed := current
+			renamed.Name = "adult"
+			if err := db.UpdatePolicy(context.Background(), renamed); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// what was approved isn't what would be published, so neither the proposal nor the patch is published
+			w = do("carol", publisher, "POST", "/change-requests/"+cr.ID+"/publish", "")
+			if w.Code != http.StatusConflict {
+				t.Fatalf("expected status code %d publishing, got %d | response: %s", http.StatusConflict, w.Code, w.Body.String())
+			}
+			got, err := db.Policy(context.Background(), current.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Name != renamed.Name || got.Value != renamed.Value {
+				t.Fatalf("expected the policy %+v, got %+v", renamed, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Package api ...
+// decisions.go gather the handlers related to the persisted decisions.
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/perebaj/policycraft"
+)
+
+const (
+	// RequestIDHeader is the header used to correlate an execution with the request that triggered it.
+	// If it's not sent, a new request ID is generated.
+	RequestIDHeader = "X-Request-ID"
+	// CallerRefHeader is the header used by the caller to attach its own reference to a decision.
+	CallerRefHeader = "X-Caller-Ref"
+
+	// defaultDecisionsLimit is the number of decisions returned when the limit query parameter is absent.
+	defaultDecisionsLimit = 100
+	// maxDecisionsLimit is the maximum number of decisions that can be returned at once.
+	maxDecisionsLimit = 1000
+)
+
+// GetDecisionHandler returns a http.HandlerFunc that get a decision by its id
+func GetDecisionHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decision, err := db.Decision(r.Context(), r.PathValue("id"))
+		if errors.Is(err, policycraft.ErrNotFound) {
+			sendErr(w, "decision not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("failed to get decision", "error", err)
+			sendErr(w, "failed to get decision", http.StatusInternalServerError)
+			return
+		}
+
+		sendJSON(w, decision, http.StatusOK)
+	}
+}
+
+// ListDecisionsHandler returns a http.HandlerFunc that get the decisions filtered by the query parameters:
+// from and to (RFC3339 time range of the execution start), decision (true or false), caller_ref, policy_set and limit.
+func ListDecisionsHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseDecisionFilter(r)
+		if err != nil {
+			sendErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		decisions, err := db.Decisions(r.Context(), filter)
+		if err != nil {
+			slog.Error("failed to get decisions", "error", err)
+			sendErr(w, "failed to get decisions", http.StatusInternalServerError)
+			return
+		}
+		if decisions == nil {
+			decisions = []policycraft.Decision{}
+		}
+
+		sendJSON(w, decisions, http.StatusOK)
+	}
+}
+
+// parseDecisionFilter reads the decision filter from the request query parameters.
+func parseDecisionFilter(r *http.Request) (policycraft.DecisionFilter, error) {
+	query := r.URL.Query()
+	filter := policycraft.DecisionFilter{
+		CallerRef: query.Get("caller_ref"),
+		PolicySet: query.Get("policy_set"),
+		Limit:     defaultDecisionsLimit,
+	}
+
+	var errs []error
+	if v := query.Get("from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errs = append(errs, errors.New("from must be a RFC3339 time"))
+		}
+		filter.From = from
+	}
+	if v := query.Get("to"); v != "" {
+		to, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errs = append(errs, errors.New("to must be a RFC3339 time"))
+		}
+		filter.To = to
+	}
+	if v := query.Get("decision"); v != "" {
+		decision, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, errors.New("decision must be true or false"))
+		}
+		filter.Decision = &decision
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxDecisionsLimit {
+			errs = append(errs, errors.New("limit must be an integer between 1 and 1000"))
+		}
+		filter.Limit = limit
+	}
+	return filter, errors.Join(errs...)
+}
+
+// ReplayDecisionHandler returns a http.HandlerFunc that re-evaluates a logged decision against the policies used at the time
+// and against the current policies of the same policy set, reporting if the logged decision is reproducible. The operators and
+// functions are looked up in the registry, which must be the one of the execution engine.
+func ReplayDecisionHandler(db Storage, registry *policycraft.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decision, err := db.Decision(r.Context(), r.PathValue("id"))
+		if errors.Is(err, policycraft.ErrNotFound) {
+			sendErr(w, "decision not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("failed to get decision", "error", err)
+			sendErr(w, "failed to get decision", http.StatusInternalServerError)
+			return
+		}
+
+		policies, err := db.PoliciesBySet(r.Context(), decision.PolicySet)
+		if err != nil {
+			slog.Error("failed to get policies", "error", err)
+			sendErr(w, "failed to get policies", http.StatusInternalServerError)
+			return
+		}
+
+		sendJSON(w, policycraft.ReplayDecision(r.Context(), decision, policies, registry), http.StatusOK)
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/enrichment"
+)
+
+// observedDecisions is the Observer that keeps the observed decisions.
+type observedDecisions []policycraft.Decision
+
+func (o *observedDecisions) ObserveDecision(ctx context.Context, decision policycraft.Decision) {
+	*o = append(*o, decision)
+}
+
+func TestExecutionEngineHandlerSavesDecision(t *testing.T) {
+	db := NewMockStorage()
+	db.addPolicies(t, []policycraft.Policy{
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: policycraft.DefaultPolicySet},
+	})
+	var observed observedDecisions
+	handler := ExecutionEngineHandler(db, enrichment.NewEnricher(nil), &observed)
+
+	req := httptest.NewRequest("POST", "/execution-engine", strings.NewReader(`{"age": 16}`))
+	req.Header.Set(RequestIDHeader, "request-1")
+	req.Header.Set(CallerRefHeader, "proposal-1")
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp ExecutionResponse
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Decision {
+		t.Fatalf("expected the decision to be false")
+	}
+
+	decisions := db.allDecisions(t)
+	if len(decisions) != 1 {
+		t.Fatalf("expected 1 decision saved, got %d", len(decisions))
+	}
+	got := decisions[0]
+	if got.ID != resp.DecisionID {
+		t.Fatalf("expected decision id %s, got %s", resp.DecisionID, got.ID)
+	}
+	if got.RequestID != "request-1" || got.CallerRef != "proposal-1" {
+		t.Fatalf("unexpected request id %q or caller ref %q", got.RequestID, got.CallerRef)
+	}
+	if got.PolicyVersion != policycraft.PoliciesVersion(db.allPolicies(t)) {
+		t.Fatalf("unexpected policy version %s", got.PolicyVersion)
+	}
+	if len(got.Trace) != 1 || got.Trace[0].Passed {
+		t.Fatalf("expected a trace with the failed policy, got %v", got.Trace)
+	}
+	if got.FinishedAt.Before(got.StartedAt) {
+		t.Fatalf("finished_at must not be before started_at")
+	}
+	if len(observed) != 1 || observed[0].ID != got.ID {
+		t.Fatalf("expected the decision to be observed, got %v", observed)
+	}
+}
+
+func TestGetDecisionHandler(t *testing.T) {
+	id := uuid.NewString()
+	db := NewMockStorage()
+	db.addDecisions(t, []policycraft.Decision{{ID: id, Decision: true}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /decisions/{id}", GetDecisionHandler(db))
+
+	tests := []struct {
+		name     string
+		id       string
+		expected int
+	}{
+		{name: "Existing decision", id: id, expected: http.StatusOK},
+		{name: "Missing decision", id: uuid.NewString(), expected: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/decisions/"+test.id, nil)
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, req)
+
+			if w.Code != test.expected {
+				t.Fatalf("expected status code %d, got %d | response: %s", test.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestListDecisionsHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{name: "No filters", query: "", expected: http.StatusOK},
+		{name: "All filters", query: "?from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z&decision=false&caller_ref=abc&limit=10", expected: http.StatusOK},
+		{name: "Invalid from", query: "?from=yesterday", expected: http.StatusBadRequest},
+		{name: "Invalid decision", query: "?decision=maybe", expected: http.StatusBadRequest},
+		{name: "Invalid limit", query: "?limit=0", expected: http.StatusBadRequest},
+	}
+
+	handler := ListDecisionsHandler(NewMockStorage())
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/decisions"+test.query, nil)
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != test.expected {
+				t.Fatalf("expected status code %d, got %d | response: %s", test.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReplayDecisionHandler(t *testing.T) {
+	policyID := uuid.NewString()
+	db := NewMockStorage()
+	db.addPolicies(t, []policycraft.Policy{
+		{ID: policyID, Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: policycraft.DefaultPolicySet},
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /execution-engine", ExecutionEngineHandler(db, enrichment.NewEnricher(nil), nil))
+	mux.HandleFunc("POST /decisions/{id}/replay", ReplayDecisionHandler(db, policycraft.DefaultRegistry))
+
+	req := httptest.NewRequest("POST", "/execution-engine", strings.NewReader(`{"age": 16}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var execution ExecutionResponse
+	err := json.Unmarshal(w.Body.Bytes(), &execution)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	// changing the current policy, the input that was rejected is now approved
+	db.addPolicies(t, []policycraft.Policy{
+		{ID: policyID, Name: "age", Criteria: ">", Value: 15, SuccessCase: true, Priority: 1, PolicySet: policycraft.DefaultPolicySet},
+	})
+
+	req = httptest.NewRequest("POST", "/decisions/"+execution.DecisionID+"/replay", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var replay policycraft.Replay
+	err = json.Unmarshal(w.Body.Bytes(), &replay)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !replay.Reproducible {
+		t.Fatalf("expected the decision to be reproducible, got %+v", replay)
+	}
+	if replay.Current.Matches || !replay.Current.Decision {
+		t.Fatalf("expected the current policies to approve the input, got %+v", replay.Current)
+	}
+
+	req = httptest.NewRequest("POST", "/decisions/"+uuid.NewString()+"/replay", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
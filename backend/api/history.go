@@ -0,0 +1,95 @@
+// Package api ...
+// history.go gather the handlers related to the history of the policies.
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/perebaj/policycraft"
+)
+
+// ChangeReasonHeader is the optional header with the reason of a change of the policies, recorded in their history.
+const ChangeReasonHeader = "X-Change-Reason"
+
+// changeContext returns the context of the request carrying the policycraft.ChangeInfo of a change made by the request:
+// the author is the principal and the reason comes from the ChangeReasonHeader.
+func changeContext(r *http.Request) context.Context {
+	return policycraft.WithChangeInfo(r.Context(), policycraft.ChangeInfo{Reason: r.Header.Get(ChangeReasonHeader)})
+}
+
+// PolicyDiff is the difference of a policy between two revisions.
+type PolicyDiff struct {
+	// PolicyID is the policy compared.
+	PolicyID string `json:"policy_id"`
+	// From is the revision compared from.
+	From int `json:"from"`
+	// To is the revision compared to.
+	To int `json:"to"`
+	// Changes are the fields that differ, with their values in each revision.
+	Changes []policycraft.FieldChange `json:"changes"`
+}
+
+// PolicyHistoryHandler returns a http.HandlerFunc that get the revisions of a policy, the oldest first.
+func PolicyHistoryHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		revs, err := db.PolicyHistory(r.Context(), r.PathValue("id"))
+		if err != nil {
+			slog.Error("failed to get policy history", "error", err)
+			sendErr(w, "failed to get policy history", http.StatusInternalServerError)
+			return
+		}
+		if revs == nil {
+			revs = []policycraft.PolicyRevision{}
+		}
+
+		sendJSON(w, revs, http.StatusOK)
+	}
+}
+
+// PolicyDiffHandler returns a http.HandlerFunc that compares a policy between the revisions given by the query parameters from and to.
+// The policy of a deleted revision doesn't exist, so every field of the other revision is returned.
+func PolicyDiffHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, err := strconv.Atoi(r.URL.Query().Get("from"))
+		if err != nil || from < 1 {
+			sendErr(w, "from must be a revision number", http.StatusBadRequest)
+			return
+		}
+		to, err := strconv.Atoi(r.URL.Query().Get("to"))
+		if err != nil || to < 1 {
+			sendErr(w, "to must be a revision number", http.StatusBadRequest)
+			return
+		}
+
+		id := r.PathValue("id")
+		revs, err := db.PolicyHistory(r.Context(), id)
+		if err != nil {
+			slog.Error("failed to get policy history", "error", err)
+			sendErr(w, "failed to get policy history", http.StatusInternalServerError)
+			return
+		}
+		// The revisions are numbered from 1 without gaps.
+		if from > len(revs) || to > len(revs) {
+			sendErr(w, fmt.Sprintf("the policy has %d revisions", len(revs)), http.StatusNotFound)
+			return
+		}
+
+		changes := policycraft.DiffPolicies(revisionPolicy(revs[from-1]), revisionPolicy(revs[to-1]))
+		if changes == nil {
+			changes = []policycraft.FieldChange{}
+		}
+		sendJSON(w, PolicyDiff{PolicyID: id, From: from, To: to, Changes: changes}, http.StatusOK)
+	}
+}
+
+// revisionPolicy returns the policy as it was after the revision, nil if the revision deleted it.
+func revisionPolicy(rev policycraft.PolicyRevision) *policycraft.Policy {
+	if rev.Action == policycraft.PolicyDeleted {
+		return nil
+	}
+	return &rev.Policy
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+)
+
+func TestPolicyHistoryHandlers(t *testing.T) {
+	db := NewMockStorage()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /policies", SavePolicyHandler(db))
+	mux.HandleFunc("GET /policies/{id}/history", PolicyHistoryHandler(db))
+	mux.HandleFunc("GET /policies/{id}/diff", PolicyDiffHandler(db))
+	do := func(method, target, body, reason string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req = req.WithContext(policycraft.WithPrincipal(req.Context(), policycraft.Principal{Subject: "alice", Roles: []policycraft.Role{policycraft.RoleEditor}}))
+		if reason != "" {
+			req.Header.Set(ChangeReasonHeader, reason)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	id := uuid.NewString()
+	for _, body := range []string{
+		`{"id": "` + id + `", "name": "age", "criteria": ">", "value": 17, "success_case": true, "priority": 1}`,
+		`{"id": "` + id + `", "name": "age", "criteria": ">=", "value": 18, "success_case": true, "priority": 1}`,
+	} {
+		if w := do("POST", "/policies", body, "legal age"); w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d saving the policy, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+
+	w := do("GET", "/policies/"+id+"/history", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var revs []policycraft.PolicyRevision
+	if err := json.Unmarshal(w.Body.Bytes(), &revs); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("expected 2 revisions, got %d | response: %s", len(revs), w.Body.String())
+	}
+	for i, action := range []policycraft.PolicyAction{policycraft.PolicyCreated, policycraft.PolicyUpdated} {
+		if revs[i].Revision != i+1 || revs[i].Action != action || revs[i].Author != "alice" || revs[i].Reason != "legal age" {
+			t.Errorf("unexpected revision %d: %+v", i+1, revs[i])
+		}
+	}
+
+	w = do("GET", "/policies/"+id+"/diff?from=1&to=2", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var diff PolicyDiff
+	if err := json.Unmarshal(w.Body.Bytes(), &diff); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	want := PolicyDiff{PolicyID: id, From: 1, To: 2, Changes: []policycraft.FieldChange{
+		{Field: "criteria", Before: ">", After: ">="},
+		{Field: "value", Before: float64(17), After: float64(18)},
+	}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("expected diff %+v, got %+v", want, diff)
+	}
+
+	// the history of a policy without changes is empty
+	w = do("GET", "/policies/"+uuid.NewString()+"/history", "", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("expected an empty history, got %d | response: %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{name: "missing from", target: "/policies/" + id + "/diff?to=2", want: http.StatusBadRequest},
+		{name: "invalid to", target: "/policies/" + id + "/diff?from=1&to=last", want: http.StatusBadRequest},
+		{name: "unknown revision", target: "/policies/" + id + "/diff?from=1&to=3", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do("GET", tt.target, "", ""); w.Code != tt.want {
+				t.Errorf("expected status code %d, got %d | response: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+// Package api ...
+// openapi.go gather the OpenAPI document of the API: the handler that serves it and the validation of the routes against it.
+package api
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/perebaj/policycraft"
+)
+
+// openAPISpec is the OpenAPI document, written by hand next to the handlers. The tests keep it in sync with them.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+func init() {
+	// the JSON Schema of the policy sets is JSON, so its responses can be validated
+	openapi3filter.RegisterBodyDecoder("application/schema+json", openapi3filter.JSONBodyDecoder)
+}
+
+// OpenAPIDocument returns the OpenAPI document of the API.
+func OpenAPIDocument() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the OpenAPI document: %v", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %v", err)
+	}
+	return doc, nil
+}
+
+// OpenAPIRoutes returns the routes of the operations of the OpenAPI document as http.ServeMux patterns, such as
+// "GET /policies/{id}", sorted.
+func OpenAPIRoutes(doc *openapi3.T) []string {
+	var routes []string
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			routes = append(routes, method+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// OpenAPIHandler returns a http.HandlerFunc that serves the OpenAPI document as JSON.
+func OpenAPIHandler(doc *openapi3.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendJSON(w, doc, http.StatusOK)
+	}
+}
+
+// OpenAPIValidator validates the requests and the responses of the routes against the OpenAPI document. An invalid request
+// is refused with 400 before reaching the handler. An invalid response is a bug of the handler, so it's only logged and the
+// client still receives it.
+type OpenAPIValidator struct {
+	doc     *openapi3.T
+	options *openapi3filter.Options
+	// invalidResponse is called with the error of every invalid response.
+	invalidResponse func(r *http.Request, pattern string, err error)
+}
+
+// NewOpenAPIValidator returns a validator of the operations of the document. The credentials aren't checked, as they are
+// authenticated before the routing.
+func NewOpenAPIValidator(doc *openapi3.T) *OpenAPIValidator {
+	return &OpenAPIValidator{
+		doc: doc,
+		options: &openapi3filter.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			// the handlers apply their own defaults
+			SkipSettingDefaults: true,
+		},
+		invalidResponse: func(r *http.Request, pattern string, err error) {
+			slog.Error("response doesn't match the OpenAPI document", "route", pattern, "error", err)
+		},
+	}
+}
+
+// Middleware returns a http.HandlerFunc that validates the requests and the responses of next as the operation of the route
+// with the given http.ServeMux pattern, such as "GET /policies/{id}". It panics if the document doesn't have the operation,
+// because the route would be served without being documented.
+func (v *OpenAPIValidator) Middleware(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	method, path, _ := strings.Cut(pattern, " ")
+	item := v.doc.Paths.Value(path)
+	var op *openapi3.Operation
+	if item != nil {
+		op = item.GetOperation(method)
+	}
+	if op == nil {
+		panic(fmt.Sprintf("the route %s isn't in the OpenAPI document", pattern))
+	}
+	route := &routers.Route{Spec: v.doc, Path: path, PathItem: item, Method: method, Operation: op}
+	params := pathParams(path)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := make(map[string]string, len(params))
+		for _, name := range params {
+			values[name] = r.PathValue(name)
+		}
+		input := &openapi3filter.RequestValidationInput{Request: r, PathParams: values, Route: route, Options: v.options}
+		if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			sendJSON(w, requestErrMsg(err), http.StatusBadRequest)
+			return
+		}
+
+		tee := &teeResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next(tee, r)
+
+		err := openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 tee.status,
+			Header:                 w.Header(),
+			Body:                   io.NopCloser(&tee.body),
+			Options:                v.options,
+		})
+		if err != nil {
+			v.invalidResponse(r, pattern, err)
+		}
+	}
+}
+
+// pathParams returns the names of the wildcards of a route path.
+func pathParams(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			names = append(names, strings.TrimSuffix(name, "}"))
+		}
+	}
+	return names
+}
+
+// requestErrMsg returns the error message of a request that doesn't match the OpenAPI document, detailing the invalid field
+// when it's known.
+func requestErrMsg(err error) ErrMsg {
+	var reqErr *openapi3filter.RequestError
+	var schemaErr *openapi3.SchemaError
+	if !errors.As(err, &reqErr) || !errors.As(err, &schemaErr) {
+		return ErrMsg{Msg: err.Error()}
+	}
+	field := strings.Join(schemaErr.JSONPointer(), ".")
+	if reqErr.Parameter != nil {
+		field = reqErr.Parameter.Name
+	}
+	if field == "" {
+		return ErrMsg{Msg: "invalid request body: " + schemaErr.Reason}
+	}
+	return ErrMsg{Msg: "invalid input", Fields: []policycraft.FieldError{{Field: field, Msg: schemaErr.Reason}}}
+}
+
+// teeResponseWriter writes the response and keeps a copy of its status and body, so they can be validated once it's written.
+type teeResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (t *teeResponseWriter) WriteHeader(code int) {
+	if !t.wroteHeader {
+		t.status, t.wroteHeader = code, true
+	}
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *teeResponseWriter) Write(b []byte) (int, error) {
+	t.wroteHeader = true
+	t.body.Write(b)
+	return t.ResponseWriter.Write(b)
+}
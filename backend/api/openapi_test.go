@@ -0,0 +1,273 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/enrichment"
+)
+
+// newValidatedHandler returns a function that serves a request as the given subject, an admin, by the handlers of the API
+// validated against the OpenAPI document. Every invalid response fails the test.
+func newValidatedHandler(t *testing.T, db *MockStorage, propose bool) func(subject, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	doc, err := OpenAPIDocument()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	validator := NewOpenAPIValidator(doc)
+	validator.invalidResponse = func(r *http.Request, pattern string, err error) {
+		t.Errorf("%s %s: invalid response: %v", r.Method, r.URL, err)
+	}
+
+	save, patch := SavePolicyHandler(db), PatchPolicyHandler(db)
+	if propose {
+		save, patch = ProposePolicyHandler(db), ProposePolicyPatchHandler(db)
+	}
+	handlers := map[string]http.HandlerFunc{
+		"POST /policies":                     save,
+		"GET /policies":                      ListPoliciesHandler(db),
+		"GET /policies/export":               ExportPoliciesHandler(db),
+		"GET /policies/{id}":                 GetPolicyHandler(db),
+		"PATCH /policies/{id}":               patch,
+		"DELETE /policies/{id}":              DeletePolicyHandler(db),
+		"POST /policies/{id}/restore":        RestorePolicyHandler(db),
+		"GET /policies/{id}/history":         PolicyHistoryHandler(db),
+		"GET /policies/{id}/diff":            PolicyDiffHandler(db),
+		"POST /policies/import":              ImportPoliciesHandler(db),
+		"GET /operators":                     ListOperatorsHandler(),
+		"GET /policy-sets":                   ListPolicySetsHandler(db),
+		"GET /policy-sets/{name}":            GetPolicySetHandler(db),
+		"PUT /policy-sets/{name}":            SavePolicySetHandler(db),
+		"GET /policy-sets/{name}/schema":     PolicySetSchemaHandler(db),
+		"POST /execution-engine":             ExecutionEngineHandler(db, enrichment.NewEnricher(nil), nil),
+		"GET /decisions":                     ListDecisionsHandler(db),
+		"GET /decisions/{id}":                GetDecisionHandler(db),
+		"POST /decisions/{id}/replay":        ReplayDecisionHandler(db, policycraft.DefaultRegistry),
+		"POST /change-requests":              CreateChangeRequestHandler(db),
+		"GET /change-requests":               ListChangeRequestsHandler(db),
+		"GET /change-requests/{id}":          GetChangeRequestHandler(db),
+		"POST /change-requests/{id}/submit":  SubmitChangeRequestHandler(db),
+		"POST /change-requests/{id}/approve": ApproveChangeRequestHandler(db),
+		"POST /change-requests/{id}/publish": PublishChangeRequestHandler(db),
+		"POST /api-keys":                     CreateAPIKeyHandler(db),
+		"GET /api-keys":                      ListAPIKeysHandler(db),
+		"DELETE /api-keys/{id}":              RevokeAPIKeyHandler(db),
+		"GET /openapi.json":                  OpenAPIHandler(doc),
+	}
+	mux := http.NewServeMux()
+	for pattern, handler := range handlers {
+		mux.HandleFunc(pattern, validator.Middleware(pattern, handler))
+	}
+
+	return func(subject, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req = req.WithContext(policycraft.WithPrincipal(req.Context(), policycraft.Principal{Subject: subject, Roles: []policycraft.Role{policycraft.RoleAdmin}}))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	doc, err := OpenAPIDocument()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	OpenAPIHandler(doc)(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a JSON document, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var served struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if served.OpenAPI != "3.0.3" || served.Paths["/policies/{id}"]["patch"] == nil {
+		t.Errorf("unexpected document: %s", w.Body.String())
+	}
+}
+
+func TestOpenAPIValidatorRequests(t *testing.T) {
+	serve := newValidatedHandler(t, NewMockStorage(), false)
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		wantMsg string
+		wantErr policycraft.FieldError
+	}{
+		{
+			name:    "missing required property",
+			method:  http.MethodPost,
+			target:  "/policies",
+			body:    `{"name":"income","value":1000,"criteria":">","success_case":true,"priority":1}`,
+			wantMsg: "invalid input",
+			wantErr: policycraft.FieldError{Field: "id", Msg: `property "id" is missing`},
+		},
+		{
+			name:    "wrong type of a property",
+			method:  http.MethodPatch,
+			target:  "/policies/f6d0a0a4-6b5c-4a9b-9d0e-1f3a2b4c5d6e",
+			body:    `{"value":"1000"}`,
+			wantMsg: "invalid input",
+			wantErr: policycraft.FieldError{Field: "value", Msg: "value must be an integer"},
+		},
+		{
+			name:    "query parameter out of range",
+			method:  http.MethodGet,
+			target:  "/decisions?limit=0",
+			wantMsg: "invalid input",
+			wantErr: policycraft.FieldError{Field: "limit", Msg: "number must be at least 1"},
+		},
+		{
+			name:    "missing body",
+			method:  http.MethodPut,
+			target:  "/policy-sets/credit",
+			wantMsg: "request body has an error: value is required but missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve("alice", tt.method, tt.target, tt.body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+			}
+			var msg ErrMsg
+			if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if msg.Msg != tt.wantMsg {
+				t.Errorf("expected message %q, got %q", tt.wantMsg, msg.Msg)
+			}
+			if tt.wantErr != (policycraft.FieldError{}) && (len(msg.Fields) != 1 || msg.Fields[0] != tt.wantErr) {
+				t.Errorf("expected fields %v, got %v", tt.wantErr, msg.Fields)
+			}
+		})
+	}
+}
+
+func TestOpenAPIValidatorResponses(t *testing.T) {
+	db := NewMockStorage()
+	serve := newValidatedHandler(t, db, false)
+	expect := func(w *httptest.ResponseRecorder, code int) {
+		t.Helper()
+		if w.Code != code {
+			t.Fatalf("expected %d, got %d: %s", code, w.Code, w.Body.String())
+		}
+	}
+	decode := func(w *httptest.ResponseRecorder, v any) {
+		t.Helper()
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("failed to unmarshal response: %v | response: %s", err, w.Body.String())
+		}
+	}
+	const id = "f6d0a0a4-6b5c-4a9b-9d0e-1f3a2b4c5d6e"
+	policy := `{"id":"` + id + `","name":"income","value":1000,"criteria":">","success_case":true,"priority":1,"policy_set":"credit"}`
+
+	// every handler answers as documented, while the policies go through their lifecycle
+	expect(serve("alice", http.MethodPut, "/policy-sets/credit", `{"description":"credit","input_schema":{"fields":{"income":{"type":"integer","required":true}}}}`), http.StatusOK)
+	expect(serve("alice", http.MethodGet, "/policy-sets", ""), http.StatusOK)
+	expect(serve("alice", http.MethodGet, "/policy-sets/credit", ""), http.StatusOK)
+	expect(serve("alice", http.MethodGet, "/policy-sets/credit/schema", ""), http.StatusOK)
+	expect(serve("alice", http.MethodGet, "/policy-sets/debit", ""), http.StatusNotFound)
+	expect(serve("alice", http.MethodGet, "/operators", ""), http.StatusOK)
+
+	expect(serve("alice", http.MethodPost, "/policies", policy), http.StatusOK)
+	expect(serve("alice", http.MethodGet, "/policies?policy_set=credit&sort=-name&limit=1", ""), http.StatusOK)
+	expect(serve("alice", http.MethodGet, "/policies/"+id, ""), http.StatusOK)
+	expect(serve("alice", http.MethodPatch, "/policies/"+id, `{"value":2000}`), http.StatusOK)
+	expect(serve("alice", http.MethodGet, "/policies/"+id+"/history", ""), http.StatusOK)
+	expect(serve("alice", http.MethodGet, "/policies/"+id+"/diff?from=1&to=2", ""), http.StatusOK)
+	expect(serve("alice", http.MethodGet, "/policies/export?policy_set=credit&format=yaml", ""), http.StatusOK)
+	export := serve("alice", http.MethodGet, "/policies/export?policy_set=credit", "")
+	expect(export, http.StatusOK)
+	expect(serve("alice", http.MethodPost, "/policies/import?dry_run=true", export.Body.String()), http.StatusOK)
+
+	w := serve("alice", http.MethodPost, "/execution-engine?policy_set=credit", `{"income":5000}`)
+	expect(w, http.StatusOK)
+	var execution ExecutionResponse
+	decode(w, &execution)
+	expect(serve("alice", http.MethodGet, "/decisions?policy_set=credit", ""), http.StatusOK)
+	expect(serve("alice", http.MethodGet, "/decisions/"+execution.DecisionID, ""), http.StatusOK)
+	expect(serve("alice", http.MethodPost, "/decisions/"+execution.DecisionID+"/replay", ""), http.StatusOK)
+
+	w = serve("alice", http.MethodPost, "/change-requests", `{"title":"raise income","policies":[`+strings.Replace(policy, "1000", "3000", 1)+`]}`)
+	expect(w, http.StatusCreated)
+	var cr policycraft.ChangeRequest
+	decode(w, &cr)
+	expect(serve("alice", http.MethodGet, "/change-requests?status=draft", ""), http.StatusOK)
+	expect(serve("alice", http.MethodPost, "/change-requests/"+cr.ID+"/submit", ""), http.StatusOK)
+	expect(serve("bob", http.MethodPost, "/change-requests/"+cr.ID+"/approve", ""), http.StatusOK)
+	expect(serve("bob", http.MethodPost, "/change-requests/"+cr.ID+"/publish", ""), http.StatusOK)
+	expect(serve("bob", http.MethodGet, "/change-requests/"+cr.ID, ""), http.StatusOK)
+
+	expect(serve("alice", http.MethodDelete, "/policies/"+id, ""), http.StatusNoContent)
+	expect(serve("alice", http.MethodGet, "/policies/"+id, ""), http.StatusNotFound)
+	expect(serve("alice", http.MethodPost, "/policies/"+id+"/restore", ""), http.StatusOK)
+
+	w = serve("alice", http.MethodPost, "/api-keys", `{"name":"ci","roles":["viewer"]}`)
+	expect(w, http.StatusCreated)
+	var key CreateAPIKeyResponse
+	decode(w, &key)
+	expect(serve("alice", http.MethodGet, "/api-keys", ""), http.StatusOK)
+	expect(serve("alice", http.MethodDelete, "/api-keys/"+key.APIKey.ID, ""), http.StatusNoContent)
+	expect(serve("alice", http.MethodGet, "/openapi.json", ""), http.StatusOK)
+
+	// with the approvals, the policy changes are proposed
+	serve = newValidatedHandler(t, db, true)
+	expect(serve("alice", http.MethodPost, "/policies", strings.Replace(policy, "1000", "4000", 1)), http.StatusCreated)
+	expect(serve("alice", http.MethodPatch, "/policies/"+id, `{"value":5000}`), http.StatusCreated)
+}
+
+func TestOpenAPIValidatorInvalidResponse(t *testing.T) {
+	doc, err := OpenAPIDocument()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	validator := NewOpenAPIValidator(doc)
+	var invalid error
+	validator.invalidResponse = func(r *http.Request, pattern string, err error) { invalid = err }
+
+	// the policy misses its required fields, but the client still receives it
+	handler := validator.Middleware("GET /policies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		sendJSON(w, map[string]string{"id": r.PathValue("id")}, http.StatusOK)
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /policies/{id}", handler)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/policies/1", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != `{"id":"1"}` {
+		t.Errorf("expected the response of the handler, got %d: %s", w.Code, w.Body.String())
+	}
+	if invalid == nil {
+		t.Error("expected the response to be invalid")
+	}
+}
+
+func TestOpenAPIValidatorUndocumentedRoute(t *testing.T) {
+	doc, err := OpenAPIDocument()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	NewOpenAPIValidator(doc).Middleware("GET /undocumented", func(w http.ResponseWriter, r *http.Request) {})
+}
@@ -0,0 +1,342 @@
+// Package api ...
+// policies.go gather the handlers of a single policy: get, partial update, delete and restore.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/perebaj/policycraft"
+)
+
+// GetPolicyHandler returns a http.HandlerFunc that get a policy by its id, with its ETag. A deleted policy isn't found.
+func GetPolicyHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		policy, err := db.Policy(r.Context(), id)
+		if errors.Is(err, policycraft.ErrNotFound) {
+			sendErr(w, "policy not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("failed to get policy", "error", err)
+			sendErr(w, "failed to get policy", http.StatusInternalServerError)
+			return
+		}
+		revision, err := db.LastPolicyRevision(r.Context(), id)
+		if err != nil {
+			slog.Error("failed to get policy revision", "error", err)
+			sendErr(w, "failed to get policy revision", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("ETag", policyETag(revision))
+		sendJSON(w, policy, http.StatusOK)
+	}
+}
+
+// PatchPolicyHandler returns a http.HandlerFunc that updates the fields of a policy present in the request body, keeping the others.
+// The change is recorded in the history of the policy, with the reason of the ChangeReasonHeader, and is refused if the policy
+// changed since the If-Match header, or since it was read. The ETag of the updated policy is returned.
+func PatchPolicyHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		patch, current, base, ok := policyPatch(w, r, db)
+		if !ok {
+			return
+		}
+		patched := patch.apply(current)
+		if err := patched.Validate(); err != nil {
+			sendErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		policy := patched.Entity()
+		if !checkPolicyPriority(w, r, db, policy) {
+			return
+		}
+
+		err := db.UpdatePolicy(revisionContext(r, base), policy)
+		if errors.Is(err, policycraft.ErrNotFound) {
+			// deleted after being read
+			sendErr(w, "policy not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, policycraft.ErrConflict) {
+			sendRevisionConflict(w, r)
+			return
+		}
+		if err != nil {
+			slog.Error("failed to update policy", "error", err)
+			sendErr(w, "failed to update policy", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("ETag", policyETag(revisionAfter(base, &current, policy)))
+		sendJSON(w, policy, http.StatusOK)
+	}
+}
+
+// ProposePolicyPatchHandler returns a http.HandlerFunc that receives a partial update, as PatchPolicyHandler, but instead of
+// saving the policy creates a change request with it, already in review. It replaces PatchPolicyHandler when the approvals are required.
+// The patch is kept in the change request, so the reviewers see the fields it changes.
+func ProposePolicyPatchHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, ok := subject(w, r)
+		if !ok {
+			return
+		}
+		patch, current, base, ok := policyPatch(w, r, db)
+		if !ok {
+			return
+		}
+		patched := patch.apply(current)
+		raw, err := json.Marshal(patch)
+		if err != nil {
+			sendErr(w, "failed to encode the patch", http.StatusInternalServerError)
+			return
+		}
+		cr, code, err := proposePolicy(r.Context(), db, author, fmt.Sprintf("update policy %s", patched.Name), patched, base, raw)
+		if err != nil {
+			sendErr(w, err.Error(), code)
+			return
+		}
+		sendJSON(w, cr, http.StatusCreated)
+	}
+}
+
+// policyPatch returns the partial update of the request body, with the stored policy with the id of the URL path it applies to and
+// the revision the change is based on, see basePolicy. The id can't be changed.
+func policyPatch(w http.ResponseWriter, r *http.Request, db Storage) (Policy, policycraft.Policy, int, bool) {
+	var patch Policy
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		sendErr(w, "invalid request body", http.StatusBadRequest)
+		return Policy{}, policycraft.Policy{}, 0, false
+	}
+	id := r.PathValue("id")
+	if patch.ID != "" && patch.ID != id {
+		sendErr(w, "the id of a policy can't be changed", http.StatusBadRequest)
+		return Policy{}, policycraft.Policy{}, 0, false
+	}
+
+	current, base, ok := basePolicy(w, r, db, id)
+	if !ok {
+		return Policy{}, policycraft.Policy{}, 0, false
+	}
+	if current == nil {
+		sendErr(w, "policy not found", http.StatusNotFound)
+		return Policy{}, policycraft.Policy{}, 0, false
+	}
+	return patch, *current, base, true
+}
+
+// apply returns the policy with the fields of the patch that are present. A string field is present when it isn't empty,
+// and a pointer or slice field when it isn't nil, so an empty function or script removes it.
+func (p Policy) apply(current policycraft.Policy) Policy {
+	policy := Policy{
+		ID:          current.ID,
+		Name:        current.Name,
+		Value:       &current.Value,
+		Criteria:    current.Criteria,
+		Function:    &current.Function,
+		Type:        current.Type,
+		Script:      &current.Script,
+		SuccessCase: &current.SuccessCase,
+		Priority:    &current.Priority,
+		PolicySet:   current.PolicySet,
+		Tags:        current.Tags,
+	}
+	if p.Name != "" {
+		policy.Name = p.Name
+	}
+	if p.Criteria != "" {
+		policy.Criteria = p.Criteria
+	}
+	if p.Function != nil {
+		policy.Function = p.Function
+	}
+	if p.Type != "" {
+		policy.Type = p.Type
+	}
+	if p.Script != nil {
+		policy.Script = p.Script
+	}
+	if p.PolicySet != "" {
+		policy.PolicySet = p.PolicySet
+	}
+	if p.Value != nil {
+		policy.Value = p.Value
+	}
+	if p.SuccessCase != nil {
+		policy.SuccessCase = p.SuccessCase
+	}
+	if p.Priority != nil {
+		policy.Priority = p.Priority
+	}
+	if p.Tags != nil {
+		policy.Tags = p.Tags
+	}
+	return policy
+}
+
+// DeletePolicyHandler returns a http.HandlerFunc that deletes a policy. The policy stops being evaluated, but it's kept, so it can be
+// restored by RestorePolicyHandler. The change is recorded in the history of the policy, with the reason of the ChangeReasonHeader,
+// and is refused if the policy changed since the If-Match header.
+func DeletePolicyHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, base, ok := basePolicy(w, r, db, r.PathValue("id"))
+		if !ok {
+			return
+		}
+		if current == nil {
+			sendErr(w, "policy not found", http.StatusNotFound)
+			return
+		}
+
+		err := db.DeletePolicy(revisionContext(r, base), current.ID)
+		if errors.Is(err, policycraft.ErrNotFound) {
+			// deleted after being read
+			sendErr(w, "policy not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, policycraft.ErrConflict) {
+			sendRevisionConflict(w, r)
+			return
+		}
+		if err != nil {
+			slog.Error("failed to delete policy", "error", err)
+			sendErr(w, "failed to delete policy", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RestorePolicyHandler returns a http.HandlerFunc that restores a deleted policy, as it was when deleted. The restore is refused if
+// another policy took its priority meanwhile. The change is recorded in the history of the policy, with the reason of the ChangeReasonHeader.
+func RestorePolicyHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		policy, err := db.DeletedPolicy(r.Context(), r.PathValue("id"))
+		if errors.Is(err, policycraft.ErrNotFound) {
+			sendErr(w, "deleted policy not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("failed to get deleted policy", "error", err)
+			sendErr(w, "failed to get deleted policy", http.StatusInternalServerError)
+			return
+		}
+		if !checkPolicyPriority(w, r, db, policy) {
+			return
+		}
+
+		err = db.RestorePolicy(changeContext(r), policy.ID)
+		if errors.Is(err, policycraft.ErrNotFound) {
+			// restored after being read
+			sendErr(w, "deleted policy not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("failed to restore policy", "error", err)
+			sendErr(w, "failed to restore policy", http.StatusInternalServerError)
+			return
+		}
+
+		sendJSON(w, policy, http.StatusOK)
+	}
+}
+
+// checkPolicyPriority checks if the priority of the policy is unique in its policy set once it's saved, answering the request if it isn't.
+func checkPolicyPriority(w http.ResponseWriter, r *http.Request, db Storage, policy policycraft.Policy) bool {
+	err := CheckPriorities(r.Context(), db, []policycraft.Policy{policy})
+	var dup DuplicatedPriorityError
+	if errors.As(err, &dup) {
+		sendErr(w, err.Error(), http.StatusConflict)
+		return false
+	}
+	if err != nil {
+		slog.Error("failed to get policies", "error", err)
+		sendErr(w, "failed to get policies", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// policyETag returns the ETag of a policy at the given revision. A policy without history, created before it was recorded, is at
+// revision 0.
+func policyETag(revision int) string {
+	return strconv.Quote(strconv.Itoa(revision))
+}
+
+// basePolicy returns the stored policy with the given id, nil if it doesn't exist, and the revision a change of it is based on: its
+// last revision, which must be the one of the If-Match header when the header is present. Otherwise, the request is answered with
+// 412, or 400 if the header isn't the ETag of a policy, and false is returned.
+func basePolicy(w http.ResponseWriter, r *http.Request, db Storage, id string) (*policycraft.Policy, int, bool) {
+	var expected *int
+	if h := r.Header.Get("If-Match"); h != "" {
+		n, err := strconv.Atoi(strings.Trim(h, `"`))
+		if err != nil || !strings.HasPrefix(h, `"`) || !strings.HasSuffix(h, `"`) {
+			sendErr(w, "If-Match must be the ETag of the policy", http.StatusBadRequest)
+			return nil, 0, false
+		}
+		expected = &n
+	}
+
+	var current *policycraft.Policy
+	policy, err := db.Policy(r.Context(), id)
+	switch {
+	case err == nil:
+		current = &policy
+	case !errors.Is(err, policycraft.ErrNotFound):
+		slog.Error("failed to get policy", "error", err)
+		sendErr(w, "failed to get policy", http.StatusInternalServerError)
+		return nil, 0, false
+	}
+	last, err := db.LastPolicyRevision(r.Context(), id)
+	if err != nil {
+		slog.Error("failed to get policy revision", "error", err)
+		sendErr(w, "failed to get policy revision", http.StatusInternalServerError)
+		return nil, 0, false
+	}
+
+	if expected != nil && (current == nil || *expected != last) {
+		sendErr(w, fmt.Sprintf("the policy changed, its ETag is %s", policyETag(last)), http.StatusPreconditionFailed)
+		return nil, 0, false
+	}
+	return current, last, true
+}
+
+// revisionContext returns the change context of the request, see changeContext, for a change based on the given revision of a policy.
+func revisionContext(r *http.Request, base int) context.Context {
+	info := policycraft.ChangeInfoFromContext(changeContext(r))
+	info.BaseRevision = &base
+	return policycraft.WithChangeInfo(r.Context(), info)
+}
+
+// sendRevisionConflict answers a change refused by the storage because the policy changed after being read: 412 if the change was
+// based on the If-Match header, or 409 if it was based on the read, so the client reads the policy again.
+func sendRevisionConflict(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("If-Match") != "" {
+		sendErr(w, "the policy changed", http.StatusPreconditionFailed)
+		return
+	}
+	sendErr(w, "the policy changed concurrently, read it again", http.StatusConflict)
+}
+
+// revisionAfter returns the last revision of a policy after a change from before to after, based on the revision base.
+// A change that doesn't change any field isn't recorded.
+func revisionAfter(base int, before *policycraft.Policy, after policycraft.Policy) int {
+	if after.Type == "" {
+		// defaulted by the storages
+		after.Type = policycraft.PolicyTypeCriteria
+	}
+	if len(policycraft.DiffPolicies(before, &after)) == 0 {
+		return base
+	}
+	return base + 1
+}
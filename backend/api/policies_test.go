@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+)
+
+// newPolicyHandler returns the routes of a single policy, with the save and the patch saving directly or proposing a change request.
+// The headers are given as name and value pairs.
+func newPolicyHandler(db *MockStorage, propose bool) func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	save, patch := SavePolicyHandler(db), PatchPolicyHandler(db)
+	if propose {
+		save, patch = ProposePolicyHandler(db), ProposePolicyPatchHandler(db)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /policies", save)
+	mux.HandleFunc("GET /policies/{id}", GetPolicyHandler(db))
+	mux.HandleFunc("PATCH /policies/{id}", patch)
+	mux.HandleFunc("DELETE /policies/{id}", DeletePolicyHandler(db))
+	mux.HandleFunc("POST /policies/{id}/restore", RestorePolicyHandler(db))
+
+	return func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		req = req.WithContext(policycraft.WithPrincipal(req.Context(), policycraft.Principal{Subject: "alice", Roles: []policycraft.Role{policycraft.RoleEditor}}))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+}
+
+func decodePolicy(t *testing.T, w *httptest.ResponseRecorder) policycraft.Policy {
+	t.Helper()
+	var p policycraft.Policy
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("failed to unmarshal response: %v | response: %s", err, w.Body.String())
+	}
+	return p
+}
+
+func TestPolicyHandlers(t *testing.T) {
+	db := NewMockStorage()
+	age := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	income := policycraft.Policy{ID: uuid.NewString(), Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	db.addPolicies(t, []policycraft.Policy{age, income})
+	do := newPolicyHandler(db, false)
+
+	w := do("GET", "/policies/"+age.ID, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if got := decodePolicy(t, w); !reflect.DeepEqual(got, age) {
+		t.Errorf("expected policy %+v, got %+v", age, got)
+	}
+
+	// only the fields present are changed, including the success_case and the priority
+	w = do("PATCH", "/policies/"+age.ID, `{"value": 18, "success_case": false, "priority": 3}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	want := age
+	want.Value, want.SuccessCase, want.Priority = 18, false, 3
+	if got := decodePolicy(t, w); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected policy %+v, got %+v", want, got)
+	}
+	stored, err := db.Policy(context.Background(), age.ID)
+	if err != nil || !reflect.DeepEqual(stored, want) {
+		t.Errorf("expected stored policy %+v, got %+v (%v)", want, stored, err)
+	}
+
+	// the tags are sorted without repetitions, and kept by the patches without them
+	w = do("PATCH", "/policies/"+age.ID, `{"tags": ["risk", "kyc", "risk"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	w = do("PATCH", "/policies/"+age.ID, `{"value": 19}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	want.Value, want.Tags = 19, policycraft.Tags{"kyc", "risk"}
+	if got := decodePolicy(t, w); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected policy %+v, got %+v", want, got)
+	}
+
+	w = do("DELETE", "/policies/"+income.ID, "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	// the priority of the deleted policy can be taken, and then it can't be restored
+	w = do("PATCH", "/policies/"+age.ID, `{"priority": 2}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	w = do("POST", "/policies/"+income.ID+"/restore", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusConflict, w.Code, w.Body.String())
+	}
+	w = do("PATCH", "/policies/"+age.ID, `{"priority": 1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	w = do("POST", "/policies/"+income.ID+"/restore", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if got := decodePolicy(t, w); !reflect.DeepEqual(got, income) {
+		t.Errorf("expected policy %+v, got %+v", income, got)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{name: "get unknown", method: "GET", target: "/policies/" + uuid.NewString(), want: http.StatusNotFound},
+		{name: "patch unknown", method: "PATCH", target: "/policies/" + uuid.NewString(), body: `{"value": 1}`, want: http.StatusNotFound},
+		{name: "patch the id", method: "PATCH", target: "/policies/" + age.ID, body: `{"id": "` + income.ID + `"}`, want: http.StatusBadRequest},
+		{name: "patch an invalid criteria", method: "PATCH", target: "/policies/" + age.ID, body: `{"criteria": "~"}`, want: http.StatusBadRequest},
+		{name: "patch a duplicated priority", method: "PATCH", target: "/policies/" + age.ID, body: `{"priority": 2}`, want: http.StatusConflict},
+		{name: "patch an invalid tag", method: "PATCH", target: "/policies/" + age.ID, body: `{"tags": [""]}`, want: http.StatusBadRequest},
+		{name: "patch an invalid body", method: "PATCH", target: "/policies/" + age.ID, body: `{`, want: http.StatusBadRequest},
+		{name: "delete unknown", method: "DELETE", target: "/policies/" + uuid.NewString(), want: http.StatusNotFound},
+		{name: "restore a policy not deleted", method: "POST", target: "/policies/" + age.ID + "/restore", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(tt.method, tt.target, tt.body); w.Code != tt.want {
+				t.Errorf("expected status code %d, got %d | response: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPatchPolicyRemovesFunctionAndScript(t *testing.T) {
+	// the function is registered only once in the default registry, shared by the whole test binary
+	err := policycraft.RegisterFunction("api_test_abs", func(input interface{}) (interface{}, error) { return input, nil })
+	if err != nil {
+		t.Fatalf("failed to register function: %v", err)
+	}
+	db := NewMockStorage()
+	age := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Function: "api_test_abs", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	income := policycraft.Policy{ID: uuid.NewString(), Name: "income", Type: policycraft.PolicyTypeStarlark, Script: "def condition(input):\n  return input['income'] > 1000\n", SuccessCase: true, Priority: 2, PolicySet: "loans"}
+	db.addPolicies(t, []policycraft.Policy{age, income})
+	do := newPolicyHandler(db, false)
+
+	// a patch without the function keeps it, an empty one removes it
+	w := do("PATCH", "/policies/"+age.ID, `{"value": 18}`)
+	if got := decodePolicy(t, w); got.Function != "api_test_abs" {
+		t.Fatalf("expected the function to be kept, got %+v", got)
+	}
+	w = do("PATCH", "/policies/"+age.ID, `{"function": ""}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if got := decodePolicy(t, w); got.Function != "" || got.Value != 18 {
+		t.Errorf("expected the function to be removed, got %+v", got)
+	}
+
+	// the script of a policy changed back to criteria is removed with it
+	w = do("PATCH", "/policies/"+income.ID, `{"type": "criteria", "criteria": ">", "value": 1000, "script": ""}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	stored, err := db.Policy(context.Background(), income.ID)
+	if err != nil || stored.Script != "" || stored.Type != policycraft.PolicyTypeCriteria {
+		t.Errorf("expected the script to be removed, got %+v (%v)", stored, err)
+	}
+}
+
+func TestPolicyETag(t *testing.T) {
+	db := NewMockStorage()
+	age := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	db.addPolicies(t, []policycraft.Policy{age})
+	do := newPolicyHandler(db, false)
+
+	assertResponse := func(w *httptest.ResponseRecorder, code int, etag string) {
+		t.Helper()
+		if w.Code != code {
+			t.Fatalf("expected status code %d, got %d | response: %s", code, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("ETag"); got != etag {
+			t.Fatalf("expected ETag %s, got %s", etag, got)
+		}
+	}
+
+	assertResponse(do("GET", "/policies/"+age.ID, ""), http.StatusOK, `"1"`)
+
+	// a change based on an old revision is refused
+	assertResponse(do("PATCH", "/policies/"+age.ID, `{"value": 18}`, "If-Match", `"0"`), http.StatusPreconditionFailed, "")
+	assertResponse(do("PATCH", "/policies/"+age.ID, `{"value": 18}`, "If-Match", `"1"`), http.StatusOK, `"2"`)
+	// a patch that changes nothing keeps the revision
+	assertResponse(do("PATCH", "/policies/"+age.ID, `{"value": 18}`, "If-Match", `"2"`), http.StatusOK, `"2"`)
+
+	// the save replaces every field, including the success_case and the priority
+	body := `{"id": "` + age.ID + `", "name": "age", "criteria": ">", "value": 21, "success_case": false, "priority": 5, "policy_set": "loans"}`
+	assertResponse(do("POST", "/policies", body, "If-Match", `"1"`), http.StatusPreconditionFailed, "")
+	assertResponse(do("POST", "/policies", body, "If-Match", `"2"`), http.StatusOK, `"3"`)
+	want := age
+	want.Value, want.SuccessCase, want.Priority = 21, false, 5
+	stored, err := db.Policy(context.Background(), age.ID)
+	if err != nil || !reflect.DeepEqual(stored, want) {
+		t.Errorf("expected stored policy %+v, got %+v (%v)", want, stored, err)
+	}
+
+	// a new policy doesn't match any ETag
+	income := uuid.NewString()
+	created := `{"id": "` + income + `", "name": "income", "criteria": ">", "value": 1000, "success_case": true, "priority": 2, "policy_set": "loans"}`
+	assertResponse(do("POST", "/policies", created, "If-Match", `"0"`), http.StatusPreconditionFailed, "")
+	assertResponse(do("POST", "/policies", created), http.StatusOK, `"1"`)
+
+	assertResponse(do("DELETE", "/policies/"+age.ID, "", "If-Match", "3"), http.StatusBadRequest, "")
+	assertResponse(do("DELETE", "/policies/"+age.ID, "", "If-Match", `"2"`), http.StatusPreconditionFailed, "")
+	assertResponse(do("DELETE", "/policies/"+age.ID, "", "If-Match", `"3"`), http.StatusNoContent, "")
+
+	// the If-Match header of a proposed change is checked when it's proposed, and a deleted policy doesn't match any ETag
+	propose := newPolicyHandler(db, true)
+	assertResponse(propose("POST", "/policies", body, "If-Match", `"3"`), http.StatusPreconditionFailed, "")
+	assertResponse(propose("PATCH", "/policies/"+income, `{"value": 2000}`, "If-Match", `"0"`), http.StatusPreconditionFailed, "")
+	if w := propose("PATCH", "/policies/"+income, `{"value": 2000}`, "If-Match", `"1"`); w.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+}
+
+func TestProposePolicyPatchHandler(t *testing.T) {
+	db := NewMockStorage()
+	age := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	db.addPolicies(t, []policycraft.Policy{age})
+	do := newPolicyHandler(db, true)
+
+	w := do("PATCH", "/policies/"+age.ID, `{"value": 18}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	cr := decodeChangeRequest(t, w)
+	want := age
+	want.Value = 18
+	if cr.Status != policycraft.ChangeStatusInReview || cr.Author != "alice" || len(cr.Policies) != 1 || !reflect.DeepEqual(cr.Policies[0], want) {
+		t.Errorf("unexpected change request %+v", cr)
+	}
+	// the policy is only changed when the change request is published
+	stored, err := db.Policy(context.Background(), age.ID)
+	if err != nil || !reflect.DeepEqual(stored, age) {
+		t.Errorf("expected stored policy %+v, got %+v (%v)", age, stored, err)
+	}
+}
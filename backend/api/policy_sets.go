@@ -0,0 +1,127 @@
+// Package api ...
+// policy_sets.go gather the handlers related to the policy sets.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/perebaj/policycraft"
+)
+
+// PolicySet is the struct that represents the policy set entity in the API. The name comes from the URL path.
+type PolicySet struct {
+	// Description is a free text describing the purpose of the policy set.
+	Description string `json:"description"`
+	// InputSchema declares the custom fields accepted by the policy set.
+	InputSchema policycraft.InputSchema `json:"input_schema"`
+	// TimeoutMS is the evaluation deadline in milliseconds. Zero means no deadline.
+	TimeoutMS int `json:"timeout_ms"`
+	// FailMode is the decision taken when the deadline is hit. It can be: open or closed.
+	FailMode string `json:"fail_mode"`
+	// Enrichments are the providers that fetch extra custom fields before the evaluation.
+	Enrichments []policycraft.EnrichmentProvider `json:"enrichments"`
+	// Tests are the examples of inputs and their expected decisions, run before a bundle of the policy set is imported.
+	Tests []policycraft.PolicyTest `json:"tests"`
+}
+
+// SavePolicySetHandler returns a http.HandlerFunc that receive a policy set and save it to the database
+func SavePolicySetHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var set PolicySet
+		err := json.NewDecoder(r.Body).Decode(&set)
+		if err != nil {
+			sendErr(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		policySet := policycraft.PolicySet{
+			Name:        r.PathValue("name"),
+			Description: set.Description,
+			InputSchema: set.InputSchema,
+			TimeoutMS:   set.TimeoutMS,
+			FailMode:    set.FailMode,
+			Enrichments: set.Enrichments,
+			Tests:       set.Tests,
+		}
+		err = policySet.Check()
+		if err != nil {
+			sendErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = db.SavePolicySet(r.Context(), policySet)
+		if err != nil {
+			slog.Error("failed to save policy set", "error", err)
+			sendErr(w, "failed to save policy set", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// ListPolicySetsHandler returns a http.HandlerFunc that get all the policy sets
+func ListPolicySetsHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sets, err := db.PolicySets(r.Context())
+		if err != nil {
+			slog.Error("failed to get policy sets", "error", err)
+			sendErr(w, "failed to get policy sets", http.StatusInternalServerError)
+			return
+		}
+		if sets == nil {
+			sets = []policycraft.PolicySet{}
+		}
+
+		sendJSON(w, sets, http.StatusOK)
+	}
+}
+
+// GetPolicySetHandler returns a http.HandlerFunc that get a policy set by its name
+func GetPolicySetHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		set, ok := getPolicySet(r.Context(), w, db, r.PathValue("name"))
+		if !ok {
+			return
+		}
+
+		sendJSON(w, set, http.StatusOK)
+	}
+}
+
+// PolicySetSchemaHandler returns a http.HandlerFunc that export the input schema of a policy set as a JSON Schema document
+// The fields filled by the enrichment providers are not part of the document, because the caller can't send them.
+func PolicySetSchemaHandler(db Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		set, ok := getPolicySet(r.Context(), w, db, r.PathValue("name"))
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/schema+json")
+		b, err := json.Marshal(set.CallerInputSchema().JSONSchema(set.Name))
+		if err != nil {
+			slog.Error("failed to marshal json schema", "error", err)
+			sendErr(w, "failed to marshal json schema", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(b)
+	}
+}
+
+// getPolicySet get a policy set from the storage, sending the error response when it fails.
+func getPolicySet(ctx context.Context, w http.ResponseWriter, db Storage, name string) (policycraft.PolicySet, bool) {
+	set, err := db.PolicySet(ctx, name)
+	if errors.Is(err, policycraft.ErrNotFound) {
+		sendErr(w, "policy set not found", http.StatusNotFound)
+		return policycraft.PolicySet{}, false
+	}
+	if err != nil {
+		slog.Error("failed to get policy set", "error", err)
+		sendErr(w, "failed to get policy set", http.StatusInternalServerError)
+		return policycraft.PolicySet{}, false
+	}
+	return set, true
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/enrichment"
+)
+
+func TestExecutionEngineHandlerInputSchema(t *testing.T) {
+	minimum := float64(0)
+	db := NewMockStorage()
+	db.addPolicies(t, []policycraft.Policy{
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"},
+	})
+	db.addPolicySets(t, []policycraft.PolicySet{
+		{
+			Name: "credit",
+			InputSchema: policycraft.InputSchema{
+				Fields: map[string]policycraft.FieldSchema{
+					"age": {Type: policycraft.FieldTypeInteger, Required: true, Minimum: &minimum},
+				},
+			},
+		},
+	})
+	handler := ExecutionEngineHandler(db, enrichment.NewEnricher(nil), nil)
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+		fields   []string
+	}{
+		{name: "Valid input", body: `{"age": 20}`, expected: http.StatusOK},
+		{name: "String instead of integer", body: `{"age": "20"}`, expected: http.StatusBadRequest, fields: []string{"age"}},
+		{name: "Out of range", body: `{"age": -1}`, expected: http.StatusBadRequest, fields: []string{"age"}},
+		{name: "Misspelled field", body: `{"agee": 20}`, expected: http.StatusBadRequest, fields: []string{"age", "agee"}},
+		{name: "Invalid request body", body: `{"age"`, expected: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/execution-engine?policy_set=credit", strings.NewReader(test.body))
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != test.expected {
+				t.Fatalf("expected status code %d, got %d | response: %s", test.expected, w.Code, w.Body.String())
+			}
+			if test.fields == nil {
+				return
+			}
+
+			var errMsg ErrMsg
+			err := json.Unmarshal(w.Body.Bytes(), &errMsg)
+			if err != nil {
+				t.Fatalf("failed to unmarshal error message: %v", err)
+			}
+			if len(errMsg.Fields) != len(test.fields) {
+				t.Fatalf("expected %d field errors, got %v", len(test.fields), errMsg.Fields)
+			}
+			for i, field := range test.fields {
+				if errMsg.Fields[i].Field != field {
+					t.Fatalf("expected error in field %s, got %s", field, errMsg.Fields[i].Field)
+				}
+			}
+		})
+	}
+}
+
+func TestExecutionEngineHandlerInvalidInputWithoutSchema(t *testing.T) {
+	db := NewMockStorage()
+	db.addPolicies(t, []policycraft.Policy{
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: policycraft.DefaultPolicySet},
+	})
+	handler := ExecutionEngineHandler(db, enrichment.NewEnricher(nil), nil)
+
+	req := httptest.NewRequest("POST", "/execution-engine", strings.NewReader(`{"age": "20"}`))
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestSavePolicySetHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{name: "Valid policy set", body: `{"description": "credit", "input_schema": {"fields": {"age": {"type": "integer", "required": true}}}}`, expected: http.StatusOK},
+		{name: "Invalid field type", body: `{"input_schema": {"fields": {"age": {"type": "int"}}}}`, expected: http.StatusBadRequest},
+		{name: "Invalid enrichment", body: `{"enrichments": [{"name": "bureau", "url": "/score", "field": "score"}]}`, expected: http.StatusBadRequest},
+		{name: "Invalid request body", body: `"invalid"`, expected: http.StatusBadRequest},
+	}
+
+	db := NewMockStorage()
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /policy-sets/{name}", SavePolicySetHandler(db))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/policy-sets/credit", strings.NewReader(test.body))
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, req)
+
+			if w.Code != test.expected {
+				t.Fatalf("expected status code %d, got %d | response: %s", test.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	sets := db.allPolicySets(t)
+	if len(sets) != 1 || sets[0].Name != "credit" {
+		t.Fatalf("expected the credit policy set to be saved, got %v", sets)
+	}
+}
+
+func TestPolicySetSchemaHandler(t *testing.T) {
+	db := NewMockStorage()
+	db.addPolicySets(t, []policycraft.PolicySet{
+		{
+			Name: "credit",
+			InputSchema: policycraft.InputSchema{
+				Fields: map[string]policycraft.FieldSchema{
+					"age": {Type: policycraft.FieldTypeInteger, Required: true},
+				},
+			},
+		},
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /policy-sets/{name}/schema", PolicySetSchemaHandler(db))
+
+	req := httptest.NewRequest("GET", "/policy-sets/credit/schema", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var schema map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &schema)
+	if err != nil {
+		t.Fatalf("failed to unmarshal schema: %v", err)
+	}
+	if schema["title"] != "credit" || schema["type"] != "object" {
+		t.Fatalf("unexpected schema: %v", schema)
+	}
+
+	req = httptest.NewRequest("GET", "/policy-sets/missing/schema", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestExecutionEngineHandlerDeadline(t *testing.T) {
+	tests := []struct {
+		name     string
+		set      policycraft.PolicySet
+		delay    time.Duration
+		decision bool
+		timedOut bool
+	}{
+		{
+			name:     "Deadline not hit",
+			set:      policycraft.PolicySet{Name: "credit", TimeoutMS: 1000, FailMode: policycraft.FailOpen},
+			decision: false,
+		},
+		{
+			name:     "Fail open",
+			set:      policycraft.PolicySet{Name: "credit", TimeoutMS: 10, FailMode: policycraft.FailOpen},
+			delay:    time.Second,
+			decision: true,
+			timedOut: true,
+		},
+		{
+			name:     "Fail closed",
+			set:      policycraft.PolicySet{Name: "credit", TimeoutMS: 10, FailMode: policycraft.FailClosed},
+			delay:    time.Second,
+			decision: false,
+			timedOut: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := NewMockStorage()
+			db.delay = test.delay
+			db.addPolicySets(t, []policycraft.PolicySet{test.set})
+			db.addPolicies(t, []policycraft.Policy{
+				{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"},
+			})
+			handler := ExecutionEngineHandler(db, enrichment.NewEnricher(nil), nil)
+
+			req := httptest.NewRequest("POST", "/execution-engine?policy_set=credit", strings.NewReader(`{"age": 16}`))
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			var resp ExecutionResponse
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if resp.Decision != test.decision || resp.TimedOut != test.timedOut {
+				t.Fatalf("expected decision %t and timed out %t, got %+v", test.decision, test.timedOut, resp)
+			}
+			decisions := db.allDecisions(t)
+			if len(decisions) != 1 || decisions[0].TimedOut != test.timedOut {
+				t.Fatalf("expected the decision to be saved with timed out %t, got %v", test.timedOut, decisions)
+			}
+		})
+	}
+}
+
+func TestExecutionEngineHandlerEnrichment(t *testing.T) {
+	bureau := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"score": 700}`))
+	}))
+	defer bureau.Close()
+
+	tests := []struct {
+		name     string
+		provider policycraft.EnrichmentProvider
+		body     string
+		expected int
+		decision bool
+		source   string
+	}{
+		{
+			name:     "Fetched value",
+			provider: policycraft.EnrichmentProvider{Name: "bureau", URL: bureau.URL, Field: "score"},
+			body:     `{"age": 20}`,
+			expected: http.StatusOK,
+			decision: true,
+			source:   policycraft.EnrichmentSourceProvider,
+		},
+		{
+			name:     "Fallback value",
+			provider: policycraft.EnrichmentProvider{Name: "bureau", URL: bureau.URL + "/down", Field: "score", Fallback: float64(0)},
+			body:     `{"age": 20}`,
+			expected: http.StatusOK,
+			decision: false,
+			source:   policycraft.EnrichmentSourceFallback,
+		},
+		{
+			name:     "Provider down without fallback",
+			provider: policycraft.EnrichmentProvider{Name: "bureau", URL: bureau.URL + "/down", Field: "score"},
+			body:     `{"age": 20}`,
+			expected: http.StatusBadGateway,
+		},
+		{
+			name:     "Enriched field sent by the caller",
+			provider: policycraft.EnrichmentProvider{Name: "bureau", URL: bureau.URL, Field: "score"},
+			body:     `{"age": 20, "score": 900}`,
+			expected: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := NewMockStorage()
+			db.addPolicySets(t, []policycraft.PolicySet{{
+				Name: "credit",
+				InputSchema: policycraft.InputSchema{Fields: map[string]policycraft.FieldSchema{
+					"age":   {Type: policycraft.FieldTypeInteger, Required: true},
+					"score": {Type: policycraft.FieldTypeInteger, Required: true},
+				}},
+				Enrichments: []policycraft.EnrichmentProvider{test.provider},
+			}})
+			db.addPolicies(t, []policycraft.Policy{
+				{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"},
+				{ID: uuid.NewString(), Name: "score", Criteria: ">", Value: 500, SuccessCase: true, Priority: 2, PolicySet: "credit"},
+			})
+			handler := ExecutionEngineHandler(db, enrichment.NewEnricher(bureau.Client()), nil)
+
+			req := httptest.NewRequest("POST", "/execution-engine?policy_set=credit", strings.NewReader(test.body))
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != test.expected {
+				t.Fatalf("expected status code %d, got %d | response: %s", test.expected, w.Code, w.Body.String())
+			}
+			if test.expected != http.StatusOK {
+				return
+			}
+			var resp ExecutionResponse
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if resp.Decision != test.decision {
+				t.Fatalf("expected decision %t, got %+v", test.decision, resp)
+			}
+			decisions := db.allDecisions(t)
+			if len(decisions) != 1 || len(decisions[0].Enrichments) != 1 || decisions[0].Enrichments[0].Source != test.source {
+				t.Fatalf("expected the enrichment from the %s to be saved, got %v", test.source, decisions)
+			}
+			if _, ok := decisions[0].Input["score"]; !ok {
+				t.Fatalf("expected the enriched field in the decision input, got %v", decisions[0].Input)
+			}
+		})
+	}
+}
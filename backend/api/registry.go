@@ -0,0 +1,27 @@
+// Package api ...
+// registry.go gather the handlers related to the operators and functions registry.
+package api
+
+import (
+	"net/http"
+
+	"github.com/perebaj/policycraft"
+)
+
+// RegistryResponse is the struct that represents the operators and functions that policies can reference.
+type RegistryResponse struct {
+	// Operators are the names accepted in the policy criteria field.
+	Operators []string `json:"operators"`
+	// Functions are the names accepted in the policy function field.
+	Functions []string `json:"functions"`
+}
+
+// ListOperatorsHandler returns a http.HandlerFunc that list the operators and functions registered in the policycraft.DefaultRegistry
+func ListOperatorsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		sendJSON(w, RegistryResponse{
+			Operators: policycraft.DefaultRegistry.Operators(),
+			Functions: policycraft.DefaultRegistry.Functions(),
+		}, http.StatusOK)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+)
+
+func TestSavePolicyHandlerRegisteredOperator(t *testing.T) {
+	// the operator is registered only once in the default registry, shared by the whole test binary
+	err := policycraft.RegisterOperator("api_test_even", policycraft.IntOperator(func(input, _ int) bool { return input%2 == 0 }))
+	if err != nil {
+		t.Fatalf("failed to register operator: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		criteria string
+		function string
+		expected int
+	}{
+		{name: "Registered operator", criteria: "api_test_even", expected: http.StatusOK},
+		{name: "Unknown operator", criteria: "api_test_odd", expected: http.StatusBadRequest},
+		{name: "Unknown function", criteria: ">", function: "api_test_len", expected: http.StatusBadRequest},
+	}
+
+	handler := SavePolicyHandler(NewMockStorage())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, successCase, priority := 1, true, 1
+			var buf bytes.Buffer
+			err := json.NewEncoder(&buf).Encode(Policy{
+				ID:          uuid.NewString(),
+				Name:        "number",
+				Value:       &value,
+				Criteria:    test.criteria,
+				Function:    &test.function,
+				SuccessCase: &successCase,
+				Priority:    &priority,
+			})
+			if err != nil {
+				t.Fatalf("failed to encode policy: %v", err)
+			}
+
+			req := httptest.NewRequest("POST", "/policies", &buf)
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != test.expected {
+				t.Fatalf("expected status code %d, got %d | response: %s", test.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/operators", nil)
+	w := httptest.NewRecorder()
+	ListOperatorsHandler()(w, req)
+
+	var resp RegistryResponse
+	err = json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if fmt.Sprint(resp.Operators) != fmt.Sprint([]string{"<", "<=", "==", ">", ">=", "api_test_even"}) {
+		t.Fatalf("unexpected operators: %v", resp.Operators)
+	}
+}
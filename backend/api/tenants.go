@@ -0,0 +1,46 @@
+// Package api ...
+// tenants.go gather the resolution of the tenant of the requests.
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/perebaj/policycraft"
+)
+
+// TenantHeader is the header used to choose the tenant of the request. If it's not sent, the default tenant is used.
+const TenantHeader = "X-Tenant-ID"
+
+// TenantMiddleware resolves the tenant of the request and carries it in the request context (see policycraft.WithTenant),
+// so every handler only reads and writes the entities of that tenant. A request with an invalid tenant is refused.
+func TenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, code, err := ResolveTenant(r.Context(), r.Header.Get(TenantHeader))
+		if err != nil {
+			sendErr(w, err.Error(), code)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(policycraft.WithTenant(r.Context(), tenant)))
+	})
+}
+
+// ResolveTenant returns the tenant of a request asking for the given tenant, the default tenant if it's empty.
+// When the principal of the context belongs to a tenant, that tenant is used, and asking for another one is refused with 403.
+// On failure, it returns the HTTP status code of the error.
+func ResolveTenant(ctx context.Context, tenant string) (string, int, error) {
+	if principal, ok := policycraft.PrincipalFromContext(ctx); ok && principal.Tenant != "" {
+		if tenant != "" && tenant != principal.Tenant {
+			return "", http.StatusForbidden, errors.New("the credentials don't belong to the tenant " + tenant)
+		}
+		tenant = principal.Tenant
+	}
+	if tenant == "" {
+		tenant = policycraft.DefaultTenant
+	}
+	if err := policycraft.CheckTenant(tenant); err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	return tenant, http.StatusOK, nil
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/enrichment"
+)
+
+func TestTenantMiddlewareIsolation(t *testing.T) {
+	db := NewMockStorage()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /policies", SavePolicyHandler(db))
+	mux.HandleFunc("GET /policies", ListPoliciesHandler(db))
+	mux.HandleFunc("GET /policy-sets/{name}", GetPolicySetHandler(db))
+	mux.HandleFunc("PUT /policy-sets/{name}", SavePolicySetHandler(db))
+	mux.HandleFunc("POST /execution-engine", ExecutionEngineHandler(db, enrichment.NewEnricher(nil), nil))
+	mux.HandleFunc("GET /decisions", ListDecisionsHandler(db))
+	mux.HandleFunc("GET /decisions/{id}", GetDecisionHandler(db))
+	mux.HandleFunc("POST /decisions/{id}/replay", ReplayDecisionHandler(db, policycraft.DefaultRegistry))
+	handler := TenantMiddleware(mux)
+
+	do := func(tenant, method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req := httptest.NewRequest(method, target, r)
+		if tenant != "" {
+			req.Header.Set(TenantHeader, tenant)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	policy := `{"id": "` + uuid.NewString() + `", "name": "age", "criteria": ">", "value": 17, "success_case": true, "priority": 1, "policy_set": "loans"}`
+	if w := do("credit", "POST", "/policies", policy); w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d saving the policy, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := do("credit", "PUT", "/policy-sets/loans", `{"description": "credit"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d saving the policy set, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	w := do("credit", "POST", "/execution-engine?policy_set=loans", `{"age": 18}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d executing the policy set, got %d | response: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var execution ExecutionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &execution); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	// the other tenants, including the default one, can't see nor execute the policies of the tenant
+	for _, tenant := range []string{"insurance", ""} {
+		if w := do(tenant, "GET", "/policies", ""); strings.Contains(w.Body.String(), "age") {
+			t.Fatalf("tenant %q listed the policies of another tenant: %s", tenant, w.Body.String())
+		}
+		if w := do(tenant, "GET", "/policy-sets/loans", ""); w.Code != http.StatusNotFound {
+			t.Fatalf("tenant %q: expected status code %d getting the policy set, got %d", tenant, http.StatusNotFound, w.Code)
+		}
+		if w := do(tenant, "POST", "/execution-engine?policy_set=loans", `{"age": 18}`); w.Code == http.StatusOK {
+			t.Fatalf("tenant %q executed the policies of another tenant: %s", tenant, w.Body.String())
+		}
+		if w := do(tenant, "GET", "/decisions/"+execution.DecisionID, ""); w.Code != http.StatusNotFound {
+			t.Fatalf("tenant %q: expected status code %d getting the decision, got %d", tenant, http.StatusNotFound, w.Code)
+		}
+		if w := do(tenant, "POST", "/decisions/"+execution.DecisionID+"/replay", ""); w.Code != http.StatusNotFound {
+			t.Fatalf("tenant %q: expected status code %d replaying the decision, got %d", tenant, http.StatusNotFound, w.Code)
+		}
+		if w := do(tenant, "GET", "/decisions", ""); strings.Contains(w.Body.String(), execution.DecisionID) {
+			t.Fatalf("tenant %q listed the decisions of another tenant: %s", tenant, w.Body.String())
+		}
+	}
+
+	if w := do("credit", "GET", "/decisions/"+execution.DecisionID, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d getting the decision, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestTenantMiddlewareInvalidTenant(t *testing.T) {
+	handler := TenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("the request with an invalid tenant must not be handled")
+	}))
+
+	for _, tenant := range []string{"Credit", "../credit", "credit unit", strings.Repeat("a", 64)} {
+		req := httptest.NewRequest("GET", "/policies", nil)
+		req.Header.Set(TenantHeader, tenant)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("tenant %q: expected status code %d, got %d", tenant, http.StatusBadRequest, w.Code)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Package policycraft ...
+// auth.go gather the roles, the principal that makes a request and the API keys.
+package policycraft
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Role is a set of permissions given to a principal.
+type Role string
+
+// The roles, from the least to the most powerful. A role also has the permissions of the roles it includes, see Principal.HasRole.
+const (
+	// RoleExecutor executes the policy sets. It's the role of the services that ask for decisions.
+	RoleExecutor Role = "executor"
+	// RoleMetrics reads the metrics of the service. It's the role of the Prometheus scrapers.
+	RoleMetrics Role = "metrics"
+	// RoleViewer reads the policies, policy sets and decisions.
+	RoleViewer Role = "viewer"
+	// RoleEditor changes the policies and policy sets. It includes the viewer role.
+	RoleEditor Role = "editor"
+	// RolePublisher publishes the policy sets, replacing them at once. It includes the editor role.
+	RolePublisher Role = "publisher"
+	// RoleAdmin manages the API keys. It includes every other role.
+	RoleAdmin Role = "admin"
+)
+
+// includedRoles maps each role to the roles it includes.
+var includedRoles = map[Role][]Role{
+	RoleExecutor:  {RoleExecutor},
+	RoleMetrics:   {RoleMetrics},
+	RoleViewer:    {RoleViewer},
+	RoleEditor:    {RoleEditor, RoleViewer},
+	RolePublisher: {RolePublisher, RoleEditor, RoleViewer},
+	RoleAdmin:     {RoleAdmin, RolePublisher, RoleEditor, RoleViewer, RoleExecutor, RoleMetrics},
+}
+
+// CheckRoles checks if every role is known and there is at least one.
+func CheckRoles(roles []Role) error {
+	if len(roles) == 0 {
+		return fmt.Errorf("at least one role is required")
+	}
+	for _, role := range roles {
+		if _, ok := includedRoles[role]; !ok {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+// Principal is who makes a request, identified by an API key or a JWT.
+type Principal struct {
+	// Subject identifies the principal: the API key id or the JWT subject.
+	Subject string `json:"subject"`
+	// Tenant is the tenant the principal belongs to. Empty means any tenant, which is only given to the operator key.
+	Tenant string `json:"tenant,omitempty"`
+	// Roles are the roles given to the principal.
+	Roles []Role `json:"roles"`
+}
+
+// HasRole tells if any role of the principal is or includes the given role.
+func (p Principal) HasRole(role Role) bool {
+	for _, r := range p.Roles {
+		for _, included := range includedRoles[r] {
+			if included == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the context that carries the principal.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal carried by the context, if any.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// APIKey is a credential of a tenant. Only the hash of the secret is stored, the secret is shown once, when the key is created.
+type APIKey struct {
+	// ID is the unique identifier of the API key.
+	ID string `json:"id"`
+	// Name is a free text telling who uses the key.
+	Name string `json:"name"`
+	// Tenant is the tenant of the key. It's filled by the storage.
+	Tenant string `json:"tenant"`
+	// Roles are the roles given to the requests made with the key.
+	Roles []Role `json:"roles"`
+	// Hash is the hash of the secret, see HashAPIKey.
+	Hash string `json:"-"`
+	// CreatedAt is the time when the key was created.
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is the time when the key stops working. Nil means never.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// RevokedAt is the time when the key was revoked. A revoked key doesn't work anymore.
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active tells if the key can be used at the given time.
+func (k APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// Principal returns the principal of the requests made with the key.
+func (k APIKey) Principal() Principal {
+	return Principal{Subject: "api-key:" + k.ID, Tenant: k.Tenant, Roles: k.Roles}
+}
+
+// NewAPIKeySecret generates a random secret for an API key, returning it and its hash.
+func NewAPIKeySecret() (secret, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("generating API key: %v", err)
+	}
+	secret = "pck_" + base64.RawURLEncoding.EncodeToString(b)
+	return secret, HashAPIKey(secret), nil
+}
+
+// HashAPIKey returns the hash stored for the secret of an API key. The secrets are random, so a fast hash is enough.
+func HashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
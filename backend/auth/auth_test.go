@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/perebaj/policycraft"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// writeJWKS writes the public keys of the signers as a JWKS file, returning its path.
+func writeJWKS(t *testing.T, keys map[string]interface{}) string {
+	t.Helper()
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			set.Keys = append(set.Keys, map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())})
+		case *ecdsa.PrivateKey:
+			set.Keys = append(set.Keys, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X.FillBytes(make([]byte, 32))), "y": b64(k.Y.FillBytes(make([]byte, 32)))})
+		case ed25519.PrivateKey:
+			set.Keys = append(set.Keys, map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(k.Public().(ed25519.PublicKey))})
+		default:
+			t.Fatalf("unexpected key type %T", key)
+		}
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("failed to marshal JWKS: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+	return path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return s
+}
+
+func TestVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	path := writeJWKS(t, map[string]interface{}{"rsa": rsaKey, "ec": ecKey, "ed": edKey})
+
+	verifier, err := NewVerifier(path, Config{Issuer: "https://idp.example.com", Audience: "policycraft"})
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+
+	now := time.Now()
+	claims := func(tenant string, roles ...policycraft.Role) Claims {
+		return Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   "user-1",
+				Issuer:    "https://idp.example.com",
+				Audience:  jwt.ClaimStrings{"policycraft"},
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+			Tenant: tenant,
+			Roles:  roles,
+		}
+	}
+	expired := claims("credit", policycraft.RoleViewer)
+	expired.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Hour))
+	otherIssuer := claims("credit", policycraft.RoleViewer)
+	otherIssuer.Issuer = "https://evil.example.com"
+	otherAudience := claims("credit", policycraft.RoleViewer)
+	otherAudience.Audience = jwt.ClaimStrings{"another-service"}
+	noExpiration := claims("credit", policycraft.RoleViewer)
+	noExpiration.ExpiresAt = nil
+
+	tests := []struct {
+		name  string
+		token string
+		want  *policycraft.Principal
+	}{
+		{
+			name:  "RSA",
+			token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims("credit", policycraft.RoleEditor)),
+			want:  &policycraft.Principal{Subject: "user-1", Tenant: "credit", Roles: []policycraft.Role{policycraft.RoleEditor}},
+		},
+		{
+			name:  "ECDSA",
+			token: sign(t, jwt.SigningMethodES256, "ec", ecKey, claims("credit", policycraft.RoleViewer, policycraft.RoleExecutor)),
+			want:  &policycraft.Principal{Subject: "user-1", Tenant: "credit", Roles: []policycraft.Role{policycraft.RoleViewer, policycraft.RoleExecutor}},
+		},
+		{
+			name:  "Ed25519 without tenant is the default tenant",
+			token: sign(t, jwt.SigningMethodEdDSA, "ed", edKey, claims("", policycraft.RoleViewer)),
+			want:  &policycraft.Principal{Subject: "user-1", Tenant: policycraft.DefaultTenant, Roles: []policycraft.Role{policycraft.RoleViewer}},
+		},
+		{name: "unknown kid", token: sign(t, jwt.SigningMethodEdDSA, "other", otherKey, claims("credit", policycraft.RoleViewer))},
+		{name: "wrong key for the kid", token: sign(t, jwt.SigningMethodEdDSA, "ed", otherKey, claims("credit", policycraft.RoleViewer))},
+		{name: "no kid with many keys", token: sign(t, jwt.SigningMethodEdDSA, "", edKey, claims("credit", policycraft.RoleViewer))},
+		{name: "HMAC", token: sign(t, jwt.SigningMethodHS256, "ed", []byte(edKey.Public().(ed25519.PublicKey)), claims("credit", policycraft.RoleViewer))},
+		{name: "expired", token: sign(t, jwt.SigningMethodEdDSA, "ed", edKey, expired)},
+		{name: "no expiration", token: sign(t, jwt.SigningMethodEdDSA, "ed", edKey, noExpiration)},
+		{name: "other issuer", token: sign(t, jwt.SigningMethodEdDSA, "ed", edKey, otherIssuer)},
+		{name: "other audience", token: sign(t, jwt.SigningMethodEdDSA, "ed", edKey, otherAudience)},
+		{name: "no roles", token: sign(t, jwt.SigningMethodEdDSA, "ed", edKey, claims("credit"))},
+		{name: "unknown role", token: sign(t, jwt.SigningMethodEdDSA, "ed", edKey, claims("credit", "root"))},
+		{name: "invalid tenant", token: sign(t, jwt.SigningMethodEdDSA, "ed", edKey, claims("../credit", policycraft.RoleViewer))},
+		{name: "malformed", token: "a.b.c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifier.Verify(tt.token)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("expected an error, got the principal %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to verify token: %v", err)
+			}
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Fatalf("got %+v, want %+v", got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifierSingleKeyWithoutKid(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	verifier, err := NewVerifier(writeJWKS(t, map[string]interface{}{"": key}), Config{})
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+	token := sign(t, jwt.SigningMethodEdDSA, "", key, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "user-1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		Roles:            []policycraft.Role{policycraft.RoleAdmin},
+	})
+
+	principal, err := verifier.Verify(token)
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+	if principal.Subject != "user-1" || !principal.HasRole(policycraft.RoleAdmin) {
+		t.Fatalf("unexpected principal %+v", principal)
+	}
+}
+
+func TestParseJWKSInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		jwks string
+	}{
+		{name: "not JSON", jwks: `keys`},
+		{name: "no keys", jwks: `{"keys": []}`},
+		{name: "only encryption keys", jwks: `{"keys": [{"kty": "OKP", "use": "enc", "crv": "X25519", "x": "AAAA"}]}`},
+		{name: "symmetric key", jwks: `{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`},
+		{name: "unsupported curve", jwks: `{"keys": [{"kty": "EC", "crv": "P-192", "x": "AQ", "y": "AQ"}]}`},
+		{name: "point not on the curve", jwks: `{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`},
+		{name: "invalid Ed25519 size", jwks: `{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "AQ"}]}`},
+		{name: "RSA without modulus", jwks: `{"keys": [{"kty": "RSA", "e": "AQAB"}]}`},
+		{name: "duplicated kid", jwks: `{"keys": [{"kty": "OKP", "kid": "a", "crv": "Ed25519", "x": "` + b64(make([]byte, 32)) + `"}, {"kty": "OKP", "kid": "a", "crv": "Ed25519", "x": "` + b64(make([]byte, 32)) + `"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseJWKS([]byte(tt.jwks)); err == nil {
+				t.Fatalf("expected an error parsing the JWKS")
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Package bolt ...
+// api_keys.go gather all the database operations related to the API keys entity
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"go.etcd.io/bbolt"
+)
+
+// SaveAPIKey save an API key of the context tenant in the database.
+func (s *Storage) SaveAPIKey(ctx context.Context, key policycraft.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(key.ID); err != nil {
+		return fmt.Errorf("parsing API key id: %v", err)
+	}
+	key.Tenant = policycraft.Tenant(ctx)
+	v, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("marshaling API key: %v", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(apiKeysBucket)
+		if bucket.Get([]byte(key.Hash)) != nil {
+			return fmt.Errorf("API key hash already exists")
+		}
+		err := bucket.ForEach(func(_, v []byte) error {
+			var k policycraft.APIKey
+			if err := json.Unmarshal(v, &k); err != nil {
+				return fmt.Errorf("unmarshaling API key: %v", err)
+			}
+			if k.ID == key.ID {
+				return fmt.Errorf("API key %s already exists", key.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key.Hash), v)
+	})
+}
+
+// APIKeys returns the API keys of the context tenant, the oldest first.
+func (s *Storage) APIKeys(ctx context.Context) ([]policycraft.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tenant := policycraft.Tenant(ctx)
+	keys := []policycraft.APIKey{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).ForEach(func(k, v []byte) error {
+			key, err := unmarshalAPIKey(k, v)
+			if err != nil {
+				return err
+			}
+			if key.Tenant == tenant {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, err
+}
+
+// APIKeyByHash returns the API key with the given hash, of any tenant. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) APIKeyByHash(ctx context.Context, hash string) (policycraft.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.APIKey{}, err
+	}
+	var key policycraft.APIKey
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(apiKeysBucket).Get([]byte(hash))
+		if v == nil {
+			return policycraft.ErrNotFound
+		}
+		var err error
+		key, err = unmarshalAPIKey([]byte(hash), v)
+		return err
+	})
+	return key, err
+}
+
+// RevokeAPIKey revokes the API key of the context tenant with the given id. Revoking it again keeps the first revocation time.
+// If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tenant := policycraft.Tenant(ctx)
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(apiKeysBucket)
+		var hash []byte
+		var key policycraft.APIKey
+		err := bucket.ForEach(func(k, v []byte) error {
+			if hash != nil {
+				return nil
+			}
+			current, err := unmarshalAPIKey(k, v)
+			if err != nil {
+				return err
+			}
+			if current.ID == id && current.Tenant == tenant {
+				hash, key = bytes.Clone(k), current
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if hash == nil {
+			return policycraft.ErrNotFound
+		}
+		if key.RevokedAt != nil {
+			return nil
+		}
+		key.RevokedAt = &at
+		v, err := json.Marshal(key)
+		if err != nil {
+			return fmt.Errorf("marshaling API key: %v", err)
+		}
+		return bucket.Put(hash, v)
+	})
+}
+
+// unmarshalAPIKey decodes an API key. The hash isn't part of the value, it's the key of the bucket.
+func unmarshalAPIKey(hash, v []byte) (policycraft.APIKey, error) {
+	var key policycraft.APIKey
+	if err := json.Unmarshal(v, &key); err != nil {
+		return policycraft.APIKey{}, fmt.Errorf("unmarshaling API key: %v", err)
+	}
+	key.Hash = string(hash)
+	return key, nil
+}
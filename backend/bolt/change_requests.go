@@ -0,0 +1,148 @@
+// Package bolt ...
+// change_requests.go gather all the database operations related to the change requests entity
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"go.etcd.io/bbolt"
+)
+
+// SaveChangeRequest save a change request of the context tenant in the database. A change request with version 0 is created,
+// otherwise it's updated only if the stored version is still the given one, and policycraft.ErrConflict is returned if it isn't.
+// The stored version is incremented.
+func (s *Storage) SaveChangeRequest(ctx context.Context, cr policycraft.ChangeRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return saveChangeRequest(ctx, tx, cr)
+	})
+}
+
+// saveChangeRequest is shared by SaveChangeRequest and PublishChangeRequest.
+func saveChangeRequest(ctx context.Context, tx *bbolt.Tx, cr policycraft.ChangeRequest) error {
+	if _, err := uuid.Parse(cr.ID); err != nil {
+		return fmt.Errorf("parsing change request id: %v", err)
+	}
+	bucket, err := tenantBucket(ctx, tx, changeRequestsBucket)
+	if err != nil {
+		return err
+	}
+	current := bucket.Get([]byte(cr.ID))
+	if cr.Version == 0 && current != nil {
+		return fmt.Errorf("%w: change request %s already exists", policycraft.ErrConflict, cr.ID)
+	}
+	if cr.Version != 0 {
+		var stored policycraft.ChangeRequest
+		if current != nil {
+			if err := json.Unmarshal(current, &stored); err != nil {
+				return fmt.Errorf("unmarshaling change request: %v", err)
+			}
+		}
+		if current == nil || stored.Version != cr.Version {
+			return fmt.Errorf("%w: the change request was changed concurrently", policycraft.ErrConflict)
+		}
+	}
+
+	cr.Version++
+	// The postgres storage returns nil for empty collections, so it's done here too.
+	if len(cr.Policies) == 0 {
+		cr.Policies = nil
+	}
+	if len(cr.Comments) == 0 {
+		cr.Comments = nil
+	}
+	v, err := json.Marshal(cr)
+	if err != nil {
+		return fmt.Errorf("marshaling change request: %v", err)
+	}
+	return bucket.Put([]byte(cr.ID), v)
+}
+
+// ChangeRequest returns the change request of the context tenant with the given id. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) ChangeRequest(ctx context.Context, id string) (policycraft.ChangeRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.ChangeRequest{}, err
+	}
+	var cr policycraft.ChangeRequest
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket, err := tenantBucket(ctx, tx, changeRequestsBucket)
+		if err != nil {
+			return err
+		}
+		if bucket == nil {
+			return policycraft.ErrNotFound
+		}
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return policycraft.ErrNotFound
+		}
+		if err := json.Unmarshal(v, &cr); err != nil {
+			return fmt.Errorf("unmarshaling change request: %v", err)
+		}
+		return nil
+	})
+	return cr, err
+}
+
+// ChangeRequests returns the change requests of the context tenant that match the filter, the most recent first.
+func (s *Storage) ChangeRequests(ctx context.Context, filter policycraft.ChangeRequestFilter) ([]policycraft.ChangeRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	crs := []policycraft.ChangeRequest{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket, err := tenantBucket(ctx, tx, changeRequestsBucket)
+		if err != nil || bucket == nil {
+			return err
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var cr policycraft.ChangeRequest
+			if err := json.Unmarshal(v, &cr); err != nil {
+				return fmt.Errorf("unmarshaling change request: %v", err)
+			}
+			if filter.Status == "" || cr.Status == filter.Status {
+				crs = append(crs, cr)
+			}
+			return nil
+		})
+	})
+	sort.Slice(crs, func(i, j int) bool {
+		if !crs[i].CreatedAt.Equal(crs[j].CreatedAt) {
+			return crs[i].CreatedAt.After(crs[j].CreatedAt)
+		}
+		return crs[i].ID < crs[j].ID
+	})
+	return crs, err
+}
+
+// PublishChangeRequest saves the published change request and its policies in a single transaction, replacing every field
+// of the existing policies. As in SaveChangeRequest, policycraft.ErrConflict is returned if the change request was changed concurrently.
+func (s *Storage) PublishChangeRequest(ctx context.Context, cr policycraft.ChangeRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := saveChangeRequest(ctx, tx, cr); err != nil {
+			return err
+		}
+		ids := make([]string, 0, len(cr.Policies))
+		for _, p := range cr.Policies {
+			ids = append(ids, p.ID)
+		}
+		return recordHistory(ctx, tx, ids, func() error {
+			for _, p := range cr.Policies {
+				if err := savePolicy(ctx, tx, p); err != nil {
+					return fmt.Errorf("saving policy %s: %v", p.ID, err)
+				}
+			}
+			return nil
+		})
+	})
+}
@@ -0,0 +1,212 @@
+// Package bolt provides a storage backed by an embedded bbolt database, for local demos and edge deployments without postgres.
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/perebaj/policycraft"
+	"go.etcd.io/bbolt"
+)
+
+// Buckets of the database. A bucket is the bbolt equivalent of a table.
+// The data buckets, except apiKeysBucket, have a nested bucket for each tenant, named by the tenant id, with its entities.
+var (
+	metaBucket       = []byte("meta")
+	policiesBucket   = []byte("policies")
+	policySetsBucket = []byte("policy_sets")
+	decisionsBucket  = []byte("decisions")
+	// decisionsByTimeBucket indexes the decisions by start time, so they can be listed from the most recent.
+	decisionsByTimeBucket = []byte("decisions_by_time")
+	// apiKeysBucket keeps the API keys of every tenant by the hash of the secret, because a key is looked up before its tenant is known.
+	apiKeysBucket = []byte("api_keys")
+	// changeRequestsBucket keeps the change requests by id.
+	changeRequestsBucket = []byte("change_requests")
+	// policyHistoryBucket has a nested bucket for each policy, with its revisions by number.
+	policyHistoryBucket = []byte("policy_history")
+	// deletedPoliciesBucket keeps the deleted policies by id, so they can be restored.
+	deletedPoliciesBucket = []byte("deleted_policies")
+)
+
+// schemaVersionKey is the key, in the meta bucket, of the version of the last applied migration.
+var schemaVersionKey = []byte("schema_version")
+
+// migration changes the database from the previous version to the next one.
+type migration func(tx *bbolt.Tx) error
+
+// migrations are applied in order, each one in its own transaction, like the postgres migrations.
+// A migration is never changed after it's released, new changes are new migrations appended here.
+var migrations = []migration{
+	// 1: create the buckets
+	func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{policiesBucket, policySetsBucket, decisionsBucket, decisionsByTimeBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: move the entities to the nested bucket of the default tenant
+	func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{policiesBucket, policySetsBucket, decisionsBucket, decisionsByTimeBucket} {
+			type entry struct{ k, v []byte }
+			var entries []entry
+			err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+				entries = append(entries, entry{k: append([]byte(nil), k...), v: append([]byte(nil), v...)})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			bucket, err := tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+			tenant, err := bucket.CreateBucket([]byte(policycraft.DefaultTenant))
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if err := tenant.Put(e.k, e.v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	},
+	// 3: create the API keys bucket
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(apiKeysBucket)
+		return err
+	},
+	// 4: create the change requests bucket
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(changeRequestsBucket)
+		return err
+	},
+	// 5: create the policy history bucket
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(policyHistoryBucket)
+		return err
+	},
+	// 6: create the deleted policies bucket
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(deletedPoliciesBucket)
+		return err
+	},
+}
+
+// tenantBucket returns the nested bucket of the context tenant inside the given bucket.
+// It returns nil if the tenant has nothing stored yet, unless tx is writable, when the bucket is created.
+func tenantBucket(ctx context.Context, tx *bbolt.Tx, name []byte) (*bbolt.Bucket, error) {
+	tenant := []byte(policycraft.Tenant(ctx))
+	if !tx.Writable() {
+		return tx.Bucket(name).Bucket(tenant), nil
+	}
+	return tx.Bucket(name).CreateBucketIfNotExists(tenant)
+}
+
+// Storage is the struct that will hold the database.
+type Storage struct {
+	db *bbolt.DB
+}
+
+// Open opens the database file at the given path, creating it if it doesn't exist, and runs the migrations.
+func Open(path string) (*Storage, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening bolt database: %v", err)
+	}
+	err = Migrate(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrating bolt database: %v", err)
+	}
+	return &Storage{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// Migrate applies the migrations that weren't applied yet. A database migrated by a newer version is refused.
+func Migrate(db *bbolt.DB) error {
+	var version uint64
+	err := db.Update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if v := meta.Get(schemaVersionKey); v != nil {
+			version = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if version > uint64(len(migrations)) {
+		return fmt.Errorf("database version %d is newer than the supported version %d", version, len(migrations))
+	}
+
+	for v := version; v < uint64(len(migrations)); v++ {
+		err := db.Update(func(tx *bbolt.Tx) error {
+			if err := migrations[v](tx); err != nil {
+				return err
+			}
+			return tx.Bucket(metaBucket).Put(schemaVersionKey, binary.BigEndian.AppendUint64(nil, v+1))
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %v", v+1, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last applied migration.
+func (s *Storage) SchemaVersion() (uint64, error) {
+	var version uint64
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket(metaBucket).Get(schemaVersionKey); v != nil {
+			version = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	return version, err
+}
+
+// LatestSchemaVersion returns the version of the last migration, the version of an up to date database.
+func LatestSchemaVersion() uint64 {
+	return uint64(len(migrations))
+}
+
+// ReadSchemaVersion returns the version of the last migration applied to the existing database file at the given path,
+// without migrating it. A database that was never migrated has the version 0.
+func ReadSchemaVersion(path string) (uint64, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return 0, fmt.Errorf("opening bolt database: %v", err)
+	}
+	defer db.Close()
+
+	var version uint64
+	err = db.View(func(tx *bbolt.Tx) error {
+		if meta := tx.Bucket(metaBucket); meta != nil {
+			if v := meta.Get(schemaVersionKey); v != nil {
+				version = binary.BigEndian.Uint64(v)
+			}
+		}
+		return nil
+	})
+	return version, err
+}
@@ -0,0 +1,141 @@
+// Package bolt ...
+// decisions.go gather all the database operations related to the decisions entity
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"go.etcd.io/bbolt"
+)
+
+// SaveDecision save a decision of the context tenant in the database.
+func (s *Storage) SaveDecision(ctx context.Context, decision policycraft.Decision) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(decision.ID); err != nil {
+		return fmt.Errorf("parsing decision id: %v", err)
+	}
+	v, err := json.Marshal(decision)
+	if err != nil {
+		return fmt.Errorf("marshaling decision: %v", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		byID, err := tenantBucket(ctx, tx, decisionsBucket)
+		if err != nil {
+			return err
+		}
+		byTime, err := tenantBucket(ctx, tx, decisionsByTimeBucket)
+		if err != nil {
+			return err
+		}
+		if byID.Get([]byte(decision.ID)) != nil {
+			return fmt.Errorf("decision %s already exists", decision.ID)
+		}
+		if err := byID.Put([]byte(decision.ID), v); err != nil {
+			return err
+		}
+		return byTime.Put(timeKey(decision.StartedAt, decision.ID), []byte(decision.ID))
+	})
+}
+
+// Decision returns the decision of the context tenant with the given id. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) Decision(ctx context.Context, id string) (policycraft.Decision, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.Decision{}, err
+	}
+	var decision policycraft.Decision
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket, err := tenantBucket(ctx, tx, decisionsBucket)
+		if err != nil {
+			return err
+		}
+		if bucket == nil {
+			return policycraft.ErrNotFound
+		}
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return policycraft.ErrNotFound
+		}
+		return unmarshalDecision(v, &decision)
+	})
+	return decision, err
+}
+
+// Decisions returns the decisions of the context tenant that match the filter, the most recent first.
+func (s *Storage) Decisions(ctx context.Context, filter policycraft.DecisionFilter) ([]policycraft.Decision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var decisions []policycraft.Decision
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		byID, err := tenantBucket(ctx, tx, decisionsBucket)
+		if err != nil || byID == nil {
+			return err
+		}
+		byTime, err := tenantBucket(ctx, tx, decisionsByTimeBucket)
+		if err != nil || byTime == nil {
+			return err
+		}
+		c := byTime.Cursor()
+
+		// The index is walked backwards, from the end of the time range.
+		var k, id []byte
+		if filter.To.IsZero() {
+			k, id = c.Last()
+		} else {
+			k, id = c.Seek(timeKey(filter.To, ""))
+			if k == nil {
+				k, id = c.Last()
+			} else {
+				k, id = c.Prev()
+			}
+		}
+		for ; k != nil; k, id = c.Prev() {
+			if filter.Limit > 0 && len(decisions) >= filter.Limit {
+				return nil
+			}
+			var d policycraft.Decision
+			if err := unmarshalDecision(byID.Get(id), &d); err != nil {
+				return err
+			}
+			if !filter.From.IsZero() && d.StartedAt.Before(filter.From) {
+				return nil
+			}
+			if !filter.To.IsZero() && !d.StartedAt.Before(filter.To) {
+				continue
+			}
+			if filter.Decision != nil && d.Decision != *filter.Decision {
+				continue
+			}
+			if filter.CallerRef != "" && d.CallerRef != filter.CallerRef {
+				continue
+			}
+			if filter.PolicySet != "" && d.PolicySet != filter.PolicySet {
+				continue
+			}
+			decisions = append(decisions, d)
+		}
+		return nil
+	})
+	return decisions, err
+}
+
+// timeKey is the key of the decisions index: the start time in nanoseconds, big endian so the byte order is the time order, and the id.
+func timeKey(t time.Time, id string) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano())), id...)
+}
+
+func unmarshalDecision(v []byte, d *policycraft.Decision) error {
+	if err := json.Unmarshal(v, d); err != nil {
+		return fmt.Errorf("unmarshaling decision: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package bolt_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+)
+
+func TestStorageDecisions(t *testing.T) {
+	storage := OpenStorage(t)
+	ctx := context.Background()
+	policies := []policycraft.Policy{
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1},
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	approved := policycraft.Decision{
+		ID:            uuid.NewString(),
+		RequestID:     "request-1",
+		CallerRef:     "proposal-1",
+		PolicySet:     "credit",
+		Input:         map[string]interface{}{"age": float64(18), "score": float64(700)},
+		Enrichments:   []policycraft.Enrichment{{Provider: "bureau", Field: "score", Value: float64(700), Source: policycraft.EnrichmentSourceProvider, Attempts: 1}},
+		PolicyVersion: policycraft.PoliciesVersion(policies),
+		Policies:      policies,
+		Decision:      true,
+		Trace:         []policycraft.Step{{PolicyID: policies[0].ID, Name: "age", Criteria: ">", Value: 17, Input: float64(18), Passed: true}},
+		StartedAt:     now.Add(-time.Hour),
+		FinishedAt:    now.Add(-time.Hour),
+	}
+	rejected := approved
+	rejected.ID = uuid.NewString()
+	rejected.RequestID = "request-2"
+	rejected.CallerRef = "proposal-2"
+	rejected.Input = map[string]interface{}{"age": float64(16)}
+	rejected.Enrichments = nil
+	rejected.Decision = false
+	rejected.TimedOut = true
+	rejected.StartedAt = now
+	rejected.FinishedAt = now
+
+	for _, d := range []policycraft.Decision{approved, rejected} {
+		err := storage.SaveDecision(ctx, d)
+		if err != nil {
+			t.Fatalf("error saving decision: %v", err)
+		}
+	}
+	err := storage.SaveDecision(ctx, approved)
+	if err == nil {
+		t.Fatalf("expected an error saving the same decision twice")
+	}
+
+	got, err := storage.Decision(ctx, approved.ID)
+	if err != nil {
+		t.Fatalf("error getting decision: %v", err)
+	}
+	assert(t, got.RequestID, approved.RequestID)
+	assert(t, got.Input, approved.Input)
+	assert(t, got.Policies, approved.Policies)
+	assert(t, got.Enrichments, approved.Enrichments)
+	assert(t, got.StartedAt.Equal(approved.StartedAt), true)
+
+	_, err = storage.Decision(ctx, uuid.NewString())
+	if !errors.Is(err, policycraft.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	_, err = storage.Decision(ctx, "invalid")
+	if !errors.Is(err, policycraft.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	decision := false
+	tests := []struct {
+		name   string
+		filter policycraft.DecisionFilter
+		want   []string
+	}{
+		{name: "all, the most recent first", filter: policycraft.DecisionFilter{}, want: []string{rejected.ID, approved.ID}},
+		{name: "by decision", filter: policycraft.DecisionFilter{Decision: &decision}, want: []string{rejected.ID}},
+		{name: "by caller ref", filter: policycraft.DecisionFilter{CallerRef: "proposal-1"}, want: []string{approved.ID}},
+		{name: "by policy set", filter: policycraft.DecisionFilter{PolicySet: "debit"}, want: nil},
+		{name: "from", filter: policycraft.DecisionFilter{From: now.Add(-time.Minute)}, want: []string{rejected.ID}},
+		{name: "to is exclusive", filter: policycraft.DecisionFilter{To: now}, want: []string{approved.ID}},
+		{name: "limit", filter: policycraft.DecisionFilter{Limit: 1}, want: []string{rejected.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions, err := storage.Decisions(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("error listing decisions: %v", err)
+			}
+			var ids []string
+			for _, d := range decisions {
+				ids = append(ids, d.ID)
+			}
+			assert(t, ids, tt.want)
+		})
+	}
+}
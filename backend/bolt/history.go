@@ -0,0 +1,149 @@
+// Package bolt ...
+// history.go gather all the database operations related to the history of the policies
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/perebaj/policycraft"
+	"go.etcd.io/bbolt"
+)
+
+// recordHistory runs write, which changes the policies with the given ids inside tx, and records a revision of each policy it changed.
+// The change is refused if a policy isn't at the base revision of the policycraft.ChangeInfo of the context.
+func recordHistory(ctx context.Context, tx *bbolt.Tx, ids []string, write func() error) error {
+	policies, err := tenantBucket(ctx, tx, policiesBucket)
+	if err != nil {
+		return err
+	}
+	history, err := tenantBucket(ctx, tx, policyHistoryBucket)
+	if err != nil {
+		return err
+	}
+	before, err := policiesByID(policies, ids)
+	if err != nil {
+		return err
+	}
+	info := policycraft.ChangeInfoFromContext(ctx)
+	for _, id := range ids {
+		if err := info.CheckBaseRevision(id, lastRevision(history, id)); err != nil {
+			return err
+		}
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	after, err := policiesByID(policies, ids)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		rev, ok := policycraft.NewPolicyRevision(ctx, before[id], after[id], now)
+		if !ok {
+			continue
+		}
+		bucket, err := history.CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		// The sequence is the last revision of the policy, so it's never repeated.
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		rev.Revision = int(seq)
+		v, err := json.Marshal(rev)
+		if err != nil {
+			return fmt.Errorf("marshaling revision: %v", err)
+		}
+		if err := bucket.Put(binary.BigEndian.AppendUint64(nil, seq), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lastRevision returns the number of the last revision of the policy with the given id in the history bucket, 0 if it has no history.
+func lastRevision(history *bbolt.Bucket, id string) int {
+	if history == nil {
+		return 0
+	}
+	bucket := history.Bucket([]byte(id))
+	if bucket == nil {
+		return 0
+	}
+	return int(bucket.Sequence())
+}
+
+// policiesByID returns the policies of the bucket with the given ids.
+func policiesByID(bucket *bbolt.Bucket, ids []string) (map[string]*policycraft.Policy, error) {
+	byID := make(map[string]*policycraft.Policy, len(ids))
+	for _, id := range ids {
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			continue
+		}
+		var p policycraft.Policy
+		if err := json.Unmarshal(v, &p); err != nil {
+			return nil, fmt.Errorf("unmarshaling policy: %v", err)
+		}
+		byID[id] = &p
+	}
+	return byID, nil
+}
+
+// LastPolicyRevision returns the number of the last revision of the policy of the context tenant with the given id, 0 if it has no
+// history.
+func (s *Storage) LastPolicyRevision(ctx context.Context, id string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var last int
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		history, err := tenantBucket(ctx, tx, policyHistoryBucket)
+		if err != nil {
+			return err
+		}
+		last = lastRevision(history, id)
+		return nil
+	})
+	return last, err
+}
+
+// PolicyHistory returns the revisions of the policy of the context tenant with the given id, the oldest first.
+func (s *Storage) PolicyHistory(ctx context.Context, id string) ([]policycraft.PolicyRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var revs []policycraft.PolicyRevision
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		history, err := tenantBucket(ctx, tx, policyHistoryBucket)
+		if err != nil || history == nil {
+			return err
+		}
+		bucket := history.Bucket([]byte(id))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var rev policycraft.PolicyRevision
+			if err := json.Unmarshal(v, &rev); err != nil {
+				return fmt.Errorf("unmarshaling revision: %v", err)
+			}
+			revs = append(revs, rev)
+			return nil
+		})
+	})
+	return revs, err
+}
@@ -0,0 +1,229 @@
+// Package bolt ...
+// policies.go gather all the database operations related to the policies entity
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"go.etcd.io/bbolt"
+)
+
+// SavePolicy save a policy in the database. If the policy already exists, every field is replaced, and if it was deleted, it's created
+// again. The change is recorded in the history of the policy.
+func (s *Storage) SavePolicy(ctx context.Context, policy policycraft.Policy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return recordHistory(ctx, tx, []string{policy.ID}, func() error {
+			return savePolicy(ctx, tx, policy)
+		})
+	})
+}
+
+// savePolicy is shared by SavePolicy, UpdatePolicy, ImportBundle and PublishChangeRequest, saving the policy of the context tenant.
+func savePolicy(ctx context.Context, tx *bbolt.Tx, policy policycraft.Policy) error {
+	if _, err := uuid.Parse(policy.ID); err != nil {
+		return fmt.Errorf("invalid policy id %q: %v", policy.ID, err)
+	}
+	if policy.Type == "" {
+		policy.Type = policycraft.PolicyTypeCriteria
+	}
+	bucket, err := tenantBucket(ctx, tx, policiesBucket)
+	if err != nil {
+		return err
+	}
+	deleted, err := tenantBucket(ctx, tx, deletedPoliciesBucket)
+	if err != nil {
+		return err
+	}
+
+	v, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("marshaling policy: %v", err)
+	}
+	if err := deleted.Delete([]byte(policy.ID)); err != nil {
+		return err
+	}
+	return bucket.Put([]byte(policy.ID), v)
+}
+
+// Policy returns the policy of the context tenant with the given id. If it doesn't exist or was deleted, policycraft.ErrNotFound is returned.
+func (s *Storage) Policy(ctx context.Context, id string) (policycraft.Policy, error) {
+	return s.policy(ctx, policiesBucket, id)
+}
+
+// DeletedPolicy returns the deleted policy of the context tenant with the given id. If it doesn't exist or wasn't deleted,
+// policycraft.ErrNotFound is returned.
+func (s *Storage) DeletedPolicy(ctx context.Context, id string) (policycraft.Policy, error) {
+	return s.policy(ctx, deletedPoliciesBucket, id)
+}
+
+// policy returns the policy with the given id of the bucket name, policiesBucket or deletedPoliciesBucket.
+func (s *Storage) policy(ctx context.Context, name []byte, id string) (policycraft.Policy, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.Policy{}, err
+	}
+	var policy policycraft.Policy
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket, err := tenantBucket(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		if bucket == nil {
+			return policycraft.ErrNotFound
+		}
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return policycraft.ErrNotFound
+		}
+		if err := json.Unmarshal(v, &policy); err != nil {
+			return fmt.Errorf("unmarshaling policy: %v", err)
+		}
+		return nil
+	})
+	return policy, err
+}
+
+// UpdatePolicy replaces every field of the existing policy of the context tenant. If it doesn't exist or was deleted,
+// policycraft.ErrNotFound is returned. The change is recorded in the history of the policy.
+func (s *Storage) UpdatePolicy(ctx context.Context, policy policycraft.Policy) error {
+	return s.changePolicy(ctx, policy.ID, func(tx *bbolt.Tx, policies, _ *bbolt.Bucket) error {
+		if policies.Get([]byte(policy.ID)) == nil {
+			return policycraft.ErrNotFound
+		}
+		return savePolicy(ctx, tx, policy)
+	})
+}
+
+// DeletePolicy deletes the policy of the context tenant with the given id. The policy is kept, so it can be restored by RestorePolicy.
+// If it doesn't exist or was already deleted, policycraft.ErrNotFound is returned. The change is recorded in the history of the policy.
+func (s *Storage) DeletePolicy(ctx context.Context, id string) error {
+	return s.changePolicy(ctx, id, func(_ *bbolt.Tx, policies, deleted *bbolt.Bucket) error {
+		return movePolicy(policies, deleted, id)
+	})
+}
+
+// RestorePolicy restores the deleted policy of the context tenant with the given id. If it doesn't exist or wasn't deleted,
+// policycraft.ErrNotFound is returned. The change is recorded in the history of the policy.
+func (s *Storage) RestorePolicy(ctx context.Context, id string) error {
+	return s.changePolicy(ctx, id, func(_ *bbolt.Tx, policies, deleted *bbolt.Bucket) error {
+		return movePolicy(deleted, policies, id)
+	})
+}
+
+// changePolicy runs the change of a single policy of the context tenant in a transaction, recording it in the history of the policy.
+func (s *Storage) changePolicy(ctx context.Context, id string, change func(tx *bbolt.Tx, policies, deleted *bbolt.Bucket) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		policies, err := tenantBucket(ctx, tx, policiesBucket)
+		if err != nil {
+			return err
+		}
+		deleted, err := tenantBucket(ctx, tx, deletedPoliciesBucket)
+		if err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, []string{id}, func() error {
+			return change(tx, policies, deleted)
+		})
+	})
+}
+
+// movePolicy moves the policy with the given id between the buckets. If it isn't in from, policycraft.ErrNotFound is returned.
+func movePolicy(from, to *bbolt.Bucket, id string) error {
+	v := from.Get([]byte(id))
+	if v == nil {
+		return policycraft.ErrNotFound
+	}
+	if err := to.Put([]byte(id), v); err != nil {
+		return err
+	}
+	return from.Delete([]byte(id))
+}
+
+// Policies returns all the policies of the context tenant. The order follows policycraft.SortPolicies.
+func (s *Storage) Policies(ctx context.Context) ([]policycraft.Policy, error) {
+	return s.policies(ctx, func(policycraft.Policy) bool { return true })
+}
+
+// PoliciesBySet returns the policies of the given policy set of the context tenant. The order follows policycraft.SortPolicies.
+func (s *Storage) PoliciesBySet(ctx context.Context, name string) ([]policycraft.Policy, error) {
+	return s.policies(ctx, func(p policycraft.Policy) bool { return p.PolicySet == name })
+}
+
+// ListPolicies returns the page of the policies of the context tenant given by the filter. The time of the last change of a policy
+// is the one of its last revision.
+func (s *Storage) ListPolicies(ctx context.Context, filter policycraft.PolicyFilter) (policycraft.PolicyPage, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.PolicyPage{}, err
+	}
+	var listed []policycraft.ListedPolicy
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket, err := tenantBucket(ctx, tx, policiesBucket)
+		if err != nil || bucket == nil {
+			return err
+		}
+		history, err := tenantBucket(ctx, tx, policyHistoryBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var l policycraft.ListedPolicy
+			if err := json.Unmarshal(v, &l.Policy); err != nil {
+				return fmt.Errorf("unmarshaling policy: %v", err)
+			}
+			if history != nil {
+				if revs := history.Bucket(k); revs != nil {
+					if _, last := revs.Cursor().Last(); last != nil {
+						var rev policycraft.PolicyRevision
+						if err := json.Unmarshal(last, &rev); err != nil {
+							return fmt.Errorf("unmarshaling revision: %v", err)
+						}
+						l.UpdatedAt = rev.CreatedAt
+					}
+				}
+			}
+			listed = append(listed, l)
+			return nil
+		})
+	})
+	if err != nil {
+		return policycraft.PolicyPage{}, err
+	}
+	return policycraft.PagePolicies(listed, filter)
+}
+
+// policies returns the policies that match the filter, in evaluation order.
+func (s *Storage) policies(ctx context.Context, match func(policycraft.Policy) bool) ([]policycraft.Policy, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var policies []policycraft.Policy
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket, err := tenantBucket(ctx, tx, policiesBucket)
+		if err != nil || bucket == nil {
+			return err
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var p policycraft.Policy
+			if err := json.Unmarshal(v, &p); err != nil {
+				return fmt.Errorf("unmarshaling policy: %v", err)
+			}
+			if match(p) {
+				policies = append(policies, p)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return policycraft.SortPolicies(policies), nil
+}
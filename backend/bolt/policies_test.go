@@ -0,0 +1,219 @@
+package bolt_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/bolt"
+	"go.etcd.io/bbolt"
+)
+
+// OpenStorage create a new database file for testing and return a storage backed by it.
+func OpenStorage(t *testing.T) *bolt.Storage {
+	t.Helper()
+
+	storage, err := bolt.Open(filepath.Join(t.TempDir(), "policycraft.db"))
+	if err != nil {
+		t.Fatalf("error opening bolt database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := storage.Close(); err != nil {
+			t.Errorf("error closing bolt database: %v", err)
+		}
+	})
+	return storage
+}
+
+func assert(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestOpenMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policycraft.db")
+	storage, err := bolt.Open(path)
+	if err != nil {
+		t.Fatalf("error opening bolt database: %v", err)
+	}
+	version, err := storage.SchemaVersion()
+	if err != nil {
+		t.Fatalf("error getting schema version: %v", err)
+	}
+	assert(t, version, uint64(6))
+
+	policy := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"}
+	err = storage.SavePolicy(context.Background(), policy)
+	if err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	err = storage.Close()
+	if err != nil {
+		t.Fatalf("error closing bolt database: %v", err)
+	}
+
+	version, err = bolt.ReadSchemaVersion(path)
+	if err != nil {
+		t.Fatalf("error reading schema version: %v", err)
+	}
+	assert(t, version, bolt.LatestSchemaVersion())
+	if _, err := bolt.ReadSchemaVersion(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Fatal("expected an error reading the schema version of a missing database")
+	}
+
+	// opening again doesn't run the applied migrations, so the data is kept
+	storage, err = bolt.Open(path)
+	if err != nil {
+		t.Fatalf("error opening bolt database: %v", err)
+	}
+	defer storage.Close()
+	policies, err := storage.Policies(context.Background())
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, len(policies), 1)
+}
+
+func TestMigrateDefaultTenant(t *testing.T) {
+	// a database of the version 1, before the tenants, keeps the entities in the top level buckets
+	path := filepath.Join(t.TempDir(), "policycraft.db")
+	db, err := bbolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatalf("error opening bolt database: %v", err)
+	}
+	policy := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "credit"}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucket([]byte("meta"))
+		if err != nil {
+			return err
+		}
+		if err := meta.Put([]byte("schema_version"), binary.BigEndian.AppendUint64(nil, 1)); err != nil {
+			return err
+		}
+		for _, name := range []string{"policies", "policy_sets", "decisions", "decisions_by_time"} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		v, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("policies")).Put([]byte(policy.ID), v)
+	})
+	if err != nil {
+		t.Fatalf("error creating version 1 database: %v", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("error closing bolt database: %v", err)
+	}
+
+	storage, err := bolt.Open(path)
+	if err != nil {
+		t.Fatalf("error opening bolt database: %v", err)
+	}
+	defer storage.Close()
+	policies, err := storage.Policies(context.Background())
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, policies, []policycraft.Policy{policy})
+
+	policies, err = storage.Policies(policycraft.WithTenant(context.Background(), "insurance"))
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, len(policies), 0)
+}
+
+func TestStorageSavePolicy(t *testing.T) {
+	storage := OpenStorage(t)
+	ctx := context.Background()
+	policy := policycraft.Policy{
+		ID:          uuid.NewString(),
+		Name:        "policy 1",
+		Criteria:    ">",
+		Value:       1,
+		SuccessCase: true,
+		Priority:    1,
+		PolicySet:   policycraft.DefaultPolicySet,
+	}
+
+	err := storage.SavePolicy(ctx, policy)
+	if err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+
+	got, err := storage.Policies(ctx)
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	policy.Type = policycraft.PolicyTypeCriteria
+	assert(t, got, []policycraft.Policy{policy})
+
+	policy2 := policy
+	policy2.Criteria = "<"
+	policy2.Function = "len"
+	policy2.Value = 2
+	err = storage.SavePolicy(ctx, policy2)
+	if err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+
+	got, err = storage.Policies(ctx)
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, got, []policycraft.Policy{policy2})
+
+	err = storage.SavePolicy(ctx, policycraft.Policy{ID: "invalid"})
+	if err == nil {
+		t.Fatalf("expected an error saving a policy with an invalid id")
+	}
+}
+
+func TestStoragePoliciesBySet(t *testing.T) {
+	storage := OpenStorage(t)
+	ctx := context.Background()
+	policies := []policycraft.Policy{
+		{ID: uuid.NewString(), Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2, PolicySet: "credit"},
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"},
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 20, SuccessCase: true, Priority: 1, PolicySet: "insurance"},
+		{ID: uuid.NewString(), Name: "income", Type: policycraft.PolicyTypeStarlark, Script: "def condition(input):\n    return True\n", SuccessCase: true, Priority: 3, PolicySet: "credit"},
+	}
+	for _, p := range policies {
+		err := storage.SavePolicy(ctx, p)
+		if err != nil {
+			t.Fatalf("error saving policy: %v", err)
+		}
+	}
+
+	got, err := storage.PoliciesBySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	if len(got) == 3 {
+		assert(t, got[0].ID, policies[1].ID)
+		assert(t, got[1].ID, policies[0].ID)
+		assert(t, got[0].Type, policycraft.PolicyTypeCriteria)
+		assert(t, got[2].Type, policycraft.PolicyTypeStarlark)
+		assert(t, got[2].Script, policies[3].Script)
+	} else {
+		t.Fatalf("expected 3 policies, got %d", len(got))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = storage.PoliciesBySet(ctx, "credit")
+	if err == nil {
+		t.Fatalf("expected an error with a canceled context")
+	}
+}
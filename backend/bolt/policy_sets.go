@@ -0,0 +1,181 @@
+// Package bolt ...
+// policy_sets.go gather all the database operations related to the policy sets entity
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/perebaj/policycraft"
+	"go.etcd.io/bbolt"
+)
+
+// SavePolicySet save a policy set in the database. If the policy set already exists, it will be updated.
+func (s *Storage) SavePolicySet(ctx context.Context, set policycraft.PolicySet) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return savePolicySet(ctx, tx, set)
+	})
+}
+
+// savePolicySet is shared by SavePolicySet and ImportBundle, saving the policy set in the bucket of the context tenant.
+func savePolicySet(ctx context.Context, tx *bbolt.Tx, set policycraft.PolicySet) error {
+	if set.FailMode == "" {
+		set.FailMode = policycraft.FailClosed
+	}
+	// The postgres storage returns nil for empty collections, so it's done here too.
+	if len(set.Enrichments) == 0 {
+		set.Enrichments = nil
+	}
+	if len(set.Tests) == 0 {
+		set.Tests = nil
+	}
+	v, err := json.Marshal(set)
+	if err != nil {
+		return fmt.Errorf("marshaling policy set: %v", err)
+	}
+	bucket, err := tenantBucket(ctx, tx, policySetsBucket)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(set.Name), v)
+}
+
+// PolicySet returns the policy set of the context tenant with the given name. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) PolicySet(ctx context.Context, name string) (policycraft.PolicySet, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.PolicySet{}, err
+	}
+	var set policycraft.PolicySet
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket, err := tenantBucket(ctx, tx, policySetsBucket)
+		if err != nil {
+			return err
+		}
+		if bucket == nil {
+			return policycraft.ErrNotFound
+		}
+		v := bucket.Get([]byte(name))
+		if v == nil {
+			return policycraft.ErrNotFound
+		}
+		return unmarshalPolicySet(v, &set)
+	})
+	return set, err
+}
+
+// PolicySets returns all the policy sets of the context tenant ordered by name.
+func (s *Storage) PolicySets(ctx context.Context) ([]policycraft.PolicySet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var sets []policycraft.PolicySet
+	// bbolt keeps the keys sorted in byte order, so the policy sets are already ordered by name.
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket, err := tenantBucket(ctx, tx, policySetsBucket)
+		if err != nil || bucket == nil {
+			return err
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var set policycraft.PolicySet
+			if err := unmarshalPolicySet(v, &set); err != nil {
+				return err
+			}
+			sets = append(sets, set)
+			return nil
+		})
+	})
+	return sets, err
+}
+
+func unmarshalPolicySet(v []byte, set *policycraft.PolicySet) error {
+	if err := json.Unmarshal(v, set); err != nil {
+		return fmt.Errorf("unmarshaling policy set: %v", err)
+	}
+	return nil
+}
+
+// ImportBundle replaces the policy set of the context tenant and its policies by the bundle in a single transaction.
+// The policies of the policy set that aren't in the bundle are deleted, as DeletePolicy does. The changes are recorded in the history
+// of the policies. A bundle policy that belongs to another policy set fails with policycraft.ErrPolicyInOtherSet.
+// If anything fails, nothing is changed.
+func (s *Storage) ImportBundle(ctx context.Context, bundle policycraft.Bundle) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := savePolicySet(ctx, tx, bundle.PolicySet); err != nil {
+			return fmt.Errorf("saving policy set: %v", err)
+		}
+
+		bucket, err := tenantBucket(ctx, tx, policiesBucket)
+		if err != nil {
+			return err
+		}
+		var others []string
+		for _, p := range bundle.Policies {
+			v := bucket.Get([]byte(p.ID))
+			if v == nil {
+				continue
+			}
+			var stored policycraft.Policy
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return fmt.Errorf("unmarshaling policy: %v", err)
+			}
+			if stored.PolicySet != bundle.PolicySet.Name {
+				others = append(others, p.ID)
+			}
+		}
+		if len(others) > 0 {
+			return fmt.Errorf("%w: %s", policycraft.ErrPolicyInOtherSet, strings.Join(others, ", "))
+		}
+		// The keys are collected before the deletion, because bbolt doesn't allow changing a bucket while iterating over it.
+		var removed [][]byte
+		err = bucket.ForEach(func(k, v []byte) error {
+			var p policycraft.Policy
+			if err := json.Unmarshal(v, &p); err != nil {
+				return fmt.Errorf("unmarshaling policy: %v", err)
+			}
+			if p.PolicySet == bundle.PolicySet.Name {
+				removed = append(removed, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// The history covers the policies removed from the policy set too.
+		changed := make([]string, 0, len(removed)+len(bundle.Policies))
+		for _, k := range removed {
+			changed = append(changed, string(k))
+		}
+		for _, p := range bundle.Policies {
+			changed = append(changed, p.ID)
+		}
+
+		deleted, err := tenantBucket(ctx, tx, deletedPoliciesBucket)
+		if err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, changed, func() error {
+			// The policies of the policy set are deleted, so they can be restored, and the bundle policies are saved again.
+			for _, k := range removed {
+				if err := movePolicy(bucket, deleted, string(k)); err != nil {
+					return err
+				}
+			}
+
+			// A bundle policy deleted from another policy set is restored in this one.
+			for _, p := range bundle.Policies {
+				if err := savePolicy(ctx, tx, p); err != nil {
+					return fmt.Errorf("saving policy %s: %v", p.ID, err)
+				}
+			}
+			return nil
+		})
+	})
+}
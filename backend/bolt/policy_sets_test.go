@@ -0,0 +1,132 @@
+package bolt_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+)
+
+func TestStoragePolicySets(t *testing.T) {
+	storage := OpenStorage(t)
+	ctx := context.Background()
+	minimum := float64(0)
+	set := policycraft.PolicySet{
+		Name:        "credit",
+		Description: "credit card approval",
+		TimeoutMS:   50,
+		FailMode:    policycraft.FailOpen,
+		InputSchema: policycraft.InputSchema{
+			Fields: map[string]policycraft.FieldSchema{
+				"age": {Type: policycraft.FieldTypeInteger, Required: true, Minimum: &minimum},
+			},
+		},
+		Enrichments: []policycraft.EnrichmentProvider{
+			{Name: "bureau", URL: "http://bureau.local/score", Field: "score", Retries: 2, CacheTTLSeconds: 60, Fallback: float64(0)},
+		},
+	}
+
+	err := storage.SavePolicySet(ctx, set)
+	if err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+
+	got, err := storage.PolicySet(ctx, set.Name)
+	if err != nil {
+		t.Fatalf("error getting policy set: %v", err)
+	}
+	assert(t, got, set)
+
+	// saving again updates the policy set, and the fail mode defaults to closed
+	set.Description = "updated"
+	set.FailMode = ""
+	err = storage.SavePolicySet(ctx, set)
+	if err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+	err = storage.SavePolicySet(ctx, policycraft.PolicySet{Name: "atm"})
+	if err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+
+	sets, err := storage.PolicySets(ctx)
+	if err != nil {
+		t.Fatalf("error getting policy sets: %v", err)
+	}
+	if len(sets) == 2 {
+		assert(t, sets[0].Name, "atm")
+		assert(t, sets[1].Description, "updated")
+		assert(t, sets[1].FailMode, policycraft.FailClosed)
+	} else {
+		t.Fatalf("expected 2 policy sets, got %d", len(sets))
+	}
+
+	_, err = storage.PolicySet(ctx, "missing")
+	if !errors.Is(err, policycraft.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStorageImportBundle(t *testing.T) {
+	storage := OpenStorage(t)
+	ctx := context.Background()
+	kept := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"}
+	removed := policycraft.Policy{ID: uuid.NewString(), Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2, PolicySet: "credit"}
+	other := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 20, SuccessCase: true, Priority: 1, PolicySet: "insurance"}
+	for _, p := range []policycraft.Policy{kept, removed, other} {
+		err := storage.SavePolicy(ctx, p)
+		if err != nil {
+			t.Fatalf("error saving policy: %v", err)
+		}
+	}
+
+	kept.Priority = 2
+	kept.SuccessCase = false
+	added := policycraft.Policy{ID: uuid.NewString(), Name: "score", Criteria: ">", Value: 500, SuccessCase: true, Priority: 1, PolicySet: "credit"}
+	set := policycraft.PolicySet{
+		Name:  "credit",
+		Tests: []policycraft.PolicyTest{{Name: "minor", Input: map[string]interface{}{"age": float64(16), "score": float64(600)}, Decision: true}},
+	}
+	err := storage.ImportBundle(ctx, policycraft.NewBundle(set, []policycraft.Policy{kept, added}))
+	if err != nil {
+		t.Fatalf("error importing bundle: %v", err)
+	}
+
+	got, err := storage.PoliciesBySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	if len(got) == 2 {
+		assert(t, got[0].ID, added.ID)
+		assert(t, got[1].ID, kept.ID)
+		assert(t, got[1].SuccessCase, false)
+	} else {
+		t.Fatalf("expected 2 policies, got %d", len(got))
+	}
+
+	gotSet, err := storage.PolicySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policy set: %v", err)
+	}
+	assert(t, len(gotSet.Tests), 1)
+
+	// the policies of other policy sets are kept
+	got, err = storage.PoliciesBySet(ctx, "insurance")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, len(got), 1)
+
+	// an invalid bundle changes nothing
+	err = storage.ImportBundle(ctx, policycraft.NewBundle(set, []policycraft.Policy{{ID: "invalid", PolicySet: "credit"}}))
+	if err == nil {
+		t.Fatalf("expected an error importing an invalid policy")
+	}
+	got, err = storage.PoliciesBySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, len(got), 2)
+}
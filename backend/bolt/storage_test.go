@@ -0,0 +1,12 @@
+package bolt_test
+
+import (
+	"testing"
+
+	"github.com/perebaj/policycraft/api"
+	"github.com/perebaj/policycraft/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) api.Storage { return OpenStorage(t) })
+}
@@ -0,0 +1,225 @@
+// Package policycraft ...
+// bundle.go gather the bundle, the versioned file format used to move a whole policy set between environments.
+package policycraft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// BundleVersion is the version of the bundle format written by this version of policycraft.
+const BundleVersion = "policycraft/v1"
+
+// ErrPolicyInOtherSet is returned when importing a bundle with a policy of another policy set. The policy must be removed from
+// its policy set first, so a bundle never takes the policies of another one.
+var ErrPolicyInOtherSet = fmt.Errorf("%w: the policy belongs to another policy set", ErrConflict)
+
+// Bundle formats.
+const (
+	BundleFormatJSON = "json"
+	BundleFormatYAML = "yaml"
+)
+
+// Bundle is a whole policy set, with its metadata, tests and policies. It's the unit exported and imported between environments.
+type Bundle struct {
+	// Version is the version of the bundle format.
+	Version string `json:"version"`
+	// PolicySet is the policy set metadata and tests.
+	PolicySet PolicySet `json:"policy_set"`
+	// Policies are all the policies of the policy set. Importing a bundle removes the policies that aren't listed here.
+	Policies []Policy `json:"policies"`
+}
+
+// NewBundle returns the bundle of the policy set and its policies, in evaluation order.
+func NewBundle(set PolicySet, policies []Policy) Bundle {
+	b := Bundle{Version: BundleVersion, PolicySet: set, Policies: SortPolicies(policies)}
+	b.normalize()
+	return b
+}
+
+// ParseBundle decodes a bundle in YAML or JSON. Unknown fields are rejected, so a misspelled field isn't silently ignored.
+func ParseBundle(data []byte) (Bundle, error) {
+	// YAML is a superset of JSON, so both formats are decoded by the YAML decoder and then converted to the JSON representation.
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return Bundle{}, fmt.Errorf("decoding bundle: %v", err)
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("decoding bundle: %v", err)
+	}
+
+	var bundle Bundle
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&bundle); err != nil {
+		return Bundle{}, fmt.Errorf("decoding bundle: %v", err)
+	}
+	if bundle.Version != BundleVersion {
+		return Bundle{}, fmt.Errorf("unsupported bundle version %q, expected %q", bundle.Version, BundleVersion)
+	}
+	bundle.normalize()
+	return bundle, nil
+}
+
+// Encode encodes the bundle in the given format: json or yaml.
+func (b Bundle) Encode(format string) ([]byte, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case BundleFormatJSON:
+		return data, nil
+	case BundleFormatYAML:
+		// The YAML is produced from the JSON representation, so both formats have the same field names and order.
+		// JSON is valid YAML, so it's parsed as a YAML document and written again in the block style.
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		resetStyle(&doc)
+		return yaml.Marshal(&doc)
+	default:
+		return nil, fmt.Errorf("unsupported bundle format %q", format)
+	}
+}
+
+// resetStyle clears the JSON styles (flow collections and quoted strings) of the node and its children.
+func resetStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, child := range n.Content {
+		resetStyle(child)
+	}
+}
+
+// normalize fills the defaults that the storage also fills, so a bundle can be compared with the stored policy set.
+func (b *Bundle) normalize() {
+	if b.PolicySet.FailMode == "" {
+		b.PolicySet.FailMode = FailClosed
+	}
+	for i := range b.Policies {
+		if b.Policies[i].PolicySet == "" {
+			b.Policies[i].PolicySet = b.PolicySet.Name
+		}
+		if b.Policies[i].Type == "" {
+			b.Policies[i].Type = PolicyTypeCriteria
+		}
+		b.Policies[i].Tags = NewTags(b.Policies[i].Tags)
+	}
+}
+
+// Check verifies if the bundle can be imported: the policy set and its policies are valid and the policy set tests pass.
+func (b Bundle) Check(ctx context.Context, registry *Registry) error {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	// The tests are meaningless against invalid policies.
+	if err := b.Validate(registry); err != nil {
+		return err
+	}
+	return b.PolicySet.RunTests(ctx, b.Policies, registry)
+}
+
+// Validate verifies if the policy set and the policies of the bundle are valid, without running the policy set tests.
+func (b Bundle) Validate(registry *Registry) error {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	if b.PolicySet.Name == "" {
+		return errors.New("policy_set name is required")
+	}
+
+	var errs []error
+	if err := b.PolicySet.Check(); err != nil {
+		errs = append(errs, fmt.Errorf("policy set %s: %w", b.PolicySet.Name, err))
+	}
+	ids := make(map[string]bool)
+	for _, p := range b.Policies {
+		if p.ID == "" {
+			errs = append(errs, fmt.Errorf("policy %s: id is required", p.Name))
+		} else if _, err := uuid.Parse(p.ID); err != nil {
+			errs = append(errs, fmt.Errorf("policy %s: id is not a valid UUID", p.ID))
+		}
+		if ids[p.ID] {
+			errs = append(errs, fmt.Errorf("policy %s: duplicated id", p.ID))
+		}
+		ids[p.ID] = true
+		if p.PolicySet != b.PolicySet.Name {
+			errs = append(errs, fmt.Errorf("policy %s: belongs to the policy set %s, not %s", p.ID, p.PolicySet, b.PolicySet.Name))
+		}
+		if err := registry.Validate(p); err != nil {
+			errs = append(errs, fmt.Errorf("policy %s: %w", p.ID, err))
+		}
+		for _, tag := range p.Tags {
+			if err := CheckTag(tag); err != nil {
+				errs = append(errs, fmt.Errorf("policy %s: %w", p.ID, err))
+			}
+		}
+		if duplicated, ok := DuplicatedPriority(p, b.Policies); ok {
+			errs = append(errs, fmt.Errorf("policy %s: priority %d is already used by the policy %s", p.ID, p.Priority, duplicated.ID))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// BundleDiff is the difference between the stored policy set and a bundle.
+type BundleDiff struct {
+	// PolicySetChanged tells if the policy set metadata or tests changed.
+	PolicySetChanged bool `json:"policy_set_changed"`
+	// Added are the IDs of the policies that will be created.
+	Added []string `json:"added"`
+	// Updated are the IDs of the policies that will be changed.
+	Updated []string `json:"updated"`
+	// Removed are the IDs of the policies that will be removed.
+	Removed []string `json:"removed"`
+}
+
+// Empty tells if there is no difference.
+func (d BundleDiff) Empty() bool {
+	return !d.PolicySetChanged && len(d.Added) == 0 && len(d.Updated) == 0 && len(d.Removed) == 0
+}
+
+// DiffBundles returns what changes when the current bundle is replaced by the next one. The IDs are sorted.
+func DiffBundles(current, next Bundle) BundleDiff {
+	current.normalize()
+	next.normalize()
+
+	diff := BundleDiff{PolicySetChanged: !sameJSON(current.PolicySet, next.PolicySet), Added: []string{}, Updated: []string{}, Removed: []string{}}
+	currentPolicies := make(map[string]Policy, len(current.Policies))
+	for _, p := range current.Policies {
+		currentPolicies[p.ID] = p
+	}
+	for _, p := range next.Policies {
+		old, ok := currentPolicies[p.ID]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, p.ID)
+		case !sameJSON(old, p):
+			diff.Updated = append(diff.Updated, p.ID)
+		}
+		delete(currentPolicies, p.ID)
+	}
+	for id := range currentPolicies {
+		diff.Removed = append(diff.Removed, id)
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Updated)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// sameJSON compares the JSON representation of the values, so nil and empty collections are equal after the storage round trip.
+func sameJSON(a, b interface{}) bool {
+	x, errX := json.Marshal(a)
+	y, errY := json.Marshal(b)
+	return errX == nil && errY == nil && bytes.Equal(x, y)
+}
@@ -0,0 +1,144 @@
+package policycraft
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const yamlBundle = `
+version: policycraft/v1
+policy_set:
+  name: credit
+  description: credit card approval
+  input_schema:
+    fields:
+      age:
+        type: integer
+        required: true
+  tests:
+    - name: adult
+      input:
+        age: 20
+      decision: true
+    - name: minor
+      input:
+        age: 16
+      decision: false
+policies:
+  - id: a43cafc3-87ad-4e13-9e42-fbd7113b7e82
+    name: age
+    criteria: ">"
+    value: 17
+    success_case: true
+    priority: 1
+`
+
+func TestParseBundle(t *testing.T) {
+	bundle, err := ParseBundle([]byte(yamlBundle))
+	if err != nil {
+		t.Fatalf("ParseBundle() error = %v", err)
+	}
+	if bundle.PolicySet.Name != "credit" || len(bundle.PolicySet.Tests) != 2 || len(bundle.Policies) != 1 {
+		t.Fatalf("ParseBundle() = %+v", bundle)
+	}
+	// the defaults of the storage are filled
+	if bundle.Policies[0].PolicySet != "credit" || bundle.Policies[0].Type != PolicyTypeCriteria || bundle.PolicySet.FailMode != FailClosed {
+		t.Errorf("Expecting the defaults to be filled, got %+v", bundle)
+	}
+	if err := bundle.Check(context.Background(), nil); err != nil {
+		t.Errorf("Bundle.Check() error = %v", err)
+	}
+
+	// the JSON encoding is parsed to the same bundle
+	for _, format := range []string{BundleFormatJSON, BundleFormatYAML} {
+		data, err := bundle.Encode(format)
+		if err != nil {
+			t.Fatalf("Bundle.Encode(%s) error = %v", format, err)
+		}
+		got, err := ParseBundle(data)
+		if err != nil {
+			t.Fatalf("ParseBundle(%s) error = %v", format, err)
+		}
+		if !reflect.DeepEqual(got, bundle) {
+			t.Errorf("Expecting the %s round trip to keep the bundle, got %+v, want %+v", format, got, bundle)
+		}
+	}
+
+	invalid := map[string]string{
+		"unsupported version": strings.Replace(yamlBundle, "policycraft/v1", "policycraft/v0", 1),
+		"unknown field":       strings.Replace(yamlBundle, "priority: 1", "priority: 1\n    prority: 2", 1),
+		"not a bundle":        "[1, 2]",
+	}
+	for name, data := range invalid {
+		if _, err := ParseBundle([]byte(data)); err == nil {
+			t.Errorf("Expecting an error parsing the bundle with %s", name)
+		}
+	}
+}
+
+func TestBundleCheck(t *testing.T) {
+	valid, err := ParseBundle([]byte(yamlBundle))
+	if err != nil {
+		t.Fatalf("ParseBundle() error = %v", err)
+	}
+
+	failingTest := valid
+	failingTest.PolicySet.Tests = []PolicyTest{{Name: "minor", Input: map[string]interface{}{"age": 16}, Decision: true}}
+	duplicatedPriority := valid
+	duplicatedPriority.Policies = append([]Policy{{ID: "0b5d8a5e-5d2c-4b8e-9d8b-1f3f3f3f3f3f", Name: "income", Criteria: ">", Value: 1, Priority: 1, PolicySet: "credit"}}, valid.Policies...)
+	otherSet := valid
+	otherSet.Policies = []Policy{{ID: valid.Policies[0].ID, Name: "age", Criteria: ">", Value: 17, Priority: 1, PolicySet: "debit"}}
+	unknownCriteria := valid
+	unknownCriteria.Policies = []Policy{{ID: valid.Policies[0].ID, Name: "age", Criteria: "~", Value: 17, Priority: 1, PolicySet: "credit"}}
+	invalidID := valid
+	invalidID.Policies = []Policy{{ID: "not-a-uuid", Name: "age", Criteria: ">", Value: 17, Priority: 1, PolicySet: "credit"}}
+	invalidTag := valid
+	invalidTag.Policies = []Policy{{ID: valid.Policies[0].ID, Name: "age", Criteria: ">", Value: 17, Priority: 1, PolicySet: "credit", Tags: Tags{"know your customer"}}}
+
+	for name, bundle := range map[string]Bundle{
+		"failing test":        failingTest,
+		"duplicated priority": duplicatedPriority,
+		"other policy set":    otherSet,
+		"unknown criteria":    unknownCriteria,
+		"invalid tag":         invalidTag,
+		"invalid id":          invalidID,
+	} {
+		if err := bundle.Check(context.Background(), nil); err == nil {
+			t.Errorf("Expecting an error checking the bundle with %s", name)
+		}
+	}
+
+	// the tests aren't run by Validate
+	if err := failingTest.Validate(nil); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	if err := unknownCriteria.Validate(nil); err == nil {
+		t.Error("Expecting an error validating the bundle with unknown criteria")
+	}
+	if err := invalidID.Validate(nil); err == nil || !strings.Contains(err.Error(), "not a valid UUID") {
+		t.Errorf("Validate() error = %v, want an invalid UUID", err)
+	}
+}
+
+func TestDiffBundles(t *testing.T) {
+	set := PolicySet{Name: "credit"}
+	current := NewBundle(set, []Policy{
+		{ID: "a", Name: "age", Criteria: ">", Value: 17, Priority: 1, PolicySet: "credit"},
+		{ID: "b", Name: "income", Criteria: ">", Value: 1000, Priority: 2, PolicySet: "credit"},
+	})
+
+	if diff := DiffBundles(current, current); !diff.Empty() {
+		t.Errorf("Expecting no difference, got %+v", diff)
+	}
+
+	next := NewBundle(PolicySet{Name: "credit", Description: "changed"}, []Policy{
+		{ID: "a", Name: "age", Criteria: ">", Value: 18, Priority: 1, PolicySet: "credit"},
+		{ID: "c", Name: "score", Criteria: ">", Value: 500, Priority: 3, PolicySet: "credit"},
+	})
+	want := BundleDiff{PolicySetChanged: true, Added: []string{"c"}, Updated: []string{"a"}, Removed: []string{"b"}}
+	if diff := DiffBundles(current, next); !reflect.DeepEqual(diff, want) {
+		t.Errorf("DiffBundles() = %+v, want %+v", diff, want)
+	}
+}
@@ -0,0 +1,319 @@
+// Package cache keeps an in-process snapshot of the policies and policy sets, so executing a policy set doesn't query the database.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+)
+
+// Storage serves the policies and policy sets from a snapshot of the wrapped storage. Everything else goes to the wrapped storage.
+// Each tenant has its own snapshot, loaded on the first read of the tenant and replaced at once by the refreshes,
+// so a reader never sees a policy set partially updated. While a tenant can't be loaded, its reads go to the wrapped storage.
+// Only the tenants with policies or policy sets are kept, so reading any number of unknown tenants doesn't grow the cache.
+type Storage struct {
+	api.Storage
+
+	// snapshots maps the tenants to their snapshots. The map is never changed, a refresh stores a new one.
+	snapshots atomic.Pointer[map[string]*snapshot]
+	// refreshMu serializes the refreshes, so an older snapshot never replaces a newer one.
+	refreshMu sync.Mutex
+	now       func() time.Time
+
+	statsMu sync.Mutex
+	stats   Stats
+}
+
+type snapshot struct {
+	policies []policycraft.Policy
+	bySet    map[string][]policycraft.Policy
+	sets     map[string]policycraft.PolicySet
+	loadedAt time.Time
+}
+
+// Stats describes the state of the cache, to be exported as metrics.
+type Stats struct {
+	// AgeSeconds is the time since the oldest snapshot was loaded. It's -1 while nothing was loaded.
+	AgeSeconds float64 `json:"age_seconds"`
+	Tenants    int     `json:"tenants"`
+	Policies   int     `json:"policies"`
+	PolicySets int     `json:"policy_sets"`
+	Refreshes  uint64  `json:"refreshes"`
+	Failures   uint64  `json:"failures"`
+	LastError  string  `json:"last_error,omitempty"`
+}
+
+// NewStorage returns a Storage that caches db. Nothing is loaded until a tenant is read or Refresh is called.
+func NewStorage(db api.Storage) *Storage {
+	s := &Storage{Storage: db, now: time.Now}
+	s.snapshots.Store(&map[string]*snapshot{})
+	return s
+}
+
+// Refresh loads again the policies and policy sets of every cached tenant and of the context tenant, replacing their snapshots.
+// If a tenant fails, its current snapshot is kept and the first error is returned.
+func (s *Storage) Refresh(ctx context.Context) error {
+	tenants := map[string]bool{policycraft.Tenant(ctx): true}
+	for tenant := range *s.snapshots.Load() {
+		tenants[tenant] = true
+	}
+	var firstErr error
+	for tenant := range tenants {
+		if err := s.refreshTenant(policycraft.WithTenant(ctx, tenant)); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("refreshing tenant %s: %v", tenant, err)
+		}
+	}
+	return firstErr
+}
+
+// refreshTenant loads the snapshot of the context tenant.
+func (s *Storage) refreshTenant(ctx context.Context) error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	_, err := s.reload(ctx)
+	return err
+}
+
+// reload loads the snapshot of the context tenant and returns it. The snapshot of a tenant without policies nor policy sets isn't
+// kept, and the one it had is dropped. The caller must hold refreshMu.
+func (s *Storage) reload(ctx context.Context) (*snapshot, error) {
+	next, err := s.load(ctx)
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if err != nil {
+		s.stats.Failures++
+		s.stats.LastError = err.Error()
+		return nil, err
+	}
+	tenant := policycraft.Tenant(ctx)
+	current := *s.snapshots.Load()
+	snapshots := make(map[string]*snapshot, len(current)+1)
+	for t, snap := range current {
+		if t != tenant {
+			snapshots[t] = snap
+		}
+	}
+	if len(next.policies) > 0 || len(next.sets) > 0 {
+		snapshots[tenant] = next
+	}
+	s.snapshots.Store(&snapshots)
+	s.stats.Refreshes++
+	s.stats.LastError = ""
+	return next, nil
+}
+
+// snapshot returns the snapshot of the context tenant, loading it on the first read. It returns nil if it can't be loaded.
+// The snapshot of an empty tenant serves the read, but is loaded again by the next one.
+func (s *Storage) snapshot(ctx context.Context) *snapshot {
+	tenant := policycraft.Tenant(ctx)
+	if snap, ok := (*s.snapshots.Load())[tenant]; ok {
+		return snap
+	}
+
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	// the concurrent readers of a new tenant wait for the first one to load it
+	if snap, ok := (*s.snapshots.Load())[tenant]; ok {
+		return snap
+	}
+	snap, err := s.reload(ctx)
+	if err != nil {
+		slog.Error("failed to load the policy cache", "tenant", tenant, "error", err)
+		return nil
+	}
+	return snap
+}
+
+func (s *Storage) load(ctx context.Context) (*snapshot, error) {
+	loadedAt := s.now()
+	policies, err := s.Storage.Policies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sets, err := s.Storage.PolicySets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	next := &snapshot{
+		policies: policies,
+		bySet:    make(map[string][]policycraft.Policy),
+		sets:     make(map[string]policycraft.PolicySet, len(sets)),
+		loadedAt: loadedAt,
+	}
+	// Policies is already in evaluation order, so the policies of each set are too.
+	for _, p := range policies {
+		next.bySet[p.PolicySet] = append(next.bySet[p.PolicySet], p)
+	}
+	for _, set := range sets {
+		next.sets[set.Name] = set
+	}
+	return next, nil
+}
+
+// Run refreshes the snapshot every interval and whenever changes receives a value, until the context is done.
+// changes may be nil, when the storage doesn't notify its changes, and then the snapshot is refreshed only every interval.
+// The periodic refresh also covers the notifications that could be lost.
+func (s *Storage) Run(ctx context.Context, interval time.Duration, changes <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				slog.Warn("policy changes are no longer notified, the cache is only refreshed periodically", "interval", interval)
+				changes = nil
+				continue
+			}
+		case <-ticker.C:
+		}
+		if err := s.Refresh(ctx); err != nil {
+			slog.Error("failed to refresh the policy cache", "error", err, "age_seconds", s.Stats().AgeSeconds)
+		}
+	}
+}
+
+// Stats returns the current state of the cache.
+func (s *Storage) Stats() Stats {
+	s.statsMu.Lock()
+	stats := s.stats
+	s.statsMu.Unlock()
+
+	stats.AgeSeconds = -1
+	now := s.now()
+	for _, snap := range *s.snapshots.Load() {
+		stats.AgeSeconds = max(stats.AgeSeconds, now.Sub(snap.loadedAt).Seconds())
+		stats.Tenants++
+		stats.Policies += len(snap.policies)
+		stats.PolicySets += len(snap.sets)
+	}
+	return stats
+}
+
+// Policies returns all the policies of the snapshot of the context tenant.
+func (s *Storage) Policies(ctx context.Context) ([]policycraft.Policy, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	snap := s.snapshot(ctx)
+	if snap == nil {
+		return s.Storage.Policies(ctx)
+	}
+	return clone(snap.policies), nil
+}
+
+// PoliciesBySet returns the policies of the given policy set from the snapshot of the context tenant.
+func (s *Storage) PoliciesBySet(ctx context.Context, name string) ([]policycraft.Policy, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	snap := s.snapshot(ctx)
+	if snap == nil {
+		return s.Storage.PoliciesBySet(ctx, name)
+	}
+	return clone(snap.bySet[name]), nil
+}
+
+// PolicySet returns the policy set with the given name from the snapshot of the context tenant. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) PolicySet(ctx context.Context, name string) (policycraft.PolicySet, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.PolicySet{}, err
+	}
+	snap := s.snapshot(ctx)
+	if snap == nil {
+		return s.Storage.PolicySet(ctx, name)
+	}
+	set, ok := snap.sets[name]
+	if !ok {
+		return policycraft.PolicySet{}, policycraft.ErrNotFound
+	}
+	return set, nil
+}
+
+// SavePolicy saves the policy in the wrapped storage and refreshes the snapshot, so the change is seen right away by this replica.
+func (s *Storage) SavePolicy(ctx context.Context, policy policycraft.Policy) error {
+	if err := s.Storage.SavePolicy(ctx, policy); err != nil {
+		return err
+	}
+	s.refreshAfterWrite(ctx)
+	return nil
+}
+
+// SavePolicySet saves the policy set in the wrapped storage and refreshes the snapshot.
+func (s *Storage) SavePolicySet(ctx context.Context, set policycraft.PolicySet) error {
+	if err := s.Storage.SavePolicySet(ctx, set); err != nil {
+		return err
+	}
+	s.refreshAfterWrite(ctx)
+	return nil
+}
+
+// ImportBundle imports the bundle in the wrapped storage and refreshes the snapshot.
+func (s *Storage) ImportBundle(ctx context.Context, bundle policycraft.Bundle) error {
+	if err := s.Storage.ImportBundle(ctx, bundle); err != nil {
+		return err
+	}
+	s.refreshAfterWrite(ctx)
+	return nil
+}
+
+// PublishChangeRequest publishes the change request in the wrapped storage and refreshes the snapshot.
+func (s *Storage) PublishChangeRequest(ctx context.Context, cr policycraft.ChangeRequest) error {
+	if err := s.Storage.PublishChangeRequest(ctx, cr); err != nil {
+		return err
+	}
+	s.refreshAfterWrite(ctx)
+	return nil
+}
+
+// UpdatePolicy updates the policy in the wrapped storage and refreshes the snapshot.
+func (s *Storage) UpdatePolicy(ctx context.Context, policy policycraft.Policy) error {
+	if err := s.Storage.UpdatePolicy(ctx, policy); err != nil {
+		return err
+	}
+	s.refreshAfterWrite(ctx)
+	return nil
+}
+
+// DeletePolicy deletes the policy in the wrapped storage and refreshes the snapshot.
+func (s *Storage) DeletePolicy(ctx context.Context, id string) error {
+	if err := s.Storage.DeletePolicy(ctx, id); err != nil {
+		return err
+	}
+	s.refreshAfterWrite(ctx)
+	return nil
+}
+
+// RestorePolicy restores the policy in the wrapped storage and refreshes the snapshot.
+func (s *Storage) RestorePolicy(ctx context.Context, id string) error {
+	if err := s.Storage.RestorePolicy(ctx, id); err != nil {
+		return err
+	}
+	s.refreshAfterWrite(ctx)
+	return nil
+}
+
+// refreshAfterWrite refreshes the snapshot of the context tenant after a write that already succeeded. A failure isn't returned,
+// because the write itself can't be undone, and the snapshot is refreshed again by Run.
+func (s *Storage) refreshAfterWrite(ctx context.Context) {
+	if err := s.refreshTenant(context.WithoutCancel(ctx)); err != nil {
+		slog.Error("failed to refresh the policy cache after a change", "error", err)
+	}
+}
+
+// clone copies the slice, so the callers can't change the snapshot.
+func clone(policies []policycraft.Policy) []policycraft.Policy {
+	if policies == nil {
+		return nil
+	}
+	return append([]policycraft.Policy(nil), policies...)
+}
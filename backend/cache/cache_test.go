@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+	"github.com/perebaj/policycraft/memory"
+	"github.com/perebaj/policycraft/storagetest"
+)
+
+// countingStorage counts the policies loaded from the wrapped storage and can fail them.
+type countingStorage struct {
+	*memory.Storage
+	loads atomic.Int64
+	fail  atomic.Bool
+}
+
+func (c *countingStorage) Policies(ctx context.Context) ([]policycraft.Policy, error) {
+	c.loads.Add(1)
+	if c.fail.Load() {
+		return nil, errors.New("database is down")
+	}
+	return c.Storage.Policies(ctx)
+}
+
+func (c *countingStorage) PoliciesBySet(ctx context.Context, name string) ([]policycraft.Policy, error) {
+	c.loads.Add(1)
+	return c.Storage.PoliciesBySet(ctx, name)
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) api.Storage {
+		s := NewStorage(memory.NewStorage())
+		if err := s.Refresh(context.Background()); err != nil {
+			t.Fatalf("error refreshing cache: %v", err)
+		}
+		return s
+	})
+}
+
+func TestStorageServesSnapshot(t *testing.T) {
+	ctx := context.Background()
+	db := &countingStorage{Storage: memory.NewStorage()}
+	policy := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"}
+	if err := db.SavePolicy(ctx, policy); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+
+	s := NewStorage(db)
+	if got := s.Stats().AgeSeconds; got != -1 {
+		t.Fatalf("expected age -1 before the first refresh, got %v", got)
+	}
+	// before the first refresh, the reads go to the database
+	if _, err := s.PoliciesBySet(ctx, "credit"); err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	if err := s.Refresh(ctx); err != nil {
+		t.Fatalf("error refreshing cache: %v", err)
+	}
+	loads := db.loads.Load()
+
+	for i := 0; i < 10; i++ {
+		got, err := s.PoliciesBySet(ctx, "credit")
+		if err != nil {
+			t.Fatalf("error getting policies: %v", err)
+		}
+		if len(got) != 1 || got[0].ID != policy.ID {
+			t.Fatalf("expected the cached policy, got %v", got)
+		}
+		// the caller can't change the snapshot
+		got[0].Name = "changed"
+	}
+	if db.loads.Load() != loads {
+		t.Fatalf("expected the policies to be served from the snapshot, got %d loads", db.loads.Load()-loads)
+	}
+	got, _ := s.PoliciesBySet(ctx, "credit")
+	if got[0].Name != "age" {
+		t.Fatalf("expected the snapshot to be unchanged, got %v", got)
+	}
+
+	// a change made by another replica is seen only after the refresh
+	changed := policy
+	changed.Value = 20
+	if err := db.SavePolicy(ctx, changed); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	if got, _ := s.PoliciesBySet(ctx, "credit"); got[0].Value != 17 {
+		t.Fatalf("expected the snapshot value, got %v", got[0].Value)
+	}
+	if err := s.Refresh(ctx); err != nil {
+		t.Fatalf("error refreshing cache: %v", err)
+	}
+	if got, _ := s.PoliciesBySet(ctx, "credit"); got[0].Value != 20 {
+		t.Fatalf("expected the refreshed value, got %v", got[0].Value)
+	}
+}
+
+func TestStorageUnknownTenants(t *testing.T) {
+	ctx := context.Background()
+	db := &countingStorage{Storage: memory.NewStorage()}
+	policy := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"}
+	if err := db.SavePolicy(ctx, policy); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	s := NewStorage(db)
+
+	// the reads of the tenants without policies nor policy sets are served, but their snapshots aren't kept
+	for i := 0; i < 100; i++ {
+		got, err := s.PoliciesBySet(policycraft.WithTenant(ctx, uuid.NewString()), "credit")
+		if err != nil || len(got) != 0 {
+			t.Fatalf("expected no policies, got %v (%v)", got, err)
+		}
+	}
+	if _, err := s.PoliciesBySet(ctx, "credit"); err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	if got := s.Stats().Tenants; got != 1 {
+		t.Fatalf("expected only the tenant with policies to be cached, got %d tenants", got)
+	}
+
+	// a tenant left without policies is dropped
+	if err := s.DeletePolicy(ctx, policy.ID); err != nil {
+		t.Fatalf("error deleting policy: %v", err)
+	}
+	if got := s.Stats().Tenants; got != 0 {
+		t.Fatalf("expected the empty tenant to be dropped, got %d tenants", got)
+	}
+}
+
+func TestStorageRefreshFailure(t *testing.T) {
+	ctx := context.Background()
+	db := &countingStorage{Storage: memory.NewStorage()}
+	if err := db.SavePolicySet(ctx, policycraft.PolicySet{Name: "credit"}); err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewStorage(db)
+	s.now = func() time.Time { return now }
+	if err := s.Refresh(ctx); err != nil {
+		t.Fatalf("error refreshing cache: %v", err)
+	}
+
+	// the snapshot is kept when the refresh fails, and gets older
+	db.fail.Store(true)
+	now = now.Add(time.Minute)
+	if err := s.Refresh(ctx); err == nil {
+		t.Fatalf("expected the refresh to fail")
+	}
+	if _, err := s.PolicySet(ctx, "credit"); err != nil {
+		t.Fatalf("expected the cached policy set, got %v", err)
+	}
+	stats := s.Stats()
+	if stats.AgeSeconds != 60 || stats.Refreshes != 1 || stats.Failures != 1 || stats.LastError == "" || stats.PolicySets != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestStorageRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := memory.NewStorage()
+	s := NewStorage(db)
+
+	changes := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx, time.Hour, changes)
+		close(done)
+	}()
+
+	err := db.SavePolicySet(ctx, policycraft.PolicySet{Name: "credit"})
+	if err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+	changes <- struct{}{}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Stats().PolicySets != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the notification to refresh the cache")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
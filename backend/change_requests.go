@@ -0,0 +1,171 @@
+// Package policycraft ...
+// change_requests.go gather the entity change request, the four-eyes review of the changes of the policies.
+package policycraft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ChangeStatus is the status of a change request in its lifecycle: draft → in_review → approved → published.
+type ChangeStatus string
+
+// The statuses of a change request.
+const (
+	// ChangeStatusDraft is a change request being written by its author. A rejected change request goes back to draft.
+	ChangeStatusDraft ChangeStatus = "draft"
+	// ChangeStatusInReview is a change request waiting for the review of another user.
+	ChangeStatusInReview ChangeStatus = "in_review"
+	// ChangeStatusApproved is a change request approved by a reviewer, ready to be published.
+	ChangeStatusApproved ChangeStatus = "approved"
+	// ChangeStatusPublished is a change request whose policies were saved, so they are evaluated by the execution engine.
+	ChangeStatusPublished ChangeStatus = "published"
+)
+
+// ErrConflict is returned when an entity can't be changed, because of its current state or because it was changed concurrently.
+var ErrConflict = errors.New("conflict")
+
+// ErrNotAuthor is returned when someone other than the author of a change request tries to edit or submit it.
+var ErrNotAuthor = errors.New("only the author can edit or submit the change request")
+
+// ErrSelfApproval is returned when the author of a change request tries to approve it.
+var ErrSelfApproval = errors.New("a change request must be approved by someone other than its author")
+
+// ChangeRequest is a proposed change of policies. The policies only reach the execution engine when the change request,
+// approved by someone other than its author, is published.
+type ChangeRequest struct {
+	// ID is the unique identifier of the change request.
+	ID string `json:"id"`
+	// Title is a short description of the change.
+	Title string `json:"title"`
+	// Author is the subject of the principal that created the change request.
+	Author string `json:"author"`
+	// Status is the current status of the change request.
+	Status ChangeStatus `json:"status"`
+	// Policies are the policies created or updated when the change request is published. Every field of an existing policy is replaced.
+	Policies []Policy `json:"policies"`
+	// BaseRevisions is the last revision of each policy when it was proposed, 0 for a new policy, by policy id. The change request
+	// can't be published once a policy has another last revision, so it doesn't overwrite the changes made since.
+	BaseRevisions map[string]int `json:"base_revisions,omitempty"`
+	// Patches are the partial updates of the policies proposed by PATCH, by policy id, so the reviewers see the fields they change.
+	// The policies are published as they were approved, never patched again.
+	Patches map[string]json.RawMessage `json:"patches,omitempty"`
+	// Comments are the comments of the author and the reviewers, the oldest first.
+	Comments []Comment `json:"comments"`
+	// ApprovedBy is the subject of the reviewer that approved the change request.
+	ApprovedBy string `json:"approved_by,omitempty"`
+	// PublishedBy is the subject of the principal that published the change request.
+	PublishedBy string `json:"published_by,omitempty"`
+	// Version is incremented by the storage on every change, so a concurrent change is detected instead of lost.
+	Version int `json:"version"`
+	// CreatedAt is the time when the change request was created.
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is the time of the last change of the change request.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Comment is a comment of a change request. The status changes made by the reviewers are recorded as comments too.
+type Comment struct {
+	// Author is the subject of the principal that wrote the comment.
+	Author string `json:"author"`
+	// Body is the text of the comment.
+	Body string `json:"body"`
+	// CreatedAt is the time when the comment was written.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChangeRequestFilter gather the optional filters used to query change requests. Zero values mean no filter.
+type ChangeRequestFilter struct {
+	// Status returns only the change requests with the given status.
+	Status ChangeStatus
+}
+
+// CheckChangeStatus checks if the status is known.
+func CheckChangeStatus(status ChangeStatus) error {
+	switch status {
+	case ChangeStatusDraft, ChangeStatusInReview, ChangeStatusApproved, ChangeStatusPublished:
+		return nil
+	default:
+		return fmt.Errorf("unknown status %q", status)
+	}
+}
+
+// Edit replaces the title, the policies and their base revisions of the change request, dropping its patches. Only its author
+// can edit it, while it's a draft.
+func (c *ChangeRequest) Edit(by, title string, policies []Policy, bases map[string]int, at time.Time) error {
+	if by != c.Author {
+		return ErrNotAuthor
+	}
+	if err := c.transition(ChangeStatusDraft, ChangeStatusDraft, at); err != nil {
+		return err
+	}
+	c.Title = title
+	c.Policies = policies
+	c.BaseRevisions = bases
+	c.Patches = nil
+	return nil
+}
+
+// Submit sends the draft to review. Only its author can submit it.
+func (c *ChangeRequest) Submit(by string, at time.Time) error {
+	if by != c.Author {
+		return ErrNotAuthor
+	}
+	return c.transition(ChangeStatusDraft, ChangeStatusInReview, at)
+}
+
+// Approve approves the change request in review. The reviewer must not be its author.
+func (c *ChangeRequest) Approve(by, comment string, at time.Time) error {
+	if by == c.Author {
+		return ErrSelfApproval
+	}
+	if err := c.transition(ChangeStatusInReview, ChangeStatusApproved, at); err != nil {
+		return err
+	}
+	c.ApprovedBy = by
+	c.AddComment(by, withDefault(comment, "approved"), at)
+	return nil
+}
+
+// Reject sends the change request in review back to draft, so its author can change it. The comment tells why.
+func (c *ChangeRequest) Reject(by, comment string, at time.Time) error {
+	if err := c.transition(ChangeStatusInReview, ChangeStatusDraft, at); err != nil {
+		return err
+	}
+	c.AddComment(by, comment, at)
+	return nil
+}
+
+// Publish marks the approved change request as published. The storage saves its policies along with it, in a single transaction.
+func (c *ChangeRequest) Publish(by string, at time.Time) error {
+	if err := c.transition(ChangeStatusApproved, ChangeStatusPublished, at); err != nil {
+		return err
+	}
+	c.PublishedBy = by
+	return nil
+}
+
+// AddComment records a comment. A change request can be commented in any status.
+func (c *ChangeRequest) AddComment(by, body string, at time.Time) {
+	c.Comments = append(c.Comments, Comment{Author: by, Body: body, CreatedAt: at})
+	c.UpdatedAt = at
+}
+
+// transition changes the status, if the current one is from.
+func (c *ChangeRequest) transition(from, to ChangeStatus, at time.Time) error {
+	if c.Status != from {
+		return fmt.Errorf("%w: the change request is %s, it must be %s", ErrConflict, c.Status, from)
+	}
+	c.Status = to
+	c.UpdatedAt = at
+	return nil
+}
+
+func withDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
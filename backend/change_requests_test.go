@@ -0,0 +1,56 @@
+package policycraft
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChangeRequestTransitions(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		status  ChangeStatus
+		change  func(cr *ChangeRequest) error
+		want    ChangeStatus
+		wantErr error
+	}{
+		{name: "author edits the draft", status: ChangeStatusDraft, change: func(cr *ChangeRequest) error { return cr.Edit("alice", "new title", nil, nil, now) }, want: ChangeStatusDraft},
+		{name: "reviewer edits the draft", status: ChangeStatusDraft, change: func(cr *ChangeRequest) error { return cr.Edit("bob", "new title", nil, nil, now) }, wantErr: ErrNotAuthor},
+		{name: "author edits in review", status: ChangeStatusInReview, change: func(cr *ChangeRequest) error { return cr.Edit("alice", "new title", nil, nil, now) }, wantErr: ErrConflict},
+		{name: "author submits", status: ChangeStatusDraft, change: func(cr *ChangeRequest) error { return cr.Submit("alice", now) }, want: ChangeStatusInReview},
+		{name: "reviewer submits", status: ChangeStatusDraft, change: func(cr *ChangeRequest) error { return cr.Submit("bob", now) }, wantErr: ErrNotAuthor},
+		{name: "reviewer approves", status: ChangeStatusInReview, change: func(cr *ChangeRequest) error { return cr.Approve("bob", "", now) }, want: ChangeStatusApproved},
+		{name: "author approves", status: ChangeStatusInReview, change: func(cr *ChangeRequest) error { return cr.Approve("alice", "", now) }, wantErr: ErrSelfApproval},
+		{name: "reviewer approves a draft", status: ChangeStatusDraft, change: func(cr *ChangeRequest) error { return cr.Approve("bob", "", now) }, wantErr: ErrConflict},
+		{name: "reviewer rejects", status: ChangeStatusInReview, change: func(cr *ChangeRequest) error { return cr.Reject("bob", "no", now) }, want: ChangeStatusDraft},
+		{name: "reviewer rejects an approved", status: ChangeStatusApproved, change: func(cr *ChangeRequest) error { return cr.Reject("bob", "no", now) }, wantErr: ErrConflict},
+		{name: "publish approved", status: ChangeStatusApproved, change: func(cr *ChangeRequest) error { return cr.Publish("carol", now) }, want: ChangeStatusPublished},
+		{name: "publish in review", status: ChangeStatusInReview, change: func(cr *ChangeRequest) error { return cr.Publish("carol", now) }, wantErr: ErrConflict},
+		{name: "publish published", status: ChangeStatusPublished, change: func(cr *ChangeRequest) error { return cr.Publish("carol", now) }, wantErr: ErrConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := ChangeRequest{Author: "alice", Status: tt.status}
+			err := tt.change(&cr)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+				}
+				if cr.Status != tt.status {
+					t.Fatalf("expected the status %s unchanged, got %s", tt.status, cr.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cr.Status != tt.want {
+				t.Fatalf("expected status %s, got %s", tt.want, cr.Status)
+			}
+			if !cr.UpdatedAt.Equal(now) {
+				t.Fatalf("expected updated_at %v, got %v", now, cr.UpdatedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Package client is the Go client of the policycraft API. It sends the typed requests of the api package, retrying the failures that
+// are worth it without repeating a change, and returns the errors answered by the API as *Error.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+)
+
+const (
+	// defaultBackoff is the wait before the first retry when Config.Backoff is zero.
+	defaultBackoff = 100 * time.Millisecond
+	// maxBackoff bounds the wait before a retry, including the one asked by the Retry-After header.
+	maxBackoff = 10 * time.Second
+	// maxResponseSize is the maximum size of a response body.
+	maxResponseSize = 10 << 20
+)
+
+// Config configures the client.
+type Config struct {
+	// URL is the base URL of the API, such as http://localhost:8080.
+	URL string
+	// Credential is the API key or the JWT sent as the bearer credentials. Empty doesn't send any, for a service with the
+	// authentication disabled.
+	Credential string
+	// Tenant is the tenant of the requests. Empty uses the tenant of the credentials, or the default one.
+	Tenant string
+	// HTTPClient makes the requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Retries is the number of retries of a request that failed with a network error, 429, 502, 503 or 504. The POST and PATCH
+	// requests, such as the executions and the creations of policies, aren't idempotent, so they are only retried when they
+	// weren't sent, on a failure to connect, or with 429.
+	Retries int
+	// Backoff is the wait before the first retry, doubled before each other. If zero, 100ms is used.
+	Backoff time.Duration
+}
+
+// Client is the client of the policycraft API. It's safe for concurrent use.
+type Client struct {
+	baseURL *url.URL
+	cfg     Config
+	client  *http.Client
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+// New returns a client of the API at cfg.URL.
+func New(cfg Config) (*Client, error) {
+	baseURL, err := url.Parse(cfg.URL)
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q, it must be an absolute http or https URL", cfg.URL)
+	}
+	if cfg.Retries < 0 {
+		return nil, errors.New("retries can't be negative")
+	}
+	if cfg.Backoff == 0 {
+		cfg.Backoff = defaultBackoff
+	}
+	c := &Client{baseURL: baseURL, cfg: cfg, client: cfg.HTTPClient, sleep: sleep}
+	if c.client == nil {
+		c.client = http.DefaultClient
+	}
+	return c, nil
+}
+
+var (
+	// ErrInvalidInput is matched by the errors answered with 400.
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrUnauthorized is matched by the errors answered with 401, when the credentials are missing or invalid.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is matched by the errors answered with 403, when the credentials don't have the role or the tenant required.
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound is matched by the errors answered with 404.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is matched by the errors answered with 409, such as a duplicated priority or a concurrent change.
+	ErrConflict = errors.New("conflict")
+	// ErrPreconditionFailed is matched by the errors answered with 412, when the policy changed since the If-Match ETag.
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+var statusErrs = map[int]error{
+	http.StatusBadRequest:         ErrInvalidInput,
+	http.StatusUnauthorized:       ErrUnauthorized,
+	http.StatusForbidden:          ErrForbidden,
+	http.StatusNotFound:           ErrNotFound,
+	http.StatusConflict:           ErrConflict,
+	http.StatusPreconditionFailed: ErrPreconditionFailed,
+}
+
+// Error is an error answered by the API, decoded from its api.ErrMsg. It matches the sentinel error of its status code with errors.Is,
+// such as ErrNotFound.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Msg is the message of the error.
+	Msg string
+	// Fields are the invalid fields of the input, when they are known.
+	Fields []policycraft.FieldError
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s (status %d)", e.Msg, e.StatusCode)
+	if len(e.Fields) == 0 {
+		return msg
+	}
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = f.Field + " " + f.Msg
+	}
+	return msg + ": " + strings.Join(fields, ", ")
+}
+
+// Is tells if the target is the sentinel error of the status code.
+func (e *Error) Is(target error) bool {
+	return statusErrs[e.StatusCode] == target
+}
+
+// request is a request to the API. The body, if not nil, is sent as JSON.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   interface{}
+}
+
+// do sends the request, retrying it on the failures that are worth it, and returns the response with its body, already closed.
+// A response with a status code other than 2xx is returned as *Error.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, []byte, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+	}
+	u := c.baseURL.JoinPath(req.path)
+	u.RawQuery = req.query.Encode()
+
+	backoff := c.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		resp, respBody, retry, err := c.send(ctx, req, u.String(), body)
+		if !retry || attempt == c.cfg.Retries {
+			return resp, respBody, err
+		}
+
+		wait := backoff
+		if resp != nil {
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+				wait = time.Duration(seconds) * time.Second
+			}
+		}
+		if err := c.sleep(ctx, min(wait, maxBackoff)); err != nil {
+			return nil, nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// send makes a single attempt of the request. It returns whether the failure is worth a retry.
+func (c *Client) send(ctx context.Context, req request, u string, body []byte) (resp *http.Response, respBody []byte, retry bool, err error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u, reader)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range req.header {
+		httpReq.Header[key] = values
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.Credential != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.cfg.Credential)
+	}
+	if c.cfg.Tenant != "" {
+		httpReq.Header.Set(api.TenantHeader, c.cfg.Tenant)
+	}
+
+	resp, err = c.client.Do(httpReq)
+	if err != nil {
+		// the context is done, so a retry would fail too
+		retry = ctx.Err() == nil && (idempotent(req.method) || dialErr(err))
+		return nil, nil, retry, fmt.Errorf("failed to request %s %s: %w", req.method, req.path, err)
+	}
+	defer resp.Body.Close()
+	respBody, err = io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		retry = ctx.Err() == nil && idempotent(req.method)
+		return nil, nil, retry, fmt.Errorf("failed to read response of %s %s: %w", req.method, req.path, err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, respBody, false, nil
+	}
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	var msg api.ErrMsg
+	if err := json.Unmarshal(respBody, &msg); err == nil && msg.Msg != "" {
+		apiErr.Msg, apiErr.Fields = msg.Msg, msg.Fields
+	} else {
+		apiErr.Msg = strings.ToLower(http.StatusText(resp.StatusCode))
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		retry = true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		// the API may have made the change before the gateway failed
+		retry = idempotent(req.method)
+	}
+	return resp, respBody, retry, apiErr
+}
+
+// idempotent reports whether sending a request of the method again has the same effect as sending it once.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// dialErr reports whether the request failed to connect to the API, so it wasn't sent.
+func dialErr(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// decode unmarshals the JSON body of a response.
+func decode(body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// sleep waits for the duration, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
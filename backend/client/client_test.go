@@ -0,0 +1,376 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+	"github.com/perebaj/policycraft/enrichment"
+	"github.com/perebaj/policycraft/memory"
+)
+
+const adminKey = "admin-key"
+
+// newServer serves the handlers of the policies and of the execution over the storage, authenticating the adminKey.
+func newServer(t *testing.T, db api.Storage, approvals bool) *httptest.Server {
+	t.Helper()
+	savePolicy, patchPolicy := api.SavePolicyHandler(db), api.PatchPolicyHandler(db)
+	if approvals {
+		savePolicy, patchPolicy = api.ProposePolicyHandler(db), api.ProposePolicyPatchHandler(db)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /policies", savePolicy)
+	mux.HandleFunc("GET /policies", api.ListPoliciesHandler(db))
+	mux.HandleFunc("GET /policies/export", api.ExportPoliciesHandler(db))
+	mux.HandleFunc("POST /policies/import", api.ImportPoliciesHandler(db))
+	mux.HandleFunc("GET /policies/{id}", api.GetPolicyHandler(db))
+	mux.HandleFunc("PATCH /policies/{id}", patchPolicy)
+	mux.HandleFunc("DELETE /policies/{id}", api.DeletePolicyHandler(db))
+	mux.HandleFunc("POST /policies/{id}/restore", api.RestorePolicyHandler(db))
+	mux.HandleFunc("GET /policies/{id}/history", api.PolicyHistoryHandler(db))
+	mux.HandleFunc("PUT /policy-sets/{name}", api.SavePolicySetHandler(db))
+	mux.HandleFunc("POST /execution-engine", api.ExecutionEngineHandler(db, enrichment.NewEnricher(nil), nil))
+
+	srv := httptest.NewServer(api.NewAuthenticator(db, nil, adminKey).Middleware(api.TenantMiddleware(mux)))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newClient(t *testing.T, cfg Config) *Client {
+	t.Helper()
+	if cfg.Credential == "" {
+		cfg.Credential = adminKey
+	}
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return c
+}
+
+func newPolicy(name string, value, priority int) api.Policy {
+	successCase := true
+	return api.Policy{ID: uuid.NewString(), Name: name, Criteria: ">", Value: &value, SuccessCase: &successCase, Priority: &priority}
+}
+
+func TestClientPolicies(t *testing.T) {
+	srv := newServer(t, memory.NewStorage(), false)
+	c := newClient(t, Config{URL: srv.URL, Tenant: "credit"})
+	ctx := context.Background()
+
+	age := newPolicy("age", 17, 1)
+	saved, err := c.SavePolicy(ctx, age, ChangeOptions{Reason: "minimum age"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.ETag != `"1"` || saved.ChangeRequest != nil {
+		t.Errorf("unexpected change: %+v", saved)
+	}
+	income := newPolicy("income", 1000, 2)
+	income.Tags = []string{"kyc"}
+	if _, err := c.SavePolicy(ctx, income, ChangeOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := c.GetPolicy(ctx, age.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Name != "age" || got.Value != 17 || got.PolicySet != policycraft.DefaultPolicySet || got.ETag != `"1"` {
+		t.Errorf("unexpected policy: %+v", got)
+	}
+
+	page, err := c.ListPolicies(ctx, policycraft.PolicyFilter{Limit: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if page.Total != 2 || len(page.Policies) != 1 || page.Policies[0].Name != "age" || page.Next == "" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	page, err = c.ListPolicies(ctx, policycraft.PolicyFilter{Limit: 1, Cursor: page.Next})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Policies) != 1 || page.Policies[0].Name != "income" || page.Next != "" {
+		t.Errorf("unexpected last page: %+v", page)
+	}
+	page, err = c.ListPolicies(ctx, policycraft.PolicyFilter{Tag: "kyc"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if page.Total != 1 || page.Policies[0].Name != "income" || !page.Policies[0].Tags.Has("kyc") {
+		t.Errorf("unexpected policies of the tag: %+v", page)
+	}
+
+	// a change based on another ETag is refused
+	value := 20
+	_, err = c.PatchPolicy(ctx, age.ID, api.Policy{Value: &value}, ChangeOptions{IfMatch: `"0"`})
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected ErrPreconditionFailed, got %v", err)
+	}
+	patched, err := c.PatchPolicy(ctx, age.ID, api.Policy{Value: &value}, ChangeOptions{IfMatch: got.ETag, Reason: "adults only"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if patched.ETag != `"2"` || patched.Policy == nil || patched.Policy.Value != 20 || patched.Policy.Name != "age" {
+		t.Errorf("unexpected change: %+v", patched)
+	}
+
+	if err := c.DeletePolicy(ctx, age.ID, ChangeOptions{IfMatch: patched.ETag}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.GetPolicy(ctx, age.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	restored, err := c.RestorePolicy(ctx, age.ID, "deleted by mistake")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored.Value != 20 {
+		t.Errorf("unexpected restored policy: %+v", restored)
+	}
+
+	history, err := c.PolicyHistory(ctx, age.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var reasons []string
+	for _, rev := range history {
+		reasons = append(reasons, rev.Reason)
+	}
+	if want := []string{"minimum age", "adults only", "", "deleted by mistake"}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("expected the reasons %q, got %q", want, reasons)
+	}
+
+	// the exported bundle imports back without changes
+	bundle, err := c.ExportPolicies(ctx, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bundle.Policies) != 2 {
+		t.Fatalf("expected 2 policies in the bundle, got %+v", bundle)
+	}
+	bundle.Policies = bundle.Policies[:1]
+	imported, err := c.ImportPolicies(ctx, bundle, ImportOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !imported.DryRun || len(imported.Diff.Removed) != 1 {
+		t.Errorf("expected a dry run removing a policy, got %+v", imported)
+	}
+
+	// the policies belong to the tenant of the client
+	other := newClient(t, Config{URL: srv.URL})
+	if _, err := other.GetPolicy(ctx, age.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound in another tenant, got %v", err)
+	}
+}
+
+func TestClientProposals(t *testing.T) {
+	srv := newServer(t, memory.NewStorage(), true)
+	c := newClient(t, Config{URL: srv.URL})
+
+	policy := newPolicy("age", 17, 1)
+	proposed, err := c.SavePolicy(context.Background(), policy, ChangeOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if proposed.ChangeRequest == nil || proposed.ETag != "" || proposed.ChangeRequest.Status != policycraft.ChangeStatusInReview {
+		t.Fatalf("expected a change request in review, got %+v", proposed)
+	}
+	if _, err := c.GetPolicy(context.Background(), policy.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the policy not to be saved, got %v", err)
+	}
+}
+
+func TestClientExecute(t *testing.T) {
+	db := memory.NewStorage()
+	srv := newServer(t, db, false)
+	c := newClient(t, Config{URL: srv.URL})
+	ctx := context.Background()
+
+	if _, err := c.SavePolicy(ctx, newPolicy("age", 17, 1), ChangeOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := c.Execute(ctx, api.ExecutionRequest{CustomFields: map[string]interface{}{"age": 18}, CallerRef: "proposal-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.Decision {
+		t.Errorf("expected an approved decision, got %+v", resp)
+	}
+	decision, err := db.Decision(policycraft.WithTenant(ctx, policycraft.DefaultTenant), resp.DecisionID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := uuid.Parse(decision.RequestID); err != nil || decision.CallerRef != "proposal-1" {
+		t.Errorf("expected a generated request id and the caller ref, got %q and %q", decision.RequestID, decision.CallerRef)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	srv := newServer(t, memory.NewStorage(), false)
+	c := newClient(t, Config{URL: srv.URL})
+	ctx := context.Background()
+
+	// the invalid fields of the input are typed
+	schema := `{"input_schema": {"fields": {"age": {"type": "integer", "required": true}}}}`
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"/policy-sets/loans", strings.NewReader(schema))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+adminKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d saving the policy set, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	_, err = c.Execute(ctx, api.ExecutionRequest{PolicySet: "loans"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected an *Error of invalid input, got %v", err)
+	}
+	want := []policycraft.FieldError{{Field: "age", Msg: "is required"}}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Msg != "invalid input" || !reflect.DeepEqual(apiErr.Fields, want) {
+		t.Errorf("unexpected error: %+v", apiErr)
+	}
+	if apiErr.Error() != "invalid input (status 400): age is required" {
+		t.Errorf("unexpected error message: %s", apiErr.Error())
+	}
+
+	_, err = c.SavePolicy(ctx, api.Policy{ID: "not a uuid"}, ChangeOptions{})
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput, got %v", err)
+	}
+
+	unauthorized := newClient(t, Config{URL: srv.URL, Credential: "unknown"})
+	if _, err := unauthorized.ListPolicies(ctx, policycraft.PolicyFilter{}); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	srv := newServer(t, memory.NewStorage(), false)
+
+	// flaky answers the first requests with the status codes of the failures, asking to retry 429 after 3 seconds, and the others
+	// with the API
+	var attempts atomic.Int32
+	flaky := func(failures ...int) *httptest.Server {
+		attempts.Store(0)
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := int(attempts.Add(1))
+			if n <= len(failures) {
+				if failures[n-1] == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "3")
+				}
+				w.WriteHeader(failures[n-1])
+				return
+			}
+			r.URL.Scheme, r.URL.Host = "http", srv.Listener.Addr().String()
+			r.RequestURI = ""
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			defer resp.Body.Close()
+			for k, v := range resp.Header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.Copy(w, resp.Body)
+		}))
+		t.Cleanup(proxy.Close)
+		return proxy
+	}
+	newRetryingClient := func(url string, retries int) (*Client, *[]time.Duration) {
+		c := newClient(t, Config{URL: url, Retries: retries, Backoff: time.Second})
+		var waits []time.Duration
+		c.sleep = func(ctx context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return ctx.Err()
+		}
+		return c, &waits
+	}
+	ctx := context.Background()
+
+	c, waits := newRetryingClient(flaky(http.StatusBadGateway, http.StatusTooManyRequests).URL, 2)
+	if _, err := c.ListPolicies(ctx, policycraft.PolicyFilter{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the backoff doubles, unless the server asks for another wait
+	if want := []time.Duration{time.Second, 3 * time.Second}; attempts.Load() != 3 || !reflect.DeepEqual(*waits, want) {
+		t.Errorf("expected 3 attempts waiting %v, got %d attempts waiting %v", want, attempts.Load(), *waits)
+	}
+
+	c, _ = newRetryingClient(flaky(http.StatusServiceUnavailable, http.StatusServiceUnavailable).URL, 1)
+	var apiErr *Error
+	if _, err := c.ListPolicies(ctx, policycraft.PolicyFilter{}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the error of the last attempt, got %v", err)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts.Load())
+	}
+
+	// the errors of the request aren't retried
+	c, _ = newRetryingClient(flaky(http.StatusBadRequest).URL, 3)
+	if _, err := c.ListPolicies(ctx, policycraft.PolicyFilter{}); !errors.Is(err, ErrInvalidInput) || attempts.Load() != 1 {
+		t.Errorf("expected a single attempt failing with ErrInvalidInput, got %d attempts failing with %v", attempts.Load(), err)
+	}
+
+	// the executions may have been made before the gateway failed, so they are only retried with 429
+	c, _ = newRetryingClient(flaky(http.StatusServiceUnavailable).URL, 3)
+	if _, err := c.Execute(ctx, api.ExecutionRequest{}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the error of the first attempt, got %v", err)
+	}
+	if attempts.Load() != 1 {
+		t.Errorf("expected 1 attempt, got %d", attempts.Load())
+	}
+	c, _ = newRetryingClient(flaky(http.StatusTooManyRequests).URL, 3)
+	_, _ = c.Execute(ctx, api.ExecutionRequest{})
+	if attempts.Load() != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts.Load())
+	}
+
+	// nor on a failure to connect
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lis.Close()
+	c, waits = newRetryingClient("http://"+lis.Addr().String(), 2)
+	if _, err := c.Execute(ctx, api.ExecutionRequest{}); err == nil || len(*waits) != 2 {
+		t.Errorf("expected 3 attempts failing, got %d retries failing with %v", len(*waits), err)
+	}
+
+	// the context stops the retries
+	c, _ = newRetryingClient(flaky(http.StatusServiceUnavailable).URL, 3)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.ListPolicies(canceled, policycraft.PolicyFilter{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, cfg := range []Config{{URL: ""}, {URL: "localhost:8080"}, {URL: "ftp://localhost"}, {URL: "http://localhost", Retries: -1}} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
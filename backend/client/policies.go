@@ -0,0 +1,255 @@
+// Package client ...
+// policies.go gather the requests of the policies and of their execution.
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+)
+
+// ChangeOptions are the options of a change of a policy.
+type ChangeOptions struct {
+	// Reason is why the policy is changed, recorded in its history.
+	Reason string
+	// IfMatch is the ETag of the policy the change is based on. The change is refused with ErrPreconditionFailed if the policy
+	// changed since. Empty bases the change on the policy read by the service.
+	IfMatch string
+}
+
+// header returns the headers of the options.
+func (o ChangeOptions) header() http.Header {
+	h := http.Header{}
+	if o.Reason != "" {
+		h.Set(api.ChangeReasonHeader, o.Reason)
+	}
+	if o.IfMatch != "" {
+		h.Set("If-Match", o.IfMatch)
+	}
+	return h
+}
+
+// Change is the result of a change of a policy. When the approvals are required, the change is proposed in a change request
+// instead of being saved.
+type Change struct {
+	// ETag is the ETag of the saved policy, to base the next change on. Empty when the change was proposed.
+	ETag string
+	// Policy is the saved policy, answered by PatchPolicy. Nil when the change was proposed.
+	Policy *policycraft.Policy
+	// ChangeRequest is the change request created with the change, already in review. Nil when the policy was saved.
+	ChangeRequest *policycraft.ChangeRequest
+}
+
+// VersionedPolicy is a policy with its ETag.
+type VersionedPolicy struct {
+	policycraft.Policy
+	// ETag is the ETag of the policy, to base a change on, see ChangeOptions.IfMatch.
+	ETag string
+}
+
+// ListPolicies returns a page of the policies that match the filter. A zero Limit returns the default number of policies.
+func (c *Client) ListPolicies(ctx context.Context, filter policycraft.PolicyFilter) (policycraft.PolicyPage, error) {
+	query := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	set("policy_set", filter.PolicySet)
+	set("name", filter.Name)
+	set("criteria", filter.Criteria)
+	set("tag", filter.Tag)
+	set("sort", string(filter.Sort))
+	set("cursor", filter.Cursor)
+	if !filter.UpdatedFrom.IsZero() {
+		set("updated_from", filter.UpdatedFrom.Format(time.RFC3339))
+	}
+	if !filter.UpdatedTo.IsZero() {
+		set("updated_to", filter.UpdatedTo.Format(time.RFC3339))
+	}
+	if filter.Limit != 0 {
+		set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	resp, body, err := c.do(ctx, request{method: http.MethodGet, path: "/policies", query: query})
+	if err != nil {
+		return policycraft.PolicyPage{}, err
+	}
+	var page policycraft.PolicyPage
+	if err := decode(body, &page.Policies); err != nil {
+		return policycraft.PolicyPage{}, err
+	}
+	page.Total, _ = strconv.Atoi(resp.Header.Get(api.TotalCountHeader))
+	page.Next = resp.Header.Get(api.NextCursorHeader)
+	return page, nil
+}
+
+// GetPolicy returns the policy with the id. A deleted policy isn't found.
+func (c *Client) GetPolicy(ctx context.Context, id string) (VersionedPolicy, error) {
+	resp, body, err := c.do(ctx, request{method: http.MethodGet, path: "/policies/" + url.PathEscape(id)})
+	if err != nil {
+		return VersionedPolicy{}, err
+	}
+	var policy VersionedPolicy
+	if err := decode(body, &policy.Policy); err != nil {
+		return VersionedPolicy{}, err
+	}
+	policy.ETag = resp.Header.Get("ETag")
+	return policy, nil
+}
+
+// SavePolicy creates a policy or replaces every field of an existing one.
+func (c *Client) SavePolicy(ctx context.Context, policy api.Policy, opts ChangeOptions) (Change, error) {
+	resp, body, err := c.do(ctx, request{method: http.MethodPost, path: "/policies", header: opts.header(), body: policy})
+	if err != nil {
+		return Change{}, err
+	}
+	return change(resp, body, false)
+}
+
+// PatchPolicy updates the fields of the policy with the id that are present in the patch, keeping the others.
+func (c *Client) PatchPolicy(ctx context.Context, id string, patch api.Policy, opts ChangeOptions) (Change, error) {
+	resp, body, err := c.do(ctx, request{method: http.MethodPatch, path: "/policies/" + url.PathEscape(id), header: opts.header(), body: patch})
+	if err != nil {
+		return Change{}, err
+	}
+	return change(resp, body, true)
+}
+
+// change decodes the response of a change of a policy, which has the saved policy when withPolicy is true.
+func change(resp *http.Response, body []byte, withPolicy bool) (Change, error) {
+	if resp.StatusCode == http.StatusCreated {
+		var cr policycraft.ChangeRequest
+		if err := decode(body, &cr); err != nil {
+			return Change{}, err
+		}
+		return Change{ChangeRequest: &cr}, nil
+	}
+	c := Change{ETag: resp.Header.Get("ETag")}
+	if withPolicy {
+		var policy policycraft.Policy
+		if err := decode(body, &policy); err != nil {
+			return Change{}, err
+		}
+		c.Policy = &policy
+	}
+	return c, nil
+}
+
+// DeletePolicy deletes the policy with the id. It can be restored by RestorePolicy.
+func (c *Client) DeletePolicy(ctx context.Context, id string, opts ChangeOptions) error {
+	_, _, err := c.do(ctx, request{method: http.MethodDelete, path: "/policies/" + url.PathEscape(id), header: opts.header()})
+	return err
+}
+
+// RestorePolicy restores the deleted policy with the id, as it was when deleted. The reason is recorded in its history.
+func (c *Client) RestorePolicy(ctx context.Context, id, reason string) (policycraft.Policy, error) {
+	opts := ChangeOptions{Reason: reason}
+	_, body, err := c.do(ctx, request{method: http.MethodPost, path: "/policies/" + url.PathEscape(id) + "/restore", header: opts.header()})
+	if err != nil {
+		return policycraft.Policy{}, err
+	}
+	var policy policycraft.Policy
+	if err := decode(body, &policy); err != nil {
+		return policycraft.Policy{}, err
+	}
+	return policy, nil
+}
+
+// PolicyHistory returns the revisions of the policy with the id, the oldest first.
+func (c *Client) PolicyHistory(ctx context.Context, id string) ([]policycraft.PolicyRevision, error) {
+	_, body, err := c.do(ctx, request{method: http.MethodGet, path: "/policies/" + url.PathEscape(id) + "/history"})
+	if err != nil {
+		return nil, err
+	}
+	var revs []policycraft.PolicyRevision
+	if err := decode(body, &revs); err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+// ExportPolicies returns the bundle of the policy set and its policies. Empty exports the default policy set.
+func (c *Client) ExportPolicies(ctx context.Context, policySet string) (policycraft.Bundle, error) {
+	query := url.Values{}
+	if policySet != "" {
+		query.Set("policy_set", policySet)
+	}
+	_, body, err := c.do(ctx, request{method: http.MethodGet, path: "/policies/export", query: query})
+	if err != nil {
+		return policycraft.Bundle{}, err
+	}
+	var bundle policycraft.Bundle
+	if err := decode(body, &bundle); err != nil {
+		return policycraft.Bundle{}, err
+	}
+	return bundle, nil
+}
+
+// ImportOptions are the options of an import of a bundle.
+type ImportOptions struct {
+	// DryRun only compares the bundle with the stored policy set, without changing it.
+	DryRun bool
+	// Reason is why the policies are changed, recorded in their history.
+	Reason string
+}
+
+// ImportPolicies replaces the policy set of the bundle and all its policies, returning what changed.
+func (c *Client) ImportPolicies(ctx context.Context, bundle policycraft.Bundle, opts ImportOptions) (api.ImportResponse, error) {
+	query := url.Values{}
+	if opts.DryRun {
+		query.Set("dry_run", "true")
+	}
+	_, body, err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/policies/import",
+		query:  query,
+		header: ChangeOptions{Reason: opts.Reason}.header(),
+		body:   bundle,
+	})
+	if err != nil {
+		return api.ImportResponse{}, err
+	}
+	var imported api.ImportResponse
+	if err := decode(body, &imported); err != nil {
+		return api.ImportResponse{}, err
+	}
+	return imported, nil
+}
+
+// Execute evaluates the policies of the policy set of the request with its custom fields, see api.Execute. Without a RequestID,
+// one is generated, so the decisions of the retries of the execution share it.
+func (c *Client) Execute(ctx context.Context, req api.ExecutionRequest) (api.ExecutionResponse, error) {
+	query := url.Values{}
+	if req.PolicySet != "" {
+		query.Set("policy_set", req.PolicySet)
+	}
+	header := http.Header{}
+	if req.RequestID == "" {
+		req.RequestID = uuid.NewString()
+	}
+	header.Set(api.RequestIDHeader, req.RequestID)
+	if req.CallerRef != "" {
+		header.Set(api.CallerRefHeader, req.CallerRef)
+	}
+	customFields := req.CustomFields
+	if customFields == nil {
+		customFields = map[string]interface{}{}
+	}
+
+	_, body, err := c.do(ctx, request{method: http.MethodPost, path: "/execution-engine", query: query, header: header, body: customFields})
+	if err != nil {
+		return api.ExecutionResponse{}, err
+	}
+	var resp api.ExecutionResponse
+	if err := decode(body, &resp); err != nil {
+		return api.ExecutionResponse{}, err
+	}
+	return resp, nil
+}
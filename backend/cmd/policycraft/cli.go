@@ -0,0 +1,218 @@
+// Package main ...
+// cli.go gather the commands of the command line, other than serve.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/gitops"
+)
+
+const usage = `Usage: policycraft <command> [arguments]
+
+Commands:
+  serve                            serve the API, configured by the environment variables (default)
+  migrate up|down|status           migrate the database
+  policies list|get|apply|export   manage the policies, through the API or directly in the database
+  eval                             evaluate an input against a policy set
+  lint <file|dir>...               validate bundle files
+  test <file|dir>...               validate bundle files and run their policy set tests
+
+Run policycraft <command> -h for the arguments of a command.
+`
+
+// cli runs the commands, reading and writing the given streams.
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// run runs the command of the args, without the program name. A usage error, already reported to stderr, is flag.ErrHelp.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return flag.ErrHelp
+	}
+	switch args[0] {
+	case "migrate":
+		return c.migrate(ctx, args[1:])
+	case "policies":
+		return c.policies(ctx, args[1:])
+	case "eval":
+		return c.eval(ctx, args[1:])
+	case "lint":
+		return c.lint(args[1:])
+	case "test":
+		return c.test(ctx, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return flag.ErrHelp
+	}
+}
+
+// flagSet returns the flag set of the command, reporting its errors and usage to stderr.
+func (c *cli) flagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: policycraft %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the args of the command. A parse error, already reported, is flag.ErrHelp.
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	return nil
+}
+
+// lint validates the bundles of the files and directories, without running their tests.
+func (c *cli) lint(args []string) error {
+	fs := c.flagSet("lint", "<file|dir>...")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	files, err := c.bundleFiles(fs)
+	if err != nil {
+		return err
+	}
+
+	invalid := 0
+	for _, f := range files {
+		if err := f.Bundle.Validate(policycraft.DefaultRegistry); err != nil {
+			invalid++
+			fmt.Fprintf(c.stdout, "FAIL %s\n%s\n", f.Path, indent(err))
+			continue
+		}
+		fmt.Fprintf(c.stdout, "ok   %s\n", f.Path)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d bundles are invalid", invalid, len(files))
+	}
+	return nil
+}
+
+// test validates the bundles of the files and directories and runs their policy set tests.
+func (c *cli) test(ctx context.Context, args []string) error {
+	fs := c.flagSet("test", "<file|dir>...")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	files, err := c.bundleFiles(fs)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, f := range files {
+		if err := f.Bundle.Check(ctx, policycraft.DefaultRegistry); err != nil {
+			failed++
+			fmt.Fprintf(c.stdout, "FAIL %s\n%s\n", f.Path, indent(err))
+			continue
+		}
+		fmt.Fprintf(c.stdout, "ok   %s (%d tests)\n", f.Path, len(f.Bundle.PolicySet.Tests))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d bundles failed", failed, len(files))
+	}
+	return nil
+}
+
+// bundleFiles loads the bundles of the files and directories of the args of the command, which requires at least one.
+func (c *cli) bundleFiles(fs *flag.FlagSet) ([]gitops.BundleFile, error) {
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return nil, flag.ErrHelp
+	}
+	return loadBundles(fs.Args())
+}
+
+// loadBundles reads the bundles of the files and of the directories, see gitops.LoadDir, keeping the order of the paths.
+func loadBundles(paths []string) ([]gitops.BundleFile, error) {
+	var files []gitops.BundleFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			dirFiles, err := gitops.LoadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range dirFiles {
+				files = append(files, gitops.BundleFile{Path: filepath.Join(path, f.Path), Bundle: f.Bundle})
+			}
+			continue
+		}
+		bundle, err := readBundle(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, gitops.BundleFile{Path: path, Bundle: bundle})
+	}
+	return files, nil
+}
+
+// readBundle reads the bundle of the YAML or JSON file.
+func readBundle(path string) (policycraft.Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policycraft.Bundle{}, err
+	}
+	bundle, err := policycraft.ParseBundle(data)
+	if err != nil {
+		return policycraft.Bundle{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return bundle, nil
+}
+
+// readInput reads the JSON object of the file, or of stdin if the path is -.
+func (c *cli) readInput(path string) (map[string]interface{}, error) {
+	var r io.Reader = c.stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var input map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&input); err != nil {
+		return nil, fmt.Errorf("invalid input, it must be a JSON object: %v", err)
+	}
+	if input == nil {
+		return nil, errors.New("invalid input, it must be a JSON object")
+	}
+	return input, nil
+}
+
+// printJSON writes the value to stdout as indented JSON.
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// indent returns the lines of the error indented, one joined error per line.
+func indent(err error) string {
+	return "    " + strings.ReplaceAll(err.Error(), "\n", "\n    ")
+}
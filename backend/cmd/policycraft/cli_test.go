@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+	"github.com/perebaj/policycraft/bolt"
+	"github.com/perebaj/policycraft/enrichment"
+	"github.com/perebaj/policycraft/memory"
+	"github.com/perebaj/policycraft/metrics"
+)
+
+const creditBundle = `
+version: policycraft/v1
+policy_set:
+  name: credit
+  input_schema:
+    fields:
+      age:
+        type: integer
+        required: true
+  tests:
+    - name: adult
+      input:
+        age: 20
+      decision: true
+    - name: minor
+      input:
+        age: 16
+      decision: false
+policies:
+  - id: a43cafc3-87ad-4e13-9e42-fbd7113b7e82
+    name: age
+    criteria: ">"
+    value: 17
+    success_case: true
+    priority: 1
+`
+
+// runCLI runs the command of the args with the input as stdin, returning its stdout.
+func runCLI(t *testing.T, input string, args ...string) (string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), args, strings.NewReader(input), &stdout, &stderr)
+	if err != nil {
+		t.Logf("policycraft %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String(), err
+}
+
+// writeFiles writes the files, by name, in a new directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"deploy"}, {"migrate"}, {"migrate", "sideways"}, {"policies", "remove"}, {"lint"}, {"eval", "-unknown"}} {
+		if _, err := runCLI(t, "", args...); !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("run(%q) error = %v, want flag.ErrHelp", args, err)
+		}
+	}
+}
+
+func TestLintAndTest(t *testing.T) {
+	failing := strings.Replace(strings.Replace(creditBundle, "name: credit", "name: debit", 1), "decision: false", "decision: true", 1)
+	dir := writeFiles(t, map[string]string{"credit.yaml": creditBundle, "debit.yaml": failing})
+
+	out, err := runCLI(t, "", "lint", dir)
+	if err != nil {
+		t.Fatalf("lint error = %v", err)
+	}
+	if want := "ok   " + filepath.Join(dir, "debit.yaml"); !strings.Contains(out, want) {
+		t.Errorf("lint output = %q, want %q", out, want)
+	}
+
+	out, err = runCLI(t, "", "test", dir)
+	if err == nil {
+		t.Fatal("expected the tests of debit to fail")
+	}
+	if want := "ok   " + filepath.Join(dir, "credit.yaml") + " (2 tests)"; !strings.Contains(out, want) {
+		t.Errorf("test output = %q, want %q", out, want)
+	}
+	if want := "FAIL " + filepath.Join(dir, "debit.yaml"); !strings.Contains(out, want) {
+		t.Errorf("test output = %q, want %q", out, want)
+	}
+
+	invalid := writeFiles(t, map[string]string{"credit.yaml": strings.Replace(creditBundle, `criteria: ">"`, `criteria: "~"`, 1)})
+	if _, err := runCLI(t, "", "lint", filepath.Join(invalid, "credit.yaml")); err == nil {
+		t.Error("expected lint to fail with an unknown criteria")
+	}
+}
+
+func TestPoliciesCommands(t *testing.T) {
+	newAPI := func(t *testing.T) []string {
+		rt, err := newRouter(Config{Approvals: "disabled"}, memory.NewStorage(), enrichment.NewEnricher(http.DefaultClient), nil, metrics.New())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		srv := httptest.NewServer(api.AnonymousMiddleware(api.TenantMiddleware(rt.mux)))
+		t.Cleanup(srv.Close)
+		return []string{"-api", srv.URL}
+	}
+	newDB := func(t *testing.T) []string {
+		return []string{"-db", "bolt://" + filepath.Join(t.TempDir(), "policycraft.db")}
+	}
+
+	for name, newTarget := range map[string]func(t *testing.T) []string{"api": newAPI, "db": newDB} {
+		t.Run(name, func(t *testing.T) {
+			targetArgs := newTarget(t)
+			command := func(args ...string) []string {
+				// the flags go after the subcommand
+				n := 1
+				if args[0] == "policies" {
+					n = 2
+				}
+				return append(append(append([]string(nil), args[:n]...), targetArgs...), args[n:]...)
+			}
+			dir := writeFiles(t, map[string]string{"credit.yaml": creditBundle})
+
+			out, err := runCLI(t, "", command("policies", "apply", "-dry-run", dir)...)
+			if err != nil {
+				t.Fatalf("apply error = %v", err)
+			}
+			if want := "(dry run) credit: policy set changed true, added [a43cafc3-87ad-4e13-9e42-fbd7113b7e82]"; !strings.Contains(out, want) {
+				t.Errorf("apply output = %q, want %q", out, want)
+			}
+			if _, err := runCLI(t, "", command("policies", "apply", "-reason", "first version", dir)...); err != nil {
+				t.Fatalf("apply error = %v", err)
+			}
+			out, err = runCLI(t, "", command("policies", "apply", dir)...)
+			if err != nil {
+				t.Fatalf("apply error = %v", err)
+			}
+			if out != "credit: no changes\n" {
+				t.Errorf("apply output = %q, want no changes", out)
+			}
+
+			out, err = runCLI(t, "", command("policies", "list", "-policy-set", "credit")...)
+			if err != nil {
+				t.Fatalf("list error = %v", err)
+			}
+			if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "a43cafc3-87ad-4e13-9e42-fbd7113b7e82  age") {
+				t.Errorf("list output = %q", out)
+			}
+
+			out, err = runCLI(t, "", command("policies", "get", "a43cafc3-87ad-4e13-9e42-fbd7113b7e82")...)
+			if err != nil {
+				t.Fatalf("get error = %v", err)
+			}
+			var policy policycraft.Policy
+			if err := json.Unmarshal([]byte(out), &policy); err != nil || policy.Name != "age" || policy.PolicySet != "credit" {
+				t.Errorf("get output = %q, error = %v", out, err)
+			}
+			if _, err := runCLI(t, "", command("policies", "get", "missing")...); err == nil {
+				t.Error("expected an error getting a missing policy")
+			}
+
+			out, err = runCLI(t, "", command("policies", "export", "-policy-set", "credit")...)
+			if err != nil {
+				t.Fatalf("export error = %v", err)
+			}
+			bundle, err := policycraft.ParseBundle([]byte(out))
+			if err != nil || len(bundle.Policies) != 1 || len(bundle.PolicySet.Tests) != 2 {
+				t.Errorf("export output = %q, error = %v", out, err)
+			}
+
+			for age, want := range map[int]bool{20: true, 16: false} {
+				out, err = runCLI(t, fmt.Sprintf(`{"age": %d}`, age), command("eval", "-policy-set", "credit")...)
+				if err != nil {
+					t.Fatalf("eval error = %v", err)
+				}
+				var resp api.ExecutionResponse
+				if err := json.Unmarshal([]byte(out), &resp); err != nil || resp.Decision != want || resp.DecisionID == "" {
+					t.Errorf("eval of age %d output = %q, error = %v", age, out, err)
+				}
+			}
+			if _, err := runCLI(t, `{"age": "old"}`, command("eval", "-policy-set", "credit")...); err == nil {
+				t.Error("expected an error evaluating an invalid input")
+			}
+		})
+	}
+}
+
+func TestPoliciesRequireOneTarget(t *testing.T) {
+	if _, err := runCLI(t, "", "policies", "list"); err == nil {
+		t.Error("expected an error without a target")
+	}
+	if _, err := runCLI(t, "", "policies", "list", "-api", "http://localhost:8080", "-db", "bolt://policycraft.db"); err == nil {
+		t.Error("expected an error with two targets")
+	}
+	if _, err := runCLI(t, "", "policies", "list", "-db", "mysql://localhost"); err == nil {
+		t.Error("expected an error with an unsupported database")
+	}
+}
+
+func TestEvalBundle(t *testing.T) {
+	path := filepath.Join(writeFiles(t, map[string]string{"credit.yaml": creditBundle}), "credit.yaml")
+
+	out, err := runCLI(t, `{"age": 20}`, "eval", "-bundle", path)
+	if err != nil {
+		t.Fatalf("eval error = %v", err)
+	}
+	var result policycraft.Result
+	if err := json.Unmarshal([]byte(out), &result); err != nil || !result.Decision || len(result.Trace) != 1 {
+		t.Errorf("eval output = %q, error = %v", out, err)
+	}
+
+	input := filepath.Join(writeFiles(t, map[string]string{"input.json": `{"age": 16}`}), "input.json")
+	out, err = runCLI(t, "", "eval", "-bundle", path, "-input", input)
+	if err != nil {
+		t.Fatalf("eval error = %v", err)
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil || result.Decision {
+		t.Errorf("eval output = %q, error = %v", out, err)
+	}
+
+	if _, err := runCLI(t, `{}`, "eval", "-bundle", path); err == nil {
+		t.Error("expected an error without the required age")
+	}
+	if _, err := runCLI(t, `{"age": 20}`, "eval", "-bundle", path, "-policy-set", "debit"); err == nil {
+		t.Error("expected an error evaluating another policy set")
+	}
+}
+
+func TestMigrateBolt(t *testing.T) {
+	db := "bolt://" + filepath.Join(t.TempDir(), "policycraft.db")
+	want := fmt.Sprintf("version %d of %d\n", bolt.LatestSchemaVersion(), bolt.LatestSchemaVersion())
+
+	if _, err := runCLI(t, "", "migrate", "status", "-db", db); err == nil {
+		t.Error("expected an error getting the status of a missing database")
+	}
+	for _, subcommand := range []string{"up", "status"} {
+		out, err := runCLI(t, "", "migrate", subcommand, "-db", db)
+		if err != nil {
+			t.Fatalf("migrate %s error = %v", subcommand, err)
+		}
+		if out != want {
+			t.Errorf("migrate %s output = %q, want %q", subcommand, out, want)
+		}
+	}
+	if _, err := runCLI(t, "", "migrate", "down", "-db", db); err == nil {
+		t.Error("expected an error reverting the bolt migrations")
+	}
+}
@@ -0,0 +1,78 @@
+// Package main ...
+// eval.go gather the command that evaluates an input against a policy set.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+)
+
+// eval evaluates an input against a policy set. With -bundle, the policies of the bundle file are evaluated locally, without
+// calling the enrichment providers nor recording the decision, and the trace is printed. Otherwise, the policy set is executed
+// by the target, as the execution engine does, and the decision is recorded.
+func (c *cli) eval(ctx context.Context, args []string) (err error) {
+	fs := c.flagSet("eval", "[-api URL | -db URL | -bundle file] [arguments]")
+	var t target
+	t.register(fs)
+	bundlePath := fs.String("bundle", "", "bundle file evaluated locally, instead of the policy set of the API or of the database")
+	policySet := fs.String("policy-set", "", "policy set evaluated, the default one if empty")
+	inputPath := fs.String("input", "-", "JSON file of the input, - for stdin")
+	requestID := fs.String("request-id", "", "request ID of the decision, generated if empty")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	input, err := c.readInput(*inputPath)
+	if err != nil {
+		return err
+	}
+
+	if *bundlePath != "" {
+		if t.api != "" || t.db != "" {
+			return errors.New("-bundle can't be set with -api or -db")
+		}
+		bundle, err := readBundle(*bundlePath)
+		if err != nil {
+			return err
+		}
+		if *policySet != "" && *policySet != bundle.PolicySet.Name {
+			return fmt.Errorf("the bundle has the policy set %s, not %s", bundle.PolicySet.Name, *policySet)
+		}
+		result, err := evalBundle(ctx, bundle, input)
+		if err != nil {
+			return err
+		}
+		return c.printJSON(result)
+	}
+
+	store, err := t.open()
+	if err != nil {
+		return err
+	}
+	defer closeStore(store, &err)
+	resp, err := store.Execute(ctx, api.ExecutionRequest{PolicySet: *policySet, CustomFields: input, RequestID: *requestID, CallerRef: "policycraft eval"})
+	if err != nil {
+		return err
+	}
+	return c.printJSON(resp)
+}
+
+// evalBundle evaluates the input against the policies of the bundle. As the policy set tests, the input must have the enriched fields.
+func evalBundle(ctx context.Context, bundle policycraft.Bundle, input map[string]interface{}) (policycraft.Result, error) {
+	if err := bundle.Validate(policycraft.DefaultRegistry); err != nil {
+		return policycraft.Result{}, err
+	}
+	if fieldErrs := bundle.PolicySet.InputSchema.Validate(input); len(fieldErrs) > 0 {
+		return policycraft.Result{}, api.FieldErrors(fieldErrs)
+	}
+	e := policycraft.Execution{CustomFields: input, Registry: policycraft.DefaultRegistry}
+	return e.EvaluateWithTrace(ctx, bundle.Policies)
+}
@@ -2,39 +2,120 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"time"
 
-	api "github.com/perebaj/policycraft/api/docs"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+	"github.com/perebaj/policycraft/auth"
+	"github.com/perebaj/policycraft/bolt"
+	"github.com/perebaj/policycraft/cache"
+	"github.com/perebaj/policycraft/enrichment"
+	"github.com/perebaj/policycraft/gitops"
+	"github.com/perebaj/policycraft/metrics"
 	"github.com/perebaj/policycraft/postgres"
+	"github.com/perebaj/policycraft/rpc"
+	"github.com/perebaj/policycraft/rpc/policycraftpb"
+	"google.golang.org/grpc"
 )
 
 // Config have the core configuration for the service.
 type Config struct {
 	// PORT is the port where the service will be listening.
 	PORT string
+	// GRPCPort is the port where the gRPC service will be listening. Empty disables it.
+	GRPCPort string
 	// LogLevel is the level of the logs. Could be INFO, DEBUG, WARN or ERROR.
 	LogLevel string
 	LogType  string // json(for cloud environments) or text(for local environments)
+	// Storage is the storage backend. Could be postgres or bolt(embedded, for local demos and edge deployments).
+	Storage string
 	// Postgres is the configuration for the postgres database.
 	Postgres postgres.Config
+	// BoltPath is the file of the bolt database.
+	BoltPath string
+	// SyncDir is the directory of bundle files reconciled with the database. Empty disables the sync.
+	SyncDir string
+	// SyncInterval is the interval between the syncs of the SyncDir.
+	SyncInterval time.Duration
+	// SyncDryRun only reports the drift between the SyncDir and the database, without changing it.
+	SyncDryRun bool
+	// SyncTenant is the tenant of the policy sets of the SyncDir.
+	SyncTenant string
+	// CacheRefreshInterval is the interval between the periodic refreshes of the policy cache. With postgres, the cache
+	// is also refreshed whenever the policies change, so the interval only covers the notifications that could be lost.
+	CacheRefreshInterval time.Duration
+	// Auth enables the authentication of the requests. Could be enabled or disabled(every request is an admin, for local development).
+	Auth string
+	// AdminKey is the secret of the operator key, an admin of every tenant used to create their first API keys. Empty disables it.
+	AdminKey string
+	// JWKSFile is the JWKS file with the public keys of the identity provider. Empty only accepts API keys.
+	JWKSFile string
+	// JWT has the expected issuer and audience of the JWTs.
+	JWT auth.Config
+	// Approvals could be required(a policy change is published only after being approved by someone else than its author)
+	// or disabled(POST /policies and PATCH /policies/{id} save the policy directly, for local development).
+	Approvals string
+	// OpenAPIValidation validates the requests and the responses against the OpenAPI document. The invalid requests are
+	// refused with 400 and the invalid responses are logged.
+	OpenAPIValidation bool
 }
 
 func main() {
+	// Without a command, the service is served, as the container images run it.
+	if len(os.Args) < 2 || os.Args[1] == "serve" {
+		serve()
+		return
+	}
+
+	// The logs of the commands go to stderr, so they don't mix with their output.
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	err := run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "policycraft:", err)
+		os.Exit(1)
+	}
+}
+
+// serve migrates the storage and serves the API, configured by the environment variables.
+func serve() {
 	// Load the configuration from the environment variables.
 	cfg := Config{
 		PORT:     getEnvWithDefault("PORT", "8080"),
+		GRPCPort: os.Getenv("POLICY_CRAFT_GRPC_PORT"),
 		LogLevel: getEnvWithDefault("LOG_LEVEL", "INFO"),
 		LogType:  getEnvWithDefault("LOG_TYPE", "json"),
+		Storage:  getEnvWithDefault("POLICY_CRAFT_STORAGE", "postgres"),
 		Postgres: postgres.Config{
 			URL:             os.Getenv("POLICY_CRAFT_POSTGRES_URL"),
 			MaxOpenConns:    10,
 			MaxIdleConns:    5,
 			ConnMaxIdleTime: 1 * time.Minute,
 		},
+		BoltPath:   getEnvWithDefault("POLICY_CRAFT_BOLT_PATH", "policycraft.db"),
+		SyncDir:    os.Getenv("POLICY_CRAFT_SYNC_DIR"),
+		SyncDryRun: os.Getenv("POLICY_CRAFT_SYNC_DRY_RUN") == "true",
+		SyncTenant: getEnvWithDefault("POLICY_CRAFT_SYNC_TENANT", policycraft.DefaultTenant),
+		Auth:       getEnvWithDefault("POLICY_CRAFT_AUTH", "enabled"),
+		AdminKey:   os.Getenv("POLICY_CRAFT_ADMIN_KEY"),
+		JWKSFile:   os.Getenv("POLICY_CRAFT_JWKS_FILE"),
+		JWT: auth.Config{
+			Issuer:   os.Getenv("POLICY_CRAFT_JWT_ISSUER"),
+			Audience: os.Getenv("POLICY_CRAFT_JWT_AUDIENCE"),
+		},
+		Approvals:         getEnvWithDefault("POLICY_CRAFT_APPROVALS", "required"),
+		OpenAPIValidation: os.Getenv("POLICY_CRAFT_OPENAPI_VALIDATION") == "true",
 	}
 
 	err := setUpLog(cfg)
@@ -43,34 +124,272 @@ func main() {
 		os.Exit(1)
 	}
 
-	db, err := postgres.OpenDB(cfg.Postgres)
+	cfg.SyncInterval, err = time.ParseDuration(getEnvWithDefault("POLICY_CRAFT_SYNC_INTERVAL", "30s"))
+	if err != nil || cfg.SyncInterval <= 0 {
+		slog.Error("invalid POLICY_CRAFT_SYNC_INTERVAL", "error", err)
+		os.Exit(1)
+	}
+
+	err = policycraft.CheckTenant(cfg.SyncTenant)
 	if err != nil {
-		slog.Error("failed to open database", "error", err)
+		slog.Error("invalid POLICY_CRAFT_SYNC_TENANT", "error", err)
 		os.Exit(1)
 	}
 
+	cfg.CacheRefreshInterval, err = time.ParseDuration(getEnvWithDefault("POLICY_CRAFT_CACHE_REFRESH_INTERVAL", "1m"))
+	if err != nil || cfg.CacheRefreshInterval <= 0 {
+		slog.Error("invalid POLICY_CRAFT_CACHE_REFRESH_INTERVAL", "error", err)
+		os.Exit(1)
+	}
+
+	m := metrics.New()
+	db, closeStorage, err := openStorage(cfg, m)
+	if err != nil {
+		slog.Error("failed to open storage", "storage", cfg.Storage, "error", err)
+		os.Exit(1)
+	}
 	defer func() {
-		err := db.Close()
+		err := closeStorage()
 		if err != nil {
-			slog.Error("failed to close database", "error", err)
+			slog.Error("failed to close storage", "error", err)
 		}
 	}()
 
-	storage := postgres.NewStorage(db)
+	storage, err := startCache(context.Background(), cfg, metrics.NewStorage(db, m), m)
+	if err != nil {
+		slog.Error("failed to start policy cache", "error", err)
+		os.Exit(1)
+	}
+
+	enricher := enrichment.NewEnricher(&http.Client{})
+
+	if cfg.SyncDir != "" {
+		slog.Info("syncing policy sets", "dir", cfg.SyncDir, "interval", cfg.SyncInterval, "dry_run", cfg.SyncDryRun, "tenant", cfg.SyncTenant)
+		syncer := gitops.NewSyncer(cfg.SyncDir, storage, nil)
+		go syncer.Run(policycraft.WithTenant(context.Background(), cfg.SyncTenant), cfg.SyncInterval, cfg.SyncDryRun)
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("POST /policies", api.SavePolicyHandler(storage))
-	mux.HandleFunc("GET /policies", api.ListPoliciesHandler(storage))
-	mux.HandleFunc("POST /execution-engine", api.ExecutionEngineHandler(storage))
-	slog.Info("starting server", "port", cfg.PORT)
+	authenticator, err := newAuthenticator(cfg, storage)
+	if err != nil {
+		slog.Error("failed to set up authentication", "error", err)
+		os.Exit(1)
+	}
+	authenticate, grpcAuthenticator := api.AnonymousMiddleware, rpc.Authenticator(nil)
+	if authenticator != nil {
+		authenticate, grpcAuthenticator = authenticator.Middleware, authenticator
+	}
+
+	validator, err := newOpenAPIValidator(cfg)
+	if err != nil {
+		slog.Error("failed to set up the OpenAPI validation", "error", err)
+		os.Exit(1)
+	}
 
-	err = http.ListenAndServe(":"+cfg.PORT, mux)
+	rt, err := newRouter(cfg, storage, enricher, validator, m)
+	if err != nil {
+		slog.Error("failed to set up routes", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.GRPCPort != "" {
+		lis, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+		if err != nil {
+			slog.Error("failed to listen for the gRPC service", "error", err)
+			os.Exit(1)
+		}
+		srv := rpc.NewServer(storage, enricher, m, cfg.Approvals == "required")
+		grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(m.UnaryServerInterceptor(), srv.UnaryInterceptor(grpcAuthenticator)))
+		policycraftpb.RegisterPolicyCraftServer(grpcServer, srv)
+		slog.Info("starting gRPC server", "port", cfg.GRPCPort)
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				slog.Error("failed to serve the gRPC service", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	slog.Info("starting server", "port", cfg.PORT, "auth", cfg.Auth, "approvals", cfg.Approvals, "openapi_validation", cfg.OpenAPIValidation)
+
+	err = http.ListenAndServe(":"+cfg.PORT, authenticate(api.TenantMiddleware(rt.mux)))
 	if err != nil {
 		slog.Error("failed to start server", "error", err)
 		os.Exit(1)
 	}
 }
 
+// router registers the routes of the API, authorizing them by role, observing their requests and, when enabled, validating them
+// against the OpenAPI document.
+type router struct {
+	mux       *http.ServeMux
+	validator *api.OpenAPIValidator
+	metrics   *metrics.Metrics
+	// patterns are the registered routes, in the order of registration.
+	patterns []string
+}
+
+// handle registers the handler for the pattern, only reachable by the principals with any of the roles.
+func (rt *router) handle(pattern string, handler http.HandlerFunc, roles ...policycraft.Role) {
+	if rt.validator != nil {
+		handler = rt.validator.Middleware(pattern, handler)
+	}
+	rt.mux.HandleFunc(pattern, rt.metrics.Middleware(pattern, api.Authorize(handler, roles...)))
+	rt.patterns = append(rt.patterns, pattern)
+}
+
+// newRouter returns the router with every route of the API. The validator may be nil, disabling the validation.
+func newRouter(cfg Config, storage api.Storage, enricher api.Enricher, validator *api.OpenAPIValidator, m *metrics.Metrics) (*router, error) {
+	// With the approvals, the policies are changed by publishing change requests. The deletions, the restores and the policy sets
+	// can't be proposed, so they are left to the publishers, as the imports.
+	var savePolicy, patchPolicy http.HandlerFunc
+	// directRole is the role of the changes made directly, without a change request.
+	var directRole policycraft.Role
+	switch cfg.Approvals {
+	case "required":
+		savePolicy, patchPolicy = api.ProposePolicyHandler(storage), api.ProposePolicyPatchHandler(storage)
+		directRole = policycraft.RolePublisher
+	case "disabled":
+		savePolicy, patchPolicy = api.SavePolicyHandler(storage), api.PatchPolicyHandler(storage)
+		directRole = policycraft.RoleEditor
+	default:
+		return nil, fmt.Errorf("invalid approvals: %s", cfg.Approvals)
+	}
+
+	doc, err := api.OpenAPIDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &router{mux: http.NewServeMux(), validator: validator, metrics: m}
+	rt.handle("POST /policies", savePolicy, policycraft.RoleEditor)
+	rt.handle("GET /policies", api.ListPoliciesHandler(storage), policycraft.RoleViewer)
+	rt.handle("GET /policies/export", api.ExportPoliciesHandler(storage), policycraft.RoleViewer)
+	rt.handle("GET /policies/{id}", api.GetPolicyHandler(storage), policycraft.RoleViewer)
+	rt.handle("PATCH /policies/{id}", patchPolicy, policycraft.RoleEditor)
+	rt.handle("DELETE /policies/{id}", api.DeletePolicyHandler(storage), directRole)
+	rt.handle("POST /policies/{id}/restore", api.RestorePolicyHandler(storage), directRole)
+	rt.handle("GET /policies/{id}/history", api.PolicyHistoryHandler(storage), policycraft.RoleViewer)
+	rt.handle("GET /policies/{id}/diff", api.PolicyDiffHandler(storage), policycraft.RoleViewer)
+	rt.handle("POST /policies/import", api.ImportPoliciesHandler(storage), policycraft.RolePublisher)
+	rt.handle("GET /operators", api.ListOperatorsHandler(), policycraft.RoleViewer)
+	rt.handle("GET /policy-sets", api.ListPolicySetsHandler(storage), policycraft.RoleViewer)
+	rt.handle("GET /policy-sets/{name}", api.GetPolicySetHandler(storage), policycraft.RoleViewer)
+	rt.handle("PUT /policy-sets/{name}", api.SavePolicySetHandler(storage), directRole)
+	// the callers read the schema to build their inputs
+	rt.handle("GET /policy-sets/{name}/schema", api.PolicySetSchemaHandler(storage), policycraft.RoleViewer, policycraft.RoleExecutor)
+	rt.handle("POST /execution-engine", api.ExecutionEngineHandler(storage, enricher, m), policycraft.RoleExecutor)
+	rt.handle("GET /decisions", api.ListDecisionsHandler(storage), policycraft.RoleViewer)
+	rt.handle("GET /decisions/{id}", api.GetDecisionHandler(storage), policycraft.RoleViewer)
+	// the execution engine evaluates the policies with the DefaultRegistry, so the replays do too
+	rt.handle("POST /decisions/{id}/replay", api.ReplayDecisionHandler(storage, policycraft.DefaultRegistry), policycraft.RoleViewer)
+	rt.handle("POST /change-requests", api.CreateChangeRequestHandler(storage), policycraft.RoleEditor)
+	rt.handle("GET /change-requests", api.ListChangeRequestsHandler(storage), policycraft.RoleViewer)
+	rt.handle("GET /change-requests/{id}", api.GetChangeRequestHandler(storage), policycraft.RoleViewer)
+	rt.handle("PUT /change-requests/{id}", api.EditChangeRequestHandler(storage), policycraft.RoleEditor)
+	rt.handle("POST /change-requests/{id}/submit", api.SubmitChangeRequestHandler(storage), policycraft.RoleEditor)
+	rt.handle("POST /change-requests/{id}/approve", api.ApproveChangeRequestHandler(storage), policycraft.RoleEditor)
+	rt.handle("POST /change-requests/{id}/reject", api.RejectChangeRequestHandler(storage), policycraft.RoleEditor)
+	rt.handle("POST /change-requests/{id}/comments", api.CommentChangeRequestHandler(storage), policycraft.RoleViewer)
+	rt.handle("POST /change-requests/{id}/publish", api.PublishChangeRequestHandler(storage), policycraft.RolePublisher)
+	rt.handle("POST /api-keys", api.CreateAPIKeyHandler(storage), policycraft.RoleAdmin)
+	rt.handle("GET /api-keys", api.ListAPIKeysHandler(storage), policycraft.RoleAdmin)
+	rt.handle("DELETE /api-keys/{id}", api.RevokeAPIKeyHandler(storage), policycraft.RoleAdmin)
+	rt.handle("GET /debug/vars", expvar.Handler().ServeHTTP, policycraft.RoleAdmin)
+	rt.handle("GET /metrics", m.Handler().ServeHTTP, policycraft.RoleMetrics)
+	// the clients and the callers read the document to know the API
+	rt.handle("GET /openapi.json", api.OpenAPIHandler(doc), policycraft.RoleViewer, policycraft.RoleExecutor)
+	return rt, nil
+}
+
+// newOpenAPIValidator returns the validator of the routes against the OpenAPI document, or nil if cfg.OpenAPIValidation is disabled.
+func newOpenAPIValidator(cfg Config) (*api.OpenAPIValidator, error) {
+	if !cfg.OpenAPIValidation {
+		return nil, nil
+	}
+	doc, err := api.OpenAPIDocument()
+	if err != nil {
+		return nil, err
+	}
+	return api.NewOpenAPIValidator(doc), nil
+}
+
+// openStorage opens the configured storage backend, returning it and the function that closes it. The stats of the postgres
+// connection pool are exported in the metrics.
+func openStorage(cfg Config, m *metrics.Metrics) (api.Storage, func() error, error) {
+	switch cfg.Storage {
+	case "postgres":
+		db, err := postgres.OpenDB(cfg.Postgres)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := m.RegisterDB("postgres", db.DB); err != nil {
+			_ = db.Close()
+			return nil, nil, err
+		}
+		return postgres.NewStorage(db), db.Close, nil
+	case "bolt":
+		storage, err := bolt.Open(cfg.BoltPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return storage, storage.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("invalid storage: %s", cfg.Storage)
+	}
+}
+
+// startCache wraps the storage with the policy cache, loads it and keeps it refreshed, publishing its stats as the policy_cache expvar
+// and as metrics. With postgres, the cache is refreshed whenever any replica changes the policies.
+func startCache(ctx context.Context, cfg Config, db api.Storage, m *metrics.Metrics) (*cache.Storage, error) {
+	storage := cache.NewStorage(db)
+	// The service starts even if the first load fails, reading from the database until a refresh succeeds.
+	if err := storage.Refresh(ctx); err != nil {
+		slog.Error("failed to load the policy cache", "error", err)
+	}
+
+	var changes <-chan struct{}
+	if cfg.Storage == "postgres" {
+		var err error
+		changes, err = postgres.ListenPolicyChanges(ctx, cfg.Postgres.URL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	go storage.Run(ctx, cfg.CacheRefreshInterval, changes)
+
+	expvar.Publish("policy_cache", expvar.Func(func() any { return storage.Stats() }))
+	if err := m.RegisterCache(storage.Stats); err != nil {
+		return nil, fmt.Errorf("registering the policy cache metrics: %v", err)
+	}
+	return storage, nil
+}
+
+// newAuthenticator returns the authenticator of the requests, as configured by cfg.Auth. It's nil when the authentication is
+// disabled, and every request is an admin of every tenant. The authentication can't be enabled without the admin key nor the JWKS
+// file, because no one could create the first API keys, so every request would be refused.
+func newAuthenticator(cfg Config, db api.Storage) (*api.Authenticator, error) {
+	switch cfg.Auth {
+	case "enabled":
+		if cfg.AdminKey == "" && cfg.JWKSFile == "" {
+			return nil, errors.New("the authentication is enabled without POLICY_CRAFT_ADMIN_KEY nor POLICY_CRAFT_JWKS_FILE, set one of them or POLICY_CRAFT_AUTH=disabled")
+		}
+		var verifier api.TokenVerifier
+		if cfg.JWKSFile != "" {
+			v, err := auth.NewVerifier(cfg.JWKSFile, cfg.JWT)
+			if err != nil {
+				return nil, err
+			}
+			verifier = v
+		}
+		return api.NewAuthenticator(db, verifier, cfg.AdminKey), nil
+	case "disabled":
+		slog.Warn("authentication is disabled, every request is an admin of every tenant")
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid auth: %s", cfg.Auth)
+	}
+}
+
 // setUpLog initialize the logger.
 func setUpLog(cfg Config) error {
 	var level slog.Level
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+	"github.com/perebaj/policycraft/memory"
+	"github.com/perebaj/policycraft/metrics"
+)
+
+func TestRoutesMatchOpenAPIDocument(t *testing.T) {
+	doc, err := api.OpenAPIDocument()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, approvals := range []string{"required", "disabled"} {
+		t.Run(approvals, func(t *testing.T) {
+			// the validator panics on a route that isn't documented
+			rt, err := newRouter(Config{Approvals: approvals}, memory.NewStorage(), nil, api.NewOpenAPIValidator(doc), metrics.New())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			patterns := append([]string(nil), rt.patterns...)
+			sort.Strings(patterns)
+			if want := api.OpenAPIRoutes(doc); !reflect.DeepEqual(patterns, want) {
+				t.Errorf("the routes don't match the OpenAPI document\nroutes:   %v\ndocument: %v", patterns, want)
+			}
+		})
+	}
+}
+
+func TestNewRouterInvalidApprovals(t *testing.T) {
+	if _, err := newRouter(Config{Approvals: "sometimes"}, memory.NewStorage(), nil, nil, metrics.New()); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestPolicySetRole(t *testing.T) {
+	tests := []struct {
+		approvals string
+		roles     []policycraft.Role
+		want      int
+	}{
+		{approvals: "required", roles: []policycraft.Role{policycraft.RoleEditor}, want: http.StatusForbidden},
+		{approvals: "required", roles: []policycraft.Role{policycraft.RolePublisher}, want: http.StatusOK},
+		{approvals: "disabled", roles: []policycraft.Role{policycraft.RoleEditor}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.approvals+"/"+string(tt.roles[0]), func(t *testing.T) {
+			rt, err := newRouter(Config{Approvals: tt.approvals}, memory.NewStorage(), nil, nil, metrics.New())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPut, "/policy-sets/credit", strings.NewReader(`{"fail_mode": "closed"}`))
+			req = req.WithContext(policycraft.WithPrincipal(req.Context(), policycraft.Principal{Subject: "alice", Roles: tt.roles}))
+			rec := httptest.NewRecorder()
+			rt.mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestMetricsRole(t *testing.T) {
+	tests := []struct {
+		role policycraft.Role
+		want int
+	}{
+		{role: policycraft.RoleMetrics, want: http.StatusOK},
+		{role: policycraft.RoleAdmin, want: http.StatusOK},
+		{role: policycraft.RolePublisher, want: http.StatusForbidden},
+		{role: policycraft.RoleExecutor, want: http.StatusForbidden},
+	}
+	rt, err := newRouter(Config{Approvals: "required"}, memory.NewStorage(), nil, nil, metrics.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.role), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req = req.WithContext(policycraft.WithPrincipal(req.Context(), policycraft.Principal{Subject: "prometheus", Roles: []policycraft.Role{tt.role}}))
+			rec := httptest.NewRecorder()
+			rt.mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	db := memory.NewStorage()
+	// without the admin key nor the JWKS file, no request could be authenticated
+	if _, err := newAuthenticator(Config{Auth: "enabled"}, db); err == nil {
+		t.Error("expected an error enabling the authentication without credentials")
+	}
+	if a, err := newAuthenticator(Config{Auth: "enabled", AdminKey: "secret"}, db); err != nil || a == nil {
+		t.Errorf("expected an authenticator, got %v (%v)", a, err)
+	}
+	if a, err := newAuthenticator(Config{Auth: "disabled"}, db); err != nil || a != nil {
+		t.Errorf("expected no authenticator, got %v (%v)", a, err)
+	}
+	if _, err := newAuthenticator(Config{Auth: "sometimes"}, db); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,120 @@
+// Package main ...
+// migrate.go gather the command that migrates the database.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/perebaj/policycraft/bolt"
+	"github.com/perebaj/policycraft/postgres"
+)
+
+// migrate runs the migrate subcommand of the args: up applies the pending migrations, down reverts the last ones and status
+// prints the version of the database.
+func (c *cli) migrate(ctx context.Context, args []string) error {
+	const subcommands = "up|down|status"
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		fmt.Fprintf(c.stderr, "Usage: policycraft migrate %s [-db URL]\n", subcommands)
+		return flag.ErrHelp
+	}
+	subcommand := args[0]
+
+	fs := c.flagSet("migrate "+subcommand, "[-db URL]")
+	dbURL := fs.String("db", defaultDBURL(), "database URL, postgres://... or bolt://<path>, the one of serve if empty")
+	steps := 1
+	if subcommand == "down" {
+		fs.IntVar(&steps, "steps", 1, "number of migrations reverted")
+	}
+	if err := parse(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	if path, ok := strings.CutPrefix(*dbURL, "bolt://"); ok {
+		return c.migrateBolt(subcommand, path)
+	}
+	if !strings.HasPrefix(*dbURL, "postgres://") && !strings.HasPrefix(*dbURL, "postgresql://") {
+		return errors.New("invalid database URL, it must be postgres://... or bolt://<path>")
+	}
+	return c.migratePostgres(ctx, subcommand, *dbURL, steps)
+}
+
+// migratePostgres runs the subcommand against the postgres database.
+func (c *cli) migratePostgres(ctx context.Context, subcommand, dbURL string, steps int) error {
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		return fmt.Errorf("connecting to postgres: %v", err)
+	}
+	defer db.Close()
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		return fmt.Errorf("ping postgres: %v", err)
+	}
+
+	switch subcommand {
+	case "up":
+		err = postgres.Migrate(db)
+	case "down":
+		err = postgres.MigrateDown(db, steps)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := postgres.MigrationVersion(db)
+	if err != nil {
+		return err
+	}
+	latest, err := postgres.LatestMigration()
+	if err != nil {
+		return err
+	}
+	status := fmt.Sprintf("version %d of %d", version, latest)
+	if dirty {
+		status += ", dirty: the migration failed halfway and must be fixed by hand"
+	}
+	fmt.Fprintln(c.stdout, status)
+	return nil
+}
+
+// migrateBolt runs the subcommand against the bolt database file. Its migrations can't be reverted.
+func (c *cli) migrateBolt(subcommand, path string) error {
+	switch subcommand {
+	case "up":
+		storage, err := bolt.Open(path)
+		if err != nil {
+			return err
+		}
+		if err := storage.Close(); err != nil {
+			return err
+		}
+	case "down":
+		return errors.New("the bolt migrations can't be reverted")
+	}
+
+	version, err := bolt.ReadSchemaVersion(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "version %d of %d\n", version, bolt.LatestSchemaVersion())
+	return nil
+}
+
+// defaultDBURL returns the URL of the database served, configured by the environment variables of serve.
+func defaultDBURL() string {
+	if os.Getenv("POLICY_CRAFT_STORAGE") == "bolt" {
+		return "bolt://" + getEnvWithDefault("POLICY_CRAFT_BOLT_PATH", "policycraft.db")
+	}
+	return os.Getenv("POLICY_CRAFT_POSTGRES_URL")
+}
@@ -0,0 +1,354 @@
+// Package main ...
+// policies.go gather the commands that manage the policies, through the API of a running service or directly in a database.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+	"github.com/perebaj/policycraft/bolt"
+	"github.com/perebaj/policycraft/client"
+	"github.com/perebaj/policycraft/enrichment"
+	"github.com/perebaj/policycraft/postgres"
+)
+
+// policyStore is where the policies commands are run.
+type policyStore interface {
+	ListPolicies(ctx context.Context, filter policycraft.PolicyFilter) (policycraft.PolicyPage, error)
+	GetPolicy(ctx context.Context, id string) (policycraft.Policy, error)
+	ExportPolicies(ctx context.Context, policySet string) (policycraft.Bundle, error)
+	ImportPolicies(ctx context.Context, bundle policycraft.Bundle, dryRun bool, reason string) (api.ImportResponse, error)
+	Execute(ctx context.Context, req api.ExecutionRequest) (api.ExecutionResponse, error)
+	Close() error
+}
+
+// target is the API or the database the policies commands are run against, chosen by the flags.
+type target struct {
+	api    string
+	token  string
+	tenant string
+	db     string
+	author string
+}
+
+// register registers the flags of the target, defaulting to the environment variables.
+func (t *target) register(fs *flag.FlagSet) {
+	fs.StringVar(&t.api, "api", os.Getenv("POLICY_CRAFT_API_URL"), "base URL of the API of a running service, such as http://localhost:8080")
+	fs.StringVar(&t.token, "token", os.Getenv("POLICY_CRAFT_TOKEN"), "API key or JWT of the API")
+	fs.StringVar(&t.tenant, "tenant", os.Getenv("POLICY_CRAFT_TENANT"), "tenant of the policies, the one of the credentials or the default one if empty")
+	fs.StringVar(&t.db, "db", "", "database URL, postgres://... or bolt://<path>, to run the command without a running service")
+	fs.StringVar(&t.author, "author", os.Getenv("USER"), "author of the changes recorded in the history of the policies, with -db")
+}
+
+// open returns the store of the target. Exactly one of the API and the database must be set.
+func (t *target) open() (policyStore, error) {
+	switch {
+	case t.api != "" && t.db != "":
+		return nil, errors.New("only one of -api and -db can be set")
+	case t.api != "":
+		c, err := client.New(client.Config{URL: t.api, Credential: t.token, Tenant: t.tenant, Retries: 2})
+		if err != nil {
+			return nil, err
+		}
+		return apiStore{c}, nil
+	case t.db != "":
+		tenant := t.tenant
+		if tenant == "" {
+			tenant = policycraft.DefaultTenant
+		}
+		if err := policycraft.CheckTenant(tenant); err != nil {
+			return nil, err
+		}
+		db, closeDB, err := openDB(t.db)
+		if err != nil {
+			return nil, err
+		}
+		return dbStore{db: db, close: closeDB, tenant: tenant, author: t.author}, nil
+	default:
+		return nil, errors.New("either -api or -db is required")
+	}
+}
+
+// openDB opens the storage of the database URL, postgres://... or bolt://<path>, migrating it.
+func openDB(dbURL string) (api.Storage, func() error, error) {
+	if path, ok := strings.CutPrefix(dbURL, "bolt://"); ok {
+		storage, err := bolt.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return storage, storage.Close, nil
+	}
+	if !strings.HasPrefix(dbURL, "postgres://") && !strings.HasPrefix(dbURL, "postgresql://") {
+		return nil, nil, errors.New("invalid database URL, it must be postgres://... or bolt://<path>")
+	}
+	db, err := postgres.OpenDB(postgres.Config{URL: dbURL, MaxOpenConns: 2, MaxIdleConns: 1, ConnMaxIdleTime: time.Minute})
+	if err != nil {
+		return nil, nil, err
+	}
+	return postgres.NewStorage(db), db.Close, nil
+}
+
+// apiStore runs the commands through the API.
+type apiStore struct {
+	client *client.Client
+}
+
+func (s apiStore) ListPolicies(ctx context.Context, filter policycraft.PolicyFilter) (policycraft.PolicyPage, error) {
+	return s.client.ListPolicies(ctx, filter)
+}
+
+func (s apiStore) GetPolicy(ctx context.Context, id string) (policycraft.Policy, error) {
+	policy, err := s.client.GetPolicy(ctx, id)
+	return policy.Policy, err
+}
+
+func (s apiStore) ExportPolicies(ctx context.Context, policySet string) (policycraft.Bundle, error) {
+	return s.client.ExportPolicies(ctx, policySet)
+}
+
+func (s apiStore) ImportPolicies(ctx context.Context, bundle policycraft.Bundle, dryRun bool, reason string) (api.ImportResponse, error) {
+	return s.client.ImportPolicies(ctx, bundle, client.ImportOptions{DryRun: dryRun, Reason: reason})
+}
+
+func (s apiStore) Execute(ctx context.Context, req api.ExecutionRequest) (api.ExecutionResponse, error) {
+	return s.client.Execute(ctx, req)
+}
+
+func (s apiStore) Close() error {
+	return nil
+}
+
+// dbStore runs the commands directly in the database, as the API of the tenant would.
+type dbStore struct {
+	db     api.Storage
+	close  func() error
+	tenant string
+	author string
+}
+
+// context returns the context of the tenant.
+func (s dbStore) context(ctx context.Context) context.Context {
+	return policycraft.WithTenant(ctx, s.tenant)
+}
+
+func (s dbStore) ListPolicies(ctx context.Context, filter policycraft.PolicyFilter) (policycraft.PolicyPage, error) {
+	if filter.Limit == 0 {
+		filter.Limit = api.DefaultPoliciesLimit
+	}
+	if filter.Limit < 0 || filter.Limit > api.MaxPoliciesLimit {
+		return policycraft.PolicyPage{}, fmt.Errorf("limit must be between 1 and %d", api.MaxPoliciesLimit)
+	}
+	return s.db.ListPolicies(s.context(ctx), filter)
+}
+
+func (s dbStore) GetPolicy(ctx context.Context, id string) (policycraft.Policy, error) {
+	return s.db.Policy(s.context(ctx), id)
+}
+
+func (s dbStore) ExportPolicies(ctx context.Context, policySet string) (policycraft.Bundle, error) {
+	if policySet == "" {
+		policySet = policycraft.DefaultPolicySet
+	}
+	bundle, found, err := api.StoredBundle(s.context(ctx), s.db, policySet)
+	if err != nil {
+		return policycraft.Bundle{}, err
+	}
+	if !found {
+		return policycraft.Bundle{}, fmt.Errorf("policy set %s: %w", policySet, policycraft.ErrNotFound)
+	}
+	return bundle, nil
+}
+
+func (s dbStore) ImportPolicies(ctx context.Context, bundle policycraft.Bundle, dryRun bool, reason string) (api.ImportResponse, error) {
+	ctx = policycraft.WithChangeInfo(s.context(ctx), policycraft.ChangeInfo{Author: s.author, Reason: reason})
+	resp, _, err := api.ImportBundle(ctx, s.db, bundle, dryRun)
+	return resp, err
+}
+
+func (s dbStore) Execute(ctx context.Context, req api.ExecutionRequest) (api.ExecutionResponse, error) {
+	decision, _, err := api.Execute(s.context(ctx), s.db, enrichment.NewEnricher(&http.Client{}), nil, req)
+	if err != nil {
+		return api.ExecutionResponse{}, err
+	}
+	return api.ExecutionResponse{DecisionID: decision.ID, Decision: decision.Decision, TimedOut: decision.TimedOut}, nil
+}
+
+func (s dbStore) Close() error {
+	return s.close()
+}
+
+// policies runs the policies subcommand of the args.
+func (c *cli) policies(ctx context.Context, args []string) error {
+	const subcommands = "list|get|apply|export"
+	if len(args) == 0 {
+		fmt.Fprintf(c.stderr, "Usage: policycraft policies %s [arguments]\n", subcommands)
+		return flag.ErrHelp
+	}
+	switch args[0] {
+	case "list":
+		return c.listPolicies(ctx, args[1:])
+	case "get":
+		return c.getPolicy(ctx, args[1:])
+	case "apply":
+		return c.applyPolicies(ctx, args[1:])
+	case "export":
+		return c.exportPolicies(ctx, args[1:])
+	default:
+		fmt.Fprintf(c.stderr, "unknown policies subcommand %q, it must be %s\n", args[0], subcommands)
+		return flag.ErrHelp
+	}
+}
+
+// listPolicies prints a page of the policies as a table.
+func (c *cli) listPolicies(ctx context.Context, args []string) (err error) {
+	fs := c.flagSet("policies list", "[-api URL | -db URL] [arguments]")
+	var t target
+	t.register(fs)
+	var filter policycraft.PolicyFilter
+	fs.StringVar(&filter.PolicySet, "policy-set", "", "only the policies of the policy set")
+	fs.StringVar(&filter.Name, "name", "", "only the policies with the name")
+	fs.StringVar(&filter.Tag, "tag", "", "only the policies with the tag")
+	fs.IntVar(&filter.Limit, "limit", 0, "maximum number of policies, the default of the API if 0")
+	fs.StringVar(&filter.Cursor, "cursor", "", "cursor of the page, printed after the previous page")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	store, err := t.open()
+	if err != nil {
+		return err
+	}
+	defer closeStore(store, &err)
+
+	page, err := store.ListPolicies(ctx, filter)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPOLICY SET\tCRITERIA\tVALUE\tPRIORITY")
+	for _, p := range page.Policies {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\t%d\n", p.ID, p.Name, p.PolicySet, p.Criteria, p.Value, p.Priority)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if page.Next != "" {
+		fmt.Fprintf(c.stderr, "%d policies in total, next page: -cursor %s\n", page.Total, page.Next)
+	}
+	return nil
+}
+
+// getPolicy prints the policy with the id as JSON.
+func (c *cli) getPolicy(ctx context.Context, args []string) (err error) {
+	fs := c.flagSet("policies get", "[-api URL | -db URL] <id>")
+	var t target
+	t.register(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	store, err := t.open()
+	if err != nil {
+		return err
+	}
+	defer closeStore(store, &err)
+
+	policy, err := store.GetPolicy(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return c.printJSON(policy)
+}
+
+// applyPolicies imports the bundles of the files and directories, printing what changed.
+func (c *cli) applyPolicies(ctx context.Context, args []string) (err error) {
+	fs := c.flagSet("policies apply", "[-api URL | -db URL] [-dry-run] <file|dir>...")
+	var t target
+	t.register(fs)
+	dryRun := fs.Bool("dry-run", false, "only print what would change")
+	reason := fs.String("reason", "", "reason of the changes, recorded in the history of the policies")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	files, err := c.bundleFiles(fs)
+	if err != nil {
+		return err
+	}
+	store, err := t.open()
+	if err != nil {
+		return err
+	}
+	defer closeStore(store, &err)
+
+	for _, f := range files {
+		resp, err := store.ImportPolicies(ctx, f.Bundle, *dryRun, *reason)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Path, err)
+		}
+		prefix := ""
+		if resp.DryRun {
+			prefix = "(dry run) "
+		}
+		d := resp.Diff
+		if d.Empty() {
+			fmt.Fprintf(c.stdout, "%s%s: no changes\n", prefix, f.Bundle.PolicySet.Name)
+			continue
+		}
+		fmt.Fprintf(c.stdout, "%s%s: policy set changed %t, added %v, updated %v, removed %v\n",
+			prefix, f.Bundle.PolicySet.Name, d.PolicySetChanged, d.Added, d.Updated, d.Removed)
+	}
+	return nil
+}
+
+// exportPolicies writes the bundle of the policy set to stdout or to a file.
+func (c *cli) exportPolicies(ctx context.Context, args []string) (err error) {
+	fs := c.flagSet("policies export", "[-api URL | -db URL] [arguments]")
+	var t target
+	t.register(fs)
+	policySet := fs.String("policy-set", "", "policy set exported, the default one if empty")
+	format := fs.String("format", policycraft.BundleFormatYAML, "format of the bundle, json or yaml")
+	out := fs.String("o", "", "file the bundle is written to, stdout if empty")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *format != policycraft.BundleFormatJSON && *format != policycraft.BundleFormatYAML {
+		return errors.New("format must be json or yaml")
+	}
+	store, err := t.open()
+	if err != nil {
+		return err
+	}
+	defer closeStore(store, &err)
+
+	bundle, err := store.ExportPolicies(ctx, *policySet)
+	if err != nil {
+		return err
+	}
+	data, err := bundle.Encode(*format)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		return os.WriteFile(*out, data, 0o644)
+	}
+	_, err = c.stdout.Write(data)
+	return err
+}
+
+// closeStore closes the store, reporting its error in err if there isn't another one.
+func closeStore(store policyStore, err *error) {
+	if closeErr := store.Close(); closeErr != nil && *err == nil {
+		*err = closeErr
+	}
+}
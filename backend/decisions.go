@@ -0,0 +1,183 @@
+// Package policycraft ...
+// decisions.go gather the entity decision, the persisted record of an execution.
+package policycraft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by the storage when the requested entity doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// Decision is the record of an execution. It keeps everything needed to explain, at any time, why an input was approved or rejected.
+type Decision struct {
+	// ID is the unique identifier of the decision.
+	ID string `json:"id"`
+	// RequestID is the identifier of the HTTP request that triggered the execution.
+	RequestID string `json:"request_id"`
+	// CallerRef is a reference sent by the caller to correlate the decision with its own entities(e.g. a loan proposal ID).
+	CallerRef string `json:"caller_ref"`
+	// PolicySet is the name of the evaluated policy set.
+	PolicySet string `json:"policy_set"`
+	// Input is the custom fields sent to be evaluated.
+	Input map[string]interface{} `json:"input"`
+	// PolicyVersion is the fingerprint of the policies used in the evaluation. See PoliciesVersion.
+	PolicyVersion string `json:"policy_version"`
+	// Policies is a snapshot of the policies used in the evaluation.
+	Policies []Policy `json:"policies"`
+	// Decision is the result of the execution.
+	Decision bool `json:"decision"`
+	// Trace is the list of evaluated policies.
+	Trace []Step `json:"trace"`
+	// Enrichments is the list of custom fields fetched by the enrichment providers. The fetched values are also part of the Input.
+	Enrichments []Enrichment `json:"enrichments,omitempty"`
+	// TimedOut tells if the evaluation deadline of the policy set was hit. In this case, the decision is given by the policy set fail mode.
+	TimedOut bool `json:"timed_out"`
+	// StartedAt is the time when the execution started.
+	StartedAt time.Time `json:"started_at"`
+	// FinishedAt is the time when the execution finished.
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Enrichment sources.
+const (
+	// EnrichmentSourceProvider means the value was fetched from the provider.
+	EnrichmentSourceProvider = "provider"
+	// EnrichmentSourceCache means the value was fetched from the provider in a previous execution and reused.
+	EnrichmentSourceCache = "cache"
+	// EnrichmentSourceFallback means the provider failed and the fallback value was used.
+	EnrichmentSourceFallback = "fallback"
+)
+
+// Enrichment is the record of a custom field fetched by an enrichment provider.
+type Enrichment struct {
+	// Provider is the name of the enrichment provider.
+	Provider string `json:"provider"`
+	// Field is the custom field that received the value.
+	Field string `json:"field"`
+	// Value is the fetched value.
+	Value interface{} `json:"value"`
+	// Source tells where the value came from. It can be: provider, cache or fallback.
+	Source string `json:"source"`
+	// Attempts is the number of requests made to the provider.
+	Attempts int `json:"attempts"`
+	// Error is the last error of the provider, filled when the fallback value was used.
+	Error string `json:"error,omitempty"`
+}
+
+// DecisionFilter gather the optional filters used to query decisions. Zero values mean no filter.
+type DecisionFilter struct {
+	// From returns only the decisions started at or after this time.
+	From time.Time
+	// To returns only the decisions started before this time.
+	To time.Time
+	// Decision returns only the decisions with the given result.
+	Decision *bool
+	// CallerRef returns only the decisions with the given caller reference.
+	CallerRef string
+	// PolicySet returns only the decisions of the given policy set.
+	PolicySet string
+	// Limit is the maximum number of decisions returned.
+	Limit int
+}
+
+// ReplayOutcome is the result of re-evaluating the input of a logged decision against a version of the policies.
+type ReplayOutcome struct {
+	// PolicyVersion is the version of the policies used in the re-evaluation.
+	PolicyVersion string `json:"policy_version"`
+	// Decision is the result of the re-evaluation.
+	Decision bool `json:"decision"`
+	// Trace is the list of evaluated policies.
+	Trace []Step `json:"trace"`
+	// Error is filled when the input can't be evaluated against this version of the policies.
+	Error string `json:"error,omitempty"`
+	// Matches tells if the re-evaluation reached the same decision that was logged.
+	Matches bool `json:"matches"`
+}
+
+// Replay is the report of re-evaluating a logged decision against the policies used at the time and against the current policies.
+type Replay struct {
+	// DecisionID is the identifier of the replayed decision.
+	DecisionID string `json:"decision_id"`
+	// Original is the logged decision.
+	Original bool `json:"original"`
+	// Logged is the re-evaluation against the policy version used at the time.
+	Logged ReplayOutcome `json:"logged"`
+	// Current is the re-evaluation against the current policies.
+	Current ReplayOutcome `json:"current"`
+	// Reproducible tells if the logged decision is reached again with the logged input and policies.
+	Reproducible bool `json:"reproducible"`
+	// Replayable tells if the decision depends only on its input and policies, so it can be replayed. When it doesn't,
+	// the policies aren't evaluated and Reason tells why.
+	Replayable bool `json:"replayable"`
+	// Reason is why the decision can't be replayed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// ReplayDecision re-evaluates the logged input of the decision against the logged policies and against the current ones.
+// The operators and functions are looked up in the registry, which must be the one of the engine. If nil, the DefaultRegistry is used.
+// A decision given by the fail mode of its policy set, because the deadline was hit, or taken with the fallback value of a failed
+// enrichment doesn't depend only on its input and policies, so it isn't replayed.
+func ReplayDecision(ctx context.Context, decision Decision, current []Policy, registry *Registry) Replay {
+	if reason := notReplayable(decision); reason != "" {
+		return Replay{
+			DecisionID: decision.ID,
+			Original:   decision.Decision,
+			Logged:     ReplayOutcome{PolicyVersion: decision.PolicyVersion, Error: reason},
+			Current:    ReplayOutcome{PolicyVersion: PoliciesVersion(current), Error: reason},
+			Reason:     reason,
+		}
+	}
+
+	logged := replayOutcome(ctx, decision, decision.Policies, registry)
+	// A snapshot that doesn't match its version was changed after the decision, so it can't prove anything. The versions
+	// without prefix hashed every field of the policies, so they can't be recomputed once a field is added and aren't checked.
+	versioned := strings.HasPrefix(decision.PolicyVersion, policiesVersionPrefix)
+	if versioned && logged.PolicyVersion != decision.PolicyVersion && logged.Error == "" {
+		logged.Error = fmt.Sprintf("the logged policies don't match the logged version %s", decision.PolicyVersion)
+		logged.Matches = false
+	}
+
+	return Replay{
+		DecisionID:   decision.ID,
+		Original:     decision.Decision,
+		Logged:       logged,
+		Current:      replayOutcome(ctx, decision, current, registry),
+		Reproducible: logged.Matches,
+		Replayable:   true,
+	}
+}
+
+// notReplayable returns why the decision can't be replayed, empty if it can.
+func notReplayable(decision Decision) string {
+	if decision.TimedOut {
+		return "the decision was given by the fail mode of the policy set, because the deadline was hit"
+	}
+	for _, e := range decision.Enrichments {
+		if e.Source == EnrichmentSourceFallback {
+			return fmt.Sprintf("the enrichment %s failed, the decision was taken with the fallback value of %s", e.Provider, e.Field)
+		}
+	}
+	return ""
+}
+
+// replayOutcome evaluates the decision input against the given policies.
+func replayOutcome(ctx context.Context, decision Decision, policies []Policy, registry *Registry) ReplayOutcome {
+	outcome := ReplayOutcome{PolicyVersion: PoliciesVersion(policies)}
+
+	e := Execution{CustomFields: decision.Input, Registry: registry}
+	result, err := e.EvaluateWithTrace(ctx, policies)
+	if err != nil {
+		outcome.Error = err.Error()
+		return outcome
+	}
+
+	outcome.Decision = result.Decision
+	outcome.Trace = result.Trace
+	outcome.Matches = result.Decision == decision.Decision
+	return outcome
+}
@@ -0,0 +1,89 @@
+package policycraft
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReplayDecision(t *testing.T) {
+	policies := []Policy{
+		{ID: "1", Name: "age", Criteria: ">=", Value: 18, SuccessCase: true, Priority: 1},
+	}
+	e := Execution{CustomFields: map[string]interface{}{"age": float64(17)}}
+	result, err := e.EvaluateWithTrace(context.Background(), policies)
+	if err != nil {
+		t.Fatalf("Error evaluating policy: %s", err)
+	}
+	decision := Decision{
+		ID:            "decision",
+		Input:         e.CustomFields,
+		PolicyVersion: PoliciesVersion(policies),
+		Policies:      policies,
+		Decision:      result.Decision,
+		Trace:         result.Trace,
+	}
+
+	// the current policies are the same used at the time
+	replay := ReplayDecision(context.Background(), decision, policies, nil)
+	if !replay.Reproducible || !replay.Logged.Matches || !replay.Current.Matches {
+		t.Errorf("Expecting the decision to be reproducible, got %+v", replay)
+	}
+	if replay.Current.PolicyVersion != decision.PolicyVersion {
+		t.Errorf("Expecting the current version to be the logged one")
+	}
+
+	// the current policies changed and now the input is approved
+	current := []Policy{
+		{ID: "1", Name: "age", Criteria: ">=", Value: 16, SuccessCase: true, Priority: 1},
+	}
+	replay = ReplayDecision(context.Background(), decision, current, nil)
+	if !replay.Reproducible {
+		t.Errorf("Expecting the decision to be reproducible against the logged policies")
+	}
+	if replay.Current.Matches || replay.Current.Decision != true {
+		t.Errorf("Expecting the current policies to approve the input, got %+v", replay.Current)
+	}
+	if replay.Current.PolicyVersion == decision.PolicyVersion {
+		t.Errorf("Expecting the current version to differ from the logged one")
+	}
+
+	// the current policies can't evaluate the logged input
+	current = []Policy{
+		{ID: "2", Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 1},
+	}
+	replay = ReplayDecision(context.Background(), decision, current, nil)
+	if replay.Current.Error == "" || replay.Current.Matches {
+		t.Errorf("Expecting an error evaluating the current policies, got %+v", replay.Current)
+	}
+
+	// a snapshot that doesn't match the logged version can't reproduce the decision
+	tampered := decision
+	tampered.Policies = []Policy{{ID: "1", Name: "age", Criteria: ">=", Value: 19, SuccessCase: true, Priority: 1}}
+	replay = ReplayDecision(context.Background(), tampered, policies, nil)
+	if replay.Reproducible || replay.Logged.Error == "" {
+		t.Errorf("Expecting the tampered decision to not be reproducible, got %+v", replay.Logged)
+	}
+
+	// a version without prefix hashed every field of the policies, so it can't be checked against the snapshot
+	legacy := decision
+	legacy.PolicyVersion = "4c2a1b"
+	replay = ReplayDecision(context.Background(), legacy, policies, nil)
+	if !replay.Reproducible || replay.Logged.Error != "" {
+		t.Errorf("Expecting the legacy decision to be reproducible, got %+v", replay.Logged)
+	}
+
+	// a decision given by the fail mode or with a fallback value doesn't depend only on its input and policies
+	timedOut := decision
+	timedOut.TimedOut = true
+	fallback := decision
+	fallback.Enrichments = []Enrichment{{Provider: "bureau", Field: "score", Source: EnrichmentSourceFallback, Error: "timeout"}}
+	for name, d := range map[string]Decision{"timed out": timedOut, "fallback": fallback} {
+		replay = ReplayDecision(context.Background(), d, policies, nil)
+		if replay.Replayable || replay.Reproducible || replay.Reason == "" || replay.Logged.Trace != nil {
+			t.Errorf("Expecting the %s decision to not be replayed, got %+v", name, replay)
+		}
+	}
+	if replay = ReplayDecision(context.Background(), decision, policies, nil); !replay.Replayable {
+		t.Errorf("Expecting the decision to be replayable, got %+v", replay)
+	}
+}
@@ -0,0 +1,236 @@
+// Package enrichment fetches the custom fields declared by the enrichment providers of a policy set.
+package enrichment
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/perebaj/policycraft"
+)
+
+// maxCacheEntries bounds the memory used by the cache. When it's full the expired entries are dropped, and if it's still full, the cache is cleared.
+const maxCacheEntries = 10000
+
+// retryBackoff is the wait before each retry, multiplied by the attempt number.
+const retryBackoff = 50 * time.Millisecond
+
+// maxResponseSize is the maximum size of a provider response body.
+const maxResponseSize = 1 << 20
+
+// Enricher fetches the values of the enrichment providers over HTTP, caching them for the provider TTL.
+// It's safe for concurrent use.
+type Enricher struct {
+	client *http.Client
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// NewEnricher returns an Enricher that makes the requests with the given client. If client is nil, http.DefaultClient is used.
+func NewEnricher(client *http.Client) *Enricher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Enricher{
+		client: client,
+		now:    time.Now,
+		cache:  make(map[string]cacheEntry),
+	}
+}
+
+// Enrich calls every provider with the input and returns a copy of the input with the fetched fields, and the record of each fetch.
+// A provider that fails uses its fallback value. If it doesn't have one, Enrich returns an error.
+func (e *Enricher) Enrich(ctx context.Context, providers []policycraft.EnrichmentProvider, input map[string]interface{}) (map[string]interface{}, []policycraft.Enrichment, error) {
+	enriched := make(map[string]interface{}, len(input)+len(providers))
+	for k, v := range input {
+		enriched[k] = v
+	}
+	if len(providers) == 0 {
+		return enriched, nil, nil
+	}
+
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal input: %w", err)
+	}
+
+	records := make([]policycraft.Enrichment, len(providers))
+	errs := make([]error, len(providers))
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		wg.Add(1)
+		go func(i int, provider policycraft.EnrichmentProvider) {
+			defer wg.Done()
+			records[i], errs[i] = e.fetch(ctx, provider, input, body)
+		}(i, provider)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	for _, record := range records {
+		enriched[record.Field] = record.Value
+	}
+	return enriched, records, nil
+}
+
+// fetch gets the value of a single provider, from the cache, the provider or the fallback, in this order.
+func (e *Enricher) fetch(ctx context.Context, provider policycraft.EnrichmentProvider, input map[string]interface{}, body []byte) (policycraft.Enrichment, error) {
+	record := policycraft.Enrichment{Provider: provider.Name, Field: provider.Field}
+
+	key := cacheKey(provider, input)
+	if value, ok := e.cached(key); ok {
+		record.Value = value
+		record.Source = policycraft.EnrichmentSourceCache
+		return record, nil
+	}
+
+	var err error
+	for attempt := 0; attempt <= provider.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				err = errors.Join(err, ctx.Err())
+			case <-time.After(time.Duration(attempt) * retryBackoff):
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		record.Attempts++
+
+		var value interface{}
+		var retry bool
+		value, retry, err = e.request(ctx, provider, body)
+		if err == nil {
+			if provider.CacheTTL() > 0 {
+				e.store(key, value, provider.CacheTTL())
+			}
+			record.Value = value
+			record.Source = policycraft.EnrichmentSourceProvider
+			return record, nil
+		}
+		if !retry {
+			break
+		}
+	}
+
+	// The deadline of the execution is not a provider failure, so the fallback doesn't hide it.
+	if provider.Fallback == nil || ctx.Err() != nil {
+		return record, fmt.Errorf("enrichment %s: %w", provider.Name, err)
+	}
+	record.Value = provider.Fallback
+	record.Source = policycraft.EnrichmentSourceFallback
+	record.Error = err.Error()
+	return record, nil
+}
+
+// request makes a single request to the provider. It returns whether the failure is worth a retry.
+func (e *Enricher) request(ctx context.Context, provider policycraft.EnrichmentProvider, body []byte) (value interface{}, retry bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, provider.Timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to request provider: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, true, fmt.Errorf("provider responded with status %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("provider responded with status %d", resp.StatusCode)
+	}
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&response); err != nil {
+		return nil, false, fmt.Errorf("failed to decode provider response: %w", err)
+	}
+	field := provider.ResponseField
+	if field == "" {
+		field = provider.Field
+	}
+	value, ok := response[field]
+	if !ok {
+		return nil, false, fmt.Errorf("provider response doesn't have the field %s", field)
+	}
+	return value, false, nil
+}
+
+func (e *Enricher) cached(key string) (interface{}, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entry, ok := e.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if !e.now().Before(entry.expiresAt) {
+		delete(e.cache, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (e *Enricher) store(key string, value interface{}, ttl time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	if len(e.cache) >= maxCacheEntries {
+		for k, entry := range e.cache {
+			if !now.Before(entry.expiresAt) {
+				delete(e.cache, k)
+			}
+		}
+	}
+	if len(e.cache) >= maxCacheEntries {
+		e.cache = make(map[string]cacheEntry)
+	}
+	e.cache[key] = cacheEntry{value: value, expiresAt: now.Add(ttl)}
+}
+
+// cacheKey identifies a provider request. The provider URL and field are part of the key, so providers of different policy sets don't share values.
+func cacheKey(provider policycraft.EnrichmentProvider, input map[string]interface{}) string {
+	fields := provider.CacheKey
+	if len(fields) == 0 {
+		for field := range input {
+			fields = append(fields, field)
+		}
+	}
+	fields = append([]string(nil), fields...)
+	sort.Strings(fields)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", provider.URL, provider.Field, provider.ResponseField)
+	for _, field := range fields {
+		// the marshaling of the JSON decoded values can't fail, and maps are marshaled with sorted keys
+		b, _ := json.Marshal(input[field])
+		fmt.Fprintf(h, "%s=%s\x00", field, b)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
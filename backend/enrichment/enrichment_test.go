@@ -0,0 +1,213 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/perebaj/policycraft"
+)
+
+// newBureau starts a stub provider that answers the score of the document. The first failures requests fail with the status code.
+func newBureau(t *testing.T, failures int32, status int) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if n <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		var input map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		score := 300
+		if input["document"] == "123" {
+			score = 700
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"bureau_score": score})
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestEnricherEnrich(t *testing.T) {
+	server, calls := newBureau(t, 0, 0)
+	e := NewEnricher(server.Client())
+	providers := []policycraft.EnrichmentProvider{
+		{Name: "bureau", URL: server.URL, Field: "score", ResponseField: "bureau_score"},
+	}
+	input := map[string]interface{}{"document": "123"}
+
+	got, records, err := e.Enrich(context.Background(), providers, input)
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	if got["score"] != float64(700) || got["document"] != "123" {
+		t.Errorf("Expecting the input with the score, got %v", got)
+	}
+	if _, ok := input["score"]; ok {
+		t.Errorf("Expecting the input to be untouched, got %v", input)
+	}
+	want := policycraft.Enrichment{Provider: "bureau", Field: "score", Value: float64(700), Source: policycraft.EnrichmentSourceProvider, Attempts: 1}
+	if len(records) != 1 || records[0] != want {
+		t.Errorf("Enrich() records = %v, want %v", records, want)
+	}
+	if *calls != 1 {
+		t.Errorf("Expecting 1 call to the provider, got %d", *calls)
+	}
+}
+
+func TestEnricherCache(t *testing.T) {
+	server, calls := newBureau(t, 0, 0)
+	e := NewEnricher(server.Client())
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	providers := []policycraft.EnrichmentProvider{
+		{Name: "bureau", URL: server.URL, Field: "score", ResponseField: "bureau_score", CacheTTLSeconds: 60, CacheKey: []string{"document"}},
+	}
+
+	_, _, err := e.Enrich(context.Background(), providers, map[string]interface{}{"document": "123", "age": float64(20)})
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	// the age is not part of the cache key, so the value is reused
+	got, records, err := e.Enrich(context.Background(), providers, map[string]interface{}{"document": "123", "age": float64(30)})
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	if got["score"] != float64(700) || records[0].Source != policycraft.EnrichmentSourceCache || records[0].Attempts != 0 {
+		t.Errorf("Expecting the score from the cache, got %v %v", got, records)
+	}
+	if *calls != 1 {
+		t.Errorf("Expecting 1 call to the provider, got %d", *calls)
+	}
+
+	// another document is another cache entry
+	got, _, err = e.Enrich(context.Background(), providers, map[string]interface{}{"document": "456"})
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	if got["score"] != float64(300) || *calls != 2 {
+		t.Errorf("Expecting the score from the provider, got %v after %d calls", got, *calls)
+	}
+
+	// the entry expires after the TTL
+	now = now.Add(time.Minute)
+	_, records, err = e.Enrich(context.Background(), providers, map[string]interface{}{"document": "123"})
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	if records[0].Source != policycraft.EnrichmentSourceProvider || *calls != 3 {
+		t.Errorf("Expecting the expired entry to be fetched again, got %v after %d calls", records, *calls)
+	}
+}
+
+func TestEnricherRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32
+		status   int
+		retries  int
+		fallback interface{}
+		wantErr  bool
+		want     policycraft.Enrichment
+	}{
+		{
+			name:     "succeeds after retrying server errors",
+			failures: 2,
+			status:   http.StatusServiceUnavailable,
+			retries:  2,
+			want:     policycraft.Enrichment{Value: float64(700), Source: policycraft.EnrichmentSourceProvider, Attempts: 3},
+		},
+		{
+			name:     "uses the fallback after the retries",
+			failures: 3,
+			status:   http.StatusInternalServerError,
+			retries:  1,
+			fallback: float64(0),
+			want:     policycraft.Enrichment{Value: float64(0), Source: policycraft.EnrichmentSourceFallback, Attempts: 2, Error: "provider responded with status 500"},
+		},
+		{
+			name:     "doesn't retry client errors",
+			failures: 1,
+			status:   http.StatusNotFound,
+			retries:  3,
+			fallback: float64(0),
+			want:     policycraft.Enrichment{Value: float64(0), Source: policycraft.EnrichmentSourceFallback, Attempts: 1, Error: "provider responded with status 404"},
+		},
+		{
+			name:     "fails without a fallback",
+			failures: 1,
+			status:   http.StatusBadGateway,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newBureau(t, tt.failures, tt.status)
+			e := NewEnricher(server.Client())
+			providers := []policycraft.EnrichmentProvider{
+				{Name: "bureau", URL: server.URL, Field: "score", ResponseField: "bureau_score", Retries: tt.retries, Fallback: tt.fallback},
+			}
+
+			got, records, err := e.Enrich(context.Background(), providers, map[string]interface{}{"document": "123"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Enrich() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			tt.want.Provider = "bureau"
+			tt.want.Field = "score"
+			if records[0] != tt.want {
+				t.Errorf("Enrich() records = %v, want %v", records[0], tt.want)
+			}
+			if got["score"] != tt.want.Value {
+				t.Errorf("Expecting the score %v, got %v", tt.want.Value, got["score"])
+			}
+		})
+	}
+}
+
+func TestEnricherTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	e := NewEnricher(server.Client())
+	providers := []policycraft.EnrichmentProvider{
+		{Name: "bureau", URL: server.URL, Field: "score", TimeoutMS: 10, Fallback: float64(0)},
+	}
+
+	start := time.Now()
+	got, records, err := e.Enrich(context.Background(), providers, map[string]interface{}{"document": "123"})
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expecting the provider timeout to be respected, took %v", elapsed)
+	}
+	if got["score"] != float64(0) || records[0].Source != policycraft.EnrichmentSourceFallback {
+		t.Errorf("Expecting the fallback value, got %v %v", got, records)
+	}
+
+	// the deadline of the execution is not hidden by the fallback
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	providers[0].TimeoutMS = 1000
+	_, _, err = e.Enrich(ctx, providers, map[string]interface{}{"document": "123"})
+	if err == nil {
+		t.Errorf("Expecting an error when the execution deadline is hit")
+	}
+}
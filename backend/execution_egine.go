@@ -2,7 +2,12 @@
 // execution_engine.go gather the logic for evaluating policies with given data.
 package policycraft
 
-import "fmt"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+)
 
 // Execution is a struct that will be used to evaluate the policies comparing them with an input data(custom fields).
 type Execution struct {
@@ -10,21 +15,70 @@ type Execution struct {
 	CustomFields map[string]interface{}
 	//Observation: The CustomFields map is dynamic map field that can store any type of data.
 	// The duty of this code is to verify if the custom fields that were passed, could be used to evaluate the policies.
+
+	// Registry is where the operators and functions referenced by the policies are looked up. If nil, the DefaultRegistry is used.
+	Registry *Registry
+}
+
+// Step is the record of a single policy evaluation. The list of steps explains how a decision was taken.
+type Step struct {
+	// PolicyID is the unique identifier of the evaluated policy.
+	PolicyID string `json:"policy_id"`
+	// Name is the name of the evaluated policy, and also the custom field used as input.
+	Name string `json:"name"`
+	// Type is the type of the evaluated policy, empty for criteria policies.
+	Type string `json:"type,omitempty"`
+	// Criteria is the criteria of the evaluated policy.
+	Criteria string `json:"criteria"`
+	// Function is the function applied to the input before the comparison, if any.
+	Function string `json:"function,omitempty"`
+	// Value is the value of the policy that the input was compared with.
+	Value int `json:"value"`
+	// Input is the custom field value used in the comparison.
+	Input interface{} `json:"input"`
+	// Passed tells if the input satisfied the policy criteria.
+	Passed bool `json:"passed"`
+}
+
+// Result is the outcome of an evaluation, the decision and the trace of the policies that were evaluated to reach it.
+type Result struct {
+	// Decision is the execution decision.
+	Decision bool `json:"decision"`
+	// Trace is the ordered list of evaluated policies. The evaluation stops in the first policy that doesn't pass.
+	Trace []Step `json:"trace"`
 }
 
 // Evaluate will evaluate the policies and custom fields and return a boolean value indicating execution decision.
-func (e *Execution) Evaluate(policies []Policy) (bool, error) {
+// The evaluation stops with the context error when the context is done.
+func (e *Execution) Evaluate(ctx context.Context, policies []Policy) (bool, error) {
+	result, err := e.EvaluateWithTrace(ctx, policies)
+	if err != nil {
+		return false, err
+	}
+	return result.Decision, nil
+}
+
+// EvaluateWithTrace works like Evaluate, but also returns the trace of every evaluated policy.
+// The policies are evaluated in the order defined by SortPolicies, regardless of the order they are given.
+func (e *Execution) EvaluateWithTrace(ctx context.Context, policies []Policy) (Result, error) {
 	// Isn't possible to evaluate a policy without any policies
 	if len(policies) == 0 {
-		return false, fmt.Errorf("no policies to evaluate")
+		return Result{}, fmt.Errorf("no policies to evaluate")
 	}
+	policies = SortPolicies(policies)
 
 	// Validating if all custom fields keys have a respective policy to be evaluated
+	// Script policies read any custom field, so when there is one, the custom fields without a policy are accepted.
 	policyMap := make(map[string]bool)
+	hasScript := false
 	for _, policy := range policies {
+		if policy.IsScript() {
+			hasScript = true
+			continue
+		}
 		_, ok := e.CustomFields[policy.Name]
 		if !ok {
-			return false, fmt.Errorf("value '%s' not found in custom fields", policy.Name)
+			return Result{}, fmt.Errorf("%w: value '%s' not found in custom fields", ErrInvalidInput, policy.Name)
 		}
 		// loading the policy name into a map to increse the performance of the next validation
 		policyMap[policy.Name] = true
@@ -33,38 +87,105 @@ func (e *Execution) Evaluate(policies []Policy) (bool, error) {
 	// Validating if there is a custom field that doesn't exist in the policies
 	for key := range e.CustomFields {
 		_, ok := policyMap[key]
-		if !ok {
-			return false, fmt.Errorf("the value '%s' doesn't exist in the policies", key)
+		if !ok && !hasScript {
+			return Result{}, fmt.Errorf("%w: the value '%s' doesn't exist in the policies", ErrInvalidInput, key)
 		}
 	}
 
+	registry := e.Registry
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+
 	// Observations:
-	// 1. It's expected that the custom field value is always an int, so we can safely cast it to int
-	// 2. We are assuming the policies are ordered by the priority, so we can iterate over them safely
+	// 1. The custom field is compared by the operator of the policy criteria, the built-in ones expect an integer.
+	// 2. The policies were sorted by priority above, so we can iterate over them safely
+	var result Result
 	for _, policy := range policies {
-		switch policy.Criteria {
-		case ">":
-			if !(e.CustomFields[policy.Name].(int) > policy.Value) {
-				return !policy.SuccessCase, nil
-			}
-		case "<":
-			if !(e.CustomFields[policy.Name].(int) < policy.Value) {
-				return !policy.SuccessCase, nil
-			}
-		case ">=":
-			if !(e.CustomFields[policy.Name].(int) >= policy.Value) {
-				return !policy.SuccessCase, nil
-			}
-		case "<=":
-			if !(e.CustomFields[policy.Name].(int) <= policy.Value) {
-				return !policy.SuccessCase, nil
-			}
-		case "==":
-			if !(e.CustomFields[policy.Name].(int) == policy.Value) {
-				return !policy.SuccessCase, nil
+		if err := ctx.Err(); err != nil {
+			return Result{}, fmt.Errorf("evaluating policy '%s': %w", policy.Name, err)
+		}
+
+		step := Step{
+			PolicyID: policy.ID,
+			Name:     policy.Name,
+			Type:     policy.Type,
+			Criteria: policy.Criteria,
+			Function: policy.Function,
+			Value:    policy.Value,
+			Input:    e.CustomFields[policy.Name],
+		}
+		var passed bool
+		var err error
+		if policy.IsScript() {
+			// the script input is the whole custom fields, that is already stored in the decision
+			step.Input = nil
+			passed, err = runScript(ctx, policy.Script, e.CustomFields)
+			if err != nil {
+				err = fmt.Errorf("policy '%s': %w", policy.Name, err)
 			}
+		} else {
+			passed, err = evaluatePolicy(registry, policy, e.CustomFields[policy.Name])
+		}
+		if err != nil {
+			return Result{}, err
+		}
+
+		step.Passed = passed
+		result.Trace = append(result.Trace, step)
+		if !passed {
+			result.Decision = !policy.SuccessCase
+			return result, nil
 		}
 	}
 	// If all policies are evaluated as true, we return the last policy success case
-	return policies[len(policies)-1].SuccessCase, nil
+	result.Decision = policies[len(policies)-1].SuccessCase
+	return result, nil
+}
+
+// evaluatePolicy applies the policy function, if any, to the input and compares it using the policy operator.
+func evaluatePolicy(registry *Registry, policy Policy, input interface{}) (bool, error) {
+	op, ok := registry.Operator(policy.Criteria)
+	if !ok {
+		return false, fmt.Errorf("policy '%s': unknown criteria '%s'", policy.Name, policy.Criteria)
+	}
+
+	if policy.Function != "" {
+		fn, ok := registry.Function(policy.Function)
+		if !ok {
+			return false, fmt.Errorf("policy '%s': unknown function '%s'", policy.Name, policy.Function)
+		}
+		var err error
+		input, err = fn(input)
+		if err != nil {
+			return false, fmt.Errorf("value '%s': function '%s': %w", policy.Name, policy.Function, err)
+		}
+	}
+
+	passed, err := op(input, policy.Value)
+	if err != nil {
+		return false, fmt.Errorf("value '%s': %w", policy.Name, err)
+	}
+	return passed, nil
+}
+
+// intValue converts a custom field value to int. Only integer numbers are accepted.
+func intValue(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case float64:
+		if n != math.Trunc(n) {
+			return 0, fmt.Errorf("expected an integer, got %v", n)
+		}
+		return int(n), nil
+	case json.Number:
+		i, err := n.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("expected an integer, got %v", n)
+		}
+		return int(i), nil
+	default:
+		return 0, fmt.Errorf("expected an integer, got %T", v)
+	}
 }
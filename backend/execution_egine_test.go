@@ -1,6 +1,11 @@
 package policycraft
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
 
 func TestEvaluate(t *testing.T) {
 	// Create a new execution
@@ -20,7 +25,7 @@ func TestEvaluate(t *testing.T) {
 	}
 
 	// Evaluate the policy
-	result, err := execution.Evaluate([]Policy{policy})
+	result, err := execution.Evaluate(context.Background(), []Policy{policy})
 	if err != nil {
 		t.Errorf("Error evaluating policy: %s", err)
 	}
@@ -42,7 +47,7 @@ func TestEvaluate(t *testing.T) {
 	execution.CustomFields["rank"] = 16
 
 	// Evaluate the policy
-	result, err = execution.Evaluate([]Policy{policy, policy2})
+	result, err = execution.Evaluate(context.Background(), []Policy{policy, policy2})
 	if err != nil {
 		t.Errorf("Error evaluating policy: %s", err)
 	}
@@ -64,7 +69,7 @@ func TestEvaluate(t *testing.T) {
 	execution.CustomFields["income"] = 1000
 
 	// Evaluate the policy
-	result, err = execution.Evaluate([]Policy{policy, policy2, policy3})
+	result, err = execution.Evaluate(context.Background(), []Policy{policy, policy2, policy3})
 	if err != nil {
 		t.Errorf("Error evaluating policy: %s", err)
 	}
@@ -84,22 +89,206 @@ func TestEvaluate(t *testing.T) {
 	}
 
 	// Evaluate the policy
-	_, err = execution.Evaluate([]Policy{policy, policy2, policy3, policy4})
+	_, err = execution.Evaluate(context.Background(), []Policy{policy, policy2, policy3, policy4})
 	if err == nil {
 		t.Errorf("Expecting an error evaluating policy because the custom field is not present")
 	}
 
 	// Expecting an error when sending an empty policy list
-	_, err = execution.Evaluate([]Policy{})
+	_, err = execution.Evaluate(context.Background(), []Policy{})
 	if err == nil {
 		t.Errorf("Expecting an error evaluating policy because the policy list is empty")
 	}
 
 	// Expecting an error when I send a customField key that is not present in the policies
 	execution.CustomFields["notpresent"] = 1
-	_, err = execution.Evaluate([]Policy{policy, policy2, policy3})
+	_, err = execution.Evaluate(context.Background(), []Policy{policy, policy2, policy3})
 
 	if err == nil {
 		t.Errorf("Expecting an error evaluating policy because the custom field is not present in the policies")
 	}
 }
+
+func TestEvaluateWithTrace(t *testing.T) {
+	policies := []Policy{
+		{ID: "1", Name: "age", Criteria: ">=", Value: 18, SuccessCase: true, Priority: 1},
+		{ID: "2", Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2},
+	}
+
+	// values decoded from JSON are float64
+	execution := &Execution{
+		CustomFields: map[string]interface{}{
+			"age":    float64(20),
+			"income": float64(500),
+		},
+	}
+
+	result, err := execution.EvaluateWithTrace(context.Background(), policies)
+	if err != nil {
+		t.Fatalf("Error evaluating policy: %s", err)
+	}
+	if result.Decision != false {
+		t.Errorf("The policy should be evaluated as false")
+	}
+	if len(result.Trace) != 2 {
+		t.Fatalf("Expecting 2 steps in the trace, got %d", len(result.Trace))
+	}
+	if !result.Trace[0].Passed || result.Trace[0].PolicyID != "1" {
+		t.Errorf("Expecting the first step to pass, got %+v", result.Trace[0])
+	}
+	if result.Trace[1].Passed || result.Trace[1].Input != float64(500) {
+		t.Errorf("Expecting the second step to fail, got %+v", result.Trace[1])
+	}
+
+	// the evaluation stops in the first policy that doesn't pass
+	execution.CustomFields["age"] = float64(16)
+	result, err = execution.EvaluateWithTrace(context.Background(), policies)
+	if err != nil {
+		t.Fatalf("Error evaluating policy: %s", err)
+	}
+	if len(result.Trace) != 1 {
+		t.Errorf("Expecting 1 step in the trace, got %d", len(result.Trace))
+	}
+
+	// Expecting an error when the custom field isn't an integer
+	execution.CustomFields["age"] = "20"
+	_, err = execution.EvaluateWithTrace(context.Background(), policies)
+	if err == nil {
+		t.Errorf("Expecting an error evaluating policy because the custom field isn't an integer")
+	}
+
+	execution.CustomFields["age"] = 20.5
+	_, err = execution.EvaluateWithTrace(context.Background(), policies)
+	if err == nil {
+		t.Errorf("Expecting an error evaluating policy because the custom field isn't an integer")
+	}
+}
+
+func TestPoliciesVersion(t *testing.T) {
+	policies := []Policy{
+		{ID: "1", Name: "age", Criteria: ">=", Value: 18, SuccessCase: true, Priority: 1},
+		{ID: "2", Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2},
+	}
+
+	version := PoliciesVersion(policies)
+	if version != PoliciesVersion(policies) {
+		t.Errorf("The version must be deterministic")
+	}
+
+	changed := []Policy{policies[0], policies[1]}
+	changed[1].Value = 2000
+	if version == PoliciesVersion(changed) {
+		t.Errorf("Changing a policy must change the version")
+	}
+
+	reordered := []Policy{policies[1], policies[0]}
+	if version != PoliciesVersion(reordered) {
+		t.Errorf("The order of the given policies must not change the version")
+	}
+
+	reprioritized := []Policy{policies[0], policies[1]}
+	reprioritized[0].Priority = 3
+	if version == PoliciesVersion(reprioritized) {
+		t.Errorf("Changing the priority of a policy must change the version")
+	}
+
+	// the fields that don't affect the evaluation aren't part of the version, so adding one keeps the versions of the decisions
+	moved := []Policy{policies[0], policies[1]}
+	moved[0].PolicySet, moved[1].PolicySet = "credit", "credit"
+	if version != PoliciesVersion(moved) {
+		t.Errorf("The policy set of the policies must not change the version")
+	}
+	typed := []Policy{policies[0], policies[1]}
+	typed[0].Type = PolicyTypeCriteria
+	if version != PoliciesVersion(typed) {
+		t.Errorf("The default type must not change the version")
+	}
+	if !strings.HasPrefix(version, "v1:") {
+		t.Errorf("The version must have its form prefix, got %s", version)
+	}
+}
+
+func TestEvaluateSortsPolicies(t *testing.T) {
+	// the policies aren't given in priority order
+	policies := []Policy{
+		{ID: "2", Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2},
+		{ID: "1", Name: "age", Criteria: ">=", Value: 18, SuccessCase: false, Priority: 1},
+	}
+	execution := &Execution{
+		CustomFields: map[string]interface{}{
+			"age":    16,
+			"income": 500,
+		},
+	}
+
+	result, err := execution.EvaluateWithTrace(context.Background(), policies)
+	if err != nil {
+		t.Fatalf("Error evaluating policy: %s", err)
+	}
+	// the age policy has the highest priority and fails first, returning the opposite of its success case
+	if result.Decision != true || len(result.Trace) != 1 || result.Trace[0].PolicyID != "1" {
+		t.Errorf("Expecting the age policy to be evaluated first, got %+v", result)
+	}
+}
+
+func TestSortPolicies(t *testing.T) {
+	policies := []Policy{
+		{ID: "c", Name: "b", Priority: 1},
+		{ID: "b", Name: "b", Priority: 1},
+		{ID: "d", Name: "z", Priority: 0},
+		{ID: "a", Name: "a", Priority: 1},
+	}
+
+	sorted := SortPolicies(policies)
+
+	want := []string{"d", "a", "b", "c"}
+	for i, id := range want {
+		if sorted[i].ID != id {
+			t.Fatalf("Expecting %s at position %d, got %s", id, i, sorted[i].ID)
+		}
+	}
+	if policies[0].ID != "c" {
+		t.Errorf("SortPolicies must not change the given slice")
+	}
+}
+
+func TestDuplicatedPriority(t *testing.T) {
+	policies := []Policy{
+		{ID: "1", Name: "age", Priority: 1, PolicySet: "credit"},
+		{ID: "2", Name: "income", Priority: 2, PolicySet: "credit"},
+		{ID: "3", Name: "age", Priority: 3, PolicySet: "insurance"},
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		want   string
+	}{
+		{name: "same priority in the same set", policy: Policy{ID: "4", Priority: 2, PolicySet: "credit"}, want: "2"},
+		{name: "same priority in another set", policy: Policy{ID: "4", Priority: 3, PolicySet: "credit"}},
+		{name: "updating the policy itself", policy: Policy{ID: "1", Priority: 1, PolicySet: "credit"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := DuplicatedPriority(tt.policy, policies)
+			if ok != (tt.want != "") || got.ID != tt.want {
+				t.Errorf("DuplicatedPriority() = %v, %v, want %s", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateContextDone(t *testing.T) {
+	policies := []Policy{
+		{ID: "1", Name: "age", Criteria: ">=", Value: 18, SuccessCase: true, Priority: 1},
+	}
+	execution := &Execution{CustomFields: map[string]interface{}{"age": 20}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := execution.Evaluate(ctx, policies)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expecting the context error, got %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+// Package gitops reconciles the stored policy sets with a directory of bundle files, usually a checked out git repository.
+package gitops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/perebaj/policycraft"
+)
+
+// Storage is the interface that wraps the storage methods used to reconcile the policy sets.
+type Storage interface {
+	PolicySet(ctx context.Context, name string) (policycraft.PolicySet, error)
+	PolicySets(ctx context.Context) ([]policycraft.PolicySet, error)
+	PoliciesBySet(ctx context.Context, name string) ([]policycraft.Policy, error)
+	ImportBundle(ctx context.Context, bundle policycraft.Bundle) error
+}
+
+// BundleFile is a bundle read from the directory.
+type BundleFile struct {
+	// Path is the path of the file, relative to the directory.
+	Path string
+	// Bundle is the parsed bundle.
+	Bundle policycraft.Bundle
+}
+
+// Drift is the difference between a bundle file and the stored policy set.
+type Drift struct {
+	// Path is the path of the bundle file, relative to the directory.
+	Path string `json:"path"`
+	// PolicySet is the name of the policy set.
+	PolicySet string `json:"policy_set"`
+	// Diff is what the sync changes, or would change in a dry run.
+	Diff policycraft.BundleDiff `json:"diff"`
+}
+
+// Report is the outcome of a sync.
+type Report struct {
+	// Drifts are the policy sets that differ from their bundle files.
+	Drifts []Drift `json:"drifts"`
+	// Unmanaged are the stored policy sets without a bundle file. They are never changed by the sync.
+	Unmanaged []string `json:"unmanaged"`
+	// Applied tells if the drifts were reconciled. It's false in a dry run.
+	Applied bool `json:"applied"`
+}
+
+// Syncer reconciles the stored policy sets with the bundle files of a directory.
+type Syncer struct {
+	dir      string
+	db       Storage
+	registry *policycraft.Registry
+}
+
+// NewSyncer returns a Syncer of the given directory. The policies are validated against the registry, if nil the policycraft.DefaultRegistry is used.
+func NewSyncer(dir string, db Storage, registry *policycraft.Registry) *Syncer {
+	if registry == nil {
+		registry = policycraft.DefaultRegistry
+	}
+	return &Syncer{dir: dir, db: db, registry: registry}
+}
+
+// Sync reads and validates every bundle file of the directory and imports the ones that differ from the stored policy sets.
+// If any bundle file is invalid, nothing is imported, so an invalid commit of the directory is never partially applied.
+// In a dry run, the drift is only reported. The policy sets belong to the context tenant (see policycraft.WithTenant).
+func (s *Syncer) Sync(ctx context.Context, dryRun bool) (Report, error) {
+	files, err := LoadDir(s.dir)
+	if err != nil {
+		return Report{}, err
+	}
+	var errs []error
+	// a policy belongs to a single policy set, so its id is in a single bundle file
+	paths := make(map[string]string)
+	for _, f := range files {
+		if err := f.Bundle.Check(ctx, s.registry); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Path, err))
+		}
+		for _, p := range f.Bundle.Policies {
+			if path, ok := paths[p.ID]; ok && path != f.Path {
+				errs = append(errs, fmt.Errorf("%s: policy %s is in %s too", f.Path, p.ID, path))
+			}
+			paths[p.ID] = f.Path
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Report{}, fmt.Errorf("invalid bundles, nothing was applied: %w", err)
+	}
+
+	report := Report{Drifts: []Drift{}, Unmanaged: []string{}}
+	managed := make(map[string]bool, len(files))
+	// pending are the indexes of the drifts to import, with their bundles
+	var pending []int
+	var bundles []policycraft.Bundle
+	for _, f := range files {
+		managed[f.Bundle.PolicySet.Name] = true
+		current, err := s.storedBundle(ctx, f.Bundle.PolicySet.Name)
+		if err != nil {
+			return Report{}, err
+		}
+		if diff := policycraft.DiffBundles(current, f.Bundle); !diff.Empty() {
+			pending = append(pending, len(report.Drifts))
+			report.Drifts = append(report.Drifts, Drift{Path: f.Path, PolicySet: f.Bundle.PolicySet.Name, Diff: diff})
+			bundles = append(bundles, f.Bundle)
+		}
+	}
+
+	sets, err := s.db.PolicySets(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("getting policy sets: %v", err)
+	}
+	for _, set := range sets {
+		if !managed[set.Name] {
+			report.Unmanaged = append(report.Unmanaged, set.Name)
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	// Each bundle is imported in its own transaction. A failure stops the sync, and it's retried by the next one.
+	// A bundle with a policy of another policy set is imported after the others, as the bundle of that policy set may remove it.
+	for len(pending) > 0 {
+		var deferred []int
+		var deferredErr error
+		for _, i := range pending {
+			ctx := policycraft.WithChangeInfo(ctx, policycraft.ChangeInfo{Author: "gitops", Reason: "synced from " + report.Drifts[i].Path})
+			err := s.db.ImportBundle(ctx, bundles[i])
+			if errors.Is(err, policycraft.ErrPolicyInOtherSet) {
+				deferred = append(deferred, i)
+				deferredErr = fmt.Errorf("%s: importing bundle: %w", report.Drifts[i].Path, err)
+				continue
+			}
+			if err != nil {
+				return report, fmt.Errorf("%s: importing bundle: %v", report.Drifts[i].Path, err)
+			}
+		}
+		if len(deferred) == len(pending) {
+			return report, deferredErr
+		}
+		pending = deferred
+	}
+	report.Applied = true
+	return report, nil
+}
+
+// Run syncs the directory every interval until the context is done. The drift and the failures are logged.
+// A failed sync keeps the stored policy sets as they are and is retried in the next interval.
+func (s *Syncer) Run(ctx context.Context, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.syncAndLog(ctx, dryRun)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Syncer) syncAndLog(ctx context.Context, dryRun bool) {
+	report, err := s.Sync(ctx, dryRun)
+	if err != nil {
+		slog.Error("failed to sync policy sets", "dir", s.dir, "error", err)
+		return
+	}
+	for _, drift := range report.Drifts {
+		slog.Warn("policy set drift",
+			"policy_set", drift.PolicySet, "path", drift.Path, "applied", report.Applied,
+			"policy_set_changed", drift.Diff.PolicySetChanged, "added", drift.Diff.Added, "updated", drift.Diff.Updated, "removed", drift.Diff.Removed)
+	}
+	if len(report.Unmanaged) > 0 {
+		slog.Debug("policy sets without bundle files", "policy_sets", report.Unmanaged)
+	}
+}
+
+// storedBundle returns the bundle of the stored policy set, empty if it doesn't exist.
+func (s *Syncer) storedBundle(ctx context.Context, name string) (policycraft.Bundle, error) {
+	set, err := s.db.PolicySet(ctx, name)
+	if errors.Is(err, policycraft.ErrNotFound) {
+		set = policycraft.PolicySet{Name: name}
+	} else if err != nil {
+		return policycraft.Bundle{}, fmt.Errorf("getting policy set %s: %v", name, err)
+	}
+	policies, err := s.db.PoliciesBySet(ctx, name)
+	if err != nil {
+		return policycraft.Bundle{}, fmt.Errorf("getting policies of %s: %v", name, err)
+	}
+	return policycraft.NewBundle(set, policies), nil
+}
+
+// LoadDir reads the bundle files (.yaml, .yml and .json) of the directory and its subdirectories, ordered by path.
+// Hidden files and directories, like .git, are skipped. Two files with the same policy set are an error.
+func LoadDir(dir string) ([]BundleFile, error) {
+	var files []BundleFile
+	var errs []error
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		bundle, err := policycraft.ParseBundle(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", rel, err))
+			return nil
+		}
+		files = append(files, BundleFile{Path: rel, Bundle: bundle})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", dir, err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	paths := make(map[string]string)
+	for _, f := range files {
+		if other, ok := paths[f.Bundle.PolicySet.Name]; ok {
+			errs = append(errs, fmt.Errorf("%s: policy set %s is already declared in %s", f.Path, f.Bundle.PolicySet.Name, other))
+		}
+		paths[f.Bundle.PolicySet.Name] = f.Path
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid bundles, nothing was applied: %w", err)
+	}
+	return files, nil
+}
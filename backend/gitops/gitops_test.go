@@ -0,0 +1,209 @@
+package gitops
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/perebaj/policycraft"
+)
+
+// fakeStorage keeps the policy sets and policies in memory.
+type fakeStorage struct {
+	sets     map[string]policycraft.PolicySet
+	policies map[string][]policycraft.Policy
+	imports  int
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{sets: make(map[string]policycraft.PolicySet), policies: make(map[string][]policycraft.Policy)}
+}
+
+func (f *fakeStorage) PolicySet(_ context.Context, name string) (policycraft.PolicySet, error) {
+	set, ok := f.sets[name]
+	if !ok {
+		return policycraft.PolicySet{}, policycraft.ErrNotFound
+	}
+	return set, nil
+}
+
+func (f *fakeStorage) PolicySets(_ context.Context) ([]policycraft.PolicySet, error) {
+	var sets []policycraft.PolicySet
+	for _, set := range f.sets {
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+func (f *fakeStorage) PoliciesBySet(_ context.Context, name string) ([]policycraft.Policy, error) {
+	return f.policies[name], nil
+}
+
+func (f *fakeStorage) ImportBundle(_ context.Context, bundle policycraft.Bundle) error {
+	for name, policies := range f.policies {
+		for _, stored := range policies {
+			for _, p := range bundle.Policies {
+				if name != bundle.PolicySet.Name && p.ID == stored.ID {
+					return policycraft.ErrPolicyInOtherSet
+				}
+			}
+		}
+	}
+	f.imports++
+	f.sets[bundle.PolicySet.Name] = bundle.PolicySet
+	f.policies[bundle.PolicySet.Name] = bundle.Policies
+	return nil
+}
+
+const creditBundle = `
+version: policycraft/v1
+policy_set:
+  name: credit
+  tests:
+    - {name: minor, input: {age: 16}, decision: false}
+policies:
+  - {id: a43cafc3-87ad-4e13-9e42-fbd7113b7e82, name: age, criteria: ">", value: 17, success_case: true, priority: 1}
+`
+
+const debitBundle = `{
+  "version": "policycraft/v1",
+  "policy_set": {"name": "debit"},
+  "policies": [{"id": "0b5d8a5e-5d2c-4b8e-9d8b-1f3f3f3f3f3f", "name": "age", "criteria": ">", "value": 15, "success_case": true, "priority": 1}]
+}`
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSyncerSync(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"credit.yaml":       creditBundle,
+		"cards/debit.json":  debitBundle,
+		"README.md":         "not a bundle",
+		".git/config.yaml":  "not a bundle either",
+		".hidden-file.json": "{",
+	})
+	db := newFakeStorage()
+	db.sets["legacy"] = policycraft.PolicySet{Name: "legacy"}
+	syncer := NewSyncer(dir, db, nil)
+
+	// the dry run only reports the drift
+	report, err := syncer.Sync(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(report.Drifts) != 2 || report.Applied || db.imports != 0 {
+		t.Fatalf("Expecting 2 drifts not applied, got %+v after %d imports", report, db.imports)
+	}
+	if report.Drifts[0].Path != filepath.Join("cards", "debit.json") || report.Drifts[1].Path != "credit.yaml" {
+		t.Errorf("Expecting the drifts ordered by path, got %+v", report.Drifts)
+	}
+	if len(report.Unmanaged) != 1 || report.Unmanaged[0] != "legacy" {
+		t.Errorf("Expecting the legacy policy set to be unmanaged, got %v", report.Unmanaged)
+	}
+
+	report, err = syncer.Sync(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if !report.Applied || db.imports != 2 || len(db.policies["credit"]) != 1 {
+		t.Fatalf("Expecting the drifts to be applied, got %+v after %d imports", report, db.imports)
+	}
+	if _, ok := db.sets["legacy"]; !ok {
+		t.Errorf("Expecting the unmanaged policy set to be kept")
+	}
+
+	// a policy changed outside of the directory is drift, and it's reverted
+	db.policies["credit"][0].Value = 30
+	report, err = syncer.Sync(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(report.Drifts) != 1 || report.Drifts[0].PolicySet != "credit" || db.policies["credit"][0].Value != 17 {
+		t.Fatalf("Expecting the credit drift to be reverted, got %+v", report)
+	}
+
+	// nothing to do when the storage matches the directory
+	report, err = syncer.Sync(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(report.Drifts) != 0 || db.imports != 3 {
+		t.Fatalf("Expecting no drift, got %+v after %d imports", report, db.imports)
+	}
+}
+
+func TestSyncerSyncRefusesInvalidBundles(t *testing.T) {
+	tests := map[string]map[string]string{
+		"unparseable bundle": {
+			"credit.yaml": creditBundle,
+			"debit.json":  debitBundle[:20],
+		},
+		"failing test": {
+			"credit.yaml": strings.Replace(creditBundle, "decision: false", "decision: true", 1),
+			"debit.json":  debitBundle,
+		},
+		"duplicated policy set": {
+			"credit.yaml":  creditBundle,
+			"credit2.yaml": creditBundle,
+		},
+		"duplicated policy": {
+			"credit.yaml": creditBundle,
+			"debit.yaml":  strings.Replace(creditBundle, "name: credit", "name: debit", 1),
+		},
+	}
+
+	for name, files := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := newFakeStorage()
+			_, err := NewSyncer(writeFiles(t, files), db, nil).Sync(context.Background(), false)
+			if err == nil {
+				t.Fatalf("Expecting an error syncing invalid bundles")
+			}
+			if db.imports != 0 {
+				t.Fatalf("Expecting nothing to be applied, got %d imports", db.imports)
+			}
+		})
+	}
+}
+
+func TestSyncerSyncMovesPolicies(t *testing.T) {
+	db := newFakeStorage()
+	if _, err := NewSyncer(writeFiles(t, map[string]string{"credit.yaml": creditBundle, "cards/debit.json": debitBundle}), db, nil).Sync(context.Background(), false); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// the age policy moves from credit to debit in a single commit: debit is imported after credit removes it
+	moved := map[string]string{
+		"credit.yaml":      strings.Replace(creditBundle, "a43cafc3-87ad-4e13-9e42-fbd7113b7e82", "9c1b7f3e-2a4d-4f5e-8b6a-7d8e9f0a1b2c", 1),
+		"cards/debit.json": strings.Replace(debitBundle, "0b5d8a5e-5d2c-4b8e-9d8b-1f3f3f3f3f3f", "a43cafc3-87ad-4e13-9e42-fbd7113b7e82", 1),
+	}
+	report, err := NewSyncer(writeFiles(t, moved), db, nil).Sync(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if !report.Applied || db.policies["debit"][0].ID != "a43cafc3-87ad-4e13-9e42-fbd7113b7e82" {
+		t.Fatalf("Expecting the policy to be moved, got %+v", db.policies)
+	}
+
+	// a policy of a policy set without a bundle file is never taken
+	db.policies["legacy"] = []policycraft.Policy{{ID: "5e6f7a8b-9c0d-4e1f-a2b3-c4d5e6f7a8b9", PolicySet: "legacy"}}
+	taken := map[string]string{"cards/debit.json": strings.Replace(debitBundle, "0b5d8a5e-5d2c-4b8e-9d8b-1f3f3f3f3f3f", "5e6f7a8b-9c0d-4e1f-a2b3-c4d5e6f7a8b9", 1)}
+	if _, err := NewSyncer(writeFiles(t, taken), db, nil).Sync(context.Background(), false); !errors.Is(err, policycraft.ErrPolicyInOtherSet) {
+		t.Fatalf("Sync() error = %v, want ErrPolicyInOtherSet", err)
+	}
+}
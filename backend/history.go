@@ -0,0 +1,176 @@
+// Package policycraft ...
+// history.go gather the history of the policies, a revision for every change with who made it, why and the changed fields.
+package policycraft
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// PolicyAction is the kind of change recorded by a policy revision.
+type PolicyAction string
+
+// The actions of a policy revision.
+const (
+	// PolicyCreated is the first save of a policy.
+	PolicyCreated PolicyAction = "created"
+	// PolicyUpdated is a save that changed at least one field of an existing policy.
+	PolicyUpdated PolicyAction = "updated"
+	// PolicyDeleted is the removal of a policy, e.g. by the import of a bundle without it.
+	PolicyDeleted PolicyAction = "deleted"
+)
+
+// PolicyRevision is a change of a policy. Every storage records the revisions in the same transaction of the change, so the history
+// can't miss a change. A save that doesn't change any field isn't recorded.
+type PolicyRevision struct {
+	// PolicyID is the policy changed.
+	PolicyID string `json:"policy_id"`
+	// Revision numbers the changes of the policy, starting at 1.
+	Revision int `json:"revision"`
+	// Action is the kind of change.
+	Action PolicyAction `json:"action"`
+	// Author is who made the change, see ChangeInfo.
+	Author string `json:"author"`
+	// Reason is why the change was made, see ChangeInfo.
+	Reason string `json:"reason,omitempty"`
+	// Changes are the fields changed, with their values before and after the change.
+	Changes []FieldChange `json:"changes"`
+	// Policy is the policy after the change. For a deleted policy, it's the policy before the removal.
+	Policy Policy `json:"policy"`
+	// CreatedAt is the time of the change.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FieldChange is the change of a field of a policy. Before is absent when the policy is created, and After when it's deleted.
+type FieldChange struct {
+	// Field is the JSON name of the field.
+	Field string `json:"field"`
+	// Before is the value of the field before the change.
+	Before interface{} `json:"before,omitempty"`
+	// After is the value of the field after the change.
+	After interface{} `json:"after,omitempty"`
+}
+
+// policyFields are the fields compared by DiffPolicies, in the order of the Policy struct. The id identifies the policy, so it never changes.
+var policyFields = []struct {
+	name  string
+	value func(Policy) interface{}
+}{
+	{"name", func(p Policy) interface{} { return p.Name }},
+	{"criteria", func(p Policy) interface{} { return p.Criteria }},
+	{"function", func(p Policy) interface{} { return p.Function }},
+	{"value", func(p Policy) interface{} { return p.Value }},
+	{"success_case", func(p Policy) interface{} { return p.SuccessCase }},
+	{"priority", func(p Policy) interface{} { return p.Priority }},
+	{"type", func(p Policy) interface{} { return p.Type }},
+	{"script", func(p Policy) interface{} { return p.Script }},
+	{"policy_set", func(p Policy) interface{} { return p.PolicySet }},
+	// the tags are never null, so the change keeps its value after the JSON round trip of the storages
+	{"tags", func(p Policy) interface{} { return append([]string{}, p.Tags...) }},
+}
+
+// DiffPolicies returns the fields that differ between two versions of a policy. A nil version is a policy that doesn't exist,
+// so every field of the other one is returned.
+func DiffPolicies(before, after *Policy) []FieldChange {
+	var changes []FieldChange
+	for _, f := range policyFields {
+		var change FieldChange
+		if before != nil {
+			change.Before = f.value(*before)
+		}
+		if after != nil {
+			change.After = f.value(*after)
+		}
+		if before != nil && after != nil && reflect.DeepEqual(change.Before, change.After) {
+			continue
+		}
+		change.Field = f.name
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// NewPolicyRevision returns the revision of the change of a policy from before to after, made at the given time by the ChangeInfo of
+// the context. A nil before is a created policy and a nil after a deleted one. It returns false if nothing changed.
+// The revision number is left for the storage, which knows the last one.
+func NewPolicyRevision(ctx context.Context, before, after *Policy, at time.Time) (PolicyRevision, bool) {
+	if before == nil && after == nil {
+		return PolicyRevision{}, false
+	}
+	changes := DiffPolicies(before, after)
+	if len(changes) == 0 {
+		return PolicyRevision{}, false
+	}
+
+	info := ChangeInfoFromContext(ctx)
+	rev := PolicyRevision{Author: info.Author, Reason: info.Reason, Changes: changes, CreatedAt: at}
+	switch {
+	case before == nil:
+		rev.Action = PolicyCreated
+		rev.Policy = *after
+	case after == nil:
+		rev.Action = PolicyDeleted
+		rev.Policy = *before
+	default:
+		rev.Action = PolicyUpdated
+		rev.Policy = *after
+	}
+	rev.PolicyID = rev.Policy.ID
+	return rev, true
+}
+
+// ChangeInfo is who changes the policies and why, recorded in their history.
+type ChangeInfo struct {
+	// Author is who makes the change. It defaults to the subject of the principal of the context.
+	Author string
+	// Reason is why the change is made. It's optional.
+	Reason string
+	// BaseRevision is the last revision of the policy the change was based on, 0 for a policy without history. When set, the
+	// storages refuse to change a policy with another last revision, so a concurrent change isn't overwritten.
+	BaseRevision *int
+	// BaseRevisions is the base revision of each policy of a change of several policies, like a change request, by policy id.
+	// It's checked like BaseRevision for the policies it has.
+	BaseRevisions map[string]int
+}
+
+// HasBaseRevisions reports whether the change has a BaseRevision or BaseRevisions to check.
+func (c ChangeInfo) HasBaseRevisions() bool {
+	return c.BaseRevision != nil || len(c.BaseRevisions) > 0
+}
+
+// CheckBaseRevision returns ErrConflict if the change has a base revision for the policy and its last revision is another.
+// The storages call it inside the transaction of the change, before writing.
+func (c ChangeInfo) CheckBaseRevision(id string, last int) error {
+	base, ok := c.BaseRevisions[id]
+	if !ok {
+		if c.BaseRevision == nil {
+			return nil
+		}
+		base = *c.BaseRevision
+	}
+	if last == base {
+		return nil
+	}
+	return fmt.Errorf("%w: the policy %s is at revision %d, the change is based on revision %d", ErrConflict, id, last, base)
+}
+
+type changeInfoKey struct{}
+
+// WithChangeInfo returns a copy of the context that carries the ChangeInfo. Like the tenant, it travels in the context so every
+// storage can record it in the history of the policies it saves, whatever the path of the change.
+func WithChangeInfo(ctx context.Context, info ChangeInfo) context.Context {
+	return context.WithValue(ctx, changeInfoKey{}, info)
+}
+
+// ChangeInfoFromContext returns the ChangeInfo carried by the context. Without an author, the subject of the principal is used.
+func ChangeInfoFromContext(ctx context.Context) ChangeInfo {
+	info, _ := ctx.Value(changeInfoKey{}).(ChangeInfo)
+	if info.Author == "" {
+		if p, ok := PrincipalFromContext(ctx); ok {
+			info.Author = p.Subject
+		}
+	}
+	return info
+}
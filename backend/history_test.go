@@ -0,0 +1,74 @@
+package policycraft
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewPolicyRevision(t *testing.T) {
+	before := Policy{ID: "a43cafc3-87ad-4e13-9e42-fbd7113b7e82", Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: PolicyTypeCriteria, PolicySet: "loans"}
+	after := before
+	after.Value, after.SuccessCase = 18, false
+	ctx := WithPrincipal(context.Background(), Principal{Subject: "api-key:1"})
+	ctx = WithChangeInfo(ctx, ChangeInfo{Reason: "legal age"})
+	now := time.Now()
+
+	rev, ok := NewPolicyRevision(ctx, &before, &after, now)
+	if !ok {
+		t.Fatalf("expected a revision")
+	}
+	want := PolicyRevision{
+		PolicyID: before.ID,
+		Action:   PolicyUpdated,
+		// the author defaults to the principal
+		Author: "api-key:1",
+		Reason: "legal age",
+		Changes: []FieldChange{
+			{Field: "value", Before: 17, After: 18},
+			{Field: "success_case", Before: true, After: false},
+		},
+		Policy:    after,
+		CreatedAt: now,
+	}
+	if !reflect.DeepEqual(rev, want) {
+		t.Fatalf("expected revision %+v, got %+v", want, rev)
+	}
+
+	if _, ok := NewPolicyRevision(ctx, &before, &before, now); ok {
+		t.Errorf("expected no revision without changes")
+	}
+	rev, ok = NewPolicyRevision(ctx, &before, nil, now)
+	if !ok || rev.Action != PolicyDeleted || !reflect.DeepEqual(rev.Policy, before) || len(rev.Changes) != len(policyFields) {
+		t.Errorf("unexpected deleted revision %+v", rev)
+	}
+	rev, ok = NewPolicyRevision(WithChangeInfo(ctx, ChangeInfo{Author: "gitops"}), nil, &after, now)
+	if !ok || rev.Action != PolicyCreated || rev.Author != "gitops" || rev.Changes[0] != (FieldChange{Field: "name", After: "age"}) {
+		t.Errorf("unexpected created revision %+v", rev)
+	}
+}
+
+func TestCheckBaseRevision(t *testing.T) {
+	if err := (ChangeInfo{}).CheckBaseRevision("a", 3); err != nil {
+		t.Errorf("expected no error without base revision, got %v", err)
+	}
+	base := 3
+	if err := (ChangeInfo{BaseRevision: &base}).CheckBaseRevision("a", 3); err != nil {
+		t.Errorf("expected no error at the base revision, got %v", err)
+	}
+	if err := (ChangeInfo{BaseRevision: &base}).CheckBaseRevision("a", 4); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict after the base revision, got %v", err)
+	}
+	bases := ChangeInfo{BaseRevisions: map[string]int{"a": 2}}
+	if err := bases.CheckBaseRevision("a", 2); err != nil {
+		t.Errorf("expected no error at the base revision of the policy, got %v", err)
+	}
+	if err := bases.CheckBaseRevision("a", 3); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict after the base revision of the policy, got %v", err)
+	}
+	if err := bases.CheckBaseRevision("b", 7); err != nil {
+		t.Errorf("expected no error for a policy without base revision, got %v", err)
+	}
+}
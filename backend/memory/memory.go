@@ -0,0 +1,715 @@
+// Package memory provides a storage that keeps everything in memory. It's meant for tests and for trying the service out,
+// since the data is lost when the process stops.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+)
+
+// Storage keeps the policies, policy sets and decisions in memory, apart by tenant. It's safe for concurrent use.
+// The values are copied through their JSON representation, as a database would do, so the caller can't change the stored values.
+type Storage struct {
+	mu      sync.RWMutex
+	tenants map[string]*tenant
+	// apiKeys maps the hashes to the API keys of every tenant, because a key is looked up before its tenant is known.
+	apiKeys map[string]policycraft.APIKey
+}
+
+// tenant holds the entities of a tenant.
+type tenant struct {
+	policies map[string]policycraft.Policy
+	// deleted keeps the deleted policies by id, so they can be restored.
+	deleted   map[string]policycraft.Policy
+	sets      map[string][]byte
+	decisions map[string][]byte
+	// changeRequests maps the ids to the change requests.
+	changeRequests map[string][]byte
+	// history maps the policy ids to their revisions, the oldest first.
+	history map[string][][]byte
+}
+
+// NewStorage returns an empty Storage.
+func NewStorage() *Storage {
+	return &Storage{tenants: make(map[string]*tenant), apiKeys: make(map[string]policycraft.APIKey)}
+}
+
+// tenant returns the entities of the context tenant. When create is false and the tenant has nothing yet, an empty tenant
+// that must not be changed is returned, so the readers don't need the write lock.
+func (s *Storage) tenant(ctx context.Context, create bool) *tenant {
+	id := policycraft.Tenant(ctx)
+	t, ok := s.tenants[id]
+	if ok {
+		return t
+	}
+	t = &tenant{
+		policies:       make(map[string]policycraft.Policy),
+		deleted:        make(map[string]policycraft.Policy),
+		sets:           make(map[string][]byte),
+		decisions:      make(map[string][]byte),
+		changeRequests: make(map[string][]byte),
+		history:        make(map[string][][]byte),
+	}
+	if create {
+		s.tenants[id] = t
+	}
+	return t
+}
+
+// SavePolicy save a policy. If the policy already exists, every field is replaced, and if it was deleted, it's created again.
+// The change is recorded in the history of the policy.
+func (s *Storage) SavePolicy(ctx context.Context, policy policycraft.Policy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tenant(ctx, true)
+	return t.recordHistory(ctx, []string{policy.ID}, func() error {
+		return t.savePolicy(policy)
+	})
+}
+
+// savePolicy is shared by SavePolicy, UpdatePolicy, ImportBundle and PublishChangeRequest.
+func (t *tenant) savePolicy(policy policycraft.Policy) error {
+	if _, err := uuid.Parse(policy.ID); err != nil {
+		return fmt.Errorf("invalid policy id %q: %v", policy.ID, err)
+	}
+	if policy.Type == "" {
+		policy.Type = policycraft.PolicyTypeCriteria
+	}
+	delete(t.deleted, policy.ID)
+	t.policies[policy.ID] = policy
+	return nil
+}
+
+// Policy returns the policy with the given id. If it doesn't exist or was deleted, policycraft.ErrNotFound is returned.
+func (s *Storage) Policy(ctx context.Context, id string) (policycraft.Policy, error) {
+	return s.policy(ctx, id, false)
+}
+
+// DeletedPolicy returns the deleted policy with the given id. If it doesn't exist or wasn't deleted, policycraft.ErrNotFound is returned.
+func (s *Storage) DeletedPolicy(ctx context.Context, id string) (policycraft.Policy, error) {
+	return s.policy(ctx, id, true)
+}
+
+func (s *Storage) policy(ctx context.Context, id string, deleted bool) (policycraft.Policy, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.Policy{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t := s.tenant(ctx, false)
+	policies := t.policies
+	if deleted {
+		policies = t.deleted
+	}
+	p, ok := policies[id]
+	if !ok {
+		return policycraft.Policy{}, policycraft.ErrNotFound
+	}
+	return p, nil
+}
+
+// UpdatePolicy replaces every field of the existing policy. If it doesn't exist or was deleted, policycraft.ErrNotFound is returned.
+// The change is recorded in the history of the policy.
+func (s *Storage) UpdatePolicy(ctx context.Context, policy policycraft.Policy) error {
+	return s.changePolicy(ctx, policy.ID, func(t *tenant) error {
+		if _, ok := t.policies[policy.ID]; !ok {
+			return policycraft.ErrNotFound
+		}
+		return t.savePolicy(policy)
+	})
+}
+
+// DeletePolicy deletes the policy with the given id. The policy is kept, so it can be restored by RestorePolicy.
+// If it doesn't exist or was already deleted, policycraft.ErrNotFound is returned. The change is recorded in the history of the policy.
+func (s *Storage) DeletePolicy(ctx context.Context, id string) error {
+	return s.changePolicy(ctx, id, func(t *tenant) error {
+		p, ok := t.policies[id]
+		if !ok {
+			return policycraft.ErrNotFound
+		}
+		delete(t.policies, id)
+		t.deleted[id] = p
+		return nil
+	})
+}
+
+// RestorePolicy restores the deleted policy with the given id. If it doesn't exist or wasn't deleted, policycraft.ErrNotFound is returned.
+// The change is recorded in the history of the policy.
+func (s *Storage) RestorePolicy(ctx context.Context, id string) error {
+	return s.changePolicy(ctx, id, func(t *tenant) error {
+		p, ok := t.deleted[id]
+		if !ok {
+			return policycraft.ErrNotFound
+		}
+		delete(t.deleted, id)
+		t.policies[id] = p
+		return nil
+	})
+}
+
+// changePolicy runs the change of a single policy, recording it in the history of the policy.
+func (s *Storage) changePolicy(ctx context.Context, id string, change func(t *tenant) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tenant(ctx, true)
+	return t.recordHistory(ctx, []string{id}, func() error {
+		return change(t)
+	})
+}
+
+// Policies returns all the policies. The order follows policycraft.SortPolicies.
+func (s *Storage) Policies(ctx context.Context) ([]policycraft.Policy, error) {
+	return s.filterPolicies(ctx, func(policycraft.Policy) bool { return true })
+}
+
+// PoliciesBySet returns the policies of the given policy set. The order follows policycraft.SortPolicies.
+func (s *Storage) PoliciesBySet(ctx context.Context, name string) ([]policycraft.Policy, error) {
+	return s.filterPolicies(ctx, func(p policycraft.Policy) bool { return p.PolicySet == name })
+}
+
+func (s *Storage) filterPolicies(ctx context.Context, match func(policycraft.Policy) bool) ([]policycraft.Policy, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var policies []policycraft.Policy
+	for _, p := range s.tenant(ctx, false).policies {
+		if match(p) {
+			policies = append(policies, p)
+		}
+	}
+	if policies == nil {
+		return nil, nil
+	}
+	return policycraft.SortPolicies(policies), nil
+}
+
+// ListPolicies returns the page of the policies given by the filter. The time of the last change of a policy is the one of its
+// last revision.
+func (s *Storage) ListPolicies(ctx context.Context, filter policycraft.PolicyFilter) (policycraft.PolicyPage, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.PolicyPage{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t := s.tenant(ctx, false)
+	listed := make([]policycraft.ListedPolicy, 0, len(t.policies))
+	for id, p := range t.policies {
+		l := policycraft.ListedPolicy{Policy: p}
+		if revs := t.history[id]; len(revs) > 0 {
+			var rev policycraft.PolicyRevision
+			if err := json.Unmarshal(revs[len(revs)-1], &rev); err != nil {
+				return policycraft.PolicyPage{}, err
+			}
+			l.UpdatedAt = rev.CreatedAt
+		}
+		listed = append(listed, l)
+	}
+	return policycraft.PagePolicies(listed, filter)
+}
+
+// SavePolicySet save a policy set. If the policy set already exists, it will be updated.
+func (s *Storage) SavePolicySet(ctx context.Context, set policycraft.PolicySet) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tenant(ctx, true).savePolicySet(set)
+}
+
+func (t *tenant) savePolicySet(set policycraft.PolicySet) error {
+	if set.FailMode == "" {
+		set.FailMode = policycraft.FailClosed
+	}
+	// The postgres storage returns nil for empty collections, so it's done here too.
+	if len(set.Enrichments) == 0 {
+		set.Enrichments = nil
+	}
+	if len(set.Tests) == 0 {
+		set.Tests = nil
+	}
+	v, err := json.Marshal(set)
+	if err != nil {
+		return fmt.Errorf("marshaling policy set: %v", err)
+	}
+	t.sets[set.Name] = v
+	return nil
+}
+
+// PolicySet returns the policy set with the given name. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) PolicySet(ctx context.Context, name string) (policycraft.PolicySet, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.PolicySet{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.tenant(ctx, false).sets[name]
+	if !ok {
+		return policycraft.PolicySet{}, policycraft.ErrNotFound
+	}
+	var set policycraft.PolicySet
+	err := json.Unmarshal(v, &set)
+	return set, err
+}
+
+// PolicySets returns all the policy sets ordered by name.
+func (s *Storage) PolicySets(ctx context.Context) ([]policycraft.PolicySet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sets []policycraft.PolicySet
+	for _, v := range s.tenant(ctx, false).sets {
+		var set policycraft.PolicySet
+		if err := json.Unmarshal(v, &set); err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].Name < sets[j].Name })
+	return sets, nil
+}
+
+// ImportBundle replaces the policy set and its policies by the bundle. The policies of the policy set that aren't in the bundle
+// are deleted, as DeletePolicy does. A bundle policy that belongs to another policy set fails with policycraft.ErrPolicyInOtherSet.
+// If anything fails, nothing is changed.
+func (s *Storage) ImportBundle(ctx context.Context, bundle policycraft.Bundle) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, p := range bundle.Policies {
+		if _, err := uuid.Parse(p.ID); err != nil {
+			return fmt.Errorf("saving policy %s: invalid policy id: %v", p.ID, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tenant(ctx, true)
+	var others []string
+	for _, p := range bundle.Policies {
+		if stored, ok := t.policies[p.ID]; ok && stored.PolicySet != bundle.PolicySet.Name {
+			others = append(others, p.ID)
+		}
+	}
+	if len(others) > 0 {
+		return fmt.Errorf("%w: %s", policycraft.ErrPolicyInOtherSet, strings.Join(others, ", "))
+	}
+	if err := t.savePolicySet(bundle.PolicySet); err != nil {
+		return err
+	}
+	// The history covers the policies removed from the policy set too.
+	var changed []string
+	for id, p := range t.policies {
+		if p.PolicySet == bundle.PolicySet.Name {
+			changed = append(changed, id)
+		}
+	}
+	for _, p := range bundle.Policies {
+		changed = append(changed, p.ID)
+	}
+	return t.recordHistory(ctx, changed, func() error {
+		// The policies of the policy set are deleted, so they can be restored, and the bundle policies are saved again.
+		for id, p := range t.policies {
+			if p.PolicySet == bundle.PolicySet.Name {
+				delete(t.policies, id)
+				t.deleted[id] = p
+			}
+		}
+		for _, p := range bundle.Policies {
+			// the ids were validated before any change
+			_ = t.savePolicy(p)
+		}
+		return nil
+	})
+}
+
+// SaveDecision save a decision.
+func (s *Storage) SaveDecision(ctx context.Context, decision policycraft.Decision) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(decision.ID); err != nil {
+		return fmt.Errorf("parsing decision id: %v", err)
+	}
+	v, err := json.Marshal(decision)
+	if err != nil {
+		return fmt.Errorf("marshaling decision: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tenant(ctx, true)
+	if _, ok := t.decisions[decision.ID]; ok {
+		return fmt.Errorf("decision %s already exists", decision.ID)
+	}
+	t.decisions[decision.ID] = v
+	return nil
+}
+
+// Decision returns the decision with the given id. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) Decision(ctx context.Context, id string) (policycraft.Decision, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.Decision{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.tenant(ctx, false).decisions[id]
+	if !ok {
+		return policycraft.Decision{}, policycraft.ErrNotFound
+	}
+	var d policycraft.Decision
+	err := json.Unmarshal(v, &d)
+	return d, err
+}
+
+// Decisions returns the decisions that match the filter, the most recent first.
+func (s *Storage) Decisions(ctx context.Context, filter policycraft.DecisionFilter) ([]policycraft.Decision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var decisions []policycraft.Decision
+	for _, v := range s.tenant(ctx, false).decisions {
+		var d policycraft.Decision
+		if err := json.Unmarshal(v, &d); err != nil {
+			return nil, err
+		}
+		if matchDecision(d, filter) {
+			decisions = append(decisions, d)
+		}
+	}
+	sort.Slice(decisions, func(i, j int) bool { return decisions[i].StartedAt.After(decisions[j].StartedAt) })
+	if filter.Limit > 0 && len(decisions) > filter.Limit {
+		decisions = decisions[:filter.Limit]
+	}
+	return decisions, nil
+}
+
+func matchDecision(d policycraft.Decision, filter policycraft.DecisionFilter) bool {
+	switch {
+	case !filter.From.IsZero() && d.StartedAt.Before(filter.From):
+		return false
+	case !filter.To.IsZero() && !d.StartedAt.Before(filter.To):
+		return false
+	case filter.Decision != nil && d.Decision != *filter.Decision:
+		return false
+	case filter.CallerRef != "" && d.CallerRef != filter.CallerRef:
+		return false
+	case filter.PolicySet != "" && d.PolicySet != filter.PolicySet:
+		return false
+	}
+	return true
+}
+
+// SaveAPIKey save an API key of the context tenant.
+func (s *Storage) SaveAPIKey(ctx context.Context, key policycraft.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(key.ID); err != nil {
+		return fmt.Errorf("parsing API key id: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.apiKeys[key.Hash]; ok {
+		return fmt.Errorf("API key hash already exists")
+	}
+	for _, k := range s.apiKeys {
+		if k.ID == key.ID {
+			return fmt.Errorf("API key %s already exists", key.ID)
+		}
+	}
+	key.Tenant = policycraft.Tenant(ctx)
+	key.Roles = append([]policycraft.Role(nil), key.Roles...)
+	s.apiKeys[key.Hash] = key
+	return nil
+}
+
+// APIKeys returns the API keys of the context tenant, the oldest first.
+func (s *Storage) APIKeys(ctx context.Context) ([]policycraft.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenant := policycraft.Tenant(ctx)
+	keys := []policycraft.APIKey{}
+	for _, k := range s.apiKeys {
+		if k.Tenant == tenant {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+// APIKeyByHash returns the API key with the given hash, of any tenant. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) APIKeyByHash(ctx context.Context, hash string) (policycraft.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.APIKey{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.apiKeys[hash]
+	if !ok {
+		return policycraft.APIKey{}, policycraft.ErrNotFound
+	}
+	return key, nil
+}
+
+// RevokeAPIKey revokes the API key of the context tenant with the given id. Revoking it again keeps the first revocation time.
+// If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tenant := policycraft.Tenant(ctx)
+	for hash, k := range s.apiKeys {
+		if k.ID == id && k.Tenant == tenant {
+			if k.RevokedAt == nil {
+				k.RevokedAt = &at
+				s.apiKeys[hash] = k
+			}
+			return nil
+		}
+	}
+	return policycraft.ErrNotFound
+}
+
+// SaveChangeRequest save a change request of the context tenant. A change request with version 0 is created, otherwise it's
+// updated only if the stored version is still the given one, and policycraft.ErrConflict is returned if it isn't.
+// The stored version is incremented.
+func (s *Storage) SaveChangeRequest(ctx context.Context, cr policycraft.ChangeRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tenant(ctx, true).saveChangeRequest(cr)
+}
+
+// saveChangeRequest is shared by SaveChangeRequest and PublishChangeRequest.
+func (t *tenant) saveChangeRequest(cr policycraft.ChangeRequest) error {
+	if _, err := uuid.Parse(cr.ID); err != nil {
+		return fmt.Errorf("parsing change request id: %v", err)
+	}
+	current, ok := t.changeRequests[cr.ID]
+	if cr.Version == 0 && ok {
+		return fmt.Errorf("%w: change request %s already exists", policycraft.ErrConflict, cr.ID)
+	}
+	if cr.Version != 0 {
+		var stored policycraft.ChangeRequest
+		if ok {
+			if err := json.Unmarshal(current, &stored); err != nil {
+				return err
+			}
+		}
+		if !ok || stored.Version != cr.Version {
+			return fmt.Errorf("%w: the change request was changed concurrently", policycraft.ErrConflict)
+		}
+	}
+
+	cr.Version++
+	// The postgres storage returns nil for empty collections, so it's done here too.
+	if len(cr.Policies) == 0 {
+		cr.Policies = nil
+	}
+	if len(cr.Comments) == 0 {
+		cr.Comments = nil
+	}
+	v, err := json.Marshal(cr)
+	if err != nil {
+		return fmt.Errorf("marshaling change request: %v", err)
+	}
+	t.changeRequests[cr.ID] = v
+	return nil
+}
+
+// ChangeRequest returns the change request with the given id. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) ChangeRequest(ctx context.Context, id string) (policycraft.ChangeRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return policycraft.ChangeRequest{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.tenant(ctx, false).changeRequests[id]
+	if !ok {
+		return policycraft.ChangeRequest{}, policycraft.ErrNotFound
+	}
+	var cr policycraft.ChangeRequest
+	err := json.Unmarshal(v, &cr)
+	return cr, err
+}
+
+// ChangeRequests returns the change requests that match the filter, the most recent first.
+func (s *Storage) ChangeRequests(ctx context.Context, filter policycraft.ChangeRequestFilter) ([]policycraft.ChangeRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	crs := []policycraft.ChangeRequest{}
+	for _, v := range s.tenant(ctx, false).changeRequests {
+		var cr policycraft.ChangeRequest
+		if err := json.Unmarshal(v, &cr); err != nil {
+			return nil, err
+		}
+		if filter.Status == "" || cr.Status == filter.Status {
+			crs = append(crs, cr)
+		}
+	}
+	sortChangeRequests(crs)
+	return crs, nil
+}
+
+// PublishChangeRequest saves the published change request and its policies at once, replacing every field of the existing policies.
+// As in SaveChangeRequest, policycraft.ErrConflict is returned if the change request was changed concurrently.
+func (s *Storage) PublishChangeRequest(ctx context.Context, cr policycraft.ChangeRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, p := range cr.Policies {
+		if _, err := uuid.Parse(p.ID); err != nil {
+			return fmt.Errorf("saving policy %s: invalid policy id: %v", p.ID, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tenant(ctx, true)
+	ids := make([]string, 0, len(cr.Policies))
+	for _, p := range cr.Policies {
+		ids = append(ids, p.ID)
+	}
+	// the change request is saved after the base revisions are checked, so nothing is changed on a conflict
+	return t.recordHistory(ctx, ids, func() error {
+		if err := t.saveChangeRequest(cr); err != nil {
+			return err
+		}
+		for _, p := range cr.Policies {
+			// the ids were validated before any change
+			_ = t.savePolicy(p)
+		}
+		return nil
+	})
+}
+
+// sortChangeRequests orders the change requests from the most recent, as the postgres storage does.
+func sortChangeRequests(crs []policycraft.ChangeRequest) {
+	sort.Slice(crs, func(i, j int) bool {
+		if !crs[i].CreatedAt.Equal(crs[j].CreatedAt) {
+			return crs[i].CreatedAt.After(crs[j].CreatedAt)
+		}
+		return crs[i].ID < crs[j].ID
+	})
+}
+
+// recordHistory runs write, which changes the policies with the given ids, and records a revision of each policy it changed.
+// The change is refused if a policy isn't at the base revision of the policycraft.ChangeInfo of the context.
+func (t *tenant) recordHistory(ctx context.Context, ids []string, write func() error) error {
+	info := policycraft.ChangeInfoFromContext(ctx)
+	before := make(map[string]policycraft.Policy, len(ids))
+	for _, id := range ids {
+		if p, ok := t.policies[id]; ok {
+			before[id] = p
+		}
+		if err := info.CheckBaseRevision(id, len(t.history[id])); err != nil {
+			return err
+		}
+	}
+	if err := write(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		var b, a *policycraft.Policy
+		if p, ok := before[id]; ok {
+			b = &p
+		}
+		if p, ok := t.policies[id]; ok {
+			a = &p
+		}
+		rev, ok := policycraft.NewPolicyRevision(ctx, b, a, now)
+		if !ok {
+			continue
+		}
+		rev.Revision = len(t.history[id]) + 1
+		v, err := json.Marshal(rev)
+		if err != nil {
+			return fmt.Errorf("marshaling revision: %v", err)
+		}
+		t.history[id] = append(t.history[id], v)
+	}
+	return nil
+}
+
+// LastPolicyRevision returns the number of the last revision of the policy with the given id, 0 if it has no history.
+func (s *Storage) LastPolicyRevision(ctx context.Context, id string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.tenant(ctx, false).history[id]), nil
+}
+
+// PolicyHistory returns the revisions of the policy with the given id, the oldest first.
+func (s *Storage) PolicyHistory(ctx context.Context, id string) ([]policycraft.PolicyRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var revs []policycraft.PolicyRevision
+	for _, v := range s.tenant(ctx, false).history[id] {
+		var rev policycraft.PolicyRevision
+		if err := json.Unmarshal(v, &rev); err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	return revs, nil
+}
@@ -0,0 +1,13 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/perebaj/policycraft/api"
+	"github.com/perebaj/policycraft/memory"
+	"github.com/perebaj/policycraft/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) api.Storage { return memory.NewStorage() })
+}
@@ -0,0 +1,206 @@
+// Package metrics exports the Prometheus metrics of the service: the HTTP requests by route, the gRPC requests by method, the executions by decision and policy set,
+// the evaluations of each policy, the latency of the storage queries, the state of the policy cache and the stats of the database
+// connection pool.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// namespace prefixes the names of the metrics of the service.
+const namespace = "policycraft"
+
+// Metrics has the collectors of the service, registered in its own registry.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests     *prometheus.HistogramVec
+	grpcRequests *prometheus.HistogramVec
+	executions   *prometheus.CounterVec
+	evaluations  *prometheus.CounterVec
+	queries      *prometheus.HistogramVec
+}
+
+// New returns the metrics of the service, with the metrics of the Go runtime and of the process.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of the HTTP requests by method, route and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "code"}),
+		grpcRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Duration of the gRPC requests by method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "executions_total",
+			Help:      "Executions of the policy sets by tenant, policy set, decision and whether the deadline decided it.",
+		}, []string{"tenant", "policy_set", "decision", "timed_out"}),
+		evaluations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "policy_evaluations_total",
+			Help:      "Evaluations of the policies by the executions, by tenant, policy set, policy and result.",
+		}, []string{"tenant", "policy_set", "policy_id", "result"}),
+		queries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_query_duration_seconds",
+			Help:      "Duration of the storage queries by operation and status.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation", "status"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.grpcRequests, m.executions, m.evaluations, m.queries,
+	)
+	return m
+}
+
+// Handler returns the handler of the metrics, in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// RegisterDB exports the stats of the connection pool of the database, from sql.DB.Stats, as the go_sql metrics with the db_name label.
+func (m *Metrics) RegisterDB(name string, db *sql.DB) error {
+	return m.registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterCache exports the state of the policy cache, read from stats on every scrape: the age of its snapshots, the cached
+// policies and policy sets, and the refreshes and their failures. A failed refresh keeps serving the previous snapshot, so the
+// age grows past the refresh interval.
+func (m *Metrics) RegisterCache(stats func() cache.Stats) error {
+	return m.registry.Register(&cacheCollector{stats: stats})
+}
+
+// cacheCollector collects the stats of the policy cache.
+type cacheCollector struct {
+	stats func() cache.Stats
+}
+
+var (
+	cacheAge = prometheus.NewDesc(prometheus.BuildFQName(namespace, "policy_cache", "age_seconds"),
+		"Time since the oldest snapshot of the policy cache was loaded, -1 while nothing was loaded.", nil, nil)
+	cachePolicies = prometheus.NewDesc(prometheus.BuildFQName(namespace, "policy_cache", "policies"),
+		"Policies in the policy cache.", nil, nil)
+	cachePolicySets = prometheus.NewDesc(prometheus.BuildFQName(namespace, "policy_cache", "policy_sets"),
+		"Policy sets in the policy cache.", nil, nil)
+	cacheRefreshes = prometheus.NewDesc(prometheus.BuildFQName(namespace, "policy_cache", "refreshes_total"),
+		"Refreshes of the policy cache.", nil, nil)
+	cacheFailures = prometheus.NewDesc(prometheus.BuildFQName(namespace, "policy_cache", "refresh_failures_total"),
+		"Failed refreshes of the policy cache.", nil, nil)
+)
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheAge
+	ch <- cachePolicies
+	ch <- cachePolicySets
+	ch <- cacheRefreshes
+	ch <- cacheFailures
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats()
+	ch <- prometheus.MustNewConstMetric(cacheAge, prometheus.GaugeValue, stats.AgeSeconds)
+	ch <- prometheus.MustNewConstMetric(cachePolicies, prometheus.GaugeValue, float64(stats.Policies))
+	ch <- prometheus.MustNewConstMetric(cachePolicySets, prometheus.GaugeValue, float64(stats.PolicySets))
+	ch <- prometheus.MustNewConstMetric(cacheRefreshes, prometheus.CounterValue, float64(stats.Refreshes))
+	ch <- prometheus.MustNewConstMetric(cacheFailures, prometheus.CounterValue, float64(stats.Failures))
+}
+
+// Middleware observes the duration of the requests of the route of the pattern, such as "GET /policies/{id}". The route, rather
+// than the path, labels the requests, so the policies don't have a metric each.
+func (m *Metrics) Middleware(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	method, route, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, route = "", pattern
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next(sw, r)
+		m.requests.WithLabelValues(method, route, strconv.Itoa(sw.status)).Observe(time.Since(start).Seconds())
+	}
+}
+
+// UnaryServerInterceptor observes the duration of the unary gRPC calls by their full method, such as
+// "/policycraft.v1.PolicyCraft/Execute", and their status code. Chained before the authentication, it observes the rejected calls too.
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.grpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+		return resp, err
+	}
+}
+
+// ObserveDecision counts the execution of the decision and the evaluations of the policies of its trace. It's the api.Observer of
+// the executions, through HTTP or gRPC.
+func (m *Metrics) ObserveDecision(ctx context.Context, d policycraft.Decision) {
+	tenant := policycraft.Tenant(ctx)
+	m.executions.WithLabelValues(tenant, d.PolicySet, strconv.FormatBool(d.Decision), strconv.FormatBool(d.TimedOut)).Inc()
+	for _, step := range d.Trace {
+		result := "failed"
+		if step.Passed {
+			result = "passed"
+		}
+		m.evaluations.WithLabelValues(tenant, d.PolicySet, step.PolicyID, result).Inc()
+	}
+}
+
+// observeQuery observes the duration of the storage operation since start. A policy, or anything else, not found isn't an error.
+func (m *Metrics) observeQuery(operation string, start time.Time, err error) {
+	status := "ok"
+	switch {
+	case err == nil:
+	case errors.Is(err, policycraft.ErrNotFound):
+		status = "not_found"
+	default:
+		status = "error"
+	}
+	m.queries.WithLabelValues(operation, status).Observe(time.Since(start).Seconds())
+}
+
+// statusResponseWriter keeps the status code of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusResponseWriter) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status, s.wroteHeader = code, true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusResponseWriter) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController reaches it.
+func (s *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
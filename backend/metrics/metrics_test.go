@@ -0,0 +1,192 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/cache"
+	"github.com/perebaj/policycraft/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+	handler := m.Middleware("GET /policies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /policies/{id}", handler)
+
+	for _, id := range []string{"a", "b", "missing"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/policies/"+id, nil))
+	}
+
+	if got := testutil.CollectAndCount(m.requests); got != 2 {
+		t.Errorf("got %d series, want one per route and code", got)
+	}
+	count := func(code string) uint64 {
+		h, err := m.requests.GetMetricWithLabelValues(http.MethodGet, "/policies/{id}", code)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return histogramCount(t, h)
+	}
+	if got := count("200"); got != 2 {
+		t.Errorf("got %d requests with 200, want 2", got)
+	}
+	if got := count("404"); got != 1 {
+		t.Errorf("got %d requests with 404, want 1", got)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	m := New()
+	interceptor := m.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/policycraft.v1.PolicyCraft/GetPolicy"}
+
+	for _, err := range []error{nil, nil, status.Error(codes.NotFound, "policy not found")} {
+		_, _ = interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+			return nil, err
+		})
+	}
+
+	count := func(code string) uint64 {
+		h, err := m.grpcRequests.GetMetricWithLabelValues(info.FullMethod, code)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return histogramCount(t, h)
+	}
+	if got := count("OK"); got != 2 {
+		t.Errorf("got %d calls with OK, want 2", got)
+	}
+	if got := count("NotFound"); got != 1 {
+		t.Errorf("got %d calls with NotFound, want 1", got)
+	}
+}
+
+func TestObserveDecision(t *testing.T) {
+	m := New()
+	ctx := policycraft.WithTenant(context.Background(), "acme")
+
+	m.ObserveDecision(ctx, policycraft.Decision{
+		ID:        uuid.NewString(),
+		PolicySet: "credit",
+		Decision:  false,
+		Trace: []policycraft.Step{
+			{PolicyID: "age", Passed: true},
+			{PolicyID: "income", Passed: false},
+		},
+	})
+	if got := testutil.ToFloat64(m.executions.WithLabelValues("acme", "credit", "false", "false")); got != 1 {
+		t.Errorf("got %v executions, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.evaluations.WithLabelValues("acme", "credit", "age", "passed")); got != 1 {
+		t.Errorf("got %v evaluations of age, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.evaluations.WithLabelValues("acme", "credit", "income", "failed")); got != 1 {
+		t.Errorf("got %v evaluations of income, want 1", got)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	m := New()
+	db := NewStorage(memory.NewStorage(), m)
+	ctx := policycraft.WithTenant(context.Background(), "acme")
+
+	decision := policycraft.Decision{ID: uuid.NewString(), PolicySet: "credit"}
+	if err := db.SaveDecision(ctx, decision); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the executions are observed by api.Execute, not when their decisions are saved
+	if got := testutil.CollectAndCount(m.executions); got != 0 {
+		t.Errorf("got %d series of executions, want none", got)
+	}
+
+	if _, err := db.Policy(ctx, "missing"); !errors.Is(err, policycraft.ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	if _, err := db.Decision(ctx, decision.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, labels := range [][]string{{"save_decision", "ok"}, {"policy", "not_found"}, {"decision", "ok"}} {
+		h, err := m.queries.GetMetricWithLabelValues(labels...)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := histogramCount(t, h); got != 1 {
+			t.Errorf("got %d queries %v, want 1", got, labels)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.Middleware("GET /policies", func(w http.ResponseWriter, r *http.Request) {})(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/policies", nil))
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{`policycraft_http_request_duration_seconds_count{code="200",method="GET",route="/policies"} 1`, "go_goroutines"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the metrics don't have %q", want)
+		}
+	}
+}
+
+func TestRegisterCache(t *testing.T) {
+	m := New()
+	db := memory.NewStorage()
+	// the cache only keeps the tenants with policies or policy sets
+	if err := db.SavePolicySet(context.Background(), policycraft.PolicySet{Name: "credit"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := cache.NewStorage(db)
+	if err := m.RegisterCache(c.Stats); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{"policycraft_policy_cache_refreshes_total 1", "policycraft_policy_cache_refresh_failures_total 0", "policycraft_policy_cache_age_seconds "} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the metrics don't have %q", want)
+		}
+	}
+	// the age is -1 only until the first load
+	if strings.Contains(body, "policycraft_policy_cache_age_seconds -1") {
+		t.Errorf("the metrics have the age of a cache never loaded")
+	}
+}
+
+// histogramCount returns the number of observations of the histogram.
+func histogramCount(t *testing.T, h prometheus.Observer) uint64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := h.(prometheus.Metric).Write(&metric); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
@@ -0,0 +1,157 @@
+// Package metrics ...
+// storage.go gather the storage that observes the latency of the queries.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+)
+
+// Storage observes the duration of every query of the wrapped storage, labeled by operation, such as policies_by_set.
+type Storage struct {
+	db      api.Storage
+	metrics *Metrics
+}
+
+// NewStorage returns a Storage that observes db.
+func NewStorage(db api.Storage, m *Metrics) *Storage {
+	return &Storage{db: db, metrics: m}
+}
+
+func (s *Storage) SavePolicy(ctx context.Context, policy policycraft.Policy) (err error) {
+	defer s.observe("save_policy", time.Now(), &err)
+	return s.db.SavePolicy(ctx, policy)
+}
+
+func (s *Storage) Policies(ctx context.Context) (_ []policycraft.Policy, err error) {
+	defer s.observe("policies", time.Now(), &err)
+	return s.db.Policies(ctx)
+}
+
+func (s *Storage) ListPolicies(ctx context.Context, filter policycraft.PolicyFilter) (_ policycraft.PolicyPage, err error) {
+	defer s.observe("list_policies", time.Now(), &err)
+	return s.db.ListPolicies(ctx, filter)
+}
+
+func (s *Storage) PoliciesBySet(ctx context.Context, name string) (_ []policycraft.Policy, err error) {
+	defer s.observe("policies_by_set", time.Now(), &err)
+	return s.db.PoliciesBySet(ctx, name)
+}
+
+func (s *Storage) SavePolicySet(ctx context.Context, set policycraft.PolicySet) (err error) {
+	defer s.observe("save_policy_set", time.Now(), &err)
+	return s.db.SavePolicySet(ctx, set)
+}
+
+func (s *Storage) PolicySet(ctx context.Context, name string) (_ policycraft.PolicySet, err error) {
+	defer s.observe("policy_set", time.Now(), &err)
+	return s.db.PolicySet(ctx, name)
+}
+
+func (s *Storage) PolicySets(ctx context.Context) (_ []policycraft.PolicySet, err error) {
+	defer s.observe("policy_sets", time.Now(), &err)
+	return s.db.PolicySets(ctx)
+}
+
+func (s *Storage) SaveDecision(ctx context.Context, decision policycraft.Decision) (err error) {
+	defer s.observe("save_decision", time.Now(), &err)
+	return s.db.SaveDecision(ctx, decision)
+}
+
+func (s *Storage) Decision(ctx context.Context, id string) (_ policycraft.Decision, err error) {
+	defer s.observe("decision", time.Now(), &err)
+	return s.db.Decision(ctx, id)
+}
+
+func (s *Storage) Decisions(ctx context.Context, filter policycraft.DecisionFilter) (_ []policycraft.Decision, err error) {
+	defer s.observe("decisions", time.Now(), &err)
+	return s.db.Decisions(ctx, filter)
+}
+
+func (s *Storage) ImportBundle(ctx context.Context, bundle policycraft.Bundle) (err error) {
+	defer s.observe("import_bundle", time.Now(), &err)
+	return s.db.ImportBundle(ctx, bundle)
+}
+
+func (s *Storage) SaveAPIKey(ctx context.Context, key policycraft.APIKey) (err error) {
+	defer s.observe("save_api_key", time.Now(), &err)
+	return s.db.SaveAPIKey(ctx, key)
+}
+
+func (s *Storage) APIKeys(ctx context.Context) (_ []policycraft.APIKey, err error) {
+	defer s.observe("api_keys", time.Now(), &err)
+	return s.db.APIKeys(ctx)
+}
+
+func (s *Storage) APIKeyByHash(ctx context.Context, hash string) (_ policycraft.APIKey, err error) {
+	defer s.observe("api_key_by_hash", time.Now(), &err)
+	return s.db.APIKeyByHash(ctx, hash)
+}
+
+func (s *Storage) RevokeAPIKey(ctx context.Context, id string, at time.Time) (err error) {
+	defer s.observe("revoke_api_key", time.Now(), &err)
+	return s.db.RevokeAPIKey(ctx, id, at)
+}
+
+func (s *Storage) SaveChangeRequest(ctx context.Context, cr policycraft.ChangeRequest) (err error) {
+	defer s.observe("save_change_request", time.Now(), &err)
+	return s.db.SaveChangeRequest(ctx, cr)
+}
+
+func (s *Storage) ChangeRequest(ctx context.Context, id string) (_ policycraft.ChangeRequest, err error) {
+	defer s.observe("change_request", time.Now(), &err)
+	return s.db.ChangeRequest(ctx, id)
+}
+
+func (s *Storage) ChangeRequests(ctx context.Context, filter policycraft.ChangeRequestFilter) (_ []policycraft.ChangeRequest, err error) {
+	defer s.observe("change_requests", time.Now(), &err)
+	return s.db.ChangeRequests(ctx, filter)
+}
+
+func (s *Storage) PublishChangeRequest(ctx context.Context, cr policycraft.ChangeRequest) (err error) {
+	defer s.observe("publish_change_request", time.Now(), &err)
+	return s.db.PublishChangeRequest(ctx, cr)
+}
+
+func (s *Storage) PolicyHistory(ctx context.Context, id string) (_ []policycraft.PolicyRevision, err error) {
+	defer s.observe("policy_history", time.Now(), &err)
+	return s.db.PolicyHistory(ctx, id)
+}
+
+func (s *Storage) LastPolicyRevision(ctx context.Context, id string) (_ int, err error) {
+	defer s.observe("last_policy_revision", time.Now(), &err)
+	return s.db.LastPolicyRevision(ctx, id)
+}
+
+func (s *Storage) Policy(ctx context.Context, id string) (_ policycraft.Policy, err error) {
+	defer s.observe("policy", time.Now(), &err)
+	return s.db.Policy(ctx, id)
+}
+
+func (s *Storage) UpdatePolicy(ctx context.Context, policy policycraft.Policy) (err error) {
+	defer s.observe("update_policy", time.Now(), &err)
+	return s.db.UpdatePolicy(ctx, policy)
+}
+
+func (s *Storage) DeletePolicy(ctx context.Context, id string) (err error) {
+	defer s.observe("delete_policy", time.Now(), &err)
+	return s.db.DeletePolicy(ctx, id)
+}
+
+func (s *Storage) DeletedPolicy(ctx context.Context, id string) (_ policycraft.Policy, err error) {
+	defer s.observe("deleted_policy", time.Now(), &err)
+	return s.db.DeletedPolicy(ctx, id)
+}
+
+func (s *Storage) RestorePolicy(ctx context.Context, id string) (err error) {
+	defer s.observe("restore_policy", time.Now(), &err)
+	return s.db.RestorePolicy(ctx, id)
+}
+
+// observe observes the duration of the operation since start, with the error it returned.
+func (s *Storage) observe(operation string, start time.Time, err *error) {
+	s.metrics.observeQuery(operation, start, *err)
+}
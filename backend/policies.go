@@ -2,18 +2,180 @@
 // policies.go gather the entiy policy and some operations related to it.
 package policycraft
 
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
 // Policy is the struct that represent the business entity policy. In other words, it is the struct that will be used as input of the service.
 type Policy struct {
 	// ID is the unique identifier of the policy.
 	ID string `json:"id" db:"id"`
 	// Name is the name of the policy.
 	Name string `json:"name" db:"name"`
-	// Criteria is the criteria that will be used to compare the value. It can be: >, <, >=, <=, ==.
+	// Criteria is the criteria that will be used to compare the value. It can be: >, <, >=, <=, == or the name of an operator in the Registry.
 	Criteria string `json:"criteria" db:"criteria"`
+	// Function is the optional name of a function in the Registry, applied to the custom field before the comparison.
+	Function string `json:"function,omitempty" db:"function"`
 	// Value is the value that will be used to compare with the criteria.
 	Value int `json:"value" db:"value"`
 	// SuccessCase is the boolean that will be used to compare the result of the policy
 	SuccessCase bool `json:"success_case" db:"success_case"`
 	// Priority is the priority of the policy. The lower the number, the higher the priority.
 	Priority int `json:"priority" db:"priority"`
+	// Type is the type of the policy condition. It can be: criteria(default) or starlark.
+	Type string `json:"type,omitempty" db:"type"`
+	// Script is the Starlark script of the starlark policies. It must define the function condition(input), returning a bool.
+	Script string `json:"script,omitempty" db:"script"`
+	// PolicySet is the name of the policy set that the policy belongs to.
+	PolicySet string `json:"policy_set" db:"policy_set"`
+	// Tags are the labels used to find the policy, e.g. by team or regulation. They don't affect the evaluation.
+	Tags Tags `json:"tags,omitempty" db:"tags"`
+}
+
+// Tags are the labels of a policy, sorted and without repetitions, see NewTags. They are stored as a JSON array.
+type Tags []string
+
+// NewTags returns the tags sorted and without repetitions, nil when there are none, so equal sets of tags are equal.
+func NewTags(tags []string) Tags {
+	if len(tags) == 0 {
+		return nil
+	}
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	unique := sorted[:1]
+	for _, t := range sorted[1:] {
+		if t != unique[len(unique)-1] {
+			unique = append(unique, t)
+		}
+	}
+	return Tags(unique)
+}
+
+// CheckTag returns an error if the tag is empty or has spaces.
+func CheckTag(tag string) error {
+	if tag == "" || strings.IndexFunc(tag, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid tag %q, it must be non-empty and without spaces", tag)
+	}
+	return nil
+}
+
+// Has tells if the tag is one of the tags.
+func (t Tags) Has(tag string) bool {
+	for _, v := range t {
+		if v == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Value encodes the tags as a JSON array, for the databases.
+func (t Tags) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(t))
+	return string(b), err
+}
+
+// Scan decodes the tags from a JSON array, for the databases. An empty array is nil.
+func (t *Tags) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("scanning tags: unexpected type %T", src)
+	}
+	var tags []string
+	if err := json.Unmarshal(b, &tags); err != nil {
+		return fmt.Errorf("scanning tags: %v", err)
+	}
+	*t = NewTags(tags)
+	return nil
+}
+
+// IsScript tells if the policy condition is a Starlark script.
+func (p Policy) IsScript() bool {
+	return p.Type == PolicyTypeStarlark
+}
+
+// SortPolicies returns a copy of the policies in evaluation order. The policies are ordered by priority, the lower first.
+// Policies with the same priority are ordered by name and then by ID, so the order is always deterministic.
+func SortPolicies(policies []Policy) []Policy {
+	sorted := make([]Policy, len(policies))
+	copy(sorted, policies)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// DuplicatedPriority returns the first policy, other than the given one, with the same priority in the same policy set.
+// The evaluation order of policies with the same priority depends on the tie-break of SortPolicies, which is rarely what the author expects.
+func DuplicatedPriority(policy Policy, policies []Policy) (Policy, bool) {
+	for _, p := range policies {
+		if p.ID != policy.ID && p.PolicySet == policy.PolicySet && p.Priority == policy.Priority {
+			return p, true
+		}
+	}
+	return Policy{}, false
+}
+
+// policiesVersionPrefix prefixes the versions of PoliciesVersion. It changes, as the version form, whenever the fields that
+// affect the evaluation change.
+const policiesVersionPrefix = "v1:"
+
+// versionedPolicy is the canonical form of a policy in its version: only the fields that affect the evaluation, in a fixed order,
+// so adding a field to Policy doesn't change the version of the existing policies.
+type versionedPolicy struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Criteria    string `json:"criteria"`
+	Function    string `json:"function"`
+	Value       int    `json:"value"`
+	SuccessCase bool   `json:"success_case"`
+	Priority    int    `json:"priority"`
+	Script      string `json:"script"`
+}
+
+// PoliciesVersion returns a fingerprint of the given policies. Any change in the policies that affects the evaluation results in a
+// different version, so it can be used to identify exactly which rules took a decision. The version doesn't depend on the order of
+// the given slice nor on the fields that don't affect the evaluation, such as the policy set.
+func PoliciesVersion(policies []Policy) string {
+	sorted := SortPolicies(policies)
+	canonical := make([]versionedPolicy, len(sorted))
+	for i, p := range sorted {
+		policyType := p.Type
+		if policyType == "" {
+			policyType = PolicyTypeCriteria
+		}
+		canonical[i] = versionedPolicy{
+			ID: p.ID, Name: p.Name, Type: policyType, Criteria: p.Criteria, Function: p.Function,
+			Value: p.Value, SuccessCase: p.SuccessCase, Priority: p.Priority, Script: p.Script,
+		}
+	}
+	// Marshaling a slice of structs never fails, the field order is the one declared in the struct.
+	b, _ := json.Marshal(canonical)
+	sum := sha256.Sum256(b)
+	return policiesVersionPrefix + hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,215 @@
+// Package policycraft ...
+// policy_list.go gather the listing of the policies: filters, sort order and cursor pagination.
+package policycraft
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned when the cursor of a policy listing wasn't returned by a listing with the same sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PolicySort is the sort order of a policy listing: a field, ascending, or descending when prefixed with "-". Ties are broken by
+// the id, so the order is always deterministic.
+type PolicySort string
+
+// The fields a policy listing can be sorted by.
+const (
+	// PolicySortPriority is the evaluation order: priority, then name, see SortPolicies. It's the default.
+	PolicySortPriority PolicySort = "priority"
+	// PolicySortName orders by name, in byte order.
+	PolicySortName PolicySort = "name"
+	// PolicySortUpdatedAt orders by the time of the last change.
+	PolicySortUpdatedAt PolicySort = "updated_at"
+)
+
+// Field returns the field of the sort order and if it's descending.
+func (s PolicySort) Field() (PolicySort, bool) {
+	if s == "" {
+		return PolicySortPriority, false
+	}
+	return PolicySort(strings.TrimPrefix(string(s), "-")), strings.HasPrefix(string(s), "-")
+}
+
+// Check returns an error if the sort order isn't a known field.
+func (s PolicySort) Check() error {
+	switch field, _ := s.Field(); field {
+	case PolicySortPriority, PolicySortName, PolicySortUpdatedAt:
+		return nil
+	}
+	return fmt.Errorf("invalid sort %q, it must be priority, name or updated_at, optionally prefixed with -", s)
+}
+
+// PolicyFilter gather the optional filters, the sort order and the page of a policy listing. Zero values mean no filter.
+type PolicyFilter struct {
+	// PolicySet returns only the policies of the given policy set.
+	PolicySet string
+	// Name returns only the policies whose name contains it, ignoring the case.
+	Name string
+	// Criteria returns only the policies with the given criteria.
+	Criteria string
+	// Tag returns only the policies with the given tag.
+	Tag string
+	// UpdatedFrom returns only the policies changed at or after this time.
+	UpdatedFrom time.Time
+	// UpdatedTo returns only the policies changed before this time.
+	UpdatedTo time.Time
+	// Sort is the sort order, PolicySortPriority by default.
+	Sort PolicySort
+	// Cursor returns the policies after the last one of the previous page, see PolicyPage.Next.
+	Cursor string
+	// Limit is the maximum number of policies returned.
+	Limit int
+}
+
+// ListedPolicy is a policy with the time of its last change, the time of its last revision.
+type ListedPolicy struct {
+	Policy
+	// UpdatedAt is the time of the last change of the policy. For a policy without history, it's unknown, so the storages
+	// may use the time they last wrote it or the zero time.
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// PolicyPage is a page of a policy listing.
+type PolicyPage struct {
+	// Policies are the policies of the page, in the sort order.
+	Policies []ListedPolicy
+	// Total is the number of policies that match the filters, in every page.
+	Total int
+	// Next is the cursor of the next page, empty on the last one.
+	Next string
+}
+
+// Match tells if the policy passes the filters. The sort order and the page are ignored.
+func (f PolicyFilter) Match(p ListedPolicy) bool {
+	switch {
+	case f.PolicySet != "" && p.PolicySet != f.PolicySet:
+		return false
+	case f.Name != "" && !strings.Contains(strings.ToLower(p.Name), strings.ToLower(f.Name)):
+		return false
+	case f.Criteria != "" && p.Criteria != f.Criteria:
+		return false
+	case f.Tag != "" && !p.Tags.Has(f.Tag):
+		return false
+	case !f.UpdatedFrom.IsZero() && p.UpdatedAt.Before(f.UpdatedFrom):
+		return false
+	case !f.UpdatedTo.IsZero() && !p.UpdatedAt.Before(f.UpdatedTo):
+		return false
+	}
+	return true
+}
+
+// policyCursor is the sort key of the last policy of a page. It's encoded in the opaque PolicyPage.Next.
+type policyCursor struct {
+	Sort      PolicySort `json:"s"`
+	Priority  int        `json:"p,omitempty"`
+	Name      string     `json:"n,omitempty"`
+	UpdatedAt time.Time  `json:"u,omitempty"`
+	ID        string     `json:"i"`
+}
+
+// EncodePolicyCursor returns the cursor of the page that follows the given policy in the sort order.
+func EncodePolicyCursor(sort PolicySort, p ListedPolicy) string {
+	c := policyCursor{Sort: sort, ID: p.ID}
+	switch field, _ := sort.Field(); field {
+	case PolicySortPriority:
+		c.Priority, c.Name = p.Priority, p.Name
+	case PolicySortName:
+		c.Name = p.Name
+	case PolicySortUpdatedAt:
+		c.UpdatedAt = p.UpdatedAt
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodePolicyCursor returns the sort key of the last policy of the previous page, as a policy with only the fields of the sort order.
+// It returns ErrInvalidCursor if the cursor is malformed or was returned by a listing with another sort order.
+func DecodePolicyCursor(sort PolicySort, cursor string) (ListedPolicy, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ListedPolicy{}, ErrInvalidCursor
+	}
+	var c policyCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return ListedPolicy{}, ErrInvalidCursor
+	}
+	if c.Sort != sort {
+		return ListedPolicy{}, fmt.Errorf("%w: the cursor is of the sort %q", ErrInvalidCursor, c.Sort)
+	}
+	return ListedPolicy{Policy: Policy{ID: c.ID, Name: c.Name, Priority: c.Priority}, UpdatedAt: c.UpdatedAt}, nil
+}
+
+// comparePolicies compares the policies by the sort order, returning a negative number if a comes first.
+func comparePolicies(sort PolicySort, a, b ListedPolicy) int {
+	field, desc := sort.Field()
+	c := 0
+	switch field {
+	case PolicySortPriority:
+		c = compareInts(a.Priority, b.Priority)
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+	case PolicySortName:
+		c = strings.Compare(a.Name, b.Name)
+	case PolicySortUpdatedAt:
+		c = a.UpdatedAt.Compare(b.UpdatedAt)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ID, b.ID)
+	}
+	if desc {
+		return -c
+	}
+	return c
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// PagePolicies returns the page of the policies given by the filter. It's used by the storages that can't query the policies,
+// so they list every policy of the tenant and let PagePolicies filter, sort and page them.
+func PagePolicies(policies []ListedPolicy, filter PolicyFilter) (PolicyPage, error) {
+	var after *ListedPolicy
+	if filter.Cursor != "" {
+		cursor, err := DecodePolicyCursor(filter.Sort, filter.Cursor)
+		if err != nil {
+			return PolicyPage{}, err
+		}
+		after = &cursor
+	}
+
+	var matched []ListedPolicy
+	for _, p := range policies {
+		if filter.Match(p) {
+			matched = append(matched, p)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return comparePolicies(filter.Sort, matched[i], matched[j]) < 0 })
+
+	page := PolicyPage{Total: len(matched)}
+	for _, p := range matched {
+		if after != nil && comparePolicies(filter.Sort, p, *after) <= 0 {
+			continue
+		}
+		if filter.Limit > 0 && len(page.Policies) == filter.Limit {
+			page.Next = EncodePolicyCursor(filter.Sort, page.Policies[len(page.Policies)-1])
+			break
+		}
+		page.Policies = append(page.Policies, p)
+	}
+	return page, nil
+}
@@ -0,0 +1,92 @@
+package policycraft
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPagePolicies(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	policies := []ListedPolicy{
+		{Policy: Policy{ID: "4", Name: "income", Criteria: ">", Priority: 2, PolicySet: "credit"}, UpdatedAt: now.Add(3 * time.Hour)},
+		{Policy: Policy{ID: "3", Name: "age", Criteria: ">", Priority: 1, PolicySet: "credit", Tags: Tags{"kyc", "risk"}}, UpdatedAt: now.Add(2 * time.Hour)},
+		{Policy: Policy{ID: "2", Name: "Age", Criteria: "<", Priority: 1, PolicySet: "debit"}, UpdatedAt: now.Add(time.Hour)},
+		{Policy: Policy{ID: "1", Name: "score", Criteria: ">", Priority: 0, PolicySet: "credit"}, UpdatedAt: now},
+	}
+	ids := func(page PolicyPage) []string {
+		var ids []string
+		for _, p := range page.Policies {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name      string
+		filter    PolicyFilter
+		wantIDs   []string
+		wantTotal int
+	}{
+		{name: "evaluation order by default", filter: PolicyFilter{}, wantIDs: []string{"1", "2", "3", "4"}, wantTotal: 4},
+		{name: "policy set", filter: PolicyFilter{PolicySet: "credit"}, wantIDs: []string{"1", "3", "4"}, wantTotal: 3},
+		{name: "name ignoring the case", filter: PolicyFilter{Name: "AG"}, wantIDs: []string{"2", "3"}, wantTotal: 2},
+		{name: "criteria", filter: PolicyFilter{Criteria: "<"}, wantIDs: []string{"2"}, wantTotal: 1},
+		{name: "tag", filter: PolicyFilter{Tag: "risk"}, wantIDs: []string{"3"}, wantTotal: 1},
+		{name: "updated range", filter: PolicyFilter{UpdatedFrom: now.Add(time.Hour), UpdatedTo: now.Add(3 * time.Hour)}, wantIDs: []string{"2", "3"}, wantTotal: 2},
+		{name: "name in byte order", filter: PolicyFilter{Sort: PolicySortName}, wantIDs: []string{"2", "3", "4", "1"}, wantTotal: 4},
+		{name: "updated at descending", filter: PolicyFilter{Sort: "-updated_at"}, wantIDs: []string{"4", "3", "2", "1"}, wantTotal: 4},
+		{name: "limit", filter: PolicyFilter{PolicySet: "credit", Limit: 2}, wantIDs: []string{"1", "3"}, wantTotal: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := PagePolicies(policies, tt.filter)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(ids(page), tt.wantIDs) || page.Total != tt.wantTotal {
+				t.Errorf("expected %v of %d, got %v of %d", tt.wantIDs, tt.wantTotal, ids(page), page.Total)
+			}
+		})
+	}
+
+	// the pages follow each other until the last one, which has no cursor
+	filter := PolicyFilter{Sort: "-priority", Limit: 3}
+	var got []string
+	for {
+		page, err := PagePolicies(policies, filter)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, ids(page)...)
+		if page.Next == "" {
+			break
+		}
+		filter.Cursor = page.Next
+	}
+	if want := []string{"4", "3", "2", "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// a cursor is only valid for its sort order
+	if _, err := PagePolicies(policies, PolicyFilter{Sort: PolicySortName, Cursor: filter.Cursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+	if _, err := PagePolicies(policies, PolicyFilter{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestPolicySortCheck(t *testing.T) {
+	for _, sort := range []PolicySort{"", "priority", "-name", "updated_at"} {
+		if err := sort.Check(); err != nil {
+			t.Errorf("expected %q to be valid, got %v", sort, err)
+		}
+	}
+	for _, sort := range []PolicySort{"value", "--name", "+name"} {
+		if err := sort.Check(); err == nil {
+			t.Errorf("expected %q to be invalid", sort)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+// Package policycraft ...
+// policy_sets.go gather the entity policy set, a named group of policies evaluated together, and its input schema.
+package policycraft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// DefaultPolicySet is the policy set used when none is given.
+const DefaultPolicySet = "default"
+
+// ErrInvalidInput is returned when the custom fields can't be evaluated, because of a missing, unknown or bad typed field.
+var ErrInvalidInput = errors.New("invalid input")
+
+// PolicySet is a named group of policies that are evaluated together.
+type PolicySet struct {
+	// Name is the unique identifier of the policy set.
+	Name string `json:"name"`
+	// Description is a free text describing the purpose of the policy set.
+	Description string `json:"description"`
+	// InputSchema declares the custom fields accepted by the policy set. An empty schema accepts any input.
+	InputSchema InputSchema `json:"input_schema"`
+	// TimeoutMS is the evaluation deadline in milliseconds, counting the policies loading. Zero means no deadline.
+	TimeoutMS int `json:"timeout_ms"`
+	// FailMode is the decision taken when the deadline is hit. It can be: open(approve) or closed(reject). Empty means closed.
+	FailMode string `json:"fail_mode"`
+	// Enrichments are the providers that fetch extra custom fields before the evaluation.
+	Enrichments []EnrichmentProvider `json:"enrichments,omitempty"`
+	// Tests are the examples of inputs and their expected decisions. They are run before a bundle of the policy set is imported.
+	Tests []PolicyTest `json:"tests,omitempty"`
+}
+
+// PolicyTest is an example of input and its expected decision.
+type PolicyTest struct {
+	// Name identifies the test inside the policy set.
+	Name string `json:"name"`
+	// Input is the custom fields of the execution, including the fields filled by the enrichment providers.
+	Input map[string]interface{} `json:"input"`
+	// Decision is the expected decision.
+	Decision bool `json:"decision"`
+}
+
+// EnrichmentProvider fetches a custom field from an external HTTP service before the evaluation.
+// The provider receives the custom fields sent by the caller as a JSON object in a POST request, and must answer with a JSON object.
+type EnrichmentProvider struct {
+	// Name is the unique identifier of the provider inside the policy set.
+	Name string `json:"name"`
+	// URL is the HTTP endpoint of the provider.
+	URL string `json:"url"`
+	// Field is the custom field that receives the fetched value. The caller can't send it.
+	Field string `json:"field"`
+	// ResponseField is the key of the provider response holding the value. If empty, Field is used.
+	ResponseField string `json:"response_field,omitempty"`
+	// TimeoutMS is the timeout of each request in milliseconds. Zero means the DefaultEnrichmentTimeoutMS.
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+	// Retries is the number of retries after a failed request. A request fails on network errors and 5xx responses.
+	Retries int `json:"retries,omitempty"`
+	// CacheTTLSeconds is for how long a fetched value is reused. Zero disables the cache.
+	CacheTTLSeconds int `json:"cache_ttl_seconds,omitempty"`
+	// CacheKey are the custom fields that identify a request in the cache. If empty, all the custom fields are used.
+	CacheKey []string `json:"cache_key,omitempty"`
+	// Fallback is the value used when the provider fails. If absent, the execution fails.
+	Fallback interface{} `json:"fallback,omitempty"`
+}
+
+const (
+	// DefaultEnrichmentTimeoutMS is the timeout of each enrichment request when the provider doesn't define one.
+	DefaultEnrichmentTimeoutMS = 1000
+	// MaxEnrichmentRetries is the maximum number of retries of an enrichment provider.
+	MaxEnrichmentRetries = 5
+)
+
+// Fail modes of a policy set.
+const (
+	// FailOpen approves the execution when the deadline is hit.
+	FailOpen = "open"
+	// FailClosed rejects the execution when the deadline is hit.
+	FailClosed = "closed"
+)
+
+// Check verifies if the policy set declaration is valid.
+func (s PolicySet) Check() error {
+	var errs []error
+	if s.TimeoutMS < 0 {
+		errs = append(errs, errors.New("timeout_ms must not be negative"))
+	}
+	switch s.FailMode {
+	case "", FailOpen, FailClosed:
+	default:
+		errs = append(errs, fmt.Errorf("invalid fail_mode: %s", s.FailMode))
+	}
+	errs = append(errs, s.InputSchema.Check())
+
+	names := make(map[string]bool)
+	fields := make(map[string]bool)
+	for _, provider := range s.Enrichments {
+		if names[provider.Name] {
+			errs = append(errs, fmt.Errorf("enrichment %s: duplicated name", provider.Name))
+		}
+		if fields[provider.Field] {
+			errs = append(errs, fmt.Errorf("enrichment %s: field %s is already enriched", provider.Name, provider.Field))
+		}
+		names[provider.Name] = true
+		fields[provider.Field] = true
+		errs = append(errs, provider.check())
+	}
+
+	tests := make(map[string]bool)
+	for _, test := range s.Tests {
+		if test.Name == "" {
+			errs = append(errs, errors.New("test name is required"))
+		}
+		if tests[test.Name] {
+			errs = append(errs, fmt.Errorf("test %s: duplicated name", test.Name))
+		}
+		tests[test.Name] = true
+	}
+	return errors.Join(errs...)
+}
+
+// RunTests evaluates the tests of the policy set against the given policies, returning one error per failed test.
+// The enrichment providers are not called, the tests input must have the enriched fields.
+func (s PolicySet) RunTests(ctx context.Context, policies []Policy, registry *Registry) error {
+	var errs []error
+	for _, test := range s.Tests {
+		e := Execution{CustomFields: test.Input, Registry: registry}
+		decision, err := e.Evaluate(ctx, policies)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("test %s: %w", test.Name, err))
+			continue
+		}
+		if decision != test.Decision {
+			errs = append(errs, fmt.Errorf("test %s: expected decision %t, got %t", test.Name, test.Decision, decision))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// check verifies if the provider declaration is valid.
+func (p EnrichmentProvider) check() error {
+	var errs []error
+	if p.Name == "" {
+		errs = append(errs, errors.New("enrichment name is required"))
+	}
+	if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("enrichment %s: url must be an absolute http(s) URL", p.Name))
+	}
+	if p.Field == "" {
+		errs = append(errs, fmt.Errorf("enrichment %s: field is required", p.Name))
+	}
+	if p.TimeoutMS < 0 {
+		errs = append(errs, fmt.Errorf("enrichment %s: timeout_ms must not be negative", p.Name))
+	}
+	if p.Retries < 0 || p.Retries > MaxEnrichmentRetries {
+		errs = append(errs, fmt.Errorf("enrichment %s: retries must be between 0 and %d", p.Name, MaxEnrichmentRetries))
+	}
+	if p.CacheTTLSeconds < 0 {
+		errs = append(errs, fmt.Errorf("enrichment %s: cache_ttl_seconds must not be negative", p.Name))
+	}
+	return errors.Join(errs...)
+}
+
+// Timeout returns the timeout of each request to the provider.
+func (p EnrichmentProvider) Timeout() time.Duration {
+	if p.TimeoutMS == 0 {
+		return DefaultEnrichmentTimeoutMS * time.Millisecond
+	}
+	return time.Duration(p.TimeoutMS) * time.Millisecond
+}
+
+// CacheTTL returns for how long a fetched value is reused.
+func (p EnrichmentProvider) CacheTTL() time.Duration {
+	return time.Duration(p.CacheTTLSeconds) * time.Second
+}
+
+// CallerInputSchema returns the input schema without the fields filled by the enrichment providers, that is, the input the caller must send.
+func (s PolicySet) CallerInputSchema() InputSchema {
+	if len(s.Enrichments) == 0 || len(s.InputSchema.Fields) == 0 {
+		return s.InputSchema
+	}
+	enriched := s.EnrichedFields()
+	fields := make(map[string]FieldSchema, len(s.InputSchema.Fields))
+	for name, field := range s.InputSchema.Fields {
+		if _, ok := enriched[name]; !ok {
+			fields[name] = field
+		}
+	}
+	return InputSchema{Fields: fields}
+}
+
+// EnrichedFields returns the custom fields that are filled by the enrichment providers.
+func (s PolicySet) EnrichedFields() map[string]string {
+	fields := make(map[string]string, len(s.Enrichments))
+	for _, provider := range s.Enrichments {
+		fields[provider.Field] = provider.Name
+	}
+	return fields
+}
+
+// Timeout returns the evaluation deadline of the policy set. Zero means no deadline.
+func (s PolicySet) Timeout() time.Duration {
+	return time.Duration(s.TimeoutMS) * time.Millisecond
+}
+
+// FailDecision returns the decision taken when the evaluation deadline is hit.
+func (s PolicySet) FailDecision() bool {
+	return s.FailMode == FailOpen
+}
+
+// Field types accepted in the input schema.
+const (
+	FieldTypeInteger = "integer"
+	FieldTypeNumber  = "number"
+	FieldTypeString  = "string"
+	FieldTypeBoolean = "boolean"
+)
+
+// InputSchema declares the custom fields that an execution can receive.
+type InputSchema struct {
+	// Fields maps the custom field name to its declaration.
+	Fields map[string]FieldSchema `json:"fields,omitempty"`
+}
+
+// FieldSchema is the declaration of a single custom field.
+type FieldSchema struct {
+	// Type is the JSON type of the field. It can be: integer, number, string, boolean.
+	Type string `json:"type"`
+	// Required tells if the field must be present in the input.
+	Required bool `json:"required"`
+	// Minimum is the inclusive lower bound of numeric fields.
+	Minimum *float64 `json:"minimum,omitempty"`
+	// Maximum is the inclusive upper bound of numeric fields.
+	Maximum *float64 `json:"maximum,omitempty"`
+}
+
+// FieldError describes why a single custom field is invalid.
+type FieldError struct {
+	// Field is the name of the invalid custom field.
+	Field string `json:"field"`
+	// Msg is the reason why the field is invalid.
+	Msg string `json:"msg"`
+}
+
+// Check verifies if the schema declaration itself is valid.
+func (s InputSchema) Check() error {
+	var errs []error
+	for _, name := range s.fieldNames() {
+		field := s.Fields[name]
+		switch field.Type {
+		case FieldTypeInteger, FieldTypeNumber:
+			if field.Minimum != nil && field.Maximum != nil && *field.Minimum > *field.Maximum {
+				errs = append(errs, fmt.Errorf("field %s: minimum is greater than maximum", name))
+			}
+		case FieldTypeString, FieldTypeBoolean:
+			if field.Minimum != nil || field.Maximum != nil {
+				errs = append(errs, fmt.Errorf("field %s: minimum and maximum are only allowed for numeric fields", name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("field %s: invalid type: %s", name, field.Type))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the input against the schema, returning one error for each invalid field. Fields that aren't declared are rejected.
+// An empty schema accepts any input.
+func (s InputSchema) Validate(input map[string]interface{}) []FieldError {
+	if len(s.Fields) == 0 {
+		return nil
+	}
+
+	var errs []FieldError
+	for _, name := range s.fieldNames() {
+		field := s.Fields[name]
+		v, ok := input[name]
+		if !ok {
+			if field.Required {
+				errs = append(errs, FieldError{Field: name, Msg: "is required"})
+			}
+			continue
+		}
+		if msg := field.validate(v); msg != "" {
+			errs = append(errs, FieldError{Field: name, Msg: msg})
+		}
+	}
+
+	var unknown []string
+	for name := range input {
+		if _, ok := s.Fields[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, FieldError{Field: name, Msg: "is not declared in the input schema"})
+	}
+	return errs
+}
+
+// validate returns the reason why the value doesn't match the field declaration, or an empty string if it matches.
+func (f FieldSchema) validate(v interface{}) string {
+	switch f.Type {
+	case FieldTypeString:
+		if _, ok := v.(string); !ok {
+			return "must be a string"
+		}
+		return ""
+	case FieldTypeBoolean:
+		if _, ok := v.(bool); !ok {
+			return "must be a boolean"
+		}
+		return ""
+	}
+
+	var n float64
+	switch value := v.(type) {
+	case float64:
+		n = value
+	case int:
+		n = float64(value)
+	default:
+		return fmt.Sprintf("must be %s", article(f.Type))
+	}
+	if f.Type == FieldTypeInteger && n != math.Trunc(n) {
+		return "must be an integer"
+	}
+	if f.Minimum != nil && n < *f.Minimum {
+		return fmt.Sprintf("must be greater than or equal to %v", *f.Minimum)
+	}
+	if f.Maximum != nil && n > *f.Maximum {
+		return fmt.Sprintf("must be less than or equal to %v", *f.Maximum)
+	}
+	return ""
+}
+
+// JSONSchema exports the input schema as a JSON Schema (draft 2020-12) document, to be shared with the client teams.
+func (s InputSchema) JSONSchema(title string) map[string]interface{} {
+	properties := make(map[string]interface{}, len(s.Fields))
+	required := []string{}
+	for _, name := range s.fieldNames() {
+		field := s.Fields[name]
+		property := map[string]interface{}{"type": field.Type}
+		if field.Minimum != nil {
+			property["minimum"] = *field.Minimum
+		}
+		if field.Maximum != nil {
+			property["maximum"] = *field.Maximum
+		}
+		properties[name] = property
+		if field.Required {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                title,
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": len(s.Fields) == 0,
+	}
+}
+
+// fieldNames returns the declared field names sorted, so errors are always reported in the same order.
+func (s InputSchema) fieldNames() []string {
+	names := make([]string, 0, len(s.Fields))
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// article prefixes the type with the right indefinite article.
+func article(fieldType string) string {
+	if fieldType == FieldTypeInteger {
+		return "an " + fieldType
+	}
+	return "a " + fieldType
+}
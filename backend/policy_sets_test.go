@@ -0,0 +1,188 @@
+package policycraft
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInputSchemaValidate(t *testing.T) {
+	minimum := float64(18)
+	maximum := float64(120)
+	schema := InputSchema{
+		Fields: map[string]FieldSchema{
+			"age":    {Type: FieldTypeInteger, Required: true, Minimum: &minimum, Maximum: &maximum},
+			"score":  {Type: FieldTypeNumber},
+			"name":   {Type: FieldTypeString},
+			"active": {Type: FieldTypeBoolean},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		input map[string]interface{}
+		want  []FieldError
+	}{
+		{
+			name:  "valid input",
+			input: map[string]interface{}{"age": float64(20), "score": 7.5, "name": "john", "active": true},
+		},
+		{
+			name:  "missing required field",
+			input: map[string]interface{}{"score": 7.5},
+			want:  []FieldError{{Field: "age", Msg: "is required"}},
+		},
+		{
+			name:  "wrong types",
+			input: map[string]interface{}{"age": "20", "name": 1, "active": "yes"},
+			want: []FieldError{
+				{Field: "active", Msg: "must be a boolean"},
+				{Field: "age", Msg: "must be an integer"},
+				{Field: "name", Msg: "must be a string"},
+			},
+		},
+		{
+			name:  "out of range",
+			input: map[string]interface{}{"age": float64(17)},
+			want:  []FieldError{{Field: "age", Msg: "must be greater than or equal to 18"}},
+		},
+		{
+			name:  "not an integer",
+			input: map[string]interface{}{"age": 20.5},
+			want:  []FieldError{{Field: "age", Msg: "must be an integer"}},
+		},
+		{
+			name:  "unknown field",
+			input: map[string]interface{}{"age": float64(20), "agee": float64(20)},
+			want:  []FieldError{{Field: "agee", Msg: "is not declared in the input schema"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := schema.Validate(tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("InputSchema.Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// an empty schema accepts any input
+	if errs := (InputSchema{}).Validate(map[string]interface{}{"anything": "goes"}); errs != nil {
+		t.Errorf("Expecting an empty schema to accept any input, got %v", errs)
+	}
+}
+
+func TestInputSchemaCheck(t *testing.T) {
+	low := float64(1)
+	high := float64(10)
+
+	valid := InputSchema{Fields: map[string]FieldSchema{"age": {Type: FieldTypeInteger, Minimum: &low, Maximum: &high}}}
+	if err := valid.Check(); err != nil {
+		t.Errorf("Expecting a valid schema, got %v", err)
+	}
+
+	invalid := []InputSchema{
+		{Fields: map[string]FieldSchema{"age": {Type: "int"}}},
+		{Fields: map[string]FieldSchema{"age": {Type: FieldTypeInteger, Minimum: &high, Maximum: &low}}},
+		{Fields: map[string]FieldSchema{"name": {Type: FieldTypeString, Minimum: &low}}},
+	}
+	for _, schema := range invalid {
+		if err := schema.Check(); err == nil {
+			t.Errorf("Expecting an error checking the schema %v", schema)
+		}
+	}
+}
+
+func TestInputSchemaJSONSchema(t *testing.T) {
+	minimum := float64(18)
+	schema := InputSchema{
+		Fields: map[string]FieldSchema{
+			"age":  {Type: FieldTypeInteger, Required: true, Minimum: &minimum},
+			"name": {Type: FieldTypeString},
+		},
+	}
+
+	got := schema.JSONSchema("credit")
+	want := map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "credit",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"age":  map[string]interface{}{"type": "integer", "minimum": float64(18)},
+			"name": map[string]interface{}{"type": "string"},
+		},
+		"required":             []string{"age"},
+		"additionalProperties": false,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InputSchema.JSONSchema() = %v, want %v", got, want)
+	}
+}
+
+func TestPolicySetCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     PolicySet
+		wantErr bool
+	}{
+		{name: "no deadline", set: PolicySet{Name: "credit"}},
+		{name: "fail open", set: PolicySet{Name: "credit", TimeoutMS: 100, FailMode: FailOpen}},
+		{name: "fail closed", set: PolicySet{Name: "credit", TimeoutMS: 100, FailMode: FailClosed}},
+		{name: "negative timeout", set: PolicySet{Name: "credit", TimeoutMS: -1}, wantErr: true},
+		{name: "invalid fail mode", set: PolicySet{Name: "credit", FailMode: "maybe"}, wantErr: true},
+		{name: "invalid input schema", set: PolicySet{Name: "credit", InputSchema: InputSchema{Fields: map[string]FieldSchema{"age": {Type: "int"}}}}, wantErr: true},
+		{name: "enrichment", set: PolicySet{Name: "credit", Enrichments: []EnrichmentProvider{{Name: "bureau", URL: "https://bureau.local/score", Field: "score", Retries: 1}}}},
+		{name: "enrichment without field", set: PolicySet{Name: "credit", Enrichments: []EnrichmentProvider{{Name: "bureau", URL: "https://bureau.local/score"}}}, wantErr: true},
+		{name: "enrichment relative url", set: PolicySet{Name: "credit", Enrichments: []EnrichmentProvider{{Name: "bureau", URL: "/score", Field: "score"}}}, wantErr: true},
+		{name: "enrichment too many retries", set: PolicySet{Name: "credit", Enrichments: []EnrichmentProvider{{Name: "bureau", URL: "https://bureau.local/score", Field: "score", Retries: MaxEnrichmentRetries + 1}}}, wantErr: true},
+		{
+			name: "enrichments of the same field",
+			set: PolicySet{Name: "credit", Enrichments: []EnrichmentProvider{
+				{Name: "bureau", URL: "https://bureau.local/score", Field: "score"},
+				{Name: "other", URL: "https://other.local/score", Field: "score"},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.set.Check(); (err != nil) != tt.wantErr {
+				t.Errorf("PolicySet.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicySetCallerInputSchema(t *testing.T) {
+	set := PolicySet{
+		Name: "credit",
+		InputSchema: InputSchema{Fields: map[string]FieldSchema{
+			"age":   {Type: FieldTypeInteger, Required: true},
+			"score": {Type: FieldTypeInteger, Required: true},
+		}},
+		Enrichments: []EnrichmentProvider{{Name: "bureau", URL: "https://bureau.local/score", Field: "score"}},
+	}
+
+	got := set.CallerInputSchema()
+	if _, ok := got.Fields["score"]; ok {
+		t.Errorf("Expecting the enriched field to be removed, got %v", got.Fields)
+	}
+	if _, ok := got.Fields["age"]; !ok {
+		t.Errorf("Expecting the caller field to be kept, got %v", got.Fields)
+	}
+	if len(set.InputSchema.Fields) != 2 {
+		t.Errorf("Expecting the policy set schema to be untouched, got %v", set.InputSchema.Fields)
+	}
+}
+
+func TestPolicySetFailDecision(t *testing.T) {
+	if (PolicySet{FailMode: FailOpen}).FailDecision() != true {
+		t.Errorf("Expecting fail open to approve")
+	}
+	if (PolicySet{FailMode: FailClosed}).FailDecision() != false {
+		t.Errorf("Expecting fail closed to reject")
+	}
+	if (PolicySet{}).FailDecision() != false {
+		t.Errorf("Expecting the default fail mode to reject")
+	}
+}
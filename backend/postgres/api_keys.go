@@ -0,0 +1,126 @@
+// Package postgres ...
+// api_keys.go gather all the database operations related to the API keys entity
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/perebaj/policycraft"
+)
+
+// APIKey is the struct that represents the API key entity in the database.
+type APIKey struct {
+	// ID is the unique identifier of the API key.
+	ID uuid.UUID `db:"id"`
+	// TenantID is the tenant that owns the API key.
+	TenantID string `db:"tenant_id"`
+	// Name is a free text telling who uses the key.
+	Name string `db:"name"`
+	// Roles are the roles given to the key.
+	Roles pq.StringArray `db:"roles"`
+	// Hash is the hash of the secret.
+	Hash string `db:"hash"`
+	// CreatedAt is the time when the key was created.
+	CreatedAt time.Time `db:"created_at"`
+	// ExpiresAt is the time when the key stops working.
+	ExpiresAt *time.Time `db:"expires_at"`
+	// RevokedAt is the time when the key was revoked.
+	RevokedAt *time.Time `db:"revoked_at"`
+}
+
+// SaveAPIKey save an API key of the context tenant in the database.
+func (s *Storage) SaveAPIKey(ctx context.Context, key policycraft.APIKey) error {
+	id, err := uuid.Parse(key.ID)
+	if err != nil {
+		return fmt.Errorf("parsing API key id: %v", err)
+	}
+	roles := make(pq.StringArray, 0, len(key.Roles))
+	for _, role := range key.Roles {
+		roles = append(roles, string(role))
+	}
+	_, err = s.db.NamedExecContext(ctx, `
+		INSERT INTO api_keys (id, tenant_id, name, roles, hash, created_at, expires_at, revoked_at)
+		VALUES (:id, :tenant_id, :name, :roles, :hash, :created_at, :expires_at, :revoked_at)
+	`, APIKey{
+		ID:        id,
+		TenantID:  policycraft.Tenant(ctx),
+		Name:      key.Name,
+		Roles:     roles,
+		Hash:      key.Hash,
+		CreatedAt: key.CreatedAt,
+		ExpiresAt: key.ExpiresAt,
+		RevokedAt: key.RevokedAt,
+	})
+	return err
+}
+
+// APIKeys returns the API keys of the context tenant, the oldest first.
+func (s *Storage) APIKeys(ctx context.Context) ([]policycraft.APIKey, error) {
+	var rows []APIKey
+	err := s.db.SelectContext(ctx, &rows, "SELECT * FROM api_keys WHERE tenant_id = $1 ORDER BY created_at ASC, id ASC", policycraft.Tenant(ctx))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]policycraft.APIKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, row.apiKey())
+	}
+	return keys, nil
+}
+
+// APIKeyByHash returns the API key with the given hash, of any tenant. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) APIKeyByHash(ctx context.Context, hash string) (policycraft.APIKey, error) {
+	var row APIKey
+	err := s.db.GetContext(ctx, &row, "SELECT * FROM api_keys WHERE hash = $1", hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return policycraft.APIKey{}, policycraft.ErrNotFound
+	}
+	if err != nil {
+		return policycraft.APIKey{}, err
+	}
+	return row.apiKey(), nil
+}
+
+// RevokeAPIKey revokes the API key of the context tenant with the given id. Revoking it again keeps the first revocation time.
+// If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	UUID, err := uuid.Parse(id)
+	if err != nil {
+		return policycraft.ErrNotFound
+	}
+	res, err := s.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $1) WHERE tenant_id = $2 AND id = $3", at, policycraft.Tenant(ctx), UUID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return policycraft.ErrNotFound
+	}
+	return nil
+}
+
+// apiKey converts the database representation to the business entity.
+func (k APIKey) apiKey() policycraft.APIKey {
+	key := policycraft.APIKey{
+		ID:        k.ID.String(),
+		Name:      k.Name,
+		Tenant:    k.TenantID,
+		Hash:      k.Hash,
+		CreatedAt: k.CreatedAt,
+		ExpiresAt: k.ExpiresAt,
+		RevokedAt: k.RevokedAt,
+	}
+	for _, role := range k.Roles {
+		key.Roles = append(key.Roles, policycraft.Role(role))
+	}
+	return key
+}
@@ -0,0 +1,267 @@
+// Package postgres ...
+// change_requests.go gather all the database operations related to the change requests entity
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/perebaj/policycraft"
+)
+
+// uniqueViolation is the postgres error code of a duplicated primary key or unique value.
+const uniqueViolation = "23505"
+
+// ChangeRequest is the struct that represents the change request entity in the database.
+type ChangeRequest struct {
+	// TenantID is the tenant that owns the change request.
+	TenantID string `db:"tenant_id"`
+	// ID is the unique identifier of the change request.
+	ID uuid.UUID `db:"id"`
+	// Title is a short description of the change.
+	Title string `db:"title"`
+	// Author is the subject that created the change request.
+	Author string `db:"author"`
+	// Status is the current status of the change request.
+	Status string `db:"status"`
+	// Policies is the JSON encoded list of proposed policies.
+	Policies []byte `db:"policies"`
+	// Comments is the JSON encoded list of comments.
+	Comments []byte `db:"comments"`
+	// BaseRevisions is the JSON encoded base revision of each proposed policy, by policy id.
+	BaseRevisions []byte `db:"base_revisions"`
+	// Patches is the JSON encoded partial update of each patched policy, by policy id.
+	Patches []byte `db:"patches"`
+	// ApprovedBy is the subject that approved the change request.
+	ApprovedBy string `db:"approved_by"`
+	// PublishedBy is the subject that published the change request.
+	PublishedBy string `db:"published_by"`
+	// Version is incremented on every update.
+	Version int `db:"version"`
+	// CreatedAt is the time when the change request was created.
+	CreatedAt time.Time `db:"created_at"`
+	// UpdatedAt is the time of the last change of the change request.
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// SaveChangeRequest save a change request of the context tenant in the database. A change request with version 0 is created,
+// otherwise it's updated only if the stored version is still the given one, and policycraft.ErrConflict is returned if it isn't.
+// The stored version is incremented.
+func (s *Storage) SaveChangeRequest(ctx context.Context, cr policycraft.ChangeRequest) error {
+	return saveChangeRequest(ctx, s.db, cr)
+}
+
+// saveChangeRequest is shared by SaveChangeRequest and PublishChangeRequest, that saves it inside a transaction.
+func saveChangeRequest(ctx context.Context, db sqlx.ExtContext, cr policycraft.ChangeRequest) error {
+	id, err := uuid.Parse(cr.ID)
+	if err != nil {
+		return fmt.Errorf("parsing change request id: %v", err)
+	}
+	row := ChangeRequest{
+		TenantID:    policycraft.Tenant(ctx),
+		ID:          id,
+		Title:       cr.Title,
+		Author:      cr.Author,
+		Status:      string(cr.Status),
+		ApprovedBy:  cr.ApprovedBy,
+		PublishedBy: cr.PublishedBy,
+		Version:     cr.Version,
+		CreatedAt:   cr.CreatedAt,
+		UpdatedAt:   cr.UpdatedAt,
+	}
+	policies := cr.Policies
+	if policies == nil {
+		policies = []policycraft.Policy{}
+	}
+	row.Policies, err = json.Marshal(policies)
+	if err != nil {
+		return fmt.Errorf("marshaling change request policies: %v", err)
+	}
+	comments := cr.Comments
+	if comments == nil {
+		comments = []policycraft.Comment{}
+	}
+	row.Comments, err = json.Marshal(comments)
+	if err != nil {
+		return fmt.Errorf("marshaling change request comments: %v", err)
+	}
+	bases := cr.BaseRevisions
+	if bases == nil {
+		bases = map[string]int{}
+	}
+	row.BaseRevisions, err = json.Marshal(bases)
+	if err != nil {
+		return fmt.Errorf("marshaling change request base revisions: %v", err)
+	}
+	patches := cr.Patches
+	if patches == nil {
+		patches = map[string]json.RawMessage{}
+	}
+	row.Patches, err = json.Marshal(patches)
+	if err != nil {
+		return fmt.Errorf("marshaling change request patches: %v", err)
+	}
+
+	if cr.Version == 0 {
+		_, err = sqlx.NamedExecContext(ctx, db, `
+			INSERT INTO change_requests (tenant_id, id, title, author, status, policies, comments, base_revisions, patches, approved_by, published_by,
+				version, created_at, updated_at)
+			VALUES (:tenant_id, :id, :title, :author, :status, :policies, :comments, :base_revisions, :patches, :approved_by, :published_by,
+				1, :created_at, :updated_at)
+		`, row)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return fmt.Errorf("%w: change request %s already exists", policycraft.ErrConflict, cr.ID)
+		}
+		return err
+	}
+
+	res, err := sqlx.NamedExecContext(ctx, db, `
+		UPDATE change_requests
+		SET title = :title, status = :status, policies = :policies, comments = :comments, base_revisions = :base_revisions,
+			patches = :patches, approved_by = :approved_by,
+			published_by = :published_by, version = version + 1, updated_at = :updated_at
+		WHERE tenant_id = :tenant_id AND id = :id AND version = :version
+	`, row)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: the change request was changed concurrently", policycraft.ErrConflict)
+	}
+	return nil
+}
+
+// ChangeRequest returns the change request of the context tenant with the given id. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) ChangeRequest(ctx context.Context, id string) (policycraft.ChangeRequest, error) {
+	UUID, err := uuid.Parse(id)
+	if err != nil {
+		return policycraft.ChangeRequest{}, policycraft.ErrNotFound
+	}
+
+	var row ChangeRequest
+	err = s.db.GetContext(ctx, &row, "SELECT * FROM change_requests WHERE tenant_id = $1 AND id = $2", policycraft.Tenant(ctx), UUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return policycraft.ChangeRequest{}, policycraft.ErrNotFound
+	}
+	if err != nil {
+		return policycraft.ChangeRequest{}, err
+	}
+	return row.changeRequest()
+}
+
+// ChangeRequests returns the change requests of the context tenant that match the filter, the most recent first.
+func (s *Storage) ChangeRequests(ctx context.Context, filter policycraft.ChangeRequestFilter) ([]policycraft.ChangeRequest, error) {
+	query := "SELECT * FROM change_requests WHERE tenant_id = $1"
+	args := []interface{}{policycraft.Tenant(ctx)}
+	if filter.Status != "" {
+		args = append(args, string(filter.Status))
+		query += " AND status = $2"
+	}
+	query += " ORDER BY created_at DESC, id ASC"
+
+	var rows []ChangeRequest
+	err := s.db.SelectContext(ctx, &rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	crs := make([]policycraft.ChangeRequest, 0, len(rows))
+	for _, row := range rows {
+		cr, err := row.changeRequest()
+		if err != nil {
+			return nil, err
+		}
+		crs = append(crs, cr)
+	}
+	return crs, nil
+}
+
+// PublishChangeRequest saves the published change request and its policies in a single transaction, replacing every field
+// of the existing policies. As in SaveChangeRequest, policycraft.ErrConflict is returned if the change request was changed concurrently.
+func (s *Storage) PublishChangeRequest(ctx context.Context, cr policycraft.ChangeRequest) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = saveChangeRequest(ctx, tx, cr)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(cr.Policies))
+	for _, p := range cr.Policies {
+		ids = append(ids, p.ID)
+	}
+	err = recordHistory(ctx, tx, ids, func() error {
+		// The policies are removed and inserted again, so every field is replaced, as ImportBundle does.
+		_, err := tx.ExecContext(ctx, "DELETE FROM policies WHERE tenant_id = $1 AND id::text = ANY($2)", policycraft.Tenant(ctx), pq.Array(ids))
+		if err != nil {
+			return fmt.Errorf("removing policies: %v", err)
+		}
+		for _, p := range cr.Policies {
+			err = savePolicy(ctx, tx, p)
+			if err != nil {
+				return fmt.Errorf("saving policy %s: %v", p.ID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// changeRequest converts the database representation to the business entity.
+func (c ChangeRequest) changeRequest() (policycraft.ChangeRequest, error) {
+	cr := policycraft.ChangeRequest{
+		ID:          c.ID.String(),
+		Title:       c.Title,
+		Author:      c.Author,
+		Status:      policycraft.ChangeStatus(c.Status),
+		ApprovedBy:  c.ApprovedBy,
+		PublishedBy: c.PublishedBy,
+		Version:     c.Version,
+		CreatedAt:   c.CreatedAt,
+		UpdatedAt:   c.UpdatedAt,
+	}
+	if err := json.Unmarshal(c.Policies, &cr.Policies); err != nil {
+		return policycraft.ChangeRequest{}, fmt.Errorf("unmarshaling change request policies: %v", err)
+	}
+	if err := json.Unmarshal(c.Comments, &cr.Comments); err != nil {
+		return policycraft.ChangeRequest{}, fmt.Errorf("unmarshaling change request comments: %v", err)
+	}
+	if err := json.Unmarshal(c.BaseRevisions, &cr.BaseRevisions); err != nil {
+		return policycraft.ChangeRequest{}, fmt.Errorf("unmarshaling change request base revisions: %v", err)
+	}
+	if err := json.Unmarshal(c.Patches, &cr.Patches); err != nil {
+		return policycraft.ChangeRequest{}, fmt.Errorf("unmarshaling change request patches: %v", err)
+	}
+	// The postgres storage returns nil for empty collections, as the other entities.
+	if len(cr.Policies) == 0 {
+		cr.Policies = nil
+	}
+	if len(cr.Comments) == 0 {
+		cr.Comments = nil
+	}
+	if len(cr.BaseRevisions) == 0 {
+		cr.BaseRevisions = nil
+	}
+	if len(cr.Patches) == 0 {
+		cr.Patches = nil
+	}
+	return cr, nil
+}
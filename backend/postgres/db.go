@@ -5,7 +5,9 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
+	"io/fs"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
@@ -55,17 +57,22 @@ func OpenDB(cfg Config) (*sqlx.DB, error) {
 //go:embed migrations/*.sql
 var migrationsFS embed.FS
 
-// Migrate run the migrations for the database.
-func Migrate(db *sql.DB) error {
-	fs, err := iofs.New(migrationsFS, "migrations")
+// newMigrate returns the migrate instance of the embedded migrations for the database.
+func newMigrate(db *sql.DB) (*migrate.Migrate, error) {
+	source, err := iofs.New(migrationsFS, "migrations")
 	if err != nil {
-		return fmt.Errorf("creating iofs driver: %v", err)
+		return nil, fmt.Errorf("creating iofs driver: %v", err)
 	}
 	driver, err := postgres.WithInstance(db, &postgres.Config{})
 	if err != nil {
-		return fmt.Errorf("creating postgres driver: %v", err)
+		return nil, fmt.Errorf("creating postgres driver: %v", err)
 	}
-	m, err := migrate.NewWithInstance("iofs", fs, "postgres", driver)
+	return migrate.NewWithInstance("iofs", source, "postgres", driver)
+}
+
+// Migrate run the migrations for the database.
+func Migrate(db *sql.DB) error {
+	m, err := newMigrate(db)
 	if err != nil {
 		return err
 	}
@@ -75,3 +82,51 @@ func Migrate(db *sql.DB) error {
 	}
 	return nil
 }
+
+// MigrateDown reverts the last steps migrations applied to the database.
+func MigrateDown(db *sql.DB, steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("steps must be positive, got %d", steps)
+	}
+	m, err := newMigrate(db)
+	if err != nil {
+		return err
+	}
+	return m.Steps(-steps)
+}
+
+// MigrationVersion returns the version of the last migration applied to the database, 0 if none was, and if it failed
+// halfway, leaving the database dirty.
+func MigrationVersion(db *sql.DB) (uint, bool, error) {
+	m, err := newMigrate(db)
+	if err != nil {
+		return 0, false, err
+	}
+	version, dirty, err := m.Version()
+	if err == migrate.ErrNilVersion {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// LatestMigration returns the version of the last embedded migration, the version of an up to date database.
+func LatestMigration() (uint, error) {
+	source, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return 0, fmt.Errorf("creating iofs driver: %v", err)
+	}
+	version, err := source.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := source.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
@@ -0,0 +1,56 @@
+//go:build integration
+// +build integration
+
+package postgres_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/perebaj/policycraft/postgres"
+)
+
+func TestMigrations(t *testing.T) {
+	db := OpenDB(t)
+
+	files, err := filepath.Glob("migrations/*.up.sql")
+	if err != nil {
+		t.Fatalf("error listing migrations: %v", err)
+	}
+	latest, err := postgres.LatestMigration()
+	if err != nil {
+		t.Fatalf("error getting latest migration: %v", err)
+	}
+	assert(t, int(latest), len(files))
+
+	version, dirty, err := postgres.MigrationVersion(db.DB)
+	if err != nil {
+		t.Fatalf("error getting migration version: %v", err)
+	}
+	assert(t, version, latest)
+	assert(t, dirty, false)
+
+	err = postgres.MigrateDown(db.DB, 2)
+	if err != nil {
+		t.Fatalf("error reverting migrations: %v", err)
+	}
+	version, _, err = postgres.MigrationVersion(db.DB)
+	if err != nil {
+		t.Fatalf("error getting migration version: %v", err)
+	}
+	assert(t, version, latest-2)
+
+	err = postgres.Migrate(db.DB)
+	if err != nil {
+		t.Fatalf("error migrating: %v", err)
+	}
+	version, _, err = postgres.MigrationVersion(db.DB)
+	if err != nil {
+		t.Fatalf("error getting migration version: %v", err)
+	}
+	assert(t, version, latest)
+
+	if err := postgres.MigrateDown(db.DB, 0); err == nil {
+		t.Error("expected an error reverting 0 migrations")
+	}
+}
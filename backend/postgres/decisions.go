@@ -0,0 +1,191 @@
+// Package postgres ...
+// decisions.go gather all the database operations related to the decisions entity
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+)
+
+// Decision is the struct that represents the decision entity in the database.
+type Decision struct {
+	// ID is the unique identifier for the decision.
+	ID uuid.UUID `db:"id"`
+	// RequestID is the identifier of the request that triggered the execution.
+	RequestID string `db:"request_id"`
+	// CallerRef is the reference sent by the caller.
+	CallerRef string `db:"caller_ref"`
+	// PolicySet is the name of the evaluated policy set.
+	PolicySet string `db:"policy_set"`
+	// Input is the JSON encoded custom fields.
+	Input []byte `db:"input"`
+	// PolicyVersion is the fingerprint of the evaluated policies.
+	PolicyVersion string `db:"policy_version"`
+	// Policies is the JSON encoded snapshot of the evaluated policies.
+	Policies []byte `db:"policies"`
+	// Decision is the result of the execution.
+	Decision bool `db:"decision"`
+	// Trace is the JSON encoded list of evaluated policies.
+	Trace []byte `db:"trace"`
+	// Enrichments is the JSON encoded list of custom fields fetched by the enrichment providers.
+	Enrichments []byte `db:"enrichments"`
+	// TimedOut tells if the evaluation deadline was hit.
+	TimedOut bool `db:"timed_out"`
+	// StartedAt is the time when the execution started.
+	StartedAt time.Time `db:"started_at"`
+	// FinishedAt is the time when the execution finished.
+	FinishedAt time.Time `db:"finished_at"`
+	// TenantID is the tenant that owns the decision.
+	TenantID string `db:"tenant_id"`
+}
+
+// SaveDecision save a decision of the context tenant in the database.
+func (s *Storage) SaveDecision(ctx context.Context, decision policycraft.Decision) error {
+	id, err := uuid.Parse(decision.ID)
+	if err != nil {
+		return fmt.Errorf("parsing decision id: %v", err)
+	}
+	d := Decision{
+		ID:            id,
+		RequestID:     decision.RequestID,
+		CallerRef:     decision.CallerRef,
+		PolicySet:     decision.PolicySet,
+		PolicyVersion: decision.PolicyVersion,
+		Decision:      decision.Decision,
+		TimedOut:      decision.TimedOut,
+		StartedAt:     decision.StartedAt,
+		FinishedAt:    decision.FinishedAt,
+		TenantID:      policycraft.Tenant(ctx),
+	}
+	d.Input, err = json.Marshal(decision.Input)
+	if err != nil {
+		return fmt.Errorf("marshaling decision input: %v", err)
+	}
+	d.Policies, err = json.Marshal(decision.Policies)
+	if err != nil {
+		return fmt.Errorf("marshaling decision policies: %v", err)
+	}
+	d.Trace, err = json.Marshal(decision.Trace)
+	if err != nil {
+		return fmt.Errorf("marshaling decision trace: %v", err)
+	}
+	enrichments := decision.Enrichments
+	if enrichments == nil {
+		enrichments = []policycraft.Enrichment{}
+	}
+	d.Enrichments, err = json.Marshal(enrichments)
+	if err != nil {
+		return fmt.Errorf("marshaling decision enrichments: %v", err)
+	}
+
+	_, err = s.db.NamedExecContext(ctx, `
+		INSERT INTO decisions (tenant_id, id, request_id, caller_ref, policy_set, input, policy_version, policies, decision, trace, enrichments, timed_out, started_at, finished_at)
+		VALUES (:tenant_id, :id, :request_id, :caller_ref, :policy_set, :input, :policy_version, :policies, :decision, :trace, :enrichments, :timed_out, :started_at, :finished_at)
+	`, d)
+	return err
+}
+
+// Decision returns the decision of the context tenant with the given id. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) Decision(ctx context.Context, id string) (policycraft.Decision, error) {
+	UUID, err := uuid.Parse(id)
+	if err != nil {
+		return policycraft.Decision{}, policycraft.ErrNotFound
+	}
+
+	var d Decision
+	err = s.db.GetContext(ctx, &d, "SELECT * FROM decisions WHERE tenant_id = $1 AND id = $2", policycraft.Tenant(ctx), UUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return policycraft.Decision{}, policycraft.ErrNotFound
+	}
+	if err != nil {
+		return policycraft.Decision{}, err
+	}
+	return d.decision()
+}
+
+// Decisions returns the decisions of the context tenant that match the filter, the most recent first.
+func (s *Storage) Decisions(ctx context.Context, filter policycraft.DecisionFilter) ([]policycraft.Decision, error) {
+	conditions := []string{"tenant_id = $1"}
+	args := []interface{}{policycraft.Tenant(ctx)}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("started_at >= $%d", len(args)))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("started_at < $%d", len(args)))
+	}
+	if filter.Decision != nil {
+		args = append(args, *filter.Decision)
+		conditions = append(conditions, fmt.Sprintf("decision = $%d", len(args)))
+	}
+	if filter.CallerRef != "" {
+		args = append(args, filter.CallerRef)
+		conditions = append(conditions, fmt.Sprintf("caller_ref = $%d", len(args)))
+	}
+	if filter.PolicySet != "" {
+		args = append(args, filter.PolicySet)
+		conditions = append(conditions, fmt.Sprintf("policy_set = $%d", len(args)))
+	}
+
+	query := "SELECT * FROM decisions WHERE " + strings.Join(conditions, " AND ") + " ORDER BY started_at DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	var rows []Decision
+	err := s.db.SelectContext(ctx, &rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := make([]policycraft.Decision, 0, len(rows))
+	for _, row := range rows {
+		d, err := row.decision()
+		if err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions, nil
+}
+
+// decision converts the database representation to the business entity.
+func (d Decision) decision() (policycraft.Decision, error) {
+	decision := policycraft.Decision{
+		ID:            d.ID.String(),
+		RequestID:     d.RequestID,
+		CallerRef:     d.CallerRef,
+		PolicySet:     d.PolicySet,
+		PolicyVersion: d.PolicyVersion,
+		Decision:      d.Decision,
+		TimedOut:      d.TimedOut,
+		StartedAt:     d.StartedAt,
+		FinishedAt:    d.FinishedAt,
+	}
+	if err := json.Unmarshal(d.Input, &decision.Input); err != nil {
+		return policycraft.Decision{}, fmt.Errorf("unmarshaling decision input: %v", err)
+	}
+	if err := json.Unmarshal(d.Policies, &decision.Policies); err != nil {
+		return policycraft.Decision{}, fmt.Errorf("unmarshaling decision policies: %v", err)
+	}
+	if err := json.Unmarshal(d.Trace, &decision.Trace); err != nil {
+		return policycraft.Decision{}, fmt.Errorf("unmarshaling decision trace: %v", err)
+	}
+	if err := json.Unmarshal(d.Enrichments, &decision.Enrichments); err != nil {
+		return policycraft.Decision{}, fmt.Errorf("unmarshaling decision enrichments: %v", err)
+	}
+	if len(decision.Enrichments) == 0 {
+		decision.Enrichments = nil
+	}
+	return decision, nil
+}
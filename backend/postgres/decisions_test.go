@@ -0,0 +1,147 @@
+//go:build integration
+// +build integration
+
+package postgres_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/postgres"
+)
+
+func TestStorageDecisions(t *testing.T) {
+	db := OpenDB(t)
+	defer db.Close()
+
+	storage := postgres.NewStorage(db)
+	ctx := context.Background()
+	policies := []policycraft.Policy{
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1},
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	approved := policycraft.Decision{
+		ID:            uuid.NewString(),
+		RequestID:     "request-1",
+		CallerRef:     "proposal-1",
+		Input:         map[string]interface{}{"age": float64(18), "score": float64(700)},
+		Enrichments:   []policycraft.Enrichment{{Provider: "bureau", Field: "score", Value: float64(700), Source: policycraft.EnrichmentSourceProvider, Attempts: 1}},
+		PolicyVersion: policycraft.PoliciesVersion(policies),
+		Policies:      policies,
+		Decision:      true,
+		Trace:         []policycraft.Step{{PolicyID: policies[0].ID, Name: "age", Criteria: ">", Value: 17, Input: float64(18), Passed: true}},
+		StartedAt:     now.Add(-time.Hour),
+		FinishedAt:    now.Add(-time.Hour),
+	}
+	rejected := approved
+	rejected.ID = uuid.NewString()
+	rejected.RequestID = "request-2"
+	rejected.CallerRef = "proposal-2"
+	rejected.Input = map[string]interface{}{"age": float64(16)}
+	rejected.Decision = false
+	rejected.TimedOut = true
+	rejected.StartedAt = now
+	rejected.FinishedAt = now
+
+	for _, d := range []policycraft.Decision{approved, rejected} {
+		err := storage.SaveDecision(ctx, d)
+		if err != nil {
+			t.Fatalf("error saving decision: %v", err)
+		}
+	}
+
+	got, err := storage.Decision(ctx, approved.ID)
+	if err != nil {
+		t.Fatalf("error getting decision: %v", err)
+	}
+	assert(t, got.RequestID, approved.RequestID)
+	assert(t, got.CallerRef, approved.CallerRef)
+	assert(t, got.PolicyVersion, approved.PolicyVersion)
+	assert(t, got.Decision, approved.Decision)
+	assert(t, got.Input["age"], float64(18))
+	assert(t, len(got.Policies), 1)
+	assert(t, len(got.Trace), 1)
+	assert(t, len(got.Enrichments), 1)
+	assert(t, got.Enrichments[0].Value, float64(700))
+	assert(t, got.StartedAt.Equal(approved.StartedAt), true)
+
+	_, err = storage.Decision(ctx, uuid.NewString())
+	if !errors.Is(err, policycraft.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	all, err := storage.Decisions(ctx, policycraft.DecisionFilter{})
+	if err != nil {
+		t.Fatalf("error listing decisions: %v", err)
+	}
+	// the most recent decision comes first
+	if len(all) == 2 {
+		assert(t, all[0].ID, rejected.ID)
+		assert(t, all[1].ID, approved.ID)
+	} else {
+		t.Fatalf("expected 2 decisions, got %d", len(all))
+	}
+
+	decision := false
+	filtered, err := storage.Decisions(ctx, policycraft.DecisionFilter{Decision: &decision})
+	if err != nil {
+		t.Fatalf("error listing decisions: %v", err)
+	}
+	if len(filtered) != 1 || filtered[0].ID != rejected.ID {
+		t.Fatalf("expected only the rejected decision, got %v", filtered)
+	}
+	assert(t, filtered[0].TimedOut, true)
+
+	filtered, err = storage.Decisions(ctx, policycraft.DecisionFilter{CallerRef: "proposal-1"})
+	if err != nil {
+		t.Fatalf("error listing decisions: %v", err)
+	}
+	if len(filtered) != 1 || filtered[0].ID != approved.ID {
+		t.Fatalf("expected only the approved decision, got %v", filtered)
+	}
+
+	filtered, err = storage.Decisions(ctx, policycraft.DecisionFilter{From: now.Add(-time.Minute), To: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("error listing decisions: %v", err)
+	}
+	if len(filtered) != 1 || filtered[0].ID != rejected.ID {
+		t.Fatalf("expected only the rejected decision, got %v", filtered)
+	}
+}
+
+func TestStorageDecisionPolicyVersion(t *testing.T) {
+	db := OpenDB(t)
+	defer db.Close()
+
+	storage := postgres.NewStorage(db)
+	ctx := context.Background()
+	policies := []policycraft.Policy{
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1},
+	}
+
+	// the version is prefixed by its form, so it's longer than the sha256 in hex
+	now := time.Now().UTC()
+	decision := policycraft.Decision{
+		ID:            uuid.NewString(),
+		RequestID:     "request-1",
+		Input:         map[string]interface{}{"age": float64(18)},
+		PolicyVersion: policycraft.PoliciesVersion(policies),
+		Policies:      policies,
+		Decision:      true,
+		StartedAt:     now,
+		FinishedAt:    now,
+	}
+	if err := storage.SaveDecision(ctx, decision); err != nil {
+		t.Fatalf("error saving decision: %v", err)
+	}
+	got, err := storage.Decision(ctx, decision.ID)
+	if err != nil {
+		t.Fatalf("error getting decision: %v", err)
+	}
+	assert(t, got.PolicyVersion, decision.PolicyVersion)
+}
@@ -0,0 +1,170 @@
+// Package postgres ...
+// history.go gather all the database operations related to the history of the policies
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/perebaj/policycraft"
+)
+
+// PolicyRevision is the struct that represents a revision of a policy in the database.
+type PolicyRevision struct {
+	// TenantID is the tenant that owns the policy.
+	TenantID string `db:"tenant_id"`
+	// PolicyID is the policy changed.
+	PolicyID string `db:"policy_id"`
+	// Revision numbers the changes of the policy.
+	Revision int `db:"revision"`
+	// Action is the kind of change.
+	Action string `db:"action"`
+	// Author is who made the change.
+	Author string `db:"author"`
+	// Reason is why the change was made.
+	Reason string `db:"reason"`
+	// Changes is the JSON encoded list of changed fields.
+	Changes []byte `db:"changes"`
+	// Policy is the JSON encoded policy after the change.
+	Policy []byte `db:"policy"`
+	// CreatedAt is the time of the change.
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// recordHistory runs write, which changes the policies with the given ids inside tx, and records a revision of each policy it changed.
+// The policies are locked until the end of tx, so the revisions of concurrent changes are recorded in order, and the change is refused
+// if a policy isn't at the base revision of the policycraft.ChangeInfo of the context.
+func recordHistory(ctx context.Context, tx *sqlx.Tx, ids []string, write func() error) error {
+	before, err := policiesByID(ctx, tx, ids, true)
+	if err != nil {
+		return fmt.Errorf("getting policies before the change: %v", err)
+	}
+	if info := policycraft.ChangeInfoFromContext(ctx); info.HasBaseRevisions() {
+		for _, id := range ids {
+			last, err := lastRevision(ctx, tx, id)
+			if err != nil {
+				return fmt.Errorf("getting the last revision of policy %s: %v", id, err)
+			}
+			if err := info.CheckBaseRevision(id, last); err != nil {
+				return err
+			}
+		}
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	after, err := policiesByID(ctx, tx, ids, false)
+	if err != nil {
+		return fmt.Errorf("getting policies after the change: %v", err)
+	}
+
+	now := time.Now().UTC()
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		rev, ok := policycraft.NewPolicyRevision(ctx, before[id], after[id], now)
+		if !ok {
+			continue
+		}
+		if err := insertRevision(ctx, tx, rev); err != nil {
+			return fmt.Errorf("recording the history of policy %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// policiesByID returns the policies of the context tenant with the given ids, except the deleted ones. When lock is true, the rows are
+// locked until the end of tx.
+func policiesByID(ctx context.Context, tx *sqlx.Tx, ids []string, lock bool) (map[string]*policycraft.Policy, error) {
+	query := "SELECT " + policyColumns + " FROM policies WHERE tenant_id = $1 AND id::text = ANY($2) AND deleted_at IS NULL"
+	if lock {
+		query += " FOR UPDATE"
+	}
+	var policies []policycraft.Policy
+	err := tx.SelectContext(ctx, &policies, query, policycraft.Tenant(ctx), pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*policycraft.Policy, len(policies))
+	for i := range policies {
+		byID[policies[i].ID] = &policies[i]
+	}
+	return byID, nil
+}
+
+// insertRevision inserts the revision, numbered after the last one of the policy.
+func insertRevision(ctx context.Context, tx *sqlx.Tx, rev policycraft.PolicyRevision) error {
+	changes, err := json.Marshal(rev.Changes)
+	if err != nil {
+		return fmt.Errorf("marshaling changes: %v", err)
+	}
+	policy, err := json.Marshal(rev.Policy)
+	if err != nil {
+		return fmt.Errorf("marshaling policy: %v", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO policy_history (tenant_id, policy_id, revision, action, author, reason, changes, policy, created_at)
+		VALUES ($1, $2, (SELECT COALESCE(MAX(revision), 0) + 1 FROM policy_history WHERE tenant_id = $1 AND policy_id = $2), $3, $4, $5, $6, $7, $8)
+	`, policycraft.Tenant(ctx), rev.PolicyID, string(rev.Action), rev.Author, rev.Reason, changes, policy, rev.CreatedAt)
+	return err
+}
+
+// LastPolicyRevision returns the number of the last revision of the policy of the context tenant with the given id, 0 if it has no
+// history.
+func (s *Storage) LastPolicyRevision(ctx context.Context, id string) (int, error) {
+	return lastRevision(ctx, s.db, id)
+}
+
+// lastRevision is shared by LastPolicyRevision and recordHistory.
+func lastRevision(ctx context.Context, db sqlx.QueryerContext, id string) (int, error) {
+	var last int
+	err := sqlx.GetContext(ctx, db, &last, "SELECT COALESCE(MAX(revision), 0) FROM policy_history WHERE tenant_id = $1 AND policy_id::text = $2",
+		policycraft.Tenant(ctx), id)
+	return last, err
+}
+
+// PolicyHistory returns the revisions of the policy of the context tenant with the given id, the oldest first.
+// A policy without history, or that doesn't exist, has no revisions.
+func (s *Storage) PolicyHistory(ctx context.Context, id string) ([]policycraft.PolicyRevision, error) {
+	var rows []PolicyRevision
+	err := s.db.SelectContext(ctx, &rows, "SELECT * FROM policy_history WHERE tenant_id = $1 AND policy_id::text = $2 ORDER BY revision ASC",
+		policycraft.Tenant(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+	var revs []policycraft.PolicyRevision
+	for _, row := range rows {
+		rev, err := row.revision()
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	return revs, nil
+}
+
+// revision converts the database representation to the business entity.
+func (r PolicyRevision) revision() (policycraft.PolicyRevision, error) {
+	rev := policycraft.PolicyRevision{
+		PolicyID:  r.PolicyID,
+		Revision:  r.Revision,
+		Action:    policycraft.PolicyAction(r.Action),
+		Author:    r.Author,
+		Reason:    r.Reason,
+		CreatedAt: r.CreatedAt,
+	}
+	if err := json.Unmarshal(r.Changes, &rev.Changes); err != nil {
+		return policycraft.PolicyRevision{}, fmt.Errorf("unmarshaling revision changes: %v", err)
+	}
+	if err := json.Unmarshal(r.Policy, &rev.Policy); err != nil {
+		return policycraft.PolicyRevision{}, fmt.Errorf("unmarshaling revision policy: %v", err)
+	}
+	return rev, nil
+}
@@ -0,0 +1,57 @@
+// Package postgres ...
+// listener.go gather the notifications of the changes of the policies and policy sets
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PolicyChangesChannel is the channel notified by the triggers of the policies and policy_sets tables.
+const PolicyChangesChannel = "policy_changes"
+
+// ListenPolicyChanges listens to the changes of the policies and policy sets, made by this or any other replica.
+// The returned channel receives a value after every change. The changes are coalesced: while a value isn't received,
+// the next changes don't send another one. After a reconnection a value is sent too, because the notifications sent
+// while disconnected are lost. The channel is closed when the context is done.
+func ListenPolicyChanges(ctx context.Context, url string) (<-chan struct{}, error) {
+	listener := pq.NewListener(url, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Error("policy changes listener", "event", event, "error", err)
+		}
+	})
+	if err := listener.Listen(PolicyChangesChannel); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("listening to %s: %v", PolicyChangesChannel, err)
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		defer func() { _ = listener.Close() }()
+
+		// The connection is checked from time to time, so a broken one is noticed even without notifications.
+		ping := time.NewTicker(90 * time.Second)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-listener.Notify:
+				// a nil notification means the connection was reestablished
+			case <-ping.C:
+				go func() { _ = listener.Ping() }()
+				continue
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes, nil
+}
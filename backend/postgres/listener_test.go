@@ -0,0 +1,75 @@
+//go:build integration
+// +build integration
+
+package postgres_test
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/postgres"
+)
+
+func TestListenPolicyChanges(t *testing.T) {
+	db := OpenDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the listener connects to the database created for the test
+	var database string
+	err := db.Get(&database, "SELECT current_database()")
+	if err != nil {
+		t.Fatalf("error getting the database name: %v", err)
+	}
+	u, err := url.Parse(os.Getenv("POLICY_CRAFT_POSTGRES_URL"))
+	if err != nil {
+		t.Fatalf("error parsing Postgres connection URL: %v", err)
+	}
+	u.Path = "/" + database
+
+	changes, err := postgres.ListenPolicyChanges(ctx, u.String())
+	if err != nil {
+		t.Fatalf("error listening to policy changes: %v", err)
+	}
+
+	storage := postgres.NewStorage(db)
+	wait := func(what string) {
+		t.Helper()
+		select {
+		case <-changes:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected a notification after %s", what)
+		}
+	}
+
+	err = storage.SavePolicy(ctx, policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, Priority: 1, PolicySet: "credit"})
+	if err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	wait("saving a policy")
+
+	err = storage.ImportBundle(ctx, policycraft.NewBundle(policycraft.PolicySet{Name: "credit"}, nil))
+	if err != nil {
+		t.Fatalf("error importing bundle: %v", err)
+	}
+	wait("importing a bundle")
+
+	// the import sends a notification per statement, so some may still be pending when the channel is closed
+	cancel()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("expected the channel to be closed")
+		}
+	}
+}
@@ -0,0 +1,16 @@
+CREATE TABLE decisions (
+  id UUID PRIMARY KEY,
+  request_id VARCHAR(255) NOT NULL,
+  caller_ref VARCHAR(255) NOT NULL DEFAULT '',
+  input JSONB NOT NULL,
+  policy_version VARCHAR(64) NOT NULL,
+  policies JSONB NOT NULL,
+  decision BOOLEAN NOT NULL,
+  trace JSONB NOT NULL,
+  started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  finished_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- The decisions are queried by time range and caller reference
+CREATE INDEX decisions_started_at_idx ON decisions (started_at);
+CREATE INDEX decisions_caller_ref_idx ON decisions (caller_ref);
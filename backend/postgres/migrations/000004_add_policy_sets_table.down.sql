@@ -0,0 +1,4 @@
+ALTER TABLE decisions DROP COLUMN policy_set;
+DROP INDEX policies_policy_set_priority_idx;
+ALTER TABLE policies DROP COLUMN policy_set;
+DROP TABLE policy_sets;
@@ -0,0 +1,19 @@
+CREATE TABLE policy_sets (
+  name VARCHAR(255) PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT '',
+  input_schema JSONB NOT NULL DEFAULT '{}',
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+CREATE TRIGGER policy_sets_updated_at_trigger
+    BEFORE UPDATE
+    ON
+        policy_sets
+    FOR EACH ROW
+EXECUTE PROCEDURE updated_at_procedure();
+
+-- The policies that already exist belong to the default policy set
+ALTER TABLE policies ADD COLUMN policy_set VARCHAR(255) NOT NULL DEFAULT 'default';
+CREATE INDEX policies_policy_set_priority_idx ON policies (policy_set, priority);
+
+ALTER TABLE decisions ADD COLUMN policy_set VARCHAR(255) NOT NULL DEFAULT 'default';
@@ -0,0 +1,4 @@
+ALTER TABLE decisions DROP COLUMN timed_out;
+
+ALTER TABLE policy_sets DROP COLUMN fail_mode;
+ALTER TABLE policy_sets DROP COLUMN timeout_ms;
@@ -0,0 +1,4 @@
+ALTER TABLE policy_sets ADD COLUMN timeout_ms INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE policy_sets ADD COLUMN fail_mode VARCHAR(16) NOT NULL DEFAULT 'closed';
+
+ALTER TABLE decisions ADD COLUMN timed_out BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE policies ADD COLUMN function VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE policies ADD COLUMN type VARCHAR(32) NOT NULL DEFAULT 'criteria';
+ALTER TABLE policies ADD COLUMN script TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE decisions DROP COLUMN enrichments;
+
+ALTER TABLE policy_sets DROP COLUMN enrichments;
@@ -0,0 +1,3 @@
+ALTER TABLE policy_sets ADD COLUMN enrichments JSONB NOT NULL DEFAULT '[]';
+
+ALTER TABLE decisions ADD COLUMN enrichments JSONB NOT NULL DEFAULT '[]';
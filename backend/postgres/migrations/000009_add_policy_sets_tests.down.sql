@@ -0,0 +1 @@
+ALTER TABLE policy_sets DROP COLUMN tests;
@@ -0,0 +1 @@
+ALTER TABLE policy_sets ADD COLUMN tests JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS policy_sets_changes_trigger ON policy_sets;
+DROP TRIGGER IF EXISTS policies_changes_trigger ON policies;
+DROP FUNCTION IF EXISTS policy_changes_notify;
@@ -0,0 +1,24 @@
+-- Notify the policy_changes channel when the policies or the policy sets change, so every replica refreshes its cache.
+-- The triggers are per statement, so an import of many policies sends one notification, and postgres only delivers
+-- them when the transaction commits.
+CREATE FUNCTION policy_changes_notify()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('policy_changes', TG_TABLE_NAME);
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER policies_changes_trigger
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE
+    ON
+        policies
+    FOR EACH STATEMENT
+EXECUTE PROCEDURE policy_changes_notify();
+
+CREATE TRIGGER policy_sets_changes_trigger
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE
+    ON
+        policy_sets
+    FOR EACH STATEMENT
+EXECUTE PROCEDURE policy_changes_notify();
@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS decisions_tenant_caller_ref_idx;
+DROP INDEX IF EXISTS decisions_tenant_started_at_idx;
+CREATE INDEX decisions_caller_ref_idx ON decisions (caller_ref);
+CREATE INDEX decisions_started_at_idx ON decisions (started_at);
+ALTER TABLE decisions DROP COLUMN tenant_id;
+
+ALTER TABLE policy_sets DROP CONSTRAINT policy_sets_pkey;
+ALTER TABLE policy_sets ADD PRIMARY KEY (name);
+ALTER TABLE policy_sets DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS policies_tenant_policy_set_priority_idx;
+CREATE INDEX policies_policy_set_priority_idx ON policies (policy_set, priority);
+ALTER TABLE policies DROP CONSTRAINT policies_pkey;
+ALTER TABLE policies ADD PRIMARY KEY (id);
+ALTER TABLE policies DROP COLUMN tenant_id;
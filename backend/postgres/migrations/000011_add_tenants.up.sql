@@ -0,0 +1,17 @@
+-- Every entity belongs to a tenant. The entities that already exist belong to the default tenant.
+-- The ids and names are unique by tenant, so a tenant can't overwrite the entities of another one.
+ALTER TABLE policies ADD COLUMN tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE policies DROP CONSTRAINT policies_pkey;
+ALTER TABLE policies ADD PRIMARY KEY (tenant_id, id);
+DROP INDEX policies_policy_set_priority_idx;
+CREATE INDEX policies_tenant_policy_set_priority_idx ON policies (tenant_id, policy_set, priority);
+
+ALTER TABLE policy_sets ADD COLUMN tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE policy_sets DROP CONSTRAINT policy_sets_pkey;
+ALTER TABLE policy_sets ADD PRIMARY KEY (tenant_id, name);
+
+ALTER TABLE decisions ADD COLUMN tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+DROP INDEX decisions_started_at_idx;
+DROP INDEX decisions_caller_ref_idx;
+CREATE INDEX decisions_tenant_started_at_idx ON decisions (tenant_id, started_at);
+CREATE INDEX decisions_tenant_caller_ref_idx ON decisions (tenant_id, caller_ref);
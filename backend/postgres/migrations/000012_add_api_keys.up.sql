@@ -0,0 +1,13 @@
+-- The API keys are looked up by the hash of the secret, whatever the tenant, because the key tells the tenant of the request.
+CREATE TABLE api_keys (
+  id UUID PRIMARY KEY,
+  tenant_id VARCHAR(63) NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  roles TEXT[] NOT NULL,
+  hash CHAR(64) NOT NULL UNIQUE,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  expires_at TIMESTAMP WITH TIME ZONE,
+  revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX api_keys_tenant_created_at_idx ON api_keys (tenant_id, created_at);
@@ -0,0 +1,19 @@
+-- The change requests keep the proposed policies until they are approved and published.
+-- version is compared on every update, so a concurrent change is refused instead of lost.
+CREATE TABLE change_requests (
+  tenant_id VARCHAR(63) NOT NULL,
+  id UUID NOT NULL,
+  title VARCHAR(255) NOT NULL,
+  author VARCHAR(255) NOT NULL,
+  status VARCHAR(16) NOT NULL,
+  policies JSONB NOT NULL,
+  comments JSONB NOT NULL,
+  approved_by VARCHAR(255) NOT NULL DEFAULT '',
+  published_by VARCHAR(255) NOT NULL DEFAULT '',
+  version INTEGER NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  PRIMARY KEY (tenant_id, id)
+);
+
+CREATE INDEX change_requests_tenant_status_created_at_idx ON change_requests (tenant_id, status, created_at);
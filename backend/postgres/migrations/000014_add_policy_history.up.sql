@@ -0,0 +1,14 @@
+-- The history of the policies keeps a revision for every change, with the changed fields and the policy after the change.
+-- policy_id isn't a foreign key, because the history of a deleted policy is kept.
+CREATE TABLE policy_history (
+  tenant_id VARCHAR(63) NOT NULL,
+  policy_id UUID NOT NULL,
+  revision INTEGER NOT NULL,
+  action VARCHAR(16) NOT NULL,
+  author VARCHAR(255) NOT NULL,
+  reason TEXT NOT NULL,
+  changes JSONB NOT NULL,
+  policy JSONB NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  PRIMARY KEY (tenant_id, policy_id, revision)
+);
@@ -0,0 +1,2 @@
+-- The deleted policies are kept, so they can be restored. Every query of the active policies filters deleted_at IS NULL.
+ALTER TABLE policies ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+ALTER TABLE change_requests DROP COLUMN IF EXISTS patches;
+ALTER TABLE change_requests DROP COLUMN IF EXISTS base_revisions;
@@ -0,0 +1,5 @@
+-- base_revisions keeps the last revision of each proposed policy when it was proposed, so a change request published over
+-- a later revision is refused. patches keeps the partial updates, so the reviewers see the fields they change.
+-- patches is JSON, not JSONB, so the partial updates are returned as they were saved.
+ALTER TABLE change_requests ADD COLUMN base_revisions JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE change_requests ADD COLUMN patches JSON NOT NULL DEFAULT '{}';
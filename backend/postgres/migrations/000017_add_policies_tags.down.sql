@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS policies_tags_idx;
+ALTER TABLE policies DROP COLUMN IF EXISTS tags;
@@ -0,0 +1,3 @@
+-- The tags label the policies, so they can be found by team or regulation. The GIN index serves the containment of the tag filter.
+ALTER TABLE policies ADD COLUMN tags JSONB NOT NULL DEFAULT '[]';
+CREATE INDEX policies_tags_idx ON policies USING GIN (tags);
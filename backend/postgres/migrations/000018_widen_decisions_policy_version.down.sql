@@ -0,0 +1 @@
+ALTER TABLE decisions ALTER COLUMN policy_version TYPE VARCHAR(64) USING right(policy_version, 64);
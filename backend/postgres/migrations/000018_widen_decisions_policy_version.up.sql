@@ -0,0 +1,2 @@
+-- The policy versions are prefixed by the version of their form, such as "v1:", so they no longer fit in 64 characters.
+ALTER TABLE decisions ALTER COLUMN policy_version TYPE TEXT;
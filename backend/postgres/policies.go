@@ -3,6 +3,11 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -30,29 +35,293 @@ type Policy struct {
 	Name string `json:"name" db:"name"`
 	// Criteria is the criteria that the policy will use to compare the value.
 	Criteria string `json:"criteria" db:"criteria"`
+	// Function is the function applied to the custom field before the comparison.
+	Function string `json:"function" db:"function"`
 	// Value is the value that the policy will use to compare.
 	Value int `json:"value" db:"value"`
 	// SuccessCase is the boolean that will be used to compare the result of the policy
 	SuccessCase bool `json:"success_case" db:"success_case"`
 	// Priority is the priority of the policy. The lower the number, the higher the priority.
 	Priority int `json:"priority" db:"priority"`
+	// Type is the type of the policy condition.
+	Type string `json:"type" db:"type"`
+	// Script is the Starlark script of starlark policies.
+	Script string `json:"script" db:"script"`
+	// PolicySet is the name of the policy set that the policy belongs to.
+	PolicySet string `json:"policy_set" db:"policy_set"`
+	// Tags are the JSON encoded labels of the policy.
+	Tags policycraft.Tags `json:"tags" db:"tags"`
+	// TenantID is the tenant that owns the policy.
+	TenantID string `json:"tenant_id" db:"tenant_id"`
 	// UpdatedAt is the time when the policy was updated.
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
 
-// SavePolicy save a policy in the database. If the policy already exists, it will be updated.
-func (s *Storage) SavePolicy(policy policycraft.Policy) error {
-	_, err := s.db.NamedExec(`
-		INSERT INTO policies (id, name, criteria, value, success_case, priority) VALUES (:id, :name, :criteria, :value, :success_case, :priority)
-		ON CONFLICT (id) DO UPDATE SET name = :name, criteria = :criteria, value = :value
-	`, policy)
+// SavePolicy save a policy of the context tenant in the database. If the policy already exists, every field is replaced, and if it
+// was deleted, it's created again. The change is recorded in the history of the policy.
+func (s *Storage) SavePolicy(ctx context.Context, policy policycraft.Policy) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = recordHistory(ctx, tx, []string{policy.ID}, func() error {
+		return savePolicy(ctx, tx, policy)
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// savePolicy is shared by SavePolicy and ImportBundle, that saves the policies inside a transaction.
+func savePolicy(ctx context.Context, db sqlx.ExtContext, policy policycraft.Policy) error {
+	if policy.Type == "" {
+		policy.Type = policycraft.PolicyTypeCriteria
+	}
+	// A deleted policy saved again is created again, instead of updating the deleted one.
+	_, err := sqlx.NamedExecContext(ctx, db, "DELETE FROM policies WHERE tenant_id = :tenant_id AND id = :id AND deleted_at IS NOT NULL",
+		tenantPolicy{Policy: policy, TenantID: policycraft.Tenant(ctx)})
+	if err != nil {
+		return err
+	}
+	_, err = sqlx.NamedExecContext(ctx, db, `
+		INSERT INTO policies (tenant_id, id, name, criteria, function, value, success_case, priority, type, script, policy_set, tags)
+		VALUES (:tenant_id, :id, :name, :criteria, :function, :value, :success_case, :priority, :type, :script, :policy_set, :tags)
+		ON CONFLICT (tenant_id, id) DO UPDATE SET name = :name, criteria = :criteria, function = :function, value = :value,
+			success_case = :success_case, priority = :priority, type = :type, script = :script, policy_set = :policy_set, tags = :tags
+	`, tenantPolicy{Policy: policy, TenantID: policycraft.Tenant(ctx)})
 
 	return err
 }
 
-// Policies returns all the policies in the database.
-func (s *Storage) Policies() ([]policycraft.Policy, error) {
+// policyColumns are the columns of the business entity policycraft.Policy.
+const policyColumns = "id, name, criteria, function, value, success_case, priority, type, script, policy_set, tags"
+
+// tenantPolicy adds the tenant to the named parameters of a policy.
+type tenantPolicy struct {
+	policycraft.Policy
+	TenantID string `db:"tenant_id"`
+}
+
+// Policies returns all the policies of the context tenant. The order follows policycraft.SortPolicies.
+func (s *Storage) Policies(ctx context.Context) ([]policycraft.Policy, error) {
+	var policies []policycraft.Policy
+	err := s.db.SelectContext(ctx, &policies, "SELECT "+policyColumns+" FROM policies WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY priority ASC, name COLLATE \"C\" ASC, id ASC", policycraft.Tenant(ctx))
+	return policies, err
+}
+
+// PoliciesBySet returns the policies of the given policy set of the context tenant. The order follows policycraft.SortPolicies.
+func (s *Storage) PoliciesBySet(ctx context.Context, name string) ([]policycraft.Policy, error) {
 	var policies []policycraft.Policy
-	err := s.db.Select(&policies, "SELECT id, name, criteria, value, success_case, priority FROM policies ORDER BY priority ASC")
+	err := s.db.SelectContext(ctx, &policies, "SELECT "+policyColumns+" FROM policies WHERE tenant_id = $1 AND policy_set = $2 AND deleted_at IS NULL ORDER BY priority ASC, name COLLATE \"C\" ASC, id ASC", policycraft.Tenant(ctx), name)
 	return policies, err
 }
+
+// Policy returns the policy of the context tenant with the given id. If it doesn't exist or was deleted, policycraft.ErrNotFound is returned.
+func (s *Storage) Policy(ctx context.Context, id string) (policycraft.Policy, error) {
+	return s.policy(ctx, id, false)
+}
+
+// DeletedPolicy returns the deleted policy of the context tenant with the given id. If it doesn't exist or wasn't deleted,
+// policycraft.ErrNotFound is returned.
+func (s *Storage) DeletedPolicy(ctx context.Context, id string) (policycraft.Policy, error) {
+	return s.policy(ctx, id, true)
+}
+
+func (s *Storage) policy(ctx context.Context, id string, deleted bool) (policycraft.Policy, error) {
+	UUID, err := uuid.Parse(id)
+	if err != nil {
+		return policycraft.Policy{}, policycraft.ErrNotFound
+	}
+	query := "SELECT " + policyColumns + " FROM policies WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL"
+	if deleted {
+		query = "SELECT " + policyColumns + " FROM policies WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NOT NULL"
+	}
+	var policy policycraft.Policy
+	err = s.db.GetContext(ctx, &policy, query, policycraft.Tenant(ctx), UUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return policycraft.Policy{}, policycraft.ErrNotFound
+	}
+	return policy, err
+}
+
+// listedPolicies selects the policies of the context tenant, except the deleted ones, with the time of their last change: the one of
+// their last revision or, for the policies without history, the last time their row was written.
+const listedPolicies = `
+	SELECT p.id, p.name, p.criteria, p.function, p.value, p.success_case, p.priority, p.type, p.script, p.policy_set, p.tags,
+		COALESCE((SELECT MAX(h.created_at) FROM policy_history h WHERE h.tenant_id = p.tenant_id AND h.policy_id = p.id), p.updated_at) AS updated_at
+	FROM policies p
+	WHERE p.tenant_id = $1 AND p.deleted_at IS NULL`
+
+// ListPolicies returns the page of the policies of the context tenant given by the filter. The total is counted by a second query,
+// so a change between them may make it differ from the policies listed.
+func (s *Storage) ListPolicies(ctx context.Context, filter policycraft.PolicyFilter) (policycraft.PolicyPage, error) {
+	var conditions []string
+	args := []interface{}{policycraft.Tenant(ctx)}
+	if filter.PolicySet != "" {
+		args = append(args, filter.PolicySet)
+		conditions = append(conditions, fmt.Sprintf("policy_set = $%d", len(args)))
+	}
+	if filter.Name != "" {
+		args = append(args, filter.Name)
+		conditions = append(conditions, fmt.Sprintf("strpos(lower(name), lower($%d)) > 0", len(args)))
+	}
+	if filter.Criteria != "" {
+		args = append(args, filter.Criteria)
+		conditions = append(conditions, fmt.Sprintf("criteria = $%d", len(args)))
+	}
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		conditions = append(conditions, fmt.Sprintf("tags @> jsonb_build_array($%d::text)", len(args)))
+	}
+	if !filter.UpdatedFrom.IsZero() {
+		args = append(args, filter.UpdatedFrom)
+		conditions = append(conditions, fmt.Sprintf("updated_at >= $%d", len(args)))
+	}
+	if !filter.UpdatedTo.IsZero() {
+		args = append(args, filter.UpdatedTo)
+		conditions = append(conditions, fmt.Sprintf("updated_at < $%d", len(args)))
+	}
+	where := func(conditions []string) string {
+		if len(conditions) == 0 {
+			return ""
+		}
+		return " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var page policycraft.PolicyPage
+	err := s.db.GetContext(ctx, &page.Total, "SELECT COUNT(*) FROM ("+listedPolicies+") AS listed"+where(conditions), args...)
+	if err != nil {
+		return policycraft.PolicyPage{}, fmt.Errorf("counting policies: %v", err)
+	}
+
+	// The names are compared in byte order, as SortPolicies does, and the ties broken by the id.
+	field, desc := filter.Sort.Field()
+	var keys []string
+	switch field {
+	case policycraft.PolicySortPriority:
+		keys = []string{"priority", `name COLLATE "C"`}
+	case policycraft.PolicySortName:
+		keys = []string{`name COLLATE "C"`}
+	case policycraft.PolicySortUpdatedAt:
+		keys = []string{"updated_at"}
+	}
+	keys = append(keys, "id")
+
+	if filter.Cursor != "" {
+		after, err := policycraft.DecodePolicyCursor(filter.Sort, filter.Cursor)
+		if err != nil {
+			return policycraft.PolicyPage{}, err
+		}
+		if _, err := uuid.Parse(after.ID); err != nil {
+			return policycraft.PolicyPage{}, policycraft.ErrInvalidCursor
+		}
+		var values []interface{}
+		switch field {
+		case policycraft.PolicySortPriority:
+			values = []interface{}{after.Priority, after.Name}
+		case policycraft.PolicySortName:
+			values = []interface{}{after.Name}
+		case policycraft.PolicySortUpdatedAt:
+			values = []interface{}{after.UpdatedAt}
+		}
+		var params []string
+		for _, v := range values {
+			args = append(args, v)
+			params = append(params, fmt.Sprintf("$%d", len(args)))
+		}
+		args = append(args, after.ID)
+		params = append(params, fmt.Sprintf("$%d::uuid", len(args)))
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s) %s (%s)", strings.Join(keys, ", "), op, strings.Join(params, ", ")))
+	}
+
+	order := " ASC"
+	if desc {
+		order = " DESC"
+	}
+	query := "SELECT * FROM (" + listedPolicies + ") AS listed" + where(conditions) + " ORDER BY " + strings.Join(keys, order+", ") + order
+	if filter.Limit > 0 {
+		// one more policy tells if there's a next page
+		args = append(args, filter.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	err = s.db.SelectContext(ctx, &page.Policies, query, args...)
+	if err != nil {
+		return policycraft.PolicyPage{}, err
+	}
+	if filter.Limit > 0 && len(page.Policies) > filter.Limit {
+		page.Policies = page.Policies[:filter.Limit]
+		page.Next = policycraft.EncodePolicyCursor(filter.Sort, page.Policies[filter.Limit-1])
+	}
+	return page, nil
+}
+
+// UpdatePolicy replaces every field of the existing policy of the context tenant. If it doesn't exist or was deleted,
+// policycraft.ErrNotFound is returned. The change is recorded in the history of the policy.
+func (s *Storage) UpdatePolicy(ctx context.Context, policy policycraft.Policy) error {
+	if policy.Type == "" {
+		policy.Type = policycraft.PolicyTypeCriteria
+	}
+	return s.changePolicy(ctx, policy.ID, func(tx *sqlx.Tx) (sql.Result, error) {
+		return sqlx.NamedExecContext(ctx, tx, `
+			UPDATE policies
+			SET name = :name, criteria = :criteria, function = :function, value = :value, success_case = :success_case, priority = :priority,
+				type = :type, script = :script, policy_set = :policy_set, tags = :tags
+			WHERE tenant_id = :tenant_id AND id = :id AND deleted_at IS NULL
+		`, tenantPolicy{Policy: policy, TenantID: policycraft.Tenant(ctx)})
+	})
+}
+
+// DeletePolicy deletes the policy of the context tenant with the given id. The policy is kept, so it can be restored by RestorePolicy.
+// If it doesn't exist or was already deleted, policycraft.ErrNotFound is returned. The change is recorded in the history of the policy.
+func (s *Storage) DeletePolicy(ctx context.Context, id string) error {
+	return s.changePolicy(ctx, id, func(tx *sqlx.Tx) (sql.Result, error) {
+		return tx.ExecContext(ctx, "UPDATE policies SET deleted_at = now() WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL", policycraft.Tenant(ctx), id)
+	})
+}
+
+// RestorePolicy restores the deleted policy of the context tenant with the given id. If it doesn't exist or wasn't deleted,
+// policycraft.ErrNotFound is returned. The change is recorded in the history of the policy.
+func (s *Storage) RestorePolicy(ctx context.Context, id string) error {
+	return s.changePolicy(ctx, id, func(tx *sqlx.Tx) (sql.Result, error) {
+		return tx.ExecContext(ctx, "UPDATE policies SET deleted_at = NULL WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NOT NULL", policycraft.Tenant(ctx), id)
+	})
+}
+
+// changePolicy runs the statement that changes a single policy in a transaction, recording the change in the history of the policy.
+// If the statement doesn't change any row, policycraft.ErrNotFound is returned.
+func (s *Storage) changePolicy(ctx context.Context, id string, change func(tx *sqlx.Tx) (sql.Result, error)) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return policycraft.ErrNotFound
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = recordHistory(ctx, tx, []string{id}, func() error {
+		res, err := change(tx)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return policycraft.ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
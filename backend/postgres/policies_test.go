@@ -84,6 +84,7 @@ func TestStorageSavePolicy(t *testing.T) {
 	defer db.Close()
 
 	storage := postgres.NewStorage(db)
+	ctx := context.Background()
 	stringUUID := uuid.NewString()
 	policy := policycraft.Policy{
 		ID:          stringUUID,
@@ -94,7 +95,7 @@ func TestStorageSavePolicy(t *testing.T) {
 		Priority:    1,
 	}
 
-	err := storage.SavePolicy(policy)
+	err := storage.SavePolicy(ctx, policy)
 	if err != nil {
 		t.Fatalf("error saving policy: %v", err)
 	}
@@ -116,6 +117,7 @@ func TestStorageSavePolicy(t *testing.T) {
 		assert(t, got[0].Value, policy.Value)
 		assert(t, got[0].Priority, policy.Priority)
 		assert(t, got[0].SuccessCase, policy.SuccessCase)
+		assert(t, got[0].TenantID, policycraft.DefaultTenant)
 	} else {
 		t.Fatalf("expected 1 policy, got %d", len(got))
 
@@ -125,12 +127,13 @@ func TestStorageSavePolicy(t *testing.T) {
 		ID:          stringUUID,
 		Name:        "policy 1",
 		Criteria:    "<",
+		Function:    "len",
 		Value:       2,
 		SuccessCase: true,
 		Priority:    1,
 	}
 
-	err = storage.SavePolicy(policy2)
+	err = storage.SavePolicy(ctx, policy2)
 	if err != nil {
 		t.Fatalf("error saving policy: %v", err)
 	}
@@ -146,6 +149,7 @@ func TestStorageSavePolicy(t *testing.T) {
 		assert(t, got2[0].ID, UUID)
 		assert(t, got2[0].Name, policy2.Name)
 		assert(t, got2[0].Criteria, policy2.Criteria)
+		assert(t, got2[0].Function, policy2.Function)
 		assert(t, got2[0].Value, policy2.Value)
 		assert(t, got2[0].UpdatedAt.After(got[0].UpdatedAt), true)
 		assert(t, got2[0].Priority, policy2.Priority)
@@ -169,7 +173,8 @@ func TestStoragePolicies(t *testing.T) {
 	}
 
 	storage := postgres.NewStorage(db)
-	err := storage.SavePolicy(policy)
+	ctx := context.Background()
+	err := storage.SavePolicy(ctx, policy)
 	if err != nil {
 		t.Fatalf("error saving policy: %v", err)
 	}
@@ -183,12 +188,12 @@ func TestStoragePolicies(t *testing.T) {
 		Priority:    2,
 	}
 
-	err = storage.SavePolicy(policy2)
+	err = storage.SavePolicy(ctx, policy2)
 	if err != nil {
 		t.Fatalf("error saving policy: %v", err)
 	}
 
-	policies, err := storage.Policies()
+	policies, err := storage.Policies(ctx)
 	if err != nil {
 		t.Fatalf("error getting policies: %v", err)
 	}
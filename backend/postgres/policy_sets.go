@@ -0,0 +1,210 @@
+// Package postgres ...
+// policy_sets.go gather all the database operations related to the policy sets entity
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/perebaj/policycraft"
+)
+
+// PolicySet is the struct that represents the policy set entity in the database.
+type PolicySet struct {
+	// Name is the unique identifier of the policy set.
+	Name string `db:"name"`
+	// Description is a free text describing the policy set.
+	Description string `db:"description"`
+	// InputSchema is the JSON encoded input schema.
+	InputSchema []byte `db:"input_schema"`
+	// TimeoutMS is the evaluation deadline in milliseconds.
+	TimeoutMS int `db:"timeout_ms"`
+	// FailMode is the decision taken when the deadline is hit.
+	FailMode string `db:"fail_mode"`
+	// Enrichments is the JSON encoded list of enrichment providers.
+	Enrichments []byte `db:"enrichments"`
+	// Tests is the JSON encoded list of policy set tests.
+	Tests []byte `db:"tests"`
+	// TenantID is the tenant that owns the policy set.
+	TenantID string `db:"tenant_id"`
+	// UpdatedAt is the time when the policy set was updated.
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// SavePolicySet save a policy set of the context tenant in the database. If the policy set already exists, it will be updated.
+func (s *Storage) SavePolicySet(ctx context.Context, set policycraft.PolicySet) error {
+	return savePolicySet(ctx, s.db, set)
+}
+
+// savePolicySet is shared by SavePolicySet and ImportBundle, that saves the policy set inside a transaction.
+func savePolicySet(ctx context.Context, db sqlx.ExtContext, set policycraft.PolicySet) error {
+	schema, err := json.Marshal(set.InputSchema)
+	if err != nil {
+		return fmt.Errorf("marshaling input schema: %v", err)
+	}
+
+	enrichments := set.Enrichments
+	if enrichments == nil {
+		enrichments = []policycraft.EnrichmentProvider{}
+	}
+	providers, err := json.Marshal(enrichments)
+	if err != nil {
+		return fmt.Errorf("marshaling enrichments: %v", err)
+	}
+
+	tests := set.Tests
+	if tests == nil {
+		tests = []policycraft.PolicyTest{}
+	}
+	testsJSON, err := json.Marshal(tests)
+	if err != nil {
+		return fmt.Errorf("marshaling tests: %v", err)
+	}
+
+	failMode := set.FailMode
+	if failMode == "" {
+		failMode = policycraft.FailClosed
+	}
+
+	row := PolicySet{
+		Name:        set.Name,
+		Description: set.Description,
+		InputSchema: schema,
+		TimeoutMS:   set.TimeoutMS,
+		FailMode:    failMode,
+		Enrichments: providers,
+		Tests:       testsJSON,
+		TenantID:    policycraft.Tenant(ctx),
+	}
+	_, err = sqlx.NamedExecContext(ctx, db, `
+		INSERT INTO policy_sets (tenant_id, name, description, input_schema, timeout_ms, fail_mode, enrichments, tests)
+		VALUES (:tenant_id, :name, :description, :input_schema, :timeout_ms, :fail_mode, :enrichments, :tests)
+		ON CONFLICT (tenant_id, name) DO UPDATE SET description = :description, input_schema = :input_schema, timeout_ms = :timeout_ms, fail_mode = :fail_mode,
+			enrichments = :enrichments, tests = :tests
+	`, row)
+	return err
+}
+
+// PolicySet returns the policy set of the context tenant with the given name. If it doesn't exist, policycraft.ErrNotFound is returned.
+func (s *Storage) PolicySet(ctx context.Context, name string) (policycraft.PolicySet, error) {
+	var set PolicySet
+	err := s.db.GetContext(ctx, &set, "SELECT * FROM policy_sets WHERE tenant_id = $1 AND name = $2", policycraft.Tenant(ctx), name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return policycraft.PolicySet{}, policycraft.ErrNotFound
+	}
+	if err != nil {
+		return policycraft.PolicySet{}, err
+	}
+	return set.policySet()
+}
+
+// PolicySets returns all the policy sets of the context tenant ordered by name.
+func (s *Storage) PolicySets(ctx context.Context) ([]policycraft.PolicySet, error) {
+	var rows []PolicySet
+	err := s.db.SelectContext(ctx, &rows, "SELECT * FROM policy_sets WHERE tenant_id = $1 ORDER BY name ASC", policycraft.Tenant(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([]policycraft.PolicySet, 0, len(rows))
+	for _, row := range rows {
+		set, err := row.policySet()
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// policySet converts the database representation to the business entity.
+func (p PolicySet) policySet() (policycraft.PolicySet, error) {
+	set := policycraft.PolicySet{Name: p.Name, Description: p.Description, TimeoutMS: p.TimeoutMS, FailMode: p.FailMode}
+	if err := json.Unmarshal(p.InputSchema, &set.InputSchema); err != nil {
+		return policycraft.PolicySet{}, fmt.Errorf("unmarshaling input schema: %v", err)
+	}
+	if err := json.Unmarshal(p.Enrichments, &set.Enrichments); err != nil {
+		return policycraft.PolicySet{}, fmt.Errorf("unmarshaling enrichments: %v", err)
+	}
+	if len(set.Enrichments) == 0 {
+		set.Enrichments = nil
+	}
+	if err := json.Unmarshal(p.Tests, &set.Tests); err != nil {
+		return policycraft.PolicySet{}, fmt.Errorf("unmarshaling tests: %v", err)
+	}
+	if len(set.Tests) == 0 {
+		set.Tests = nil
+	}
+	return set, nil
+}
+
+// ImportBundle replaces the policy set of the context tenant and its policies by the bundle in a single transaction.
+// The bundle policies are inserted again, so every field of them is replaced, and the other policies of the policy set are deleted,
+// as DeletePolicy does. A bundle policy that belongs to another policy set fails with policycraft.ErrPolicyInOtherSet.
+// The changes are recorded in the history of the policies. If anything fails, nothing is changed.
+func (s *Storage) ImportBundle(ctx context.Context, bundle policycraft.Bundle) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = savePolicySet(ctx, tx, bundle.PolicySet)
+	if err != nil {
+		return fmt.Errorf("saving policy set: %v", err)
+	}
+
+	ids := make([]string, 0, len(bundle.Policies))
+	for _, p := range bundle.Policies {
+		ids = append(ids, p.ID)
+	}
+	var others []string
+	err = tx.SelectContext(ctx, &others, "SELECT id FROM policies WHERE tenant_id = $1 AND id::text = ANY($2) AND policy_set <> $3 AND deleted_at IS NULL ORDER BY id",
+		policycraft.Tenant(ctx), pq.Array(ids), bundle.PolicySet.Name)
+	if err != nil {
+		return fmt.Errorf("getting policies of other policy sets: %v", err)
+	}
+	if len(others) > 0 {
+		return fmt.Errorf("%w: %s", policycraft.ErrPolicyInOtherSet, strings.Join(others, ", "))
+	}
+	// The history covers the policies removed from the policy set too.
+	var changed []string
+	err = tx.SelectContext(ctx, &changed, "SELECT id FROM policies WHERE tenant_id = $1 AND policy_set = $2 AND deleted_at IS NULL", policycraft.Tenant(ctx), bundle.PolicySet.Name)
+	if err != nil {
+		return fmt.Errorf("getting policies: %v", err)
+	}
+	changed = append(changed, ids...)
+
+	err = recordHistory(ctx, tx, changed, func() error {
+		// The bundle policies are inserted again, even if deleted from another policy set, so they are restored in this one.
+		_, err := tx.ExecContext(ctx, "DELETE FROM policies WHERE tenant_id = $1 AND id::text = ANY($2)", policycraft.Tenant(ctx), pq.Array(ids))
+		if err != nil {
+			return fmt.Errorf("removing policies: %v", err)
+		}
+		// The other policies of the policy set are deleted, so they can be restored.
+		_, err = tx.ExecContext(ctx, "UPDATE policies SET deleted_at = now() WHERE tenant_id = $1 AND policy_set = $2 AND deleted_at IS NULL",
+			policycraft.Tenant(ctx), bundle.PolicySet.Name)
+		if err != nil {
+			return fmt.Errorf("deleting policies: %v", err)
+		}
+
+		for _, p := range bundle.Policies {
+			err = savePolicy(ctx, tx, p)
+			if err != nil {
+				return fmt.Errorf("saving policy %s: %v", p.ID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,169 @@
+//go:build integration
+// +build integration
+
+package postgres_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/postgres"
+)
+
+func TestStoragePolicySets(t *testing.T) {
+	db := OpenDB(t)
+	defer db.Close()
+
+	storage := postgres.NewStorage(db)
+	ctx := context.Background()
+	minimum := float64(0)
+	set := policycraft.PolicySet{
+		Name:        "credit",
+		Description: "credit card approval",
+		TimeoutMS:   50,
+		FailMode:    policycraft.FailOpen,
+		InputSchema: policycraft.InputSchema{
+			Fields: map[string]policycraft.FieldSchema{
+				"age": {Type: policycraft.FieldTypeInteger, Required: true, Minimum: &minimum},
+			},
+		},
+		Enrichments: []policycraft.EnrichmentProvider{
+			{Name: "bureau", URL: "http://bureau.local/score", Field: "score", Retries: 2, CacheTTLSeconds: 60, Fallback: float64(0)},
+		},
+	}
+
+	err := storage.SavePolicySet(ctx, set)
+	if err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+
+	got, err := storage.PolicySet(ctx, set.Name)
+	if err != nil {
+		t.Fatalf("error getting policy set: %v", err)
+	}
+	assert(t, got.Description, set.Description)
+	assert(t, got.TimeoutMS, set.TimeoutMS)
+	assert(t, got.FailMode, set.FailMode)
+	assert(t, got.InputSchema.Fields["age"].Type, policycraft.FieldTypeInteger)
+	assert(t, got.InputSchema.Fields["age"].Required, true)
+	assert(t, *got.InputSchema.Fields["age"].Minimum, minimum)
+	assert(t, len(got.Enrichments), 1)
+	assert(t, got.Enrichments[0].URL, set.Enrichments[0].URL)
+	assert(t, got.Enrichments[0].Retries, 2)
+	assert(t, got.Enrichments[0].Fallback, float64(0))
+
+	// saving again updates the policy set
+	set.Description = "updated"
+	err = storage.SavePolicySet(ctx, set)
+	if err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+
+	sets, err := storage.PolicySets(ctx)
+	if err != nil {
+		t.Fatalf("error getting policy sets: %v", err)
+	}
+	if len(sets) == 1 {
+		assert(t, sets[0].Description, "updated")
+	} else {
+		t.Fatalf("expected 1 policy set, got %d", len(sets))
+	}
+
+	_, err = storage.PolicySet(ctx, "missing")
+	if !errors.Is(err, policycraft.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoragePoliciesBySet(t *testing.T) {
+	db := OpenDB(t)
+	defer db.Close()
+
+	storage := postgres.NewStorage(db)
+	ctx := context.Background()
+	policies := []policycraft.Policy{
+		{ID: uuid.NewString(), Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2, PolicySet: "credit"},
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"},
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 20, SuccessCase: true, Priority: 1, PolicySet: "insurance"},
+		{ID: uuid.NewString(), Name: "income", Type: policycraft.PolicyTypeStarlark, Script: "def condition(input):\n    return True\n", SuccessCase: true, Priority: 3, PolicySet: "credit"},
+	}
+	for _, p := range policies {
+		err := storage.SavePolicy(ctx, p)
+		if err != nil {
+			t.Fatalf("error saving policy: %v", err)
+		}
+	}
+
+	got, err := storage.PoliciesBySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	if len(got) == 3 {
+		assert(t, got[0].ID, policies[1].ID)
+		assert(t, got[1].ID, policies[0].ID)
+		assert(t, got[0].PolicySet, "credit")
+		assert(t, got[0].Type, policycraft.PolicyTypeCriteria)
+		assert(t, got[2].Type, policycraft.PolicyTypeStarlark)
+		assert(t, got[2].Script, policies[3].Script)
+	} else {
+		t.Fatalf("expected 3 policies, got %d", len(got))
+	}
+}
+
+func TestStorageImportBundle(t *testing.T) {
+	db := OpenDB(t)
+	defer db.Close()
+
+	storage := postgres.NewStorage(db)
+	ctx := context.Background()
+	kept := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"}
+	removed := policycraft.Policy{ID: uuid.NewString(), Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2, PolicySet: "credit"}
+	other := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 20, SuccessCase: true, Priority: 1, PolicySet: "insurance"}
+	for _, p := range []policycraft.Policy{kept, removed, other} {
+		err := storage.SavePolicy(ctx, p)
+		if err != nil {
+			t.Fatalf("error saving policy: %v", err)
+		}
+	}
+
+	kept.Priority = 2
+	kept.SuccessCase = false
+	added := policycraft.Policy{ID: uuid.NewString(), Name: "score", Criteria: ">", Value: 500, SuccessCase: true, Priority: 1, PolicySet: "credit"}
+	set := policycraft.PolicySet{
+		Name:  "credit",
+		Tests: []policycraft.PolicyTest{{Name: "minor", Input: map[string]interface{}{"age": float64(16), "score": float64(600)}, Decision: true}},
+	}
+	err := storage.ImportBundle(ctx, policycraft.NewBundle(set, []policycraft.Policy{kept, added}))
+	if err != nil {
+		t.Fatalf("error importing bundle: %v", err)
+	}
+
+	got, err := storage.PoliciesBySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	if len(got) == 2 {
+		assert(t, got[0].ID, added.ID)
+		assert(t, got[1].ID, kept.ID)
+		assert(t, got[1].SuccessCase, false)
+	} else {
+		t.Fatalf("expected 2 policies, got %d", len(got))
+	}
+
+	gotSet, err := storage.PolicySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policy set: %v", err)
+	}
+	assert(t, len(gotSet.Tests), 1)
+	assert(t, gotSet.Tests[0].Name, "minor")
+
+	// the policies of other policy sets are kept
+	got, err = storage.PoliciesBySet(ctx, "insurance")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, len(got), 1)
+}
@@ -0,0 +1,16 @@
+//go:build integration
+// +build integration
+
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/perebaj/policycraft/api"
+	"github.com/perebaj/policycraft/postgres"
+	"github.com/perebaj/policycraft/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) api.Storage { return postgres.NewStorage(OpenDB(t)) })
+}
@@ -0,0 +1,175 @@
+// Package policycraft ...
+// registry.go gather the operators and functions that policies can reference by name.
+package policycraft
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Operator compares the custom field value with the policy value, telling if the policy passed.
+// The input has the type decoded from JSON (float64, string, bool...) or the type set by Go callers.
+type Operator func(input interface{}, value int) (bool, error)
+
+// Function transforms the custom field value before it's compared by the operator of the policy.
+type Function func(input interface{}) (interface{}, error)
+
+// Registry holds the operators and functions that policies can reference by name.
+// It's safe for concurrent use.
+type Registry struct {
+	mu        sync.RWMutex
+	operators map[string]Operator
+	functions map[string]Function
+}
+
+// DefaultRegistry is the registry used by the executions and the API when none is given.
+// Embedders register their domain operators and functions here, usually in an init function.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns a registry with the built-in operators: >, <, >=, <=, ==.
+func NewRegistry() *Registry {
+	r := &Registry{
+		operators: make(map[string]Operator),
+		functions: make(map[string]Function),
+	}
+	builtins := map[string]func(input, value int) bool{
+		">":  func(input, value int) bool { return input > value },
+		"<":  func(input, value int) bool { return input < value },
+		">=": func(input, value int) bool { return input >= value },
+		"<=": func(input, value int) bool { return input <= value },
+		"==": func(input, value int) bool { return input == value },
+	}
+	for name, compare := range builtins {
+		r.operators[name] = IntOperator(compare)
+	}
+	return r
+}
+
+// RegisterOperator registers an operator in the default registry. See Registry.RegisterOperator.
+func RegisterOperator(name string, op Operator) error {
+	return DefaultRegistry.RegisterOperator(name, op)
+}
+
+// RegisterFunction registers a function in the default registry. See Registry.RegisterFunction.
+func RegisterFunction(name string, fn Function) error {
+	return DefaultRegistry.RegisterFunction(name, fn)
+}
+
+// RegisterOperator registers an operator that policies can reference by name in the criteria field.
+// It fails if the name is empty or already registered.
+func (r *Registry) RegisterOperator(name string, op Operator) error {
+	if name == "" || op == nil {
+		return errors.New("operator name and implementation are required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.operators[name]; ok {
+		return fmt.Errorf("operator %s is already registered", name)
+	}
+	r.operators[name] = op
+	return nil
+}
+
+// RegisterFunction registers a function that policies can reference by name in the function field.
+// It fails if the name is empty or already registered.
+func (r *Registry) RegisterFunction(name string, fn Function) error {
+	if name == "" || fn == nil {
+		return errors.New("function name and implementation are required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.functions[name]; ok {
+		return fmt.Errorf("function %s is already registered", name)
+	}
+	r.functions[name] = fn
+	return nil
+}
+
+// Operator returns the operator registered with the given name.
+func (r *Registry) Operator(name string) (Operator, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	op, ok := r.operators[name]
+	return op, ok
+}
+
+// Function returns the function registered with the given name.
+func (r *Registry) Function(name string) (Function, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fn, ok := r.functions[name]
+	return fn, ok
+}
+
+// Operators returns the names of the registered operators, sorted.
+func (r *Registry) Operators() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return sortedKeys(r.operators)
+}
+
+// Functions returns the names of the registered functions, sorted.
+func (r *Registry) Functions() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return sortedKeys(r.functions)
+}
+
+// Validate checks if the operator and the function referenced by the policy are registered.
+// For starlark policies, the script is checked instead.
+func (r *Registry) Validate(policy Policy) error {
+	switch policy.Type {
+	case "", PolicyTypeCriteria:
+	case PolicyTypeStarlark:
+		return CheckScript(policy.Script)
+	default:
+		return fmt.Errorf("invalid type: %s", policy.Type)
+	}
+
+	var errs []error
+	if _, ok := r.Operator(policy.Criteria); !ok {
+		errs = append(errs, fmt.Errorf("invalid criteria: %s", policy.Criteria))
+	}
+	if policy.Function != "" {
+		if _, ok := r.Function(policy.Function); !ok {
+			errs = append(errs, fmt.Errorf("invalid function: %s", policy.Function))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// IntOperator adapts a comparison between integers to an Operator. The input must be an integer number.
+func IntOperator(compare func(input, value int) bool) Operator {
+	return func(input interface{}, value int) (bool, error) {
+		n, err := intValue(input)
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		return compare(n, value), nil
+	}
+}
+
+// StringOperator adapts a check over a string to an Operator. The input must be a string.
+func StringOperator(check func(input string, value int) (bool, error)) Operator {
+	return func(input interface{}, value int) (bool, error) {
+		s, ok := input.(string)
+		if !ok {
+			return false, fmt.Errorf("%w: expected a string, got %T", ErrInvalidInput, input)
+		}
+		return check(s, value)
+	}
+}
+
+// sortedKeys returns the keys of the map sorted.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,125 @@
+package policycraft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// luhn is an example of a domain operator that doesn't live in core, it passes when the input is a valid Luhn number.
+func luhn(input string, _ int) (bool, error) {
+	sum := 0
+	double := false
+	for i := len(input) - 1; i >= 0; i-- {
+		d := int(input[i] - '0')
+		if d < 0 || d > 9 {
+			return false, fmt.Errorf("%w: %q has non digit characters", ErrInvalidInput, input)
+		}
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return input != "" && sum%10 == 0, nil
+}
+
+func TestRegistryCustomOperatorAndFunction(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.RegisterOperator("luhn", StringOperator(luhn)); err != nil {
+		t.Fatalf("Error registering operator: %s", err)
+	}
+	err := registry.RegisterFunction("len", func(input interface{}) (interface{}, error) {
+		s, ok := input.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected a string, got %T", ErrInvalidInput, input)
+		}
+		return len(s), nil
+	})
+	if err != nil {
+		t.Fatalf("Error registering function: %s", err)
+	}
+
+	policies := []Policy{
+		{ID: "1", Name: "card", Criteria: "luhn", SuccessCase: true, Priority: 1},
+		{ID: "2", Name: "name", Criteria: ">=", Function: "len", Value: 3, SuccessCase: true, Priority: 2},
+	}
+
+	tests := []struct {
+		name     string
+		fields   map[string]interface{}
+		decision bool
+		wantErr  error
+	}{
+		{name: "valid card and name", fields: map[string]interface{}{"card": "79927398713", "name": "john"}, decision: true},
+		{name: "invalid card", fields: map[string]interface{}{"card": "79927398710", "name": "john"}, decision: false},
+		{name: "short name", fields: map[string]interface{}{"card": "79927398713", "name": "jo"}, decision: false},
+		{name: "card isn't a string", fields: map[string]interface{}{"card": 79927398713, "name": "john"}, wantErr: ErrInvalidInput},
+		{name: "card with letters", fields: map[string]interface{}{"card": "7992739871a", "name": "john"}, wantErr: ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Execution{CustomFields: tt.fields, Registry: registry}
+			decision, err := e.Evaluate(context.Background(), policies)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expecting error %v, got %v", tt.wantErr, err)
+			}
+			if decision != tt.decision {
+				t.Errorf("Expecting decision %t, got %t", tt.decision, decision)
+			}
+		})
+	}
+
+	// the operator isn't registered in the default registry
+	e := Execution{CustomFields: map[string]interface{}{"card": "79927398713", "name": "john"}}
+	if _, err := e.Evaluate(context.Background(), policies); err == nil {
+		t.Errorf("Expecting an error evaluating an unknown operator")
+	}
+}
+
+func TestRegistryRegister(t *testing.T) {
+	registry := NewRegistry()
+
+	if err := registry.RegisterOperator(">", IntOperator(func(input, value int) bool { return true })); err == nil {
+		t.Errorf("Expecting an error registering a built-in operator again")
+	}
+	if err := registry.RegisterOperator("", StringOperator(luhn)); err == nil {
+		t.Errorf("Expecting an error registering an operator without name")
+	}
+	if err := registry.RegisterFunction("noop", nil); err == nil {
+		t.Errorf("Expecting an error registering a function without implementation")
+	}
+
+	want := []string{"<", "<=", "==", ">", ">="}
+	got := registry.Operators()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expecting the built-in operators %v, got %v", want, got)
+	}
+}
+
+func TestRegistryValidate(t *testing.T) {
+	registry := NewRegistry()
+	_ = registry.RegisterFunction("len", func(input interface{}) (interface{}, error) { return input, nil })
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{name: "built-in operator", policy: Policy{Criteria: ">"}},
+		{name: "registered function", policy: Policy{Criteria: ">", Function: "len"}},
+		{name: "unknown operator", policy: Policy{Criteria: "luhn"}, wantErr: true},
+		{name: "unknown function", policy: Policy{Criteria: ">", Function: "upper"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := registry.Validate(tt.policy); (err != nil) != tt.wantErr {
+				t.Errorf("Registry.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
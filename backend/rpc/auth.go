@@ -0,0 +1,96 @@
+// Package rpc ...
+// auth.go gather the authentication, the tenant resolution and the authorization of the calls, as the middlewares of the HTTP API.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+	pb "github.com/perebaj/policycraft/rpc/policycraftpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// AuthorizationMetadata is the metadata with the bearer credentials, as the Authorization header.
+	AuthorizationMetadata = "authorization"
+	// TenantMetadata is the metadata used to choose the tenant of the call, as the api.TenantHeader.
+	TenantMetadata = "x-tenant-id"
+)
+
+// Authenticator is the interface that wraps the authentication of the bearer credentials, see api.Authenticator.
+type Authenticator interface {
+	Authenticate(ctx context.Context, credential string) (policycraft.Principal, error)
+}
+
+// roles returns the roles that can call each method, the ones of the equivalent HTTP route.
+func (s *Server) roles() map[string][]policycraft.Role {
+	// With the approvals, the deletions can't be proposed, so they are left to the publishers.
+	deleteRole := policycraft.RoleEditor
+	if s.approvals {
+		deleteRole = policycraft.RolePublisher
+	}
+	return map[string][]policycraft.Role{
+		pb.PolicyCraft_Execute_FullMethodName:      {policycraft.RoleExecutor},
+		pb.PolicyCraft_BatchExecute_FullMethodName: {policycraft.RoleExecutor},
+		pb.PolicyCraft_ListPolicies_FullMethodName: {policycraft.RoleViewer},
+		pb.PolicyCraft_GetPolicy_FullMethodName:    {policycraft.RoleViewer},
+		pb.PolicyCraft_SavePolicy_FullMethodName:   {policycraft.RoleEditor},
+		pb.PolicyCraft_DeletePolicy_FullMethodName: {deleteRole},
+	}
+}
+
+// UnaryInterceptor returns the interceptor that authenticates the calls by the AuthorizationMetadata, carries the principal and
+// the tenant of the TenantMetadata in their context and only calls the methods if the principal has their roles.
+// If authenticator is nil, the authentication is disabled and every call is an admin of every tenant, as api.AnonymousMiddleware.
+func (s *Server) UnaryInterceptor(authenticator Authenticator) grpc.UnaryServerInterceptor {
+	roles := s.roles()
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		principal := policycraft.Principal{Subject: "anonymous", Roles: []policycraft.Role{policycraft.RoleAdmin}}
+		if authenticator != nil {
+			credential, ok := strings.CutPrefix(firstMetadata(md, AuthorizationMetadata), "Bearer ")
+			if !ok || credential == "" {
+				return nil, status.Error(codes.Unauthenticated, "missing bearer credentials")
+			}
+			var err error
+			principal, err = authenticator.Authenticate(ctx, credential)
+			if errors.Is(err, api.ErrInvalidCredentials) {
+				return nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+			if err != nil {
+				slog.Error("failed to authenticate", "error", err)
+				return nil, status.Error(codes.Internal, "failed to authenticate")
+			}
+		}
+		ctx = policycraft.WithPrincipal(ctx, principal)
+
+		tenant, code, err := api.ResolveTenant(ctx, firstMetadata(md, TenantMetadata))
+		if err != nil {
+			return nil, statusErr(code, err)
+		}
+		ctx = policycraft.WithTenant(ctx, tenant)
+
+		for _, role := range roles[info.FullMethod] {
+			if principal.HasRole(role) {
+				return handler(ctx, req)
+			}
+		}
+		return nil, status.Error(codes.PermissionDenied, "the credentials don't have the role required by the method")
+	}
+}
+
+// firstMetadata returns the first value of the metadata key, or empty if it's absent.
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,160 @@
+// Package rpc ...
+// convert.go gather the conversions between the protobuf messages and the entities, and of the errors to the gRPC status.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+	pb "github.com/perebaj/policycraft/rpc/policycraftpb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// customFields converts the input of an execution to the custom fields evaluated by the policies. The integers become float64,
+// as the numbers of the JSON inputs of the HTTP API, so both transports give the same decisions.
+func customFields(input map[string]*pb.Value) (map[string]interface{}, error) {
+	if input == nil {
+		return nil, nil
+	}
+	fields := make(map[string]interface{}, len(input))
+	var missing []string
+	for field, value := range input {
+		switch kind := value.GetKind().(type) {
+		case *pb.Value_IntegerValue:
+			fields[field] = float64(kind.IntegerValue)
+		case *pb.Value_NumberValue:
+			fields[field] = kind.NumberValue
+		case *pb.Value_StringValue:
+			fields[field] = kind.StringValue
+		case *pb.Value_BoolValue:
+			fields[field] = kind.BoolValue
+		case *pb.Value_JsonValue:
+			fields[field] = kind.JsonValue.AsInterface()
+		default:
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("the custom fields %v don't have a value", missing)
+	}
+	return fields, nil
+}
+
+// apiPolicy converts the policy message to the policy of the API, so it's validated as the HTTP requests.
+func apiPolicy(p *pb.Policy) api.Policy {
+	policy := api.Policy{
+		ID:          p.GetId(),
+		Name:        p.GetName(),
+		Criteria:    p.GetCriteria(),
+		Function:    proto.String(p.GetFunction()),
+		Type:        p.GetType(),
+		Script:      proto.String(p.GetScript()),
+		SuccessCase: p.SuccessCase,
+		PolicySet:   p.GetPolicySet(),
+		Tags:        p.GetTags(),
+	}
+	if p.Value != nil {
+		value := int(*p.Value)
+		policy.Value = &value
+	}
+	if p.Priority != nil {
+		priority := int(*p.Priority)
+		policy.Priority = &priority
+	}
+	return policy
+}
+
+// policyPB converts the policy to its message.
+func policyPB(p policycraft.Policy) *pb.Policy {
+	return &pb.Policy{
+		Id:          p.ID,
+		Name:        p.Name,
+		Criteria:    p.Criteria,
+		Function:    p.Function,
+		Value:       proto.Int64(int64(p.Value)),
+		SuccessCase: proto.Bool(p.SuccessCase),
+		Priority:    proto.Int64(int64(p.Priority)),
+		Type:        p.Type,
+		Script:      p.Script,
+		PolicySet:   p.PolicySet,
+		Tags:        p.Tags,
+	}
+}
+
+// listedPolicyPB converts the listed policy to its message. The unknown time of the last change is absent.
+func listedPolicyPB(p policycraft.ListedPolicy) *pb.ListedPolicy {
+	listed := &pb.ListedPolicy{Policy: policyPB(p.Policy)}
+	if !p.UpdatedAt.IsZero() {
+		listed.UpdatedAt = timestamppb.New(p.UpdatedAt)
+	}
+	return listed
+}
+
+// executeError converts the error of Execute to the error of a batch result, with the field violations of its details.
+func executeError(err error) *pb.ExecuteError {
+	st := status.Convert(err)
+	executeErr := &pb.ExecuteError{Code: int32(st.Code()), Message: st.Message()}
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range badRequest.GetFieldViolations() {
+				executeErr.FieldViolations = append(executeErr.FieldViolations, &pb.FieldViolation{Field: v.GetField(), Description: v.GetDescription()})
+			}
+		}
+	}
+	return executeErr
+}
+
+// statusErr converts the error of the api package, with its HTTP status code, to the gRPC status with the equivalent code.
+// The invalid fields of api.FieldErrors are detailed as errdetails.BadRequest field violations, and a duplicated priority,
+// which can't be solved by retrying, is AlreadyExists instead of Aborted.
+func statusErr(code int, err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	var dup api.DuplicatedPriorityError
+	if errors.As(err, &dup) {
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+
+	var fieldErrs api.FieldErrors
+	if errors.As(err, &fieldErrs) {
+		violations := make([]*errdetails.BadRequest_FieldViolation, len(fieldErrs))
+		for i, fieldErr := range fieldErrs {
+			violations[i] = &errdetails.BadRequest_FieldViolation{Field: fieldErr.Field, Description: fieldErr.Msg}
+		}
+		st, detailsErr := status.New(codes.InvalidArgument, "invalid input").WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+		if detailsErr != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		return st.Err()
+	}
+
+	switch code {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case http.StatusUnauthorized:
+		return status.Error(codes.Unauthenticated, err.Error())
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case http.StatusConflict:
+		return status.Error(codes.Aborted, err.Error())
+	case http.StatusPreconditionFailed:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case http.StatusBadGateway:
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
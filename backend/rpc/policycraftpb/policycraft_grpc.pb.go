@@ -0,0 +1,338 @@
+// The gRPC service of policycraft, for the internal services. It shares the storage and the execution engine of the HTTP API,
+// see the README.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: policycraft/v1/policycraft.proto
+
+package policycraftpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PolicyCraft_Execute_FullMethodName      = "/policycraft.v1.PolicyCraft/Execute"
+	PolicyCraft_BatchExecute_FullMethodName = "/policycraft.v1.PolicyCraft/BatchExecute"
+	PolicyCraft_ListPolicies_FullMethodName = "/policycraft.v1.PolicyCraft/ListPolicies"
+	PolicyCraft_GetPolicy_FullMethodName    = "/policycraft.v1.PolicyCraft/GetPolicy"
+	PolicyCraft_SavePolicy_FullMethodName   = "/policycraft.v1.PolicyCraft/SavePolicy"
+	PolicyCraft_DeletePolicy_FullMethodName = "/policycraft.v1.PolicyCraft/DeletePolicy"
+)
+
+// PolicyCraftClient is the client API for PolicyCraft service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PolicyCraft executes the policies of the policy sets and manages the policies.
+//
+// The credentials are sent in the "authorization" metadata, as the Authorization header of the HTTP API, and the tenant in
+// the "x-tenant-id" metadata. The roles required by each method are the ones of the equivalent HTTP route.
+type PolicyCraftClient interface {
+	// Execute evaluates the policies of a policy set, as POST /execution-engine.
+	Execute(ctx context.Context, in *ExecuteRequest, opts ...grpc.CallOption) (*ExecuteResponse, error)
+	// BatchExecute evaluates many inputs at once. Each execution is independent: the failure of one doesn't fail the others.
+	BatchExecute(ctx context.Context, in *BatchExecuteRequest, opts ...grpc.CallOption) (*BatchExecuteResponse, error)
+	// ListPolicies returns a page of the policies, as GET /policies.
+	ListPolicies(ctx context.Context, in *ListPoliciesRequest, opts ...grpc.CallOption) (*ListPoliciesResponse, error)
+	// GetPolicy returns a policy with its last revision, as GET /policies/{id}.
+	GetPolicy(ctx context.Context, in *GetPolicyRequest, opts ...grpc.CallOption) (*GetPolicyResponse, error)
+	// SavePolicy creates or replaces a policy, as POST /policies. When the approvals are required, a change request with the
+	// policy is created instead.
+	SavePolicy(ctx context.Context, in *SavePolicyRequest, opts ...grpc.CallOption) (*SavePolicyResponse, error)
+	// DeletePolicy deletes a policy, as DELETE /policies/{id}.
+	DeletePolicy(ctx context.Context, in *DeletePolicyRequest, opts ...grpc.CallOption) (*DeletePolicyResponse, error)
+}
+
+type policyCraftClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPolicyCraftClient(cc grpc.ClientConnInterface) PolicyCraftClient {
+	return &policyCraftClient{cc}
+}
+
+func (c *policyCraftClient) Execute(ctx context.Context, in *ExecuteRequest, opts ...grpc.CallOption) (*ExecuteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExecuteResponse)
+	err := c.cc.Invoke(ctx, PolicyCraft_Execute_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *policyCraftClient) BatchExecute(ctx context.Context, in *BatchExecuteRequest, opts ...grpc.CallOption) (*BatchExecuteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchExecuteResponse)
+	err := c.cc.Invoke(ctx, PolicyCraft_BatchExecute_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *policyCraftClient) ListPolicies(ctx context.Context, in *ListPoliciesRequest, opts ...grpc.CallOption) (*ListPoliciesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListPoliciesResponse)
+	err := c.cc.Invoke(ctx, PolicyCraft_ListPolicies_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *policyCraftClient) GetPolicy(ctx context.Context, in *GetPolicyRequest, opts ...grpc.CallOption) (*GetPolicyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetPolicyResponse)
+	err := c.cc.Invoke(ctx, PolicyCraft_GetPolicy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *policyCraftClient) SavePolicy(ctx context.Context, in *SavePolicyRequest, opts ...grpc.CallOption) (*SavePolicyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SavePolicyResponse)
+	err := c.cc.Invoke(ctx, PolicyCraft_SavePolicy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *policyCraftClient) DeletePolicy(ctx context.Context, in *DeletePolicyRequest, opts ...grpc.CallOption) (*DeletePolicyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeletePolicyResponse)
+	err := c.cc.Invoke(ctx, PolicyCraft_DeletePolicy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PolicyCraftServer is the server API for PolicyCraft service.
+// All implementations must embed UnimplementedPolicyCraftServer
+// for forward compatibility.
+//
+// PolicyCraft executes the policies of the policy sets and manages the policies.
+//
+// The credentials are sent in the "authorization" metadata, as the Authorization header of the HTTP API, and the tenant in
+// the "x-tenant-id" metadata. The roles required by each method are the ones of the equivalent HTTP route.
+type PolicyCraftServer interface {
+	// Execute evaluates the policies of a policy set, as POST /execution-engine.
+	Execute(context.Context, *ExecuteRequest) (*ExecuteResponse, error)
+	// BatchExecute evaluates many inputs at once. Each execution is independent: the failure of one doesn't fail the others.
+	BatchExecute(context.Context, *BatchExecuteRequest) (*BatchExecuteResponse, error)
+	// ListPolicies returns a page of the policies, as GET /policies.
+	ListPolicies(context.Context, *ListPoliciesRequest) (*ListPoliciesResponse, error)
+	// GetPolicy returns a policy with its last revision, as GET /policies/{id}.
+	GetPolicy(context.Context, *GetPolicyRequest) (*GetPolicyResponse, error)
+	// SavePolicy creates or replaces a policy, as POST /policies. When the approvals are required, a change request with the
+	// policy is created instead.
+	SavePolicy(context.Context, *SavePolicyRequest) (*SavePolicyResponse, error)
+	// DeletePolicy deletes a policy, as DELETE /policies/{id}.
+	DeletePolicy(context.Context, *DeletePolicyRequest) (*DeletePolicyResponse, error)
+	mustEmbedUnimplementedPolicyCraftServer()
+}
+
+// UnimplementedPolicyCraftServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPolicyCraftServer struct{}
+
+func (UnimplementedPolicyCraftServer) Execute(context.Context, *ExecuteRequest) (*ExecuteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Execute not implemented")
+}
+func (UnimplementedPolicyCraftServer) BatchExecute(context.Context, *BatchExecuteRequest) (*BatchExecuteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchExecute not implemented")
+}
+func (UnimplementedPolicyCraftServer) ListPolicies(context.Context, *ListPoliciesRequest) (*ListPoliciesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPolicies not implemented")
+}
+func (UnimplementedPolicyCraftServer) GetPolicy(context.Context, *GetPolicyRequest) (*GetPolicyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPolicy not implemented")
+}
+func (UnimplementedPolicyCraftServer) SavePolicy(context.Context, *SavePolicyRequest) (*SavePolicyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SavePolicy not implemented")
+}
+func (UnimplementedPolicyCraftServer) DeletePolicy(context.Context, *DeletePolicyRequest) (*DeletePolicyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeletePolicy not implemented")
+}
+func (UnimplementedPolicyCraftServer) mustEmbedUnimplementedPolicyCraftServer() {}
+func (UnimplementedPolicyCraftServer) testEmbeddedByValue()                     {}
+
+// UnsafePolicyCraftServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PolicyCraftServer will
+// result in compilation errors.
+type UnsafePolicyCraftServer interface {
+	mustEmbedUnimplementedPolicyCraftServer()
+}
+
+func RegisterPolicyCraftServer(s grpc.ServiceRegistrar, srv PolicyCraftServer) {
+	// If the following call pancis, it indicates UnimplementedPolicyCraftServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PolicyCraft_ServiceDesc, srv)
+}
+
+func _PolicyCraft_Execute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExecuteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PolicyCraftServer).Execute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PolicyCraft_Execute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PolicyCraftServer).Execute(ctx, req.(*ExecuteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PolicyCraft_BatchExecute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchExecuteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PolicyCraftServer).BatchExecute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PolicyCraft_BatchExecute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PolicyCraftServer).BatchExecute(ctx, req.(*BatchExecuteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PolicyCraft_ListPolicies_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPoliciesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PolicyCraftServer).ListPolicies(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PolicyCraft_ListPolicies_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PolicyCraftServer).ListPolicies(ctx, req.(*ListPoliciesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PolicyCraft_GetPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPolicyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PolicyCraftServer).GetPolicy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PolicyCraft_GetPolicy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PolicyCraftServer).GetPolicy(ctx, req.(*GetPolicyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PolicyCraft_SavePolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SavePolicyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PolicyCraftServer).SavePolicy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PolicyCraft_SavePolicy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PolicyCraftServer).SavePolicy(ctx, req.(*SavePolicyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PolicyCraft_DeletePolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeletePolicyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PolicyCraftServer).DeletePolicy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PolicyCraft_DeletePolicy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PolicyCraftServer).DeletePolicy(ctx, req.(*DeletePolicyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PolicyCraft_ServiceDesc is the grpc.ServiceDesc for PolicyCraft service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PolicyCraft_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "policycraft.v1.PolicyCraft",
+	HandlerType: (*PolicyCraftServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Execute",
+			Handler:    _PolicyCraft_Execute_Handler,
+		},
+		{
+			MethodName: "BatchExecute",
+			Handler:    _PolicyCraft_BatchExecute_Handler,
+		},
+		{
+			MethodName: "ListPolicies",
+			Handler:    _PolicyCraft_ListPolicies_Handler,
+		},
+		{
+			MethodName: "GetPolicy",
+			Handler:    _PolicyCraft_GetPolicy_Handler,
+		},
+		{
+			MethodName: "SavePolicy",
+			Handler:    _PolicyCraft_SavePolicy_Handler,
+		},
+		{
+			MethodName: "DeletePolicy",
+			Handler:    _PolicyCraft_DeletePolicy_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "policycraft/v1/policycraft.proto",
+}
@@ -0,0 +1,273 @@
+// Package rpc provides the gRPC service of policycraft, for the internal services. It shares the storage and the execution
+// engine of the HTTP API, so both transports give the same decisions and change the same policies.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+	pb "github.com/perebaj/policycraft/rpc/policycraftpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MaxBatchExecutions is the maximum number of executions of a BatchExecute.
+	MaxBatchExecutions = 1000
+	// batchConcurrency is the number of executions of a BatchExecute evaluated at the same time.
+	batchConcurrency = 8
+)
+
+// Server implements the policycraft.v1.PolicyCraft service.
+type Server struct {
+	pb.UnimplementedPolicyCraftServer
+	db       api.Storage
+	enricher api.Enricher
+	observer api.Observer
+	// approvals tells if the policy changes must be approved, so SavePolicy creates change requests instead of saving.
+	approvals bool
+}
+
+// NewServer returns the service of the storage. The executions are observed by the observer, if not nil. When approvals is true,
+// SavePolicy creates a change request with the policy, as POST /policies does when the approvals are required.
+func NewServer(db api.Storage, enricher api.Enricher, observer api.Observer, approvals bool) *Server {
+	return &Server{db: db, enricher: enricher, observer: observer, approvals: approvals}
+}
+
+// Execute evaluates the policies of a policy set, see api.Execute.
+func (s *Server) Execute(ctx context.Context, req *pb.ExecuteRequest) (*pb.ExecuteResponse, error) {
+	customFields, err := customFields(req.GetInput())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	decision, code, err := api.Execute(ctx, s.db, s.enricher, s.observer, api.ExecutionRequest{
+		PolicySet:    req.GetPolicySet(),
+		CustomFields: customFields,
+		RequestID:    req.GetRequestId(),
+		CallerRef:    req.GetCallerRef(),
+	})
+	if err != nil {
+		return nil, statusErr(code, err)
+	}
+	return &pb.ExecuteResponse{DecisionId: decision.ID, Decision: decision.Decision, TimedOut: decision.TimedOut}, nil
+}
+
+// BatchExecute evaluates each execution as Execute, batchConcurrency at a time. The failure of an execution is returned in its result.
+func (s *Server) BatchExecute(ctx context.Context, req *pb.BatchExecuteRequest) (*pb.BatchExecuteResponse, error) {
+	executions := req.GetExecutions()
+	if len(executions) > MaxBatchExecutions {
+		return nil, status.Errorf(codes.InvalidArgument, "a batch can't have more than %d executions", MaxBatchExecutions)
+	}
+
+	results := make([]*pb.BatchExecuteResult, len(executions))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, execution := range executions {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			resp, err := s.Execute(ctx, execution)
+			if err != nil {
+				results[i] = &pb.BatchExecuteResult{Result: &pb.BatchExecuteResult_Error{Error: executeError(err)}}
+				return
+			}
+			results[i] = &pb.BatchExecuteResult{Result: &pb.BatchExecuteResult_Response{Response: resp}}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return &pb.BatchExecuteResponse{Results: results}, nil
+}
+
+// ListPolicies returns a page of the policies, filtered and sorted as GET /policies.
+func (s *Server) ListPolicies(ctx context.Context, req *pb.ListPoliciesRequest) (*pb.ListPoliciesResponse, error) {
+	filter := policycraft.PolicyFilter{
+		PolicySet: req.GetPolicySet(),
+		Name:      req.GetName(),
+		Criteria:  req.GetCriteria(),
+		Tag:       req.GetTag(),
+		Sort:      policycraft.PolicySort(req.GetSort()),
+		Cursor:    req.GetPageToken(),
+		Limit:     int(req.GetPageSize()),
+	}
+	if err := filter.Sort.Check(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if filter.Limit < 0 || filter.Limit > api.MaxPoliciesLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "page_size must be between 1 and %d", api.MaxPoliciesLimit)
+	}
+	if filter.Limit == 0 {
+		filter.Limit = api.DefaultPoliciesLimit
+	}
+	if req.UpdatedFrom != nil {
+		filter.UpdatedFrom = req.UpdatedFrom.AsTime()
+	}
+	if req.UpdatedTo != nil {
+		filter.UpdatedTo = req.UpdatedTo.AsTime()
+	}
+
+	page, err := s.db.ListPolicies(ctx, filter)
+	if errors.Is(err, policycraft.ErrInvalidCursor) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		slog.Error("failed to get policies", "error", err)
+		return nil, status.Error(codes.Internal, "failed to get policies")
+	}
+
+	resp := &pb.ListPoliciesResponse{Total: int32(page.Total), NextPageToken: page.Next}
+	for _, p := range page.Policies {
+		resp.Policies = append(resp.Policies, listedPolicyPB(p))
+	}
+	return resp, nil
+}
+
+// GetPolicy returns a policy with its last revision. A deleted policy isn't found.
+func (s *Server) GetPolicy(ctx context.Context, req *pb.GetPolicyRequest) (*pb.GetPolicyResponse, error) {
+	policy, err := s.db.Policy(ctx, req.GetId())
+	if errors.Is(err, policycraft.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "policy not found")
+	}
+	if err != nil {
+		slog.Error("failed to get policy", "error", err)
+		return nil, status.Error(codes.Internal, "failed to get policy")
+	}
+	revision, err := s.db.LastPolicyRevision(ctx, policy.ID)
+	if err != nil {
+		slog.Error("failed to get policy revision", "error", err)
+		return nil, status.Error(codes.Internal, "failed to get policy revision")
+	}
+	return &pb.GetPolicyResponse{Policy: policyPB(policy), Revision: int64(revision)}, nil
+}
+
+// SavePolicy creates or replaces a policy, recording the change in its history with the reason of the request. When the
+// approvals are required, a change request with the policy is created instead, already in review.
+func (s *Server) SavePolicy(ctx context.Context, req *pb.SavePolicyRequest) (*pb.SavePolicyResponse, error) {
+	if req.GetPolicy() == nil {
+		return nil, status.Error(codes.InvalidArgument, "policy is required")
+	}
+	policy := apiPolicy(req.GetPolicy())
+	ctx = policycraft.WithChangeInfo(ctx, policycraft.ChangeInfo{Reason: req.GetReason()})
+
+	_, base, err := s.basePolicy(ctx, policy.ID, req.BaseRevision)
+	if err != nil {
+		return nil, err
+	}
+	if s.approvals {
+		principal, _ := policycraft.PrincipalFromContext(ctx)
+		cr, code, err := api.ProposePolicy(ctx, s.db, principal.Subject, fmt.Sprintf("save policy %s", policy.Name), policy, base)
+		if err != nil {
+			return nil, statusErr(code, err)
+		}
+		return &pb.SavePolicyResponse{ChangeRequestId: cr.ID}, nil
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	p := policy.Entity()
+	// Policies with the same priority are evaluated in a tie-break order, so they are rejected to keep the order explicit.
+	err = api.CheckPriorities(ctx, s.db, []policycraft.Policy{p})
+	var dup api.DuplicatedPriorityError
+	if errors.As(err, &dup) {
+		return nil, statusErr(http.StatusConflict, err)
+	}
+	if err != nil {
+		slog.Error("failed to get policies", "error", err)
+		return nil, status.Error(codes.Internal, "failed to get policies")
+	}
+
+	err = s.db.SavePolicy(revisionContext(ctx, base), p)
+	if errors.Is(err, policycraft.ErrConflict) {
+		return nil, revisionConflict(req.BaseRevision)
+	}
+	if err != nil {
+		slog.Error("failed to save policy", "error", err)
+		return nil, status.Error(codes.Internal, "failed to save policy")
+	}
+	revision, err := s.db.LastPolicyRevision(ctx, p.ID)
+	if err != nil {
+		slog.Error("failed to get policy revision", "error", err)
+		return nil, status.Error(codes.Internal, "failed to get policy revision")
+	}
+	return &pb.SavePolicyResponse{Policy: policyPB(p), Revision: int64(revision)}, nil
+}
+
+// DeletePolicy deletes a policy, recording the change in its history with the reason of the request. The policy is kept, so it
+// can be restored by POST /policies/{id}/restore.
+func (s *Server) DeletePolicy(ctx context.Context, req *pb.DeletePolicyRequest) (*pb.DeletePolicyResponse, error) {
+	ctx = policycraft.WithChangeInfo(ctx, policycraft.ChangeInfo{Reason: req.GetReason()})
+	current, base, err := s.basePolicy(ctx, req.GetId(), req.BaseRevision)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, status.Error(codes.NotFound, "policy not found")
+	}
+
+	err = s.db.DeletePolicy(revisionContext(ctx, base), req.GetId())
+	if errors.Is(err, policycraft.ErrNotFound) {
+		// deleted after being read
+		return nil, status.Error(codes.NotFound, "policy not found")
+	}
+	if errors.Is(err, policycraft.ErrConflict) {
+		return nil, revisionConflict(req.BaseRevision)
+	}
+	if err != nil {
+		slog.Error("failed to delete policy", "error", err)
+		return nil, status.Error(codes.Internal, "failed to delete policy")
+	}
+	return &pb.DeletePolicyResponse{}, nil
+}
+
+// basePolicy returns the policy with the given id, nil if it doesn't exist, and the revision a change of it is based on: its last
+// revision, which must be the expected one when it's given, as the If-Match header of the HTTP API.
+func (s *Server) basePolicy(ctx context.Context, id string, expected *int64) (*policycraft.Policy, int, error) {
+	var current *policycraft.Policy
+	policy, err := s.db.Policy(ctx, id)
+	switch {
+	case err == nil:
+		current = &policy
+	case !errors.Is(err, policycraft.ErrNotFound):
+		slog.Error("failed to get policy", "error", err)
+		return nil, 0, status.Error(codes.Internal, "failed to get policy")
+	}
+	last, err := s.db.LastPolicyRevision(ctx, id)
+	if err != nil {
+		slog.Error("failed to get policy revision", "error", err)
+		return nil, 0, status.Error(codes.Internal, "failed to get policy revision")
+	}
+	if expected != nil && (current == nil || *expected != int64(last)) {
+		return nil, 0, status.Errorf(codes.FailedPrecondition, "the policy changed, its revision is %d", last)
+	}
+	return current, last, nil
+}
+
+// revisionContext returns the context of a change based on the given revision of a policy.
+func revisionContext(ctx context.Context, base int) context.Context {
+	info := policycraft.ChangeInfoFromContext(ctx)
+	info.BaseRevision = &base
+	return policycraft.WithChangeInfo(ctx, info)
+}
+
+// revisionConflict returns the error of a change refused by the storage because the policy changed after being read:
+// FailedPrecondition if the change was based on the revision of the request, or Aborted if it was based on the read, so the
+// client reads the policy again.
+func revisionConflict(expected *int64) error {
+	if expected != nil {
+		return status.Error(codes.FailedPrecondition, "the policy changed")
+	}
+	return status.Error(codes.Aborted, "the policy changed concurrently, read it again")
+}
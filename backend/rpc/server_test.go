@@ -0,0 +1,402 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+	"github.com/perebaj/policycraft/enrichment"
+	"github.com/perebaj/policycraft/memory"
+	pb "github.com/perebaj/policycraft/rpc/policycraftpb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// keys authenticates the credentials that are its keys as their principals.
+type keys map[string]policycraft.Principal
+
+func (k keys) Authenticate(_ context.Context, credential string) (policycraft.Principal, error) {
+	principal, ok := k[credential]
+	if !ok {
+		return policycraft.Principal{}, api.ErrInvalidCredentials
+	}
+	return principal, nil
+}
+
+// newClient serves the service of the storage through an in-memory connection, returning its client.
+func newClient(t *testing.T, db api.Storage, approvals bool, authenticator Authenticator) pb.PolicyCraftClient {
+	t.Helper()
+	lis := bufconn.Listen(1024 * 1024)
+	srv := NewServer(db, enrichment.NewEnricher(nil), nil, approvals)
+	gs := grpc.NewServer(grpc.UnaryInterceptor(srv.UnaryInterceptor(authenticator)))
+	pb.RegisterPolicyCraftServer(gs, srv)
+	go func() {
+		_ = gs.Serve(lis)
+	}()
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return pb.NewPolicyCraftClient(conn)
+}
+
+func newPolicy(name string, value, priority int64) *pb.Policy {
+	return &pb.Policy{
+		Id:          uuid.NewString(),
+		Name:        name,
+		Criteria:    ">",
+		Value:       proto.Int64(value),
+		SuccessCase: proto.Bool(true),
+		Priority:    proto.Int64(priority),
+	}
+}
+
+func assertCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("expected code %s, got %s: %v", want, got, err)
+	}
+}
+
+func TestServerExecute(t *testing.T) {
+	db := memory.NewStorage()
+	client := newClient(t, db, false, nil)
+	ctx := context.Background()
+
+	if _, err := client.SavePolicy(ctx, &pb.SavePolicyRequest{Policy: newPolicy("age", 17, 1)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resp, err := client.Execute(ctx, &pb.ExecuteRequest{
+		Input:     map[string]*pb.Value{"age": {Kind: &pb.Value_IntegerValue{IntegerValue: 18}}},
+		RequestId: "request-1",
+		CallerRef: "proposal-1",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.Decision || resp.TimedOut {
+		t.Errorf("expected an approved decision, got %v", resp)
+	}
+
+	// the decision is persisted as the ones of the HTTP API, with the integers as JSON numbers
+	decision, err := db.Decision(policycraft.WithTenant(ctx, policycraft.DefaultTenant), resp.DecisionId)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.RequestID != "request-1" || decision.CallerRef != "proposal-1" || decision.PolicySet != policycraft.DefaultPolicySet {
+		t.Errorf("unexpected decision: %+v", decision)
+	}
+	if age, ok := decision.Input["age"].(float64); !ok || age != 18 {
+		t.Errorf("expected the age 18 as float64, got %#v", decision.Input["age"])
+	}
+
+	resp, err = client.Execute(ctx, &pb.ExecuteRequest{Input: map[string]*pb.Value{"age": {Kind: &pb.Value_NumberValue{NumberValue: 16}}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Decision {
+		t.Errorf("expected a denied decision, got %v", resp)
+	}
+
+	_, err = client.Execute(ctx, &pb.ExecuteRequest{Input: map[string]*pb.Value{"age": {}}})
+	assertCode(t, err, codes.InvalidArgument)
+}
+
+func TestServerExecuteInvalidInput(t *testing.T) {
+	db := memory.NewStorage()
+	client := newClient(t, db, false, nil)
+	ctx := context.Background()
+
+	minimum := 0.0
+	set := policycraft.PolicySet{
+		Name:        "loans",
+		InputSchema: policycraft.InputSchema{Fields: map[string]policycraft.FieldSchema{"age": {Type: "integer", Required: true, Minimum: &minimum}}},
+	}
+	if err := db.SavePolicySet(policycraft.WithTenant(ctx, policycraft.DefaultTenant), set); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tags, err := structpb.NewValue([]interface{}{"new"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = client.Execute(ctx, &pb.ExecuteRequest{
+		PolicySet: "loans",
+		Input:     map[string]*pb.Value{"tags": {Kind: &pb.Value_JsonValue{JsonValue: tags}}},
+	})
+	assertCode(t, err, codes.InvalidArgument)
+
+	// the invalid fields are detailed, as the fields of the HTTP error messages
+	var violations []*errdetails.BadRequest_FieldViolation
+	for _, detail := range status.Convert(err).Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			violations = append(violations, badRequest.FieldViolations...)
+		}
+	}
+	if len(violations) != 2 || violations[0].Field != "age" || violations[1].Field != "tags" {
+		t.Errorf("expected violations of the fields age and tags, got %v", violations)
+	}
+}
+
+func TestServerBatchExecute(t *testing.T) {
+	db := memory.NewStorage()
+	client := newClient(t, db, false, nil)
+	ctx := context.Background()
+
+	if _, err := client.SavePolicy(ctx, &pb.SavePolicyRequest{Policy: newPolicy("age", 17, 1)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	minimum := 0.0
+	set := policycraft.PolicySet{Name: "loans", InputSchema: policycraft.InputSchema{Fields: map[string]policycraft.FieldSchema{"age": {Type: "integer", Minimum: &minimum}}}}
+	if err := db.SavePolicySet(policycraft.WithTenant(ctx, policycraft.DefaultTenant), set); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ages := []int64{18, 10, 30}
+	req := &pb.BatchExecuteRequest{}
+	for _, age := range ages {
+		req.Executions = append(req.Executions, &pb.ExecuteRequest{Input: map[string]*pb.Value{"age": {Kind: &pb.Value_IntegerValue{IntegerValue: age}}}})
+	}
+	// an invalid execution doesn't fail the others
+	req.Executions = append(req.Executions, &pb.ExecuteRequest{PolicySet: "loans", Input: map[string]*pb.Value{"age": {Kind: &pb.Value_IntegerValue{IntegerValue: -1}}}})
+
+	resp, err := client.BatchExecute(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Results) != len(req.Executions) {
+		t.Fatalf("expected %d results, got %d", len(req.Executions), len(resp.Results))
+	}
+	for i, age := range ages {
+		got := resp.Results[i].GetResponse()
+		if got == nil || got.Decision != (age > 17) {
+			t.Errorf("result %d: expected the decision %v, got %v", i, age > 17, resp.Results[i])
+		}
+	}
+	got := resp.Results[len(ages)].GetError()
+	if got == nil || codes.Code(got.Code) != codes.InvalidArgument || len(got.FieldViolations) != 1 || got.FieldViolations[0].Field != "age" {
+		t.Errorf("expected an invalid argument error of the field age, got %v", resp.Results[len(ages)])
+	}
+
+	_, err = client.BatchExecute(ctx, &pb.BatchExecuteRequest{Executions: make([]*pb.ExecuteRequest, MaxBatchExecutions+1)})
+	assertCode(t, err, codes.InvalidArgument)
+}
+
+func TestServerPolicyTags(t *testing.T) {
+	db := memory.NewStorage()
+	client := newClient(t, db, false, nil)
+	ctx := context.Background()
+
+	age, income := newPolicy("age", 17, 1), newPolicy("income", 1000, 2)
+	age.Tags = []string{"risk", "kyc", "risk"}
+	for _, policy := range []*pb.Policy{age, income} {
+		if _, err := client.SavePolicy(ctx, &pb.SavePolicyRequest{Policy: policy}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the tags are sorted without repetitions
+	got, err := client.GetPolicy(ctx, &pb.GetPolicyRequest{Id: age.Id})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"kyc", "risk"}; !reflect.DeepEqual(got.Policy.GetTags(), want) {
+		t.Errorf("expected the tags %v, got %v", want, got.Policy.GetTags())
+	}
+
+	list, err := client.ListPolicies(ctx, &pb.ListPoliciesRequest{Tag: "kyc"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Policies) != 1 || list.Total != 1 || list.Policies[0].Policy.GetId() != age.Id {
+		t.Errorf("expected only the policy with the tag, got %v", list.Policies)
+	}
+
+	// a save replaces every field, the tags too
+	age.Tags = nil
+	if _, err := client.SavePolicy(ctx, &pb.SavePolicyRequest{Policy: age}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, err := db.Policy(policycraft.WithTenant(ctx, policycraft.DefaultTenant), age.Id)
+	if err != nil || len(stored.Tags) != 0 {
+		t.Errorf("expected the tags to be removed, got %+v (%v)", stored, err)
+	}
+}
+
+func TestServerPolicies(t *testing.T) {
+	db := memory.NewStorage()
+	client := newClient(t, db, false, nil)
+	ctx := context.Background()
+
+	policy := newPolicy("age", 17, 1)
+	saved, err := client.SavePolicy(ctx, &pb.SavePolicyRequest{Policy: policy, Reason: "minimum age"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.Revision != 1 || saved.ChangeRequestId != "" || saved.Policy.PolicySet != policycraft.DefaultPolicySet {
+		t.Errorf("unexpected response: %v", saved)
+	}
+
+	got, err := client.GetPolicy(ctx, &pb.GetPolicyRequest{Id: policy.Id})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Revision != 1 || got.Policy.GetName() != "age" || got.Policy.GetValue() != 17 {
+		t.Errorf("unexpected policy: %v", got)
+	}
+
+	if _, err := client.SavePolicy(ctx, &pb.SavePolicyRequest{Policy: newPolicy("income", 1000, 2)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	list, err := client.ListPolicies(ctx, &pb.ListPoliciesRequest{PageSize: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if list.Total != 2 || len(list.Policies) != 1 || list.Policies[0].Policy.GetName() != "age" || list.NextPageToken == "" {
+		t.Errorf("unexpected first page: %v", list)
+	}
+	list, err = client.ListPolicies(ctx, &pb.ListPoliciesRequest{PageSize: 1, PageToken: list.NextPageToken})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Policies) != 1 || list.Policies[0].Policy.GetName() != "income" || list.NextPageToken != "" {
+		t.Errorf("unexpected last page: %v", list)
+	}
+	_, err = client.ListPolicies(ctx, &pb.ListPoliciesRequest{Sort: "value"})
+	assertCode(t, err, codes.InvalidArgument)
+
+	// the invalid policies and the duplicated priorities are refused
+	_, err = client.SavePolicy(ctx, &pb.SavePolicyRequest{Policy: &pb.Policy{Id: uuid.NewString(), Criteria: ">"}})
+	assertCode(t, err, codes.InvalidArgument)
+	_, err = client.SavePolicy(ctx, &pb.SavePolicyRequest{Policy: newPolicy("score", 500, 1)})
+	assertCode(t, err, codes.AlreadyExists)
+
+	// a change based on another revision is refused
+	policy.Value = proto.Int64(20)
+	_, err = client.SavePolicy(ctx, &pb.SavePolicyRequest{Policy: policy, BaseRevision: proto.Int64(0)})
+	assertCode(t, err, codes.FailedPrecondition)
+	saved, err = client.SavePolicy(ctx, &pb.SavePolicyRequest{Policy: policy, BaseRevision: proto.Int64(1)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.Revision != 2 {
+		t.Errorf("expected the revision 2, got %d", saved.Revision)
+	}
+
+	_, err = client.DeletePolicy(ctx, &pb.DeletePolicyRequest{Id: policy.Id, BaseRevision: proto.Int64(1)})
+	assertCode(t, err, codes.FailedPrecondition)
+	if _, err := client.DeletePolicy(ctx, &pb.DeletePolicyRequest{Id: policy.Id, Reason: "no longer needed"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = client.GetPolicy(ctx, &pb.GetPolicyRequest{Id: policy.Id})
+	assertCode(t, err, codes.NotFound)
+	_, err = client.DeletePolicy(ctx, &pb.DeletePolicyRequest{Id: policy.Id})
+	assertCode(t, err, codes.NotFound)
+
+	// the changes are recorded in the history, as the ones of the HTTP API
+	history, err := db.PolicyHistory(policycraft.WithTenant(ctx, policycraft.DefaultTenant), policy.Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(history) != 3 || history[0].Reason != "minimum age" || history[2].Reason != "no longer needed" || history[2].Author != "anonymous" {
+		t.Errorf("unexpected history: %+v", history)
+	}
+}
+
+func TestServerApprovals(t *testing.T) {
+	db := memory.NewStorage()
+	editor := policycraft.Principal{Subject: "alice", Roles: []policycraft.Role{policycraft.RoleEditor}}
+	client := newClient(t, db, true, keys{"editor-key": editor})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), AuthorizationMetadata, "Bearer editor-key")
+
+	policy := newPolicy("age", 17, 1)
+	resp, err := client.SavePolicy(ctx, &pb.SavePolicyRequest{Policy: policy})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ChangeRequestId == "" || resp.Policy != nil {
+		t.Fatalf("expected a change request, got %v", resp)
+	}
+
+	// the policy is only saved once the change request is published
+	tenantCtx := policycraft.WithTenant(context.Background(), policycraft.DefaultTenant)
+	cr, err := db.ChangeRequest(tenantCtx, resp.ChangeRequestId)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cr.Author != "alice" || cr.Status != policycraft.ChangeStatusInReview || len(cr.Policies) != 1 || cr.Policies[0].ID != policy.Id {
+		t.Errorf("unexpected change request: %+v", cr)
+	}
+	if _, err := db.Policy(tenantCtx, policy.Id); !errors.Is(err, policycraft.ErrNotFound) {
+		t.Errorf("expected the policy not to be saved, got %v", err)
+	}
+
+	// the deletions are left to the publishers
+	_, err = client.DeletePolicy(ctx, &pb.DeletePolicyRequest{Id: policy.Id})
+	assertCode(t, err, codes.PermissionDenied)
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	db := memory.NewStorage()
+	client := newClient(t, db, false, keys{
+		"credit-editor":   {Subject: "alice", Tenant: "credit", Roles: []policycraft.Role{policycraft.RoleEditor}},
+		"credit-executor": {Subject: "checkout", Tenant: "credit", Roles: []policycraft.Role{policycraft.RoleExecutor}},
+		"operator":        {Subject: "bob", Roles: []policycraft.Role{policycraft.RoleAdmin}},
+	})
+	withKey := func(key string, pairs ...string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), append([]string{AuthorizationMetadata, "Bearer " + key}, pairs...)...)
+	}
+
+	_, err := client.ListPolicies(context.Background(), &pb.ListPoliciesRequest{})
+	assertCode(t, err, codes.Unauthenticated)
+	_, err = client.ListPolicies(withKey("unknown"), &pb.ListPoliciesRequest{})
+	assertCode(t, err, codes.Unauthenticated)
+
+	// the roles of the methods are the ones of the HTTP routes
+	_, err = client.Execute(withKey("credit-editor"), &pb.ExecuteRequest{})
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = client.ListPolicies(withKey("credit-executor"), &pb.ListPoliciesRequest{})
+	assertCode(t, err, codes.PermissionDenied)
+
+	// the principals of a tenant can't ask for another one, and the others choose it
+	_, err = client.ListPolicies(withKey("credit-editor", TenantMetadata, "insurance"), &pb.ListPoliciesRequest{})
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = client.ListPolicies(withKey("operator", TenantMetadata, "Not a tenant"), &pb.ListPoliciesRequest{})
+	assertCode(t, err, codes.InvalidArgument)
+
+	policy := newPolicy("age", 17, 1)
+	if _, err := client.SavePolicy(withKey("credit-editor"), &pb.SavePolicyRequest{Policy: policy}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.GetPolicy(withKey("operator", TenantMetadata, "credit"), &pb.GetPolicyRequest{Id: policy.Id}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = client.GetPolicy(withKey("operator"), &pb.GetPolicyRequest{Id: policy.Id})
+	assertCode(t, err, codes.NotFound)
+
+	resp, err := client.Execute(withKey("credit-executor"), &pb.ExecuteRequest{Input: map[string]*pb.Value{"age": {Kind: &pb.Value_IntegerValue{IntegerValue: 18}}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.Decision {
+		t.Errorf("expected the policy of the tenant to approve, got %v", resp)
+	}
+}
@@ -0,0 +1,244 @@
+// Package policycraft ...
+// script.go gather the logic for the policies whose condition is a Starlark script.
+package policycraft
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Policy types.
+const (
+	// PolicyTypeCriteria compares a custom field with the policy value using the policy criteria. It's the default type.
+	PolicyTypeCriteria = "criteria"
+	// PolicyTypeStarlark evaluates the policy script, that receives all the custom fields and returns a boolean.
+	PolicyTypeStarlark = "starlark"
+)
+
+// Limits of the Starlark scripts.
+const (
+	// ScriptMaxSize is the maximum size of a script in bytes.
+	ScriptMaxSize = 16 << 10
+	// ScriptMaxSteps is the maximum number of Starlark computation steps of a single script evaluation.
+	ScriptMaxSteps = 100_000
+	// ScriptMaxAllocBytes is the maximum number of bytes of the values created during a single script evaluation. It's accounted
+	// by the operations of the script (see script_budget.go), so it depends only on the script and its input.
+	ScriptMaxAllocBytes = 32 << 20
+	// scriptConditionFunc is the function that every script must define.
+	scriptConditionFunc = "condition"
+	// scriptCacheSize is the maximum number of compiled scripts kept in memory.
+	scriptCacheSize = 1024
+)
+
+// scriptFileOptions disables the Starlark features that aren't needed by a condition.
+var scriptFileOptions = &syntax.FileOptions{}
+
+// scripts caches the compiled scripts, so they aren't compiled in every execution.
+var scripts = newScriptCache(scriptCacheSize)
+
+// scriptCache keeps the most recently used compiled scripts by the hash of their source. The scripts of the policies that changed
+// stop being used, so they're evicted once scriptCacheSize other scripts are compiled.
+type scriptCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *scriptCacheEntry, the most recently used first
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+type scriptCacheEntry struct {
+	key  [sha256.Size]byte
+	prog *starlark.Program
+}
+
+func newScriptCache(size int) *scriptCache {
+	return &scriptCache{size: size, order: list.New(), entries: make(map[[sha256.Size]byte]*list.Element)}
+}
+
+// get returns the compiled script of the key, marking it as the most recently used.
+func (c *scriptCache) get(key [sha256.Size]byte) (*starlark.Program, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*scriptCacheEntry).prog, true
+}
+
+// add caches the compiled script of the key, evicting the least recently used one when the cache is full.
+func (c *scriptCache) add(key [sha256.Size]byte, prog *starlark.Program) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&scriptCacheEntry{key: key, prog: prog})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*scriptCacheEntry).key)
+	}
+}
+
+// len returns the number of cached scripts.
+func (c *scriptCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// CheckScript verifies if the script is valid: its size, syntax and if it defines the function condition(input).
+func CheckScript(src string) error {
+	if src == "" {
+		return errors.New("script is required")
+	}
+	if len(src) > ScriptMaxSize {
+		return fmt.Errorf("script is larger than %d bytes", ScriptMaxSize)
+	}
+	_, err := compileScript(src)
+	return err
+}
+
+// compileScript compiles the script, returning the condition function.
+func compileScript(src string) (*starlark.Program, error) {
+	key := sha256.Sum256([]byte(src))
+	if prog, ok := scripts.get(key); ok {
+		return prog, nil
+	}
+
+	f, err := scriptFileOptions.Parse("policy.star", src, 0)
+	if err != nil {
+		return nil, fmt.Errorf("script syntax: %v", err)
+	}
+	if err := budgetScript(f); err != nil {
+		return nil, err
+	}
+	prog, err := starlark.FileProgram(f, scriptPredeclared.Has)
+	if err != nil {
+		return nil, fmt.Errorf("script syntax: %v", err)
+	}
+	globals, err := prog.Init(newScriptThread(), scriptPredeclared)
+	if err != nil {
+		return nil, fmt.Errorf("script initialization: %v", err)
+	}
+	if _, ok := globals[scriptConditionFunc].(*starlark.Function); !ok {
+		return nil, fmt.Errorf("script must define the function %s(input)", scriptConditionFunc)
+	}
+
+	scripts.add(key, prog)
+	return prog, nil
+}
+
+// runScript evaluates the script condition with the custom fields as input. The script runs without any I/O,
+// limited by ScriptMaxSteps and ScriptMaxAllocBytes, and it's canceled when the context is done.
+func runScript(ctx context.Context, src string, customFields map[string]interface{}) (bool, error) {
+	if len(src) > ScriptMaxSize {
+		return false, fmt.Errorf("script is larger than %d bytes", ScriptMaxSize)
+	}
+	prog, err := compileScript(src)
+	if err != nil {
+		return false, err
+	}
+	input, err := toStarlark(customFields)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	thread := newScriptThread()
+	stop := context.AfterFunc(ctx, func() { thread.Cancel(ctx.Err().Error()) })
+	defer stop()
+
+	globals, err := prog.Init(thread, scriptPredeclared)
+	if err != nil {
+		return false, fmt.Errorf("script initialization: %v", err)
+	}
+	v, err := starlark.Call(thread, globals[scriptConditionFunc], starlark.Tuple{input}, nil)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return false, ctxErr
+	}
+	if err != nil {
+		return false, fmt.Errorf("script: %v", err)
+	}
+	passed, ok := v.(starlark.Bool)
+	if !ok {
+		return false, fmt.Errorf("script: %s must return a bool, got %s", scriptConditionFunc, v.Type())
+	}
+	return bool(passed), nil
+}
+
+// newScriptThread returns a thread limited by ScriptMaxSteps and ScriptMaxAllocBytes, and without I/O: print is discarded and
+// load isn't allowed.
+func newScriptThread() *starlark.Thread {
+	thread := &starlark.Thread{
+		Name:  "policy",
+		Print: func(*starlark.Thread, string) {},
+		Load: func(*starlark.Thread, string) (starlark.StringDict, error) {
+			return nil, errors.New("load is not allowed in policy scripts")
+		},
+	}
+	thread.SetMaxExecutionSteps(ScriptMaxSteps)
+	thread.SetLocal(scriptBudgetKey, &scriptBudget{})
+	return thread
+}
+
+// toStarlark converts a custom field value, with the types decoded from JSON, to a frozen Starlark value.
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch value := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(value), nil
+	case int:
+		return starlark.MakeInt(value), nil
+	case float64:
+		if value == float64(int64(value)) {
+			return starlark.MakeInt64(int64(value)), nil
+		}
+		return starlark.Float(value), nil
+	case string:
+		return starlark.String(value), nil
+	case []interface{}:
+		elems := make([]starlark.Value, 0, len(value))
+		for _, e := range value {
+			sv, err := toStarlark(e)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, sv)
+		}
+		list := starlark.NewList(elems)
+		list.Freeze()
+		return list, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		// the keys are sorted, so the iteration order inside the script is deterministic
+		sort.Strings(keys)
+		dict := starlark.NewDict(len(value))
+		for _, k := range keys {
+			sv, err := toStarlark(value[k])
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(k), sv); err != nil {
+				return nil, err
+			}
+		}
+		dict.Freeze()
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", v)
+	}
+}
@@ -0,0 +1,506 @@
+// Package policycraft ...
+// script_budget.go gather the memory budget of the Starlark scripts.
+//
+// Starlark doesn't account the memory of a thread, so the scripts are rewritten before being compiled: every arithmetic operation,
+// call and slice goes through a builtin that charges the size of the value it creates to the budget of the thread, before creating
+// it. The other values, such as the list literals and the comprehensions, grow by a bounded size per step, so ScriptMaxSteps limits
+// them. The budget depends only on the script and its input, so a script exceeds it in the same operation whatever the other
+// goroutines allocate.
+package policycraft
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const (
+	// scriptBudgetKey is the thread local of the budget of the script.
+	scriptBudgetKey = "budget"
+	// scriptBuiltinPrefix prefixes the builtins of the rewritten scripts, so it's reserved.
+	scriptBuiltinPrefix = "_policycraft_"
+	scriptBinaryBuiltin = scriptBuiltinPrefix + "binary"
+	scriptCallBuiltin   = scriptBuiltinPrefix + "call"
+	scriptSliceBuiltin  = scriptBuiltinPrefix + "slice"
+	// scriptValueSize and scriptEntrySize approximate the bytes of an element of a list and of an entry of a dict.
+	scriptValueSize = 16
+	scriptEntrySize = 48
+)
+
+// scriptPredeclared are the builtins called by the rewritten scripts.
+var scriptPredeclared = starlark.StringDict{
+	scriptBinaryBuiltin: starlark.NewBuiltin(scriptBinaryBuiltin, scriptBinary),
+	scriptCallBuiltin:   starlark.NewBuiltin(scriptCallBuiltin, scriptCall),
+	scriptSliceBuiltin:  starlark.NewBuiltin(scriptSliceBuiltin, scriptSlice),
+}
+
+// scriptBudget accounts the bytes of the values created by a script evaluation.
+type scriptBudget struct {
+	allocated int
+}
+
+// charge adds the bytes to the budget, failing if they exceed ScriptMaxAllocBytes.
+func (b *scriptBudget) charge(n int) error {
+	if n > ScriptMaxAllocBytes-b.allocated {
+		return fmt.Errorf("memory limit of %d bytes exceeded", ScriptMaxAllocBytes)
+	}
+	b.allocated += n
+	return nil
+}
+
+// charge charges the bytes to the budget of the thread.
+func charge(thread *starlark.Thread, n int) error {
+	b, ok := thread.Local(scriptBudgetKey).(*scriptBudget)
+	if !ok {
+		return errors.New("the thread has no memory budget")
+	}
+	return b.charge(n)
+}
+
+// scriptBinary evaluates the arithmetic operation op(x, y), such as "*" or "+=", charging the size of its result.
+func scriptBinary(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("%s: got %d arguments, want 3", scriptBinaryBuiltin, len(args))
+	}
+	name, _ := starlark.AsString(args[0])
+	op, inPlace, ok := binaryOp(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown operator %q", scriptBinaryBuiltin, name)
+	}
+	x, y := args[1], args[2]
+	if err := charge(thread, binarySize(op, inPlace, x, y)); err != nil {
+		return nil, err
+	}
+
+	// += extends a list and |= updates a dict in place, as the Starlark interpreter does
+	if inPlace {
+		if list, ok := x.(*starlark.List); ok && op == syntax.PLUS {
+			if iterable, ok := y.(starlark.Iterable); ok {
+				return list, extendList(list, iterable)
+			}
+		}
+		if dict, ok := x.(*starlark.Dict); ok && op == syntax.PIPE {
+			if other, ok := y.(*starlark.Dict); ok {
+				for _, item := range other.Items() {
+					if err := dict.SetKey(item[0], item[1]); err != nil {
+						return nil, err
+					}
+				}
+				return dict, nil
+			}
+		}
+	}
+	return starlark.Binary(op, x, y)
+}
+
+// scriptCall calls the function of the first argument with the others, charging the size of the value created by a builtin.
+// The functions of the script charge their own operations.
+func scriptCall(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: missing the function", scriptCallBuiltin)
+	}
+	fn, args := args[0], args[1:]
+	if b, ok := fn.(*starlark.Builtin); ok {
+		if err := charge(thread, callSize(b, args, kwargs)); err != nil {
+			return nil, err
+		}
+	}
+	return starlark.Call(thread, fn, args, kwargs)
+}
+
+// scriptSlice charges the size of a slice of the value, returning the value to be sliced.
+func scriptSlice(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s: got %d arguments, want 1", scriptSliceBuiltin, len(args))
+	}
+	return args[0], charge(thread, shallowSize(args[0]))
+}
+
+// binaryOp returns the token of the arithmetic operator, and if it's an augmented assignment, such as "+=".
+func binaryOp(name string) (op syntax.Token, inPlace bool, ok bool) {
+	for op := syntax.PLUS; op <= syntax.GTGT; op++ {
+		switch name {
+		case op.String():
+			return op, false, true
+		case op.String() + "=":
+			return op, true, true
+		}
+	}
+	return 0, false, false
+}
+
+// binarySize returns the bytes created by op(x, y): the repetitions and the string formatting may create values much larger than
+// their operands, any other operation creates at most the size of both of them.
+func binarySize(op syntax.Token, inPlace bool, x, y starlark.Value) int {
+	switch {
+	case op == syntax.STAR:
+		if n, ok := y.(starlark.Int); ok && sequenceSize(x) >= 0 {
+			return mulSize(sequenceSize(x), intSize(n))
+		}
+		if n, ok := x.(starlark.Int); ok && sequenceSize(y) >= 0 {
+			return mulSize(sequenceSize(y), intSize(n))
+		}
+	case op == syntax.PERCENT:
+		if _, ok := x.(starlark.String); ok {
+			return addSize(shallowSize(x), deepSize(y))
+		}
+	case inPlace && (op == syntax.PLUS || op == syntax.PIPE):
+		// the list or the dict grows by the other operand
+		switch x.(type) {
+		case *starlark.List, *starlark.Dict:
+			return shallowSize(y)
+		}
+	}
+	return addSize(shallowSize(x), shallowSize(y))
+}
+
+// callSize returns the bytes created by a call of the builtin function or method. The builtins that aren't listed return scalars
+// or values that already exist, so they don't create anything.
+func callSize(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) int {
+	recv := b.Receiver()
+	switch b.Name() {
+	case "str", "repr", "print", "fail", "format":
+		// the string of the values, with all their elements
+		size := 0
+		if recv != nil {
+			size = shallowSize(recv)
+		}
+		for _, arg := range args {
+			size = addSize(size, deepSize(arg))
+		}
+		for _, kwarg := range kwargs {
+			size = addSize(size, deepSize(kwarg[1]))
+		}
+		return size
+	case "join":
+		if len(args) == 0 {
+			return 0
+		}
+		iterable, ok := args[0].(starlark.Iterable)
+		if !ok {
+			return 0
+		}
+		size := 0
+		iter := iterable.Iterate()
+		defer iter.Done()
+		var elem starlark.Value
+		for size <= ScriptMaxAllocBytes && iter.Next(&elem) {
+			size = addSize(size, addSize(scriptValueSize+shallowSize(recv), shallowSize(elem)))
+		}
+		return size
+	case "replace":
+		if len(args) < 2 {
+			return 0
+		}
+		s, _ := starlark.AsString(recv)
+		old, _ := starlark.AsString(args[0])
+		replacement, _ := starlark.AsString(args[1])
+		count := len(s) + 1
+		if old != "" {
+			count = len(s) / len(old)
+		}
+		return addSize(len(s), mulSize(count, len(replacement)))
+	case "capitalize", "lower", "upper", "title", "swapcase", "strip", "lstrip", "rstrip", "removeprefix", "removesuffix":
+		return shallowSize(recv)
+	case "split", "rsplit", "splitlines", "elems", "codepoints", "elem_ords", "codepoint_ords", "partition", "rpartition":
+		return mulSize(shallowSize(recv), scriptEntrySize)
+	case "list", "tuple", "sorted", "set", "dict", "enumerate", "zip", "reversed", "bytes",
+		"extend", "update", "union", "intersection", "difference", "symmetric_difference", "keys", "values", "items":
+		size := 0
+		if recv != nil {
+			size = mulSize(collectionLen(recv), scriptEntrySize)
+		}
+		for _, arg := range args {
+			size = addSize(size, mulSize(collectionLen(arg), scriptEntrySize))
+		}
+		return addSize(size, mulSize(len(kwargs), scriptEntrySize))
+	}
+	return 0
+}
+
+// shallowSize returns the bytes of the value without its elements, which were charged when they were created.
+func shallowSize(v starlark.Value) int {
+	switch v := v.(type) {
+	case starlark.String:
+		return len(v)
+	case starlark.Bytes:
+		return len(v)
+	case starlark.Int:
+		if _, ok := v.Int64(); ok {
+			return scriptValueSize
+		}
+		return v.BigInt().BitLen() / 8
+	case *starlark.List, starlark.Tuple:
+		return mulSize(starlark.Len(v), scriptValueSize)
+	case *starlark.Dict, *starlark.Set:
+		return mulSize(starlark.Len(v), scriptEntrySize)
+	}
+	return scriptValueSize
+}
+
+// sequenceSize returns the bytes of a sequence that can be repeated, or -1 if it can't.
+func sequenceSize(v starlark.Value) int {
+	switch v.(type) {
+	case starlark.String, starlark.Bytes, *starlark.List, starlark.Tuple:
+		return shallowSize(v)
+	}
+	return -1
+}
+
+// collectionLen returns the number of elements of the value, or 0 if it doesn't know it.
+func collectionLen(v starlark.Value) int {
+	if n := starlark.Len(v); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// deepSize returns the bytes of the value with all its elements, as its string has. It stops counting beyond ScriptMaxAllocBytes,
+// so it ends with cyclic values too.
+func deepSize(v starlark.Value) int {
+	size := 0
+	stack := []starlark.Value{v}
+	for len(stack) > 0 && size <= ScriptMaxAllocBytes {
+		v, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		size = addSize(size, shallowSize(v))
+		switch v := v.(type) {
+		case *starlark.List, starlark.Tuple:
+			indexable := v.(starlark.Indexable)
+			for i := 0; i < indexable.Len() && len(stack) <= ScriptMaxAllocBytes/scriptValueSize; i++ {
+				stack = append(stack, indexable.Index(i))
+			}
+		case *starlark.Dict:
+			for _, item := range v.Items() {
+				stack = append(stack, item[0], item[1])
+			}
+		case *starlark.Set:
+			iter := v.Iterate()
+			var elem starlark.Value
+			for iter.Next(&elem) {
+				stack = append(stack, elem)
+			}
+			iter.Done()
+		}
+	}
+	return size
+}
+
+// intSize returns the int as a count of repetitions, math.MaxInt if it doesn't fit.
+func intSize(n starlark.Int) int {
+	if n.Sign() <= 0 {
+		return 0
+	}
+	if i, ok := n.Int64(); ok && i <= math.MaxInt {
+		return int(i)
+	}
+	return math.MaxInt
+}
+
+// addSize and mulSize return the sum and the product of the sizes, math.MaxInt if they overflow.
+func addSize(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+func mulSize(a, b int) int {
+	if a != 0 && b > math.MaxInt/a {
+		return math.MaxInt
+	}
+	return a * b
+}
+
+// extendList appends the elements of the iterable to the list.
+func extendList(list *starlark.List, iterable starlark.Iterable) error {
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var elem starlark.Value
+	for iter.Next(&elem) {
+		if err := list.Append(elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// budgetScript rewrites the script so its arithmetic operations, calls and slices go through the builtins that charge the budget.
+func budgetScript(f *syntax.File) error {
+	var err error
+	syntax.Walk(f, func(n syntax.Node) bool {
+		if id, ok := n.(*syntax.Ident); ok && strings.HasPrefix(id.Name, scriptBuiltinPrefix) && err == nil {
+			err = fmt.Errorf("script: %s: the names starting with %s are reserved", id.NamePos, scriptBuiltinPrefix)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	r := &scriptRewriter{}
+	r.stmts(f.Stmts)
+	return r.err
+}
+
+// scriptRewriter rewrites the statements of a script in place, keeping the first error.
+type scriptRewriter struct {
+	err error
+}
+
+func (r *scriptRewriter) stmts(stmts []syntax.Stmt) {
+	for _, stmt := range stmts {
+		r.stmt(stmt)
+	}
+}
+
+func (r *scriptRewriter) stmt(stmt syntax.Stmt) {
+	switch s := stmt.(type) {
+	case *syntax.AssignStmt:
+		s.RHS = r.expr(s.RHS)
+		if s.Op == syntax.EQ {
+			s.LHS = r.expr(s.LHS)
+			return
+		}
+		// x op= y is x = op(x, y), so the target is evaluated twice and must not have side effects
+		if !pureExpr(s.LHS) {
+			if r.err == nil {
+				r.err = fmt.Errorf("script: %s: the target of %s must be a name or an index of a name", s.OpPos, s.Op)
+			}
+			return
+		}
+		s.RHS = builtinCall(scriptBinaryBuiltin, s.OpPos, stringLiteral(s.Op.String(), s.OpPos), cloneExpr(s.LHS), s.RHS)
+		s.Op = syntax.EQ
+	case *syntax.DefStmt:
+		r.exprs(s.Params)
+		r.stmts(s.Body)
+	case *syntax.ExprStmt:
+		s.X = r.expr(s.X)
+	case *syntax.IfStmt:
+		s.Cond = r.expr(s.Cond)
+		r.stmts(s.True)
+		r.stmts(s.False)
+	case *syntax.ForStmt:
+		s.X = r.expr(s.X)
+		r.stmts(s.Body)
+	case *syntax.WhileStmt:
+		s.Cond = r.expr(s.Cond)
+		r.stmts(s.Body)
+	case *syntax.ReturnStmt:
+		if s.Result != nil {
+			s.Result = r.expr(s.Result)
+		}
+	}
+}
+
+func (r *scriptRewriter) exprs(exprs []syntax.Expr) {
+	for i, e := range exprs {
+		exprs[i] = r.expr(e)
+	}
+}
+
+// expr rewrites the expression, returning it or the call that replaces it.
+func (r *scriptRewriter) expr(expr syntax.Expr) syntax.Expr {
+	switch e := expr.(type) {
+	case *syntax.BinaryExpr:
+		// the keyword arguments and the default values of the parameters are binary expressions too, with the EQ operator
+		e.X, e.Y = r.expr(e.X), r.expr(e.Y)
+		if e.Op >= syntax.PLUS && e.Op <= syntax.GTGT {
+			return builtinCall(scriptBinaryBuiltin, e.OpPos, stringLiteral(e.Op.String(), e.OpPos), e.X, e.Y)
+		}
+	case *syntax.CallExpr:
+		fn := r.expr(e.Fn)
+		r.exprs(e.Args)
+		e.Args = append([]syntax.Expr{fn}, e.Args...)
+		e.Fn = &syntax.Ident{NamePos: e.Lparen, Name: scriptCallBuiltin}
+	case *syntax.SliceExpr:
+		e.X = builtinCall(scriptSliceBuiltin, e.Lbrack, r.expr(e.X))
+		if e.Lo != nil {
+			e.Lo = r.expr(e.Lo)
+		}
+		if e.Hi != nil {
+			e.Hi = r.expr(e.Hi)
+		}
+		if e.Step != nil {
+			e.Step = r.expr(e.Step)
+		}
+	case *syntax.Comprehension:
+		e.Body = r.expr(e.Body)
+		for _, clause := range e.Clauses {
+			switch c := clause.(type) {
+			case *syntax.ForClause:
+				c.X = r.expr(c.X)
+			case *syntax.IfClause:
+				c.Cond = r.expr(c.Cond)
+			}
+		}
+	case *syntax.CondExpr:
+		e.Cond, e.True, e.False = r.expr(e.Cond), r.expr(e.True), r.expr(e.False)
+	case *syntax.DictExpr:
+		r.exprs(e.List)
+	case *syntax.DictEntry:
+		e.Key, e.Value = r.expr(e.Key), r.expr(e.Value)
+	case *syntax.DotExpr:
+		e.X = r.expr(e.X)
+	case *syntax.IndexExpr:
+		e.X, e.Y = r.expr(e.X), r.expr(e.Y)
+	case *syntax.LambdaExpr:
+		r.exprs(e.Params)
+		e.Body = r.expr(e.Body)
+	case *syntax.ListExpr:
+		r.exprs(e.List)
+	case *syntax.TupleExpr:
+		r.exprs(e.List)
+	case *syntax.ParenExpr:
+		e.X = r.expr(e.X)
+	case *syntax.UnaryExpr:
+		if e.X != nil {
+			e.X = r.expr(e.X)
+		}
+	}
+	return expr
+}
+
+// builtinCall returns the call of the builtin with the arguments.
+func builtinCall(name string, pos syntax.Position, args ...syntax.Expr) *syntax.CallExpr {
+	return &syntax.CallExpr{Fn: &syntax.Ident{NamePos: pos, Name: name}, Lparen: pos, Args: args, Rparen: pos}
+}
+
+func stringLiteral(s string, pos syntax.Position) *syntax.Literal {
+	return &syntax.Literal{Token: syntax.STRING, TokenPos: pos, Raw: strconv.Quote(s), Value: s}
+}
+
+// pureExpr reports if evaluating the expression has no side effects: it's a name, a literal, or an index or attribute of them.
+func pureExpr(expr syntax.Expr) bool {
+	switch e := expr.(type) {
+	case *syntax.Ident, *syntax.Literal:
+		return true
+	case *syntax.IndexExpr:
+		return pureExpr(e.X) && pureExpr(e.Y)
+	case *syntax.DotExpr:
+		return pureExpr(e.X)
+	case *syntax.ParenExpr:
+		return pureExpr(e.X)
+	}
+	return false
+}
+
+// cloneExpr copies a pure expression, so the copy is resolved apart from it.
+func cloneExpr(expr syntax.Expr) syntax.Expr {
+	switch e := expr.(type) {
+	case *syntax.Ident:
+		return &syntax.Ident{NamePos: e.NamePos, Name: e.Name}
+	case *syntax.Literal:
+		return &syntax.Literal{Token: e.Token, TokenPos: e.TokenPos, Raw: e.Raw, Value: e.Value}
+	case *syntax.IndexExpr:
+		return &syntax.IndexExpr{X: cloneExpr(e.X), Lbrack: e.Lbrack, Y: cloneExpr(e.Y), Rbrack: e.Rbrack}
+	case *syntax.DotExpr:
+		return &syntax.DotExpr{X: cloneExpr(e.X), Dot: e.Dot, NamePos: e.NamePos, Name: &syntax.Ident{NamePos: e.Name.NamePos, Name: e.Name.Name}}
+	case *syntax.ParenExpr:
+		return &syntax.ParenExpr{Lparen: e.Lparen, X: cloneExpr(e.X), Rparen: e.Rparen}
+	}
+	return expr
+}
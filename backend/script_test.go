@@ -0,0 +1,194 @@
+package policycraft
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+func TestCheckScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantErr bool
+	}{
+		{name: "valid script", script: "def condition(input):\n    return input['age'] >= 18\n"},
+		{name: "empty script", script: "", wantErr: true},
+		{name: "syntax error", script: "def condition(input)\n    return True\n", wantErr: true},
+		{name: "without condition", script: "def check(input):\n    return True\n", wantErr: true},
+		{name: "condition isn't a function", script: "condition = True\n", wantErr: true},
+		{name: "load isn't allowed", script: "load('time.star', 'now')\ndef condition(input):\n    return True\n", wantErr: true},
+		{name: "too large", script: "def condition(input):\n    return True\n" + strings.Repeat("#", ScriptMaxSize), wantErr: true},
+		{name: "reserved name", script: "def condition(input):\n    return _policycraft_call(len, input) > 0\n", wantErr: true},
+		{name: "augmented assignment to a call", script: "def condition(input):\n    d = {}\n    d[str(1)] += 1\n    return True\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckScript(tt.script); (err != nil) != tt.wantErr {
+				t.Errorf("CheckScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluateScriptPolicy(t *testing.T) {
+	policies := []Policy{
+		{ID: "1", Name: "age", Criteria: ">=", Value: 18, SuccessCase: true, Priority: 1},
+		{
+			ID:   "2",
+			Name: "income per dependent",
+			Type: PolicyTypeStarlark,
+			Script: `
+def condition(input):
+    dependents = input.get("dependents", 0)
+    return input["income"] / (dependents + 1) >= 1000 and "debt" not in input["flags"]
+`,
+			SuccessCase: true,
+			Priority:    2,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		fields   map[string]interface{}
+		decision bool
+		wantErr  bool
+	}{
+		{
+			name:     "script passes",
+			fields:   map[string]interface{}{"age": float64(20), "income": float64(3000), "dependents": float64(2), "flags": []interface{}{}},
+			decision: true,
+		},
+		{
+			name:     "script fails",
+			fields:   map[string]interface{}{"age": float64(20), "income": float64(3000), "dependents": float64(2), "flags": []interface{}{"debt"}},
+			decision: false,
+		},
+		{
+			name:    "script error",
+			fields:  map[string]interface{}{"age": float64(20), "flags": []interface{}{}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Execution{CustomFields: tt.fields}
+			result, err := e.EvaluateWithTrace(context.Background(), policies)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expecting error %t, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if result.Decision != tt.decision {
+				t.Errorf("Expecting decision %t, got %t", tt.decision, result.Decision)
+			}
+			if len(result.Trace) != 2 || result.Trace[1].Type != PolicyTypeStarlark {
+				t.Errorf("Expecting the script policy in the trace, got %+v", result.Trace)
+			}
+		})
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{
+			name:   "step limit",
+			script: "def condition(input):\n    n = 0\n    for i in range(10000000):\n        n += i\n    return True\n",
+			want:   "too many steps",
+		},
+		{
+			name:   "memory limit",
+			script: "def condition(input):\n    s = []\n    for i in range(4096):\n        s.append('x' * 1048576)\n    return True\n",
+			want:   "memory limit",
+		},
+		{
+			name:   "memory limit in a single operation",
+			script: "def condition(input):\n    return len('x' * 1000000000) > 0\n",
+			want:   "memory limit",
+		},
+		{
+			name:   "memory limit of a builtin",
+			script: "def condition(input):\n    s = 'x' * 1048576\n    return len(''.join([s] * 1024)) > 0\n",
+			want:   "memory limit",
+		},
+		{
+			name:   "memory limit of the slices",
+			script: "def condition(input):\n    s = 'x' * 1048576\n    l = []\n    for i in range(64):\n        l.append(s[i:])\n    return True\n",
+			want:   "memory limit",
+		},
+		{
+			name:   "not a bool",
+			script: "def condition(input):\n    return 1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runScript(context.Background(), tt.script, map[string]interface{}{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expecting an error with %q running the script, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestScriptOperations(t *testing.T) {
+	// the operations go through the builtins that charge the budget, with the semantics of Starlark
+	script := `
+def condition(input):
+    l = [1]
+    alias = l
+    l += [2]
+    d = {"a": 1}
+    d |= {"b": 2}
+    counts = {"k": 0}
+    counts["k"] += 2
+    scale = lambda x, factor=2*3: x * factor
+    args = [4]
+    return (alias == [1, 2] and len(d) == 2 and counts["k"] == 2 and scale(*args) == 24 and
+        ",".join(["a", "b"]) == "a,b" and sorted([3, 1], reverse=True)[0:1] == [3] and
+        [x * 2 for x in range(3) if x % 2 == 0] == [0, 4] and "%d-%s" % (1, input["name"]) == "1-ana")
+`
+	passed, err := runScript(context.Background(), script, map[string]interface{}{"name": "ana"})
+	if err != nil || !passed {
+		t.Errorf("Expecting the script to pass, got %t and %v", passed, err)
+	}
+}
+
+func TestScriptCache(t *testing.T) {
+	cache := newScriptCache(2)
+	keys := [][sha256.Size]byte{{1}, {2}, {3}}
+	cache.add(keys[0], &starlark.Program{})
+	cache.add(keys[1], &starlark.Program{})
+	if _, ok := cache.get(keys[0]); !ok {
+		t.Fatal("Expecting the first script in the cache")
+	}
+	// the second script is the least recently used one
+	cache.add(keys[2], &starlark.Program{})
+	if _, ok := cache.get(keys[1]); ok {
+		t.Error("Expecting the second script to be evicted")
+	}
+	if cache.len() != 2 {
+		t.Errorf("Expecting 2 scripts in the cache, got %d", cache.len())
+	}
+}
+
+func TestScriptContextDone(t *testing.T) {
+	script := "def condition(input):\n    for i in range(1000):\n        sorted(range(1000), reverse=True)\n    return True\n"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	_, err := runScript(ctx, script, map[string]interface{}{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expecting the context error, got %v", err)
+	}
+}
@@ -0,0 +1,1095 @@
+// Package storagetest implements a conformance suite for the api.Storage implementations.
+// Every implementation runs the same suite, so the service behaves the same way whatever storage is configured:
+//
+//	func TestStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) api.Storage { return mystorage.New() })
+//	}
+package storagetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/perebaj/policycraft"
+	"github.com/perebaj/policycraft/api"
+)
+
+// Run runs the conformance suite. The open function is called once per test and must return an empty storage.
+func Run(t *testing.T, open func(t *testing.T) api.Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s api.Storage)
+	}{
+		{name: "SavePolicyUpsert", test: testSavePolicyUpsert},
+		{name: "SavePolicyInvalidID", test: testSavePolicyInvalidID},
+		{name: "PoliciesOrder", test: testPoliciesOrder},
+		{name: "PoliciesVersion", test: testPoliciesVersion},
+		{name: "ListPolicies", test: testListPolicies},
+		{name: "PolicySets", test: testPolicySets},
+		{name: "ImportBundle", test: testImportBundle},
+		{name: "Decisions", test: testDecisions},
+		{name: "TenantIsolation", test: testTenantIsolation},
+		{name: "APIKeys", test: testAPIKeys},
+		{name: "ChangeRequests", test: testChangeRequests},
+		{name: "PublishChangeRequest", test: testPublishChangeRequest},
+		{name: "PolicyHistory", test: testPolicyHistory},
+		{name: "PolicyLifecycle", test: testPolicyLifecycle},
+		{name: "PolicyBaseRevision", test: testPolicyBaseRevision},
+		{name: "CanceledContext", test: testCanceledContext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, open(t))
+		})
+	}
+}
+
+func assert(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func mustSavePolicies(t *testing.T, s api.Storage, policies ...policycraft.Policy) {
+	t.Helper()
+	for _, p := range policies {
+		if err := s.SavePolicy(context.Background(), p); err != nil {
+			t.Fatalf("error saving policy: %v", err)
+		}
+	}
+}
+
+func testSavePolicyUpsert(t *testing.T, s api.Storage) {
+	ctx := context.Background()
+	policy := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"}
+	mustSavePolicies(t, s, policy)
+
+	got, err := s.PoliciesBySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	// the type defaults to criteria
+	policy.Type = policycraft.PolicyTypeCriteria
+	assert(t, got, []policycraft.Policy{policy})
+
+	updated := policy
+	updated.Name = "age updated"
+	updated.Criteria = "<"
+	updated.Function = "len"
+	updated.Value = 2
+	updated.SuccessCase = false
+	updated.Priority = 2
+	mustSavePolicies(t, s, updated)
+
+	// every field is replaced
+	got, err = s.Policies(ctx)
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, got, []policycraft.Policy{updated})
+
+	// a policy saved with another policy set is moved
+	moved := updated
+	moved.PolicySet = "debit"
+	mustSavePolicies(t, s, moved)
+	got, err = s.PoliciesBySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, len(got), 0)
+}
+
+func testSavePolicyInvalidID(t *testing.T, s api.Storage) {
+	err := s.SavePolicy(context.Background(), policycraft.Policy{ID: "invalid", Name: "age", Criteria: ">", PolicySet: "credit"})
+	if err == nil {
+		t.Fatalf("expected an error saving a policy with an invalid id")
+	}
+}
+
+func testPoliciesOrder(t *testing.T, s api.Storage) {
+	ctx := context.Background()
+	// same priority: ordered by name in byte order ("Z" < "a") and then by id
+	policies := []policycraft.Policy{
+		{ID: "00000000-0000-0000-0000-000000000004", Name: "income", Criteria: ">", Value: 1, Priority: 2, PolicySet: "credit"},
+		{ID: "00000000-0000-0000-0000-000000000003", Name: "age", Criteria: ">", Value: 1, Priority: 1, PolicySet: "credit"},
+		{ID: "00000000-0000-0000-0000-000000000002", Name: "age", Criteria: ">", Value: 1, Priority: 1, PolicySet: "credit"},
+		{ID: "00000000-0000-0000-0000-000000000001", Name: "Zip", Criteria: ">", Value: 1, Priority: 1, PolicySet: "credit"},
+		{ID: "00000000-0000-0000-0000-000000000005", Name: "age", Criteria: ">", Value: 1, Priority: 0, PolicySet: "debit"},
+	}
+	mustSavePolicies(t, s, policies...)
+
+	ids := func(policies []policycraft.Policy) []string {
+		var ids []string
+		for _, p := range policies {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+
+	got, err := s.PoliciesBySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, ids(got), []string{policies[3].ID, policies[2].ID, policies[1].ID, policies[0].ID})
+
+	got, err = s.Policies(ctx)
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, ids(got), []string{policies[4].ID, policies[3].ID, policies[2].ID, policies[1].ID, policies[0].ID})
+
+	got, err = s.PoliciesBySet(ctx, "missing")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, len(got), 0)
+}
+
+func testListPolicies(t *testing.T, s api.Storage) {
+	ctx := policycraft.WithTenant(context.Background(), "loans-unit")
+	policies := []policycraft.Policy{
+		{ID: "00000000-0000-0000-0000-000000000001", Name: "score", Criteria: ">", Value: 1, Priority: 0, PolicySet: "credit", Tags: policycraft.Tags{"kyc", "risk"}},
+		{ID: "00000000-0000-0000-0000-000000000002", Name: "Age", Criteria: "<", Value: 1, Priority: 1, PolicySet: "debit"},
+		{ID: "00000000-0000-0000-0000-000000000003", Name: "age", Criteria: ">", Value: 1, Priority: 1, PolicySet: "credit", Tags: policycraft.Tags{"risk"}},
+	}
+	for _, p := range policies {
+		if err := s.SavePolicy(ctx, p); err != nil {
+			t.Fatalf("error saving policy: %v", err)
+		}
+	}
+	// the policy saved after the mark is the only one updated after it
+	time.Sleep(2 * time.Millisecond)
+	mark := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	income := policycraft.Policy{ID: "00000000-0000-0000-0000-000000000004", Name: "income", Criteria: ">", Value: 1, Priority: 2, PolicySet: "credit"}
+	if err := s.SavePolicy(ctx, income); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	deleted := policycraft.Policy{ID: uuid.NewString(), Name: "deleted", Criteria: ">", Value: 1, Priority: 3, PolicySet: "credit"}
+	if err := s.SavePolicy(ctx, deleted); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	if err := s.DeletePolicy(ctx, deleted.ID); err != nil {
+		t.Fatalf("error deleting policy: %v", err)
+	}
+
+	list := func(filter policycraft.PolicyFilter) ([]string, policycraft.PolicyPage) {
+		t.Helper()
+		page, err := s.ListPolicies(ctx, filter)
+		if err != nil {
+			t.Fatalf("error listing policies: %v", err)
+		}
+		var ids []string
+		for _, p := range page.Policies {
+			if p.UpdatedAt.IsZero() {
+				t.Fatalf("policy %s listed without updated_at", p.ID)
+			}
+			ids = append(ids, p.ID[len(p.ID)-1:])
+		}
+		return ids, page
+	}
+
+	tests := []struct {
+		name      string
+		filter    policycraft.PolicyFilter
+		wantIDs   []string
+		wantTotal int
+	}{
+		{name: "evaluation order by default", filter: policycraft.PolicyFilter{}, wantIDs: []string{"1", "2", "3", "4"}, wantTotal: 4},
+		{name: "policy set", filter: policycraft.PolicyFilter{PolicySet: "credit"}, wantIDs: []string{"1", "3", "4"}, wantTotal: 3},
+		{name: "name ignoring the case", filter: policycraft.PolicyFilter{Name: "AG"}, wantIDs: []string{"2", "3"}, wantTotal: 2},
+		{name: "criteria", filter: policycraft.PolicyFilter{Criteria: "<"}, wantIDs: []string{"2"}, wantTotal: 1},
+		{name: "tag", filter: policycraft.PolicyFilter{Tag: "risk"}, wantIDs: []string{"1", "3"}, wantTotal: 2},
+		{name: "tag and policy set", filter: policycraft.PolicyFilter{Tag: "kyc", PolicySet: "credit"}, wantIDs: []string{"1"}, wantTotal: 1},
+		{name: "unknown tag", filter: policycraft.PolicyFilter{Tag: "ris"}, wantIDs: nil, wantTotal: 0},
+		{name: "updated from", filter: policycraft.PolicyFilter{UpdatedFrom: mark}, wantIDs: []string{"4"}, wantTotal: 1},
+		{name: "updated to", filter: policycraft.PolicyFilter{UpdatedTo: mark, Sort: "-updated_at"}, wantIDs: []string{"3", "2", "1"}, wantTotal: 3},
+		{name: "name in byte order", filter: policycraft.PolicyFilter{Sort: policycraft.PolicySortName}, wantIDs: []string{"2", "3", "4", "1"}, wantTotal: 4},
+		{name: "limit", filter: policycraft.PolicyFilter{PolicySet: "credit", Limit: 2}, wantIDs: []string{"1", "3"}, wantTotal: 3},
+	}
+	for _, tt := range tests {
+		ids, page := list(tt.filter)
+		if !reflect.DeepEqual(ids, tt.wantIDs) || page.Total != tt.wantTotal {
+			t.Errorf("%s: expected %v of %d, got %v of %d", tt.name, tt.wantIDs, tt.wantTotal, ids, page.Total)
+		}
+	}
+	// the tags are listed and returned as they were saved
+	_, page := list(policycraft.PolicyFilter{Tag: "kyc"})
+	assert(t, page.Policies[0].Tags, policies[0].Tags)
+	got, err := s.Policy(ctx, policies[0].ID)
+	if err != nil {
+		t.Fatalf("error getting policy: %v", err)
+	}
+	assert(t, got.Tags, policies[0].Tags)
+
+	// every sort order pages through every policy once
+	for _, sort := range []policycraft.PolicySort{"priority", "-priority", "name", "-name", "updated_at", "-updated_at"} {
+		all, _ := list(policycraft.PolicyFilter{Sort: sort})
+		filter := policycraft.PolicyFilter{Sort: sort, Limit: 3}
+		var paged []string
+		for {
+			ids, page := list(filter)
+			paged = append(paged, ids...)
+			if page.Total != 4 {
+				t.Fatalf("sort %s: expected a total of 4, got %d", sort, page.Total)
+			}
+			if page.Next == "" {
+				break
+			}
+			filter.Cursor = page.Next
+		}
+		assert(t, paged, all)
+	}
+
+	_, err = s.ListPolicies(ctx, policycraft.PolicyFilter{Cursor: "not a cursor"})
+	if !errors.Is(err, policycraft.ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+	page, err = s.ListPolicies(policycraft.WithTenant(context.Background(), "insurance-unit"), policycraft.PolicyFilter{})
+	if err != nil {
+		t.Fatalf("error listing policies: %v", err)
+	}
+	assert(t, page.Total, 0)
+}
+
+func testPoliciesVersion(t *testing.T, s api.Storage) {
+	policies := []policycraft.Policy{
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "credit"},
+		{ID: uuid.NewString(), Name: "name", Criteria: ">", Function: "len", Value: 2, SuccessCase: false, Priority: 2, Type: policycraft.PolicyTypeCriteria, PolicySet: "credit"},
+		{ID: uuid.NewString(), Name: "income", Type: policycraft.PolicyTypeStarlark, Script: "def condition(input):\n    return True\n", SuccessCase: true, Priority: 3, PolicySet: "credit"},
+	}
+	mustSavePolicies(t, s, policies...)
+
+	// the stored policies have the same version of the saved ones, so the decisions can be traced back to them
+	got, err := s.PoliciesBySet(context.Background(), "credit")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, policycraft.PoliciesVersion(got), policycraft.PoliciesVersion(policies))
+}
+
+func testPolicySets(t *testing.T, s api.Storage) {
+	ctx := context.Background()
+	minimum := float64(0)
+	set := policycraft.PolicySet{
+		Name:        "credit",
+		Description: "credit card approval",
+		TimeoutMS:   50,
+		FailMode:    policycraft.FailOpen,
+		InputSchema: policycraft.InputSchema{
+			Fields: map[string]policycraft.FieldSchema{
+				"age": {Type: policycraft.FieldTypeInteger, Required: true, Minimum: &minimum},
+			},
+		},
+		Enrichments: []policycraft.EnrichmentProvider{
+			{Name: "bureau", URL: "http://bureau.local/score", Field: "score", Retries: 2, CacheTTLSeconds: 60, Fallback: float64(0)},
+		},
+		Tests: []policycraft.PolicyTest{{Name: "minor", Input: map[string]interface{}{"age": float64(16)}, Decision: false}},
+	}
+	err := s.SavePolicySet(ctx, set)
+	if err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+	got, err := s.PolicySet(ctx, set.Name)
+	if err != nil {
+		t.Fatalf("error getting policy set: %v", err)
+	}
+	assert(t, got, set)
+
+	// saving again replaces the policy set. The fail mode defaults to closed and the empty collections are nil.
+	err = s.SavePolicySet(ctx, policycraft.PolicySet{Name: "credit", Description: "updated", Enrichments: []policycraft.EnrichmentProvider{}})
+	if err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+	err = s.SavePolicySet(ctx, policycraft.PolicySet{Name: "atm"})
+	if err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+	sets, err := s.PolicySets(ctx)
+	if err != nil {
+		t.Fatalf("error getting policy sets: %v", err)
+	}
+	assert(t, sets, []policycraft.PolicySet{
+		{Name: "atm", FailMode: policycraft.FailClosed},
+		{Name: "credit", Description: "updated", FailMode: policycraft.FailClosed},
+	})
+
+	_, err = s.PolicySet(ctx, "missing")
+	if !errors.Is(err, policycraft.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func testImportBundle(t *testing.T, s api.Storage) {
+	ctx := context.Background()
+	kept := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, PolicySet: "credit"}
+	removed := policycraft.Policy{ID: uuid.NewString(), Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2, PolicySet: "credit"}
+	other := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 20, SuccessCase: true, Priority: 1, PolicySet: "insurance"}
+	moved := policycraft.Policy{ID: uuid.NewString(), Name: "score", Criteria: ">", Value: 500, SuccessCase: true, Priority: 2, PolicySet: "insurance"}
+	mustSavePolicies(t, s, kept, removed, other, moved)
+
+	// every field of the bundle policies is replaced
+	kept.Priority = 3
+	kept.SuccessCase = false
+	moved.PolicySet = "credit"
+	set := policycraft.PolicySet{Name: "credit", Description: "imported"}
+	bundle := policycraft.NewBundle(set, []policycraft.Policy{kept, moved})
+
+	// a policy of another policy set isn't taken by the bundle, until it's deleted from that policy set
+	err := s.ImportBundle(ctx, bundle)
+	if !errors.Is(err, policycraft.ErrPolicyInOtherSet) {
+		t.Fatalf("got %v importing a bundle with a policy of another policy set, want ErrPolicyInOtherSet", err)
+	}
+	got, err := s.PoliciesBySet(ctx, "insurance")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, len(got), 2)
+	if err := s.DeletePolicy(ctx, moved.ID); err != nil {
+		t.Fatalf("error deleting policy: %v", err)
+	}
+
+	err = s.ImportBundle(ctx, bundle)
+	if err != nil {
+		t.Fatalf("error importing bundle: %v", err)
+	}
+
+	stored, err := s.PolicySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policy set: %v", err)
+	}
+	got, err = s.PoliciesBySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, policycraft.NewBundle(stored, got), bundle)
+
+	got, err = s.PoliciesBySet(ctx, "insurance")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, len(got), 1)
+
+	// an invalid bundle changes nothing
+	err = s.ImportBundle(ctx, policycraft.NewBundle(policycraft.PolicySet{Name: "credit", Description: "invalid"}, []policycraft.Policy{kept, {ID: "invalid", Name: "age", PolicySet: "credit"}}))
+	if err == nil {
+		t.Fatalf("expected an error importing a bundle with an invalid policy id")
+	}
+	stored, err = s.PolicySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policy set: %v", err)
+	}
+	got, err = s.PoliciesBySet(ctx, "credit")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, policycraft.NewBundle(stored, got), bundle)
+}
+
+func testDecisions(t *testing.T, s api.Storage) {
+	ctx := context.Background()
+	policies := []policycraft.Policy{
+		{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "credit"},
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	approved := policycraft.Decision{
+		ID:            uuid.NewString(),
+		RequestID:     "request-1",
+		CallerRef:     "proposal-1",
+		PolicySet:     "credit",
+		Input:         map[string]interface{}{"age": float64(18), "score": float64(700)},
+		PolicyVersion: policycraft.PoliciesVersion(policies),
+		Policies:      policies,
+		Decision:      true,
+		Trace:         []policycraft.Step{{PolicyID: policies[0].ID, Name: "age", Criteria: ">", Value: 17, Input: float64(18), Passed: true}},
+		Enrichments:   []policycraft.Enrichment{{Provider: "bureau", Field: "score", Value: float64(700), Source: policycraft.EnrichmentSourceProvider, Attempts: 1}},
+		StartedAt:     now.Add(-time.Hour),
+		FinishedAt:    now.Add(-time.Hour).Add(time.Millisecond),
+	}
+	rejected := policycraft.Decision{
+		ID:            uuid.NewString(),
+		RequestID:     "request-2",
+		CallerRef:     "proposal-2",
+		PolicySet:     "credit",
+		Input:         map[string]interface{}{"age": float64(16)},
+		PolicyVersion: policycraft.PoliciesVersion(policies),
+		Policies:      policies,
+		Trace:         []policycraft.Step{{PolicyID: policies[0].ID, Name: "age", Criteria: ">", Value: 17, Input: float64(16)}},
+		TimedOut:      true,
+		StartedAt:     now,
+		FinishedAt:    now,
+	}
+	other := rejected
+	other.ID = uuid.NewString()
+	other.PolicySet = "debit"
+	other.CallerRef = "proposal-3"
+	other.StartedAt = now.Add(-2 * time.Hour)
+	other.FinishedAt = other.StartedAt
+	for _, d := range []policycraft.Decision{approved, rejected, other} {
+		if err := s.SaveDecision(ctx, d); err != nil {
+			t.Fatalf("error saving decision: %v", err)
+		}
+	}
+
+	got, err := s.Decision(ctx, approved.ID)
+	if err != nil {
+		t.Fatalf("error getting decision: %v", err)
+	}
+	// the times are compared apart, because their location may change
+	assert(t, got.StartedAt.Equal(approved.StartedAt), true)
+	assert(t, got.FinishedAt.Equal(approved.FinishedAt), true)
+	got.StartedAt, got.FinishedAt = approved.StartedAt, approved.FinishedAt
+	assert(t, got, approved)
+
+	for _, id := range []string{uuid.NewString(), "invalid"} {
+		_, err = s.Decision(ctx, id)
+		if !errors.Is(err, policycraft.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound getting the decision %s, got %v", id, err)
+		}
+	}
+	if err := s.SaveDecision(ctx, policycraft.Decision{ID: "invalid"}); err == nil {
+		t.Fatalf("expected an error saving a decision with an invalid id")
+	}
+
+	decision := false
+	tests := []struct {
+		name   string
+		filter policycraft.DecisionFilter
+		want   []string
+	}{
+		{name: "all, the most recent first", filter: policycraft.DecisionFilter{}, want: []string{rejected.ID, approved.ID, other.ID}},
+		{name: "by decision", filter: policycraft.DecisionFilter{Decision: &decision}, want: []string{rejected.ID, other.ID}},
+		{name: "by caller ref", filter: policycraft.DecisionFilter{CallerRef: "proposal-1"}, want: []string{approved.ID}},
+		{name: "by policy set", filter: policycraft.DecisionFilter{PolicySet: "credit"}, want: []string{rejected.ID, approved.ID}},
+		{name: "from is inclusive", filter: policycraft.DecisionFilter{From: approved.StartedAt}, want: []string{rejected.ID, approved.ID}},
+		{name: "to is exclusive", filter: policycraft.DecisionFilter{To: now}, want: []string{approved.ID, other.ID}},
+		{name: "limit", filter: policycraft.DecisionFilter{Limit: 2}, want: []string{rejected.ID, approved.ID}},
+		{name: "no match", filter: policycraft.DecisionFilter{CallerRef: "missing"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions, err := s.Decisions(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("error listing decisions: %v", err)
+			}
+			var ids []string
+			for _, d := range decisions {
+				ids = append(ids, d.ID)
+			}
+			assert(t, ids, tt.want)
+		})
+	}
+}
+
+func testTenantIsolation(t *testing.T, s api.Storage) {
+	credit := policycraft.WithTenant(context.Background(), "credit-unit")
+	insurance := policycraft.WithTenant(context.Background(), "insurance-unit")
+
+	policy := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	if err := s.SavePolicy(credit, policy); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	if err := s.SavePolicySet(credit, policycraft.PolicySet{Name: "loans", Description: "credit"}); err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+	decision := policycraft.Decision{ID: uuid.NewString(), PolicySet: "loans", Input: map[string]interface{}{"age": float64(18)}, Decision: true, StartedAt: time.Now().UTC()}
+	if err := s.SaveDecision(credit, decision); err != nil {
+		t.Fatalf("error saving decision: %v", err)
+	}
+
+	// another tenant, including the default one, can't read anything of the tenant
+	for _, ctx := range []context.Context{insurance, context.Background()} {
+		policies, err := s.Policies(ctx)
+		if err != nil {
+			t.Fatalf("error getting policies: %v", err)
+		}
+		assert(t, len(policies), 0)
+		policies, err = s.PoliciesBySet(ctx, "loans")
+		if err != nil {
+			t.Fatalf("error getting policies: %v", err)
+		}
+		assert(t, len(policies), 0)
+		if _, err := s.PolicySet(ctx, "loans"); !errors.Is(err, policycraft.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound getting the policy set of another tenant, got %v", err)
+		}
+		sets, err := s.PolicySets(ctx)
+		if err != nil {
+			t.Fatalf("error getting policy sets: %v", err)
+		}
+		assert(t, len(sets), 0)
+		if _, err := s.Decision(ctx, decision.ID); !errors.Is(err, policycraft.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound getting the decision of another tenant, got %v", err)
+		}
+		decisions, err := s.Decisions(ctx, policycraft.DecisionFilter{})
+		if err != nil {
+			t.Fatalf("error listing decisions: %v", err)
+		}
+		assert(t, len(decisions), 0)
+	}
+
+	// nor change it, even using the same ids and names
+	other := policy
+	other.Value = 99
+	if err := s.SavePolicy(insurance, other); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	err := s.ImportBundle(insurance, policycraft.NewBundle(policycraft.PolicySet{Name: "loans", Description: "insurance"}, nil))
+	if err != nil {
+		t.Fatalf("error importing bundle: %v", err)
+	}
+
+	policies, err := s.PoliciesBySet(credit, "loans")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, policies, []policycraft.Policy{policy})
+	set, err := s.PolicySet(credit, "loans")
+	if err != nil {
+		t.Fatalf("error getting policy set: %v", err)
+	}
+	assert(t, set.Description, "credit")
+	set, err = s.PolicySet(insurance, "loans")
+	if err != nil {
+		t.Fatalf("error getting policy set: %v", err)
+	}
+	assert(t, set.Description, "insurance")
+
+	// the context without a tenant is the default tenant
+	if err := s.SavePolicySet(context.Background(), policycraft.PolicySet{Name: "loans", Description: "default"}); err != nil {
+		t.Fatalf("error saving policy set: %v", err)
+	}
+	set, err = s.PolicySet(policycraft.WithTenant(context.Background(), policycraft.DefaultTenant), "loans")
+	if err != nil {
+		t.Fatalf("error getting policy set: %v", err)
+	}
+	assert(t, set.Description, "default")
+}
+
+func testAPIKeys(t *testing.T, s api.Storage) {
+	credit := policycraft.WithTenant(context.Background(), "credit-unit")
+	insurance := policycraft.WithTenant(context.Background(), "insurance-unit")
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	expiresAt := now.Add(time.Hour)
+
+	older := policycraft.APIKey{ID: uuid.NewString(), Name: "checkout", Roles: []policycraft.Role{policycraft.RoleExecutor}, Hash: policycraft.HashAPIKey("older"), CreatedAt: now.Add(-time.Minute), ExpiresAt: &expiresAt}
+	newer := policycraft.APIKey{ID: uuid.NewString(), Name: "backoffice", Roles: []policycraft.Role{policycraft.RoleViewer, policycraft.RoleEditor}, Hash: policycraft.HashAPIKey("newer"), CreatedAt: now}
+	for _, key := range []policycraft.APIKey{newer, older} {
+		if err := s.SaveAPIKey(credit, key); err != nil {
+			t.Fatalf("error saving API key: %v", err)
+		}
+	}
+	if err := s.SaveAPIKey(credit, policycraft.APIKey{ID: "invalid", Name: "invalid", Roles: []policycraft.Role{policycraft.RoleViewer}, Hash: policycraft.HashAPIKey("invalid"), CreatedAt: now}); err == nil {
+		t.Fatalf("expected an error saving an API key with an invalid id")
+	}
+
+	keys, err := s.APIKeys(credit)
+	if err != nil {
+		t.Fatalf("error listing API keys: %v", err)
+	}
+	assert(t, len(keys), 2)
+	assertAPIKey(t, keys[0], older, "credit-unit")
+	assertAPIKey(t, keys[1], newer, "credit-unit")
+
+	keys, err = s.APIKeys(insurance)
+	if err != nil {
+		t.Fatalf("error listing API keys: %v", err)
+	}
+	assert(t, len(keys), 0)
+
+	// the lookup by hash authenticates the requests, so it finds the key whatever the context tenant
+	key, err := s.APIKeyByHash(insurance, newer.Hash)
+	if err != nil {
+		t.Fatalf("error getting API key: %v", err)
+	}
+	assertAPIKey(t, key, newer, "credit-unit")
+	if _, err := s.APIKeyByHash(credit, policycraft.HashAPIKey("unknown")); !errors.Is(err, policycraft.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound getting an unknown API key, got %v", err)
+	}
+
+	// another tenant can't revoke the key
+	if err := s.RevokeAPIKey(insurance, newer.ID, now); !errors.Is(err, policycraft.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound revoking the API key of another tenant, got %v", err)
+	}
+	for _, id := range []string{uuid.NewString(), "invalid"} {
+		if err := s.RevokeAPIKey(credit, id, now); !errors.Is(err, policycraft.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound revoking the unknown API key %q, got %v", id, err)
+		}
+	}
+
+	// revoking again keeps the first revocation time
+	for _, at := range []time.Time{now, now.Add(time.Minute)} {
+		if err := s.RevokeAPIKey(credit, newer.ID, at); err != nil {
+			t.Fatalf("error revoking API key: %v", err)
+		}
+	}
+	key, err = s.APIKeyByHash(credit, newer.Hash)
+	if err != nil {
+		t.Fatalf("error getting API key: %v", err)
+	}
+	if key.RevokedAt == nil || !key.RevokedAt.Equal(now) {
+		t.Fatalf("got revoked_at %v, want %v", key.RevokedAt, now)
+	}
+	if key.Active(now) {
+		t.Fatalf("expected the revoked API key to be inactive")
+	}
+}
+
+// assertAPIKey compares the keys, comparing the times with Equal because the storages may return them in another location.
+func assertAPIKey(t *testing.T, got, want policycraft.APIKey, tenant string) {
+	t.Helper()
+	assert(t, got.ID, want.ID)
+	assert(t, got.Name, want.Name)
+	assert(t, got.Tenant, tenant)
+	assert(t, got.Roles, want.Roles)
+	assert(t, got.Hash, want.Hash)
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Fatalf("got created_at %v, want %v", got.CreatedAt, want.CreatedAt)
+	}
+	if (got.ExpiresAt == nil) != (want.ExpiresAt == nil) || (got.ExpiresAt != nil && !got.ExpiresAt.Equal(*want.ExpiresAt)) {
+		t.Fatalf("got expires_at %v, want %v", got.ExpiresAt, want.ExpiresAt)
+	}
+	if (got.RevokedAt == nil) != (want.RevokedAt == nil) {
+		t.Fatalf("got revoked_at %v, want %v", got.RevokedAt, want.RevokedAt)
+	}
+}
+
+func testChangeRequests(t *testing.T, s api.Storage) {
+	ctx := policycraft.WithTenant(context.Background(), "credit-unit")
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	policy := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	older := policycraft.ChangeRequest{ID: uuid.NewString(), Title: "raise the age", Author: "alice", Status: policycraft.ChangeStatusDraft, Policies: []policycraft.Policy{policy},
+		BaseRevisions: map[string]int{policy.ID: 3}, Patches: map[string]json.RawMessage{policy.ID: json.RawMessage(`{"value":17}`)}, CreatedAt: now.Add(-time.Minute), UpdatedAt: now.Add(-time.Minute)}
+	newer := policycraft.ChangeRequest{ID: uuid.NewString(), Title: "empty", Author: "bob", Status: policycraft.ChangeStatusDraft, CreatedAt: now, UpdatedAt: now}
+	for _, cr := range []policycraft.ChangeRequest{older, newer} {
+		if err := s.SaveChangeRequest(ctx, cr); err != nil {
+			t.Fatalf("error saving change request: %v", err)
+		}
+	}
+	if err := s.SaveChangeRequest(ctx, older); !errors.Is(err, policycraft.ErrConflict) {
+		t.Fatalf("expected ErrConflict creating a change request that already exists, got %v", err)
+	}
+	if err := s.SaveChangeRequest(ctx, policycraft.ChangeRequest{ID: "invalid", Status: policycraft.ChangeStatusDraft, CreatedAt: now, UpdatedAt: now}); err == nil {
+		t.Fatalf("expected an error saving a change request with an invalid id")
+	}
+
+	// the storage sets the version
+	got, err := s.ChangeRequest(ctx, older.ID)
+	if err != nil {
+		t.Fatalf("error getting change request: %v", err)
+	}
+	older.Version = 1
+	assertChangeRequest(t, got, older)
+
+	// an update is only saved over the version it was read from
+	got.Status = policycraft.ChangeStatusInReview
+	got.AddComment("bob", "looks good", now)
+	if err := s.SaveChangeRequest(ctx, got); err != nil {
+		t.Fatalf("error saving change request: %v", err)
+	}
+	stale := got
+	stale.Title = "stale"
+	if err := s.SaveChangeRequest(ctx, stale); !errors.Is(err, policycraft.ErrConflict) {
+		t.Fatalf("expected ErrConflict saving a stale change request, got %v", err)
+	}
+	updated, err := s.ChangeRequest(ctx, older.ID)
+	if err != nil {
+		t.Fatalf("error getting change request: %v", err)
+	}
+	got.Version = 2
+	assertChangeRequest(t, updated, got)
+
+	crs, err := s.ChangeRequests(ctx, policycraft.ChangeRequestFilter{})
+	if err != nil {
+		t.Fatalf("error listing change requests: %v", err)
+	}
+	assert(t, len(crs), 2)
+	assert(t, crs[0].ID, newer.ID)
+	assert(t, crs[1].ID, older.ID)
+	crs, err = s.ChangeRequests(ctx, policycraft.ChangeRequestFilter{Status: policycraft.ChangeStatusInReview})
+	if err != nil {
+		t.Fatalf("error listing change requests: %v", err)
+	}
+	assert(t, len(crs), 1)
+	assert(t, crs[0].ID, older.ID)
+
+	// the other tenants don't see it
+	other := policycraft.WithTenant(context.Background(), "insurance-unit")
+	if _, err := s.ChangeRequest(other, older.ID); !errors.Is(err, policycraft.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound getting the change request of another tenant, got %v", err)
+	}
+	crs, err = s.ChangeRequests(other, policycraft.ChangeRequestFilter{})
+	if err != nil {
+		t.Fatalf("error listing change requests: %v", err)
+	}
+	assert(t, len(crs), 0)
+	for _, id := range []string{uuid.NewString(), "invalid"} {
+		if _, err := s.ChangeRequest(ctx, id); !errors.Is(err, policycraft.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound getting the unknown change request %q, got %v", id, err)
+		}
+	}
+}
+
+func testPublishChangeRequest(t *testing.T, s api.Storage) {
+	ctx := policycraft.WithTenant(context.Background(), "credit-unit")
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	current := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	untouched := policycraft.Policy{ID: uuid.NewString(), Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 5, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	if err := s.SavePolicy(ctx, current); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	if err := s.SavePolicy(ctx, untouched); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+
+	changed := current
+	changed.Value, changed.SuccessCase, changed.Priority = 20, false, 2
+	added := policycraft.Policy{ID: uuid.NewString(), Name: "score", Criteria: ">=", Value: 600, SuccessCase: true, Priority: 3, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	cr := policycraft.ChangeRequest{ID: uuid.NewString(), Title: "tighten loans", Author: "alice", Status: policycraft.ChangeStatusApproved, ApprovedBy: "bob", Policies: []policycraft.Policy{changed, added}, CreatedAt: now, UpdatedAt: now}
+	if err := s.SaveChangeRequest(ctx, cr); err != nil {
+		t.Fatalf("error saving change request: %v", err)
+	}
+
+	// publishing a stale change request changes nothing
+	cr.Version = 1
+	stale := cr
+	stale.Status, stale.PublishedBy = policycraft.ChangeStatusPublished, "carol"
+	cr.AddComment("carol", "publishing after lunch", now)
+	if err := s.SaveChangeRequest(ctx, cr); err != nil {
+		t.Fatalf("error saving change request: %v", err)
+	}
+	if err := s.PublishChangeRequest(ctx, stale); !errors.Is(err, policycraft.ErrConflict) {
+		t.Fatalf("expected ErrConflict publishing a stale change request, got %v", err)
+	}
+	policies, err := s.PoliciesBySet(ctx, "loans")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, policies, []policycraft.Policy{current, untouched})
+
+	published := cr
+	published.Version = 2
+	published.Status, published.PublishedBy = policycraft.ChangeStatusPublished, "carol"
+	// publishing over a revision of a policy other than the base one changes nothing either
+	based := policycraft.WithChangeInfo(ctx, policycraft.ChangeInfo{BaseRevisions: map[string]int{current.ID: 0, added.ID: 0}})
+	if err := s.PublishChangeRequest(based, published); !errors.Is(err, policycraft.ErrConflict) {
+		t.Fatalf("expected ErrConflict publishing over another revision, got %v", err)
+	}
+	policies, err = s.PoliciesBySet(ctx, "loans")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, policies, []policycraft.Policy{current, untouched})
+
+	based = policycraft.WithChangeInfo(ctx, policycraft.ChangeInfo{BaseRevisions: map[string]int{current.ID: 1, added.ID: 0}})
+	if err := s.PublishChangeRequest(based, published); err != nil {
+		t.Fatalf("error publishing change request: %v", err)
+	}
+
+	// every field of the changed policies is replaced, and the other policies are kept
+	policies, err = s.PoliciesBySet(ctx, "loans")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, policies, []policycraft.Policy{changed, added, untouched})
+	got, err := s.ChangeRequest(ctx, cr.ID)
+	if err != nil {
+		t.Fatalf("error getting change request: %v", err)
+	}
+	published.Version = 3
+	assertChangeRequest(t, got, published)
+}
+
+// assertChangeRequest compares the change requests, comparing the times with Equal because the storages may return them in another location.
+func assertChangeRequest(t *testing.T, got, want policycraft.ChangeRequest) {
+	t.Helper()
+	if !got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Fatalf("got created_at %v and updated_at %v, want %v and %v", got.CreatedAt, got.UpdatedAt, want.CreatedAt, want.UpdatedAt)
+	}
+	got.CreatedAt, got.UpdatedAt = want.CreatedAt, want.UpdatedAt
+	assert(t, len(got.Comments), len(want.Comments))
+	for i := range got.Comments {
+		if !got.Comments[i].CreatedAt.Equal(want.Comments[i].CreatedAt) {
+			t.Fatalf("got comment created_at %v, want %v", got.Comments[i].CreatedAt, want.Comments[i].CreatedAt)
+		}
+		got.Comments[i].CreatedAt = want.Comments[i].CreatedAt
+	}
+	assert(t, got, want)
+}
+
+func testPolicyHistory(t *testing.T, s api.Storage) {
+	tenant := policycraft.WithTenant(context.Background(), "credit-unit")
+	policy := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	ctx := policycraft.WithChangeInfo(tenant, policycraft.ChangeInfo{Author: "alice", Reason: "minimum age"})
+	if err := s.SavePolicy(ctx, policy); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	// saving the same policy again changes nothing, so nothing is recorded
+	if err := s.SavePolicy(ctx, policy); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	renamed := policy
+	renamed.Name = "minimum age"
+	ctx = policycraft.WithChangeInfo(tenant, policycraft.ChangeInfo{Author: "bob"})
+	if err := s.SavePolicy(ctx, renamed); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+
+	// the import removes the policy from the policy set and creates another one
+	added := policycraft.Policy{ID: uuid.NewString(), Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	ctx = policycraft.WithChangeInfo(tenant, policycraft.ChangeInfo{Author: "gitops", Reason: "loans.yaml"})
+	if err := s.ImportBundle(ctx, policycraft.NewBundle(policycraft.PolicySet{Name: "loans"}, []policycraft.Policy{added})); err != nil {
+		t.Fatalf("error importing bundle: %v", err)
+	}
+
+	got, err := s.PolicyHistory(tenant, policy.ID)
+	if err != nil {
+		t.Fatalf("error getting history: %v", err)
+	}
+	assertRevisions(t, got, []policycraft.PolicyRevision{
+		{PolicyID: policy.ID, Revision: 1, Action: policycraft.PolicyCreated, Author: "alice", Reason: "minimum age", Changes: policycraft.DiffPolicies(nil, &policy), Policy: policy},
+		{PolicyID: policy.ID, Revision: 2, Action: policycraft.PolicyUpdated, Author: "bob", Changes: []policycraft.FieldChange{{Field: "name", Before: "age", After: "minimum age"}}, Policy: renamed},
+		{PolicyID: policy.ID, Revision: 3, Action: policycraft.PolicyDeleted, Author: "gitops", Reason: "loans.yaml", Changes: policycraft.DiffPolicies(&renamed, nil), Policy: renamed},
+	})
+	got, err = s.PolicyHistory(tenant, added.ID)
+	if err != nil {
+		t.Fatalf("error getting history: %v", err)
+	}
+	assertRevisions(t, got, []policycraft.PolicyRevision{
+		{PolicyID: added.ID, Revision: 1, Action: policycraft.PolicyCreated, Author: "gitops", Reason: "loans.yaml", Changes: policycraft.DiffPolicies(nil, &added), Policy: added},
+	})
+
+	// the publication of a change request continues the numbering
+	now := time.Now().UTC()
+	cr := policycraft.ChangeRequest{ID: uuid.NewString(), Title: "restore age", Author: "alice", Status: policycraft.ChangeStatusApproved, ApprovedBy: "bob", Policies: []policycraft.Policy{policy}, CreatedAt: now, UpdatedAt: now}
+	if err := s.SaveChangeRequest(tenant, cr); err != nil {
+		t.Fatalf("error saving change request: %v", err)
+	}
+	cr.Version, cr.Status, cr.PublishedBy = 1, policycraft.ChangeStatusPublished, "carol"
+	ctx = policycraft.WithChangeInfo(tenant, policycraft.ChangeInfo{Author: "alice", Reason: "restore age"})
+	if err := s.PublishChangeRequest(ctx, cr); err != nil {
+		t.Fatalf("error publishing change request: %v", err)
+	}
+	got, err = s.PolicyHistory(tenant, policy.ID)
+	if err != nil {
+		t.Fatalf("error getting history: %v", err)
+	}
+	assert(t, len(got), 4)
+	assert(t, got[3].Revision, 4)
+	assert(t, got[3].Action, policycraft.PolicyCreated)
+
+	// another tenant sees no history
+	got, err = s.PolicyHistory(policycraft.WithTenant(context.Background(), "insurance-unit"), policy.ID)
+	if err != nil {
+		t.Fatalf("error getting history: %v", err)
+	}
+	assert(t, len(got), 0)
+}
+
+func testPolicyLifecycle(t *testing.T, s api.Storage) {
+	ctx := policycraft.WithTenant(context.Background(), "credit-unit")
+	policy := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	other := policycraft.Policy{ID: uuid.NewString(), Name: "income", Criteria: ">", Value: 1000, SuccessCase: true, Priority: 2, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	for _, p := range []policycraft.Policy{policy, other} {
+		if err := s.SavePolicy(ctx, p); err != nil {
+			t.Fatalf("error saving policy: %v", err)
+		}
+	}
+	assertPolicy := func(get func(context.Context, string) (policycraft.Policy, error), id string, want policycraft.Policy) {
+		t.Helper()
+		got, err := get(ctx, id)
+		if err != nil {
+			t.Fatalf("error getting policy: %v", err)
+		}
+		assert(t, got, want)
+	}
+	assertNotFound := func(err error) {
+		t.Helper()
+		if !errors.Is(err, policycraft.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+
+	assertPolicy(s.Policy, policy.ID, policy)
+	_, err := s.Policy(ctx, uuid.NewString())
+	assertNotFound(err)
+	_, err = s.Policy(ctx, "not-a-uuid")
+	assertNotFound(err)
+	_, err = s.Policy(policycraft.WithTenant(context.Background(), "insurance-unit"), policy.ID)
+	assertNotFound(err)
+
+	// the update replaces every field
+	updated := policy
+	updated.Name, updated.Value, updated.SuccessCase, updated.Priority = "minimum age", 18, false, 3
+	if err := s.UpdatePolicy(ctx, updated); err != nil {
+		t.Fatalf("error updating policy: %v", err)
+	}
+	assertPolicy(s.Policy, policy.ID, updated)
+	assertNotFound(s.UpdatePolicy(ctx, policycraft.Policy{ID: uuid.NewString(), Name: "unknown", Criteria: ">", PolicySet: "loans"}))
+
+	// a deleted policy isn't listed, but kept to be restored
+	if err := s.DeletePolicy(ctx, policy.ID); err != nil {
+		t.Fatalf("error deleting policy: %v", err)
+	}
+	_, err = s.Policy(ctx, policy.ID)
+	assertNotFound(err)
+	assertPolicy(s.DeletedPolicy, policy.ID, updated)
+	policies, err := s.PoliciesBySet(ctx, "loans")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, policies, []policycraft.Policy{other})
+	assertNotFound(s.DeletePolicy(ctx, policy.ID))
+	assertNotFound(s.UpdatePolicy(ctx, updated))
+	_, err = s.DeletedPolicy(ctx, other.ID)
+	assertNotFound(err)
+
+	if err := s.RestorePolicy(ctx, policy.ID); err != nil {
+		t.Fatalf("error restoring policy: %v", err)
+	}
+	assertPolicy(s.Policy, policy.ID, updated)
+	assertNotFound(s.RestorePolicy(ctx, policy.ID))
+	assertNotFound(s.RestorePolicy(ctx, uuid.NewString()))
+
+	// a deleted policy saved again is created again, with every field of the saved one
+	if err := s.DeletePolicy(ctx, policy.ID); err != nil {
+		t.Fatalf("error deleting policy: %v", err)
+	}
+	if err := s.SavePolicy(ctx, policy); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	assertPolicy(s.Policy, policy.ID, policy)
+	_, err = s.DeletedPolicy(ctx, policy.ID)
+	assertNotFound(err)
+
+	// the import deletes the policies of the policy set that aren't in the bundle, so they can be restored
+	if err := s.ImportBundle(ctx, policycraft.NewBundle(policycraft.PolicySet{Name: "loans"}, []policycraft.Policy{policy})); err != nil {
+		t.Fatalf("error importing bundle: %v", err)
+	}
+	assertPolicy(s.DeletedPolicy, other.ID, other)
+	if err := s.RestorePolicy(ctx, other.ID); err != nil {
+		t.Fatalf("error restoring policy: %v", err)
+	}
+	policies, err = s.PoliciesBySet(ctx, "loans")
+	if err != nil {
+		t.Fatalf("error getting policies: %v", err)
+	}
+	assert(t, policies, []policycraft.Policy{policy, other})
+
+	revs, err := s.PolicyHistory(ctx, policy.ID)
+	if err != nil {
+		t.Fatalf("error getting history: %v", err)
+	}
+	var actions []policycraft.PolicyAction
+	for _, rev := range revs {
+		actions = append(actions, rev.Action)
+	}
+	// the restored policy is recorded as created again
+	assert(t, actions, []policycraft.PolicyAction{policycraft.PolicyCreated, policycraft.PolicyUpdated, policycraft.PolicyDeleted,
+		policycraft.PolicyCreated, policycraft.PolicyDeleted, policycraft.PolicyCreated})
+}
+
+func testPolicyBaseRevision(t *testing.T, s api.Storage) {
+	ctx := policycraft.WithTenant(context.Background(), "loans-unit")
+	policy := policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", Value: 17, SuccessCase: true, Priority: 1, Type: policycraft.PolicyTypeCriteria, PolicySet: "loans"}
+	at := func(base int) context.Context {
+		return policycraft.WithChangeInfo(ctx, policycraft.ChangeInfo{BaseRevision: &base})
+	}
+	assertLast := func(want int) {
+		t.Helper()
+		got, err := s.LastPolicyRevision(ctx, policy.ID)
+		if err != nil {
+			t.Fatalf("error getting the last revision: %v", err)
+		}
+		assert(t, got, want)
+	}
+	assertConflict := func(err error) {
+		t.Helper()
+		if !errors.Is(err, policycraft.ErrConflict) {
+			t.Fatalf("expected ErrConflict, got %v", err)
+		}
+	}
+
+	assertLast(0)
+	assertConflict(s.SavePolicy(at(1), policy))
+	if err := s.SavePolicy(at(0), policy); err != nil {
+		t.Fatalf("error saving policy: %v", err)
+	}
+	assertLast(1)
+
+	updated := policy
+	updated.Value = 18
+	assertConflict(s.UpdatePolicy(at(0), updated))
+	assertConflict(s.SavePolicy(at(0), updated))
+	assertConflict(s.DeletePolicy(at(0), policy.ID))
+	assertPolicy := func(want policycraft.Policy) {
+		t.Helper()
+		got, err := s.Policy(ctx, policy.ID)
+		if err != nil {
+			t.Fatalf("error getting policy: %v", err)
+		}
+		assert(t, got, want)
+	}
+	// the refused changes aren't applied nor recorded
+	assertPolicy(policy)
+	assertLast(1)
+
+	if err := s.UpdatePolicy(at(1), updated); err != nil {
+		t.Fatalf("error updating policy: %v", err)
+	}
+	assertPolicy(updated)
+	assertLast(2)
+	// a change without base revision is never refused
+	if err := s.DeletePolicy(ctx, policy.ID); err != nil {
+		t.Fatalf("error deleting policy: %v", err)
+	}
+	assertLast(3)
+
+	// the revisions are counted by tenant
+	other, err := s.LastPolicyRevision(policycraft.WithTenant(context.Background(), "insurance-unit"), policy.ID)
+	if err != nil {
+		t.Fatalf("error getting the last revision: %v", err)
+	}
+	assert(t, other, 0)
+}
+
+// assertRevisions compares the revisions through their JSON representation, because the storages return the values of the changed
+// fields as decoded from JSON. The times are only checked to be set.
+func assertRevisions(t *testing.T, got, want []policycraft.PolicyRevision) {
+	t.Helper()
+	for i := range got {
+		if got[i].CreatedAt.IsZero() {
+			t.Fatalf("revision %d without created_at", got[i].Revision)
+		}
+		got[i].CreatedAt = time.Time{}
+	}
+	g, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("error marshaling revisions: %v", err)
+	}
+	w, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("error marshaling revisions: %v", err)
+	}
+	assert(t, string(g), string(w))
+}
+
+func testCanceledContext(t *testing.T, s api.Storage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.PoliciesBySet(ctx, "credit"); err == nil {
+		t.Errorf("expected an error getting policies with a canceled context")
+	}
+	if err := s.SavePolicy(ctx, policycraft.Policy{ID: uuid.NewString(), Name: "age", Criteria: ">", PolicySet: "credit"}); err == nil {
+		t.Errorf("expected an error saving a policy with a canceled context")
+	}
+	if _, err := s.PolicySet(ctx, "credit"); err == nil || errors.Is(err, policycraft.ErrNotFound) {
+		t.Errorf("expected the context error getting a policy set with a canceled context, got %v", err)
+	}
+}
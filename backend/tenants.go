@@ -0,0 +1,41 @@
+// Package policycraft ...
+// tenants.go gather the tenant, the business unit that owns a set of policies, policy sets and decisions.
+package policycraft
+
+import (
+	"context"
+	"errors"
+	"regexp"
+)
+
+// DefaultTenant is the tenant used when none is given. The entities created before the tenants existed belong to it.
+const DefaultTenant = "default"
+
+// ErrInvalidTenant is returned when a tenant id doesn't follow the expected format.
+var ErrInvalidTenant = errors.New("invalid tenant: must have between 1 and 63 lowercase letters, digits, '-' or '_', starting with a letter or digit")
+
+var tenantRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+type tenantKey struct{}
+
+// CheckTenant checks if the tenant id has the expected format.
+func CheckTenant(tenant string) error {
+	if !tenantRegexp.MatchString(tenant) {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+// WithTenant returns a copy of the context that carries the tenant. Every storage operation made with the returned context
+// only reads and writes the entities of the tenant, so the tenant is resolved once, from the request, and can't be forgotten later.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// Tenant returns the tenant carried by the context, or DefaultTenant if there is none.
+func Tenant(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}